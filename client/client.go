@@ -0,0 +1,378 @@
+// Package client is a Go client for the gitdb HTTP API.  It retries failed requests with jittered backoff, honors
+// Retry-After, and revalidates previously fetched content with If-None-Match.  With a list of replicas, requests are
+// routed by consistent hashing so each replica caches a stable share of the content.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileStat is one entry of a directory listing
+type FileStat struct {
+	Name string
+	Mode uint32
+	Hash string
+	// Zero for directories
+	Size int64 `json:",omitempty"`
+	// Only set by LsModified
+	LastModified *time.Time `json:",omitempty"`
+}
+
+// Snapshot lists every file of a branch with the path to download it from, as served by /snapshot
+type Snapshot struct {
+	Repo   string
+	Branch string
+	Commit string
+	Files  []SnapshotFile
+}
+
+type SnapshotFile struct {
+	Path string
+	Mode uint32
+	Hash string
+	Size int64
+	// API path of the file at Commit, for Get
+	URL string
+}
+
+// StatusError is returned when the server responds with an unexpected status code
+type StatusError struct {
+	Code int
+	Body string
+}
+
+func (s *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status code %d: %s", s.Code, s.Body)
+}
+
+// IsNotFound is true if err is a 404 from the server
+func IsNotFound(err error) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && statusErr.Code == http.StatusNotFound
+}
+
+type Client struct {
+	// Base URL of the gitdb server, like "http://gitdb:8080"
+	BaseURL string
+	// Optional base URLs of individual gitdb replicas.  When set, BaseURL is ignored and each request goes to the
+	// replica picked by rendezvous hashing of its repo and path, so the same content is read from the same replica's
+	// caches.  Retries move on to the next replica in the same order.
+	Replicas []string
+	// Defaults to http.DefaultClient
+	HTTPClient *http.Client
+	// Optional bearer token sent with every request
+	Token string
+	// How many times a failed request is retried.  Defaults to 3.  Negative disables retries.
+	MaxRetries int
+	// Delay before the first retry, doubled for each later retry.  Defaults to 100ms.
+	BackoffBase time.Duration
+	// Longest delay between retries, including delays asked for by Retry-After.  Defaults to 10s.
+	BackoffMax time.Duration
+
+	mu sync.Mutex
+	// Keyed by request path, since with Replicas the same path can be fetched from different base URLs
+	cached map[string]cachedResponse
+}
+
+type cachedResponse struct {
+	etag   string
+	body   []byte
+	header http.Header
+}
+
+// Response is a successful response body and its headers
+type Response struct {
+	Body   []byte
+	Header http.Header
+	// True if the server answered 304 Not Modified and Body came from the client's cache
+	NotModified bool
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient == nil {
+		return http.DefaultClient
+	}
+	return c.HTTPClient
+}
+
+func (c *Client) maxRetries() int {
+	if c.MaxRetries == 0 {
+		return 3
+	}
+	if c.MaxRetries < 0 {
+		return 0
+	}
+	return c.MaxRetries
+}
+
+func (c *Client) backoffBase() time.Duration {
+	if c.BackoffBase == 0 {
+		return time.Millisecond * 100
+	}
+	return c.BackoffBase
+}
+
+func (c *Client) backoffMax() time.Duration {
+	if c.BackoffMax == 0 {
+		return time.Second * 10
+	}
+	return c.BackoffMax
+}
+
+// FilePath is the API path of path in repo at branch, for Get
+func FilePath(repo string, branch string, path string) string {
+	return "/file/" + url.PathEscape(repo) + "/" + url.PathEscape(branch) + "/" + escapePath(path)
+}
+
+// LsPath is the API path of the listing of dir in repo at branch, for Get
+func LsPath(repo string, branch string, dir string) string {
+	return "/ls/" + url.PathEscape(repo) + "/" + url.PathEscape(branch) + "/" + escapePath(dir)
+}
+
+// GetFile returns the content of path in repo at branch
+func (c *Client) GetFile(ctx context.Context, repo string, branch string, path string) ([]byte, error) {
+	resp, err := c.Get(ctx, FilePath(repo, branch, path))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Ls lists the entries of dir in repo at branch
+func (c *Client) Ls(ctx context.Context, repo string, branch string, dir string) ([]FileStat, error) {
+	return c.ls(ctx, LsPath(repo, branch, dir))
+}
+
+// LsModified is Ls with the LastModified of each entry, which walks history on the server
+func (c *Client) LsModified(ctx context.Context, repo string, branch string, dir string) ([]FileStat, error) {
+	return c.ls(ctx, LsPath(repo, branch, dir)+"?modified=true")
+}
+
+func (c *Client) ls(ctx context.Context, path string) ([]FileStat, error) {
+	resp, err := c.Get(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	var ret []FileStat
+	if err := json.Unmarshal(resp.Body, &ret); err != nil {
+		return nil, fmt.Errorf("unable to decode listing: %w", err)
+	}
+	return ret, nil
+}
+
+// Snapshot lists every file of repo at branch.  Fetching it again while the branch has not moved is a 304 Not Modified.
+func (c *Client) Snapshot(ctx context.Context, repo string, branch string) (*Snapshot, error) {
+	resp, err := c.Get(ctx, "/snapshot/"+url.PathEscape(repo)+"/"+url.PathEscape(branch))
+	if err != nil {
+		return nil, err
+	}
+	var ret Snapshot
+	if err := json.Unmarshal(resp.Body, &ret); err != nil {
+		return nil, fmt.Errorf("unable to decode snapshot: %w", err)
+	}
+	return &ret, nil
+}
+
+// Get fetches path from the server.  Responses with an ETag are remembered, and later requests for the same path send
+// If-None-Match so unchanged content is not downloaded again.
+func (c *Client) Get(ctx context.Context, path string) (*Response, error) {
+	c.mu.Lock()
+	prev, hasPrev := c.cached[path]
+	c.mu.Unlock()
+	resp, body, err := c.getIfNoneMatch(ctx, path, prev.etag)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotModified && hasPrev {
+		return &Response{Body: prev.body, Header: prev.header, NotModified: true}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{Code: resp.StatusCode, Body: string(body)}
+	}
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		c.mu.Lock()
+		if c.cached == nil {
+			c.cached = make(map[string]cachedResponse)
+		}
+		c.cached[path] = cachedResponse{etag: etag, body: body, header: resp.Header}
+		c.mu.Unlock()
+	}
+	return &Response{Body: body, Header: resp.Header}, nil
+}
+
+// GetIfNoneMatch fetches path unless its ETag still matches etag, in which case the Response is NotModified with an
+// empty Body.  Unlike Get nothing is remembered, for callers keeping their own cache.
+func (c *Client) GetIfNoneMatch(ctx context.Context, path string, etag string) (*Response, error) {
+	resp, body, err := c.getIfNoneMatch(ctx, path, etag)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotModified && etag != "" {
+		return &Response{Header: resp.Header, NotModified: true}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{Code: resp.StatusCode, Body: string(body)}
+	}
+	return &Response{Body: body, Header: resp.Header}, nil
+}
+
+func (c *Client) getIfNoneMatch(ctx context.Context, path string, etag string) (*http.Response, []byte, error) {
+	bases := c.baseURLs(routingKey(path))
+	return c.do(ctx, func(attempt int) (*http.Request, error) {
+		reqURL := strings.TrimSuffix(bases[attempt%len(bases)], "/") + path
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		return req, nil
+	})
+}
+
+// do sends the request from newReq, retrying connection errors, 5xx and 429 responses.  The returned body is fully
+// read.
+func (c *Client) do(ctx context.Context, newReq func(attempt int) (*http.Request, error)) (*http.Response, []byte, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := newReq(attempt)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to create request: %w", err)
+		}
+		if c.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.Token)
+		}
+		resp, body, err := c.send(req)
+		if !retryable(resp, err) || attempt >= c.maxRetries() {
+			if err != nil {
+				return nil, nil, fmt.Errorf("unable to send request: %w", err)
+			}
+			return resp, body, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-time.After(c.retryDelay(attempt, resp)):
+		}
+	}
+}
+
+func (c *Client) send(req *http.Request) (*http.Response, []byte, error) {
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read response body: %w", err)
+	}
+	return resp, body, nil
+}
+
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+}
+
+// retryDelay honors Retry-After when the server sends it, and otherwise backs off exponentially with full jitter
+func (c *Client) retryDelay(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if d, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			return min(d, c.backoffMax())
+		}
+	}
+	ceiling := c.backoffBase() << attempt
+	if ceiling <= 0 || ceiling > c.backoffMax() {
+		ceiling = c.backoffMax()
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// parseRetryAfter understands both forms of Retry-After: a number of seconds, or an HTTP date
+func parseRetryAfter(val string, now time.Time) (time.Duration, bool) {
+	if val == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(val); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(val); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// baseURLs orders the replicas for key by rendezvous hashing: every replica is scored by a hash of itself and the key,
+// highest first.  Adding or removing a replica only moves the keys that replica wins.
+func (c *Client) baseURLs(key string) []string {
+	if len(c.Replicas) == 0 {
+		return []string{c.BaseURL}
+	}
+	type scored struct {
+		base  string
+		score uint64
+	}
+	ranked := make([]scored, 0, len(c.Replicas))
+	for _, r := range c.Replicas {
+		h := fnv.New64a()
+		_, _ = io.WriteString(h, r)
+		_, _ = h.Write([]byte{0})
+		_, _ = io.WriteString(h, key)
+		ranked = append(ranked, scored{base: r, score: h.Sum64()})
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].score != ranked[j].score {
+			return ranked[i].score > ranked[j].score
+		}
+		return ranked[i].base < ranked[j].base
+	})
+	ret := make([]string, 0, len(ranked))
+	for _, r := range ranked {
+		ret = append(ret, r.base)
+	}
+	return ret
+}
+
+// routingKey is the repo and path of an API path like /file/{repo}/{branch}/{path}, ignoring the query.  The branch is
+// left out so every branch of a file lands on the replica that already has the file's blobs.
+func routingKey(p string) string {
+	p, _, _ = strings.Cut(p, "?")
+	parts := strings.SplitN(strings.TrimPrefix(p, "/"), "/", 4)
+	if len(parts) < 2 {
+		return p
+	}
+	key := parts[1]
+	if len(parts) == 4 && parts[3] != "" {
+		key += "/" + parts[3]
+	}
+	return key
+}
+
+func escapePath(p string) string {
+	parts := strings.Split(strings.TrimPrefix(p, "/"), "/")
+	for i := range parts {
+		parts[i] = url.PathEscape(parts[i])
+	}
+	return strings.Join(parts, "/")
+}
@@ -0,0 +1,168 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_RetriesServerErrors(t *testing.T) {
+	var calls int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		require.Equal(t, "/file/repo/master/adir/a.txt", r.URL.Path)
+		_, _ = w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+	c := &Client{BaseURL: srv.URL, BackoffBase: time.Millisecond}
+	b, err := c.GetFile(context.Background(), "repo", "master", "adir/a.txt")
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(b))
+	require.Equal(t, int64(3), atomic.LoadInt64(&calls))
+}
+
+func TestClient_GivesUp(t *testing.T) {
+	var calls int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&calls, 1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+	c := &Client{BaseURL: srv.URL, BackoffBase: time.Millisecond}
+	_, err := c.GetFile(context.Background(), "repo", "master", "missing.txt")
+	require.True(t, IsNotFound(err))
+	require.Equal(t, int64(1), atomic.LoadInt64(&calls))
+}
+
+func TestClient_ConditionalRequests(t *testing.T) {
+	var fullResponses int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"abc"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		atomic.AddInt64(&fullResponses, 1)
+		w.Header().Set("ETag", `"abc"`)
+		_, _ = w.Write([]byte("content"))
+	}))
+	defer srv.Close()
+	c := &Client{BaseURL: srv.URL}
+	for i := 0; i < 2; i++ {
+		resp, err := c.Get(context.Background(), "/file/repo/master/a.txt")
+		require.NoError(t, err)
+		require.Equal(t, "content", string(resp.Body))
+		require.Equal(t, i == 1, resp.NotModified)
+	}
+	require.Equal(t, int64(1), atomic.LoadInt64(&fullResponses))
+
+	resp, err := c.GetIfNoneMatch(context.Background(), FilePath("repo", "master", "a.txt"), `"abc"`)
+	require.NoError(t, err)
+	require.True(t, resp.NotModified)
+	require.Empty(t, resp.Body)
+	resp, err = c.GetIfNoneMatch(context.Background(), FilePath("repo", "master", "a.txt"), "")
+	require.NoError(t, err)
+	require.False(t, resp.NotModified)
+	require.Equal(t, "content", string(resp.Body))
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	d, ok := parseRetryAfter("3", now)
+	require.True(t, ok)
+	require.Equal(t, time.Second*3, d)
+	d, ok = parseRetryAfter(now.Add(time.Minute).Format(http.TimeFormat), now)
+	require.True(t, ok)
+	require.Equal(t, time.Minute, d)
+	_, ok = parseRetryAfter("soon", now)
+	require.False(t, ok)
+}
+
+func TestClient_ReplicaRouting(t *testing.T) {
+	var hits [3]int64
+	replicas := make([]string, 0, len(hits))
+	for i := range hits {
+		i := i
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt64(&hits[i], 1)
+			_, _ = w.Write([]byte(r.URL.Path))
+		}))
+		defer srv.Close()
+		replicas = append(replicas, srv.URL)
+	}
+	c := &Client{Replicas: replicas}
+	first := c.baseURLs(routingKey("/file/repo/master/a.txt"))[0]
+	require.Equal(t, first, c.baseURLs(routingKey("/file/repo/other-branch/a.txt"))[0])
+	for i := 0; i < 5; i++ {
+		_, err := c.GetFile(context.Background(), "repo", "master", "a.txt")
+		require.NoError(t, err)
+	}
+	require.ElementsMatch(t, []int64{0, 0, 5}, hits[:])
+
+	used := make(map[string]struct{})
+	for i := 0; i < 50; i++ {
+		used[c.baseURLs(routingKey(fmt.Sprintf("/file/repo/master/%d.txt", i)))[0]] = struct{}{}
+	}
+	require.Len(t, used, len(replicas))
+}
+
+func TestClient_ReplicaFailover(t *testing.T) {
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer bad.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello"))
+	}))
+	defer good.Close()
+	c := &Client{Replicas: []string{bad.URL, good.URL}, BackoffBase: time.Millisecond, MaxRetries: 1}
+	for i := 0; i < 20; i++ {
+		b, err := c.GetFile(context.Background(), "repo", "master", fmt.Sprintf("%d.txt", i))
+		require.NoError(t, err)
+		require.Equal(t, "hello", string(b))
+	}
+}
+
+func TestRoutingKey(t *testing.T) {
+	require.Equal(t, "repo/adir/a.txt", routingKey("/file/repo/master/adir/a.txt"))
+	require.Equal(t, "repo", routingKey("/zip/repo/master/?dirs=a,b"))
+	require.Equal(t, "repo", routingKey("/bundle/repo/master"))
+}
+
+func TestMirror_Sync(t *testing.T) {
+	var downloads int64
+	commit := "c1"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/snapshot/repo/master":
+			_, _ = fmt.Fprintf(w, `{"Commit":%q,"Files":[{"Path":"a.txt","Hash":"h1","URL":"/file/repo/%s/a.txt"}]}`, commit, commit)
+		default:
+			atomic.AddInt64(&downloads, 1)
+			_, _ = w.Write([]byte("content"))
+		}
+	}))
+	defer srv.Close()
+	m := &Mirror{Client: &Client{BaseURL: srv.URL}, Repo: "repo", Branch: "master"}
+	_, err := m.ReadFile("a.txt")
+	require.ErrorIs(t, err, os.ErrNotExist)
+	require.NoError(t, m.Sync(context.Background()))
+	content, err := m.ReadFile("a.txt")
+	require.NoError(t, err)
+	require.Equal(t, "content", string(content))
+	require.Equal(t, "c1", m.Commit())
+
+	// Unchanged blobs are not downloaded again
+	commit = "c2"
+	require.NoError(t, m.Sync(context.Background()))
+	require.Equal(t, "c2", m.Commit())
+	require.Equal(t, int64(1), atomic.LoadInt64(&downloads))
+}
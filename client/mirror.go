@@ -0,0 +1,79 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Mirror keeps every file of a branch in memory, so an agent can serve reads while the server is unreachable.  Sync
+// downloads only the blobs that changed since the last sync.
+type Mirror struct {
+	Client *Client
+	Repo   string
+	Branch string
+
+	mu       sync.RWMutex
+	snapshot *Snapshot
+	// Keyed by path
+	files map[string][]byte
+}
+
+// Sync brings the mirror up to date with the branch.  On error the mirror keeps serving the previous sync.
+func (m *Mirror) Sync(ctx context.Context) error {
+	snap, err := m.Client.Snapshot(ctx, m.Repo, m.Branch)
+	if err != nil {
+		return err
+	}
+	m.mu.RLock()
+	prev, prevFiles := m.snapshot, m.files
+	m.mu.RUnlock()
+	if prev != nil && prev.Commit != "" && prev.Commit == snap.Commit {
+		return nil
+	}
+	held := make(map[string][]byte)
+	if prev != nil {
+		for _, f := range prev.Files {
+			held[f.Hash] = prevFiles[f.Path]
+		}
+	}
+	files := make(map[string][]byte, len(snap.Files))
+	for _, f := range snap.Files {
+		content, exists := held[f.Hash]
+		if !exists {
+			resp, err := m.Client.Get(ctx, f.URL)
+			if err != nil {
+				return fmt.Errorf("unable to download %s: %w", f.Path, err)
+			}
+			content = resp.Body
+			held[f.Hash] = content
+		}
+		files[f.Path] = content
+	}
+	m.mu.Lock()
+	m.snapshot, m.files = snap, files
+	m.mu.Unlock()
+	return nil
+}
+
+// Commit is the commit of the last sync, or empty before the first
+func (m *Mirror) Commit() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.snapshot == nil {
+		return ""
+	}
+	return m.snapshot.Commit
+}
+
+// ReadFile returns p as of the last sync, or an error matching os.ErrNotExist if it was not in the branch
+func (m *Mirror) ReadFile(p string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	content, exists := m.files[p]
+	if !exists {
+		return nil, fmt.Errorf("%s: %w", p, os.ErrNotExist)
+	}
+	return content, nil
+}
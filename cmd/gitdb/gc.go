@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"runtime"
+	"runtime/debug"
+	"runtime/metrics"
+
+	"github.com/cresta/gitdb/internal/log"
+	"go.uber.org/zap"
+)
+
+// setupGC applies the GC config and returns the ballast, which the caller must keep referenced.  A ballast is a large
+// allocation that is never touched, so it costs no resident memory but raises the heap size the GC paces against.
+func setupGC(cfg config, logger *log.Logger) []byte {
+	if cfg.GCPercent != 0 {
+		old := debug.SetGCPercent(cfg.GCPercent)
+		logger.Info(context.Background(), "set GC percent", zap.Int("gc_percent", cfg.GCPercent), zap.Int("old", old))
+	}
+	if cfg.MemoryLimit > 0 {
+		old := debug.SetMemoryLimit(cfg.MemoryLimit)
+		logger.Info(context.Background(), "set memory limit", zap.Int64("memory_limit", cfg.MemoryLimit), zap.Int64("old", old))
+	}
+	if cfg.BallastBytes <= 0 {
+		return nil
+	}
+	logger.Info(context.Background(), "allocating heap ballast", zap.Int64("bytes", cfg.BallastBytes))
+	return make([]byte, cfg.BallastBytes)
+}
+
+type heapStats struct {
+	HeapAlloc     uint64
+	HeapInuse     uint64
+	HeapSys       uint64
+	NextGC        uint64
+	NumGC         uint32
+	PauseTotalNs  uint64
+	GCCPUFraction float64
+	GCPercent     uint64
+	MemoryLimit   uint64
+	BallastBytes  int64
+}
+
+// heapStatsVar reports heap usage and the current GC settings on the debug server's /debug/vars
+func heapStatsVar(cfg config) expvar.Var {
+	return expvar.Func(func() interface{} {
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		settings := []metrics.Sample{{Name: "/gc/gogc:percent"}, {Name: "/gc/gomemlimit:bytes"}}
+		metrics.Read(settings)
+		return heapStats{
+			HeapAlloc:     ms.HeapAlloc,
+			HeapInuse:     ms.HeapInuse,
+			HeapSys:       ms.HeapSys,
+			NextGC:        ms.NextGC,
+			NumGC:         ms.NumGC,
+			PauseTotalNs:  ms.PauseTotalNs,
+			GCCPUFraction: ms.GCCPUFraction,
+			GCPercent:     settings[0].Value.Uint64(),
+			MemoryLimit:   settings[1].Value.Uint64(),
+			BallastBytes:  cfg.BallastBytes,
+		}
+	})
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/json"
+	"runtime/debug"
+	"testing"
+
+	"github.com/cresta/gitdb/internal/testhelp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetupGC(t *testing.T) {
+	oldPercent := debug.SetGCPercent(100)
+	oldLimit := debug.SetMemoryLimit(-1)
+	defer func() {
+		debug.SetGCPercent(oldPercent)
+		debug.SetMemoryLimit(oldLimit)
+	}()
+	logger := testhelp.ZapTestingLogger(t)
+	require.Nil(t, setupGC(config{}, logger))
+
+	cfg := config{GCPercent: 150, MemoryLimit: 1 << 40, BallastBytes: 1 << 20}
+	ballast := setupGC(cfg, logger)
+	require.Len(t, ballast, 1<<20)
+	require.Equal(t, 150, debug.SetGCPercent(150))
+	require.Equal(t, int64(1<<40), debug.SetMemoryLimit(-1))
+
+	var stats heapStats
+	require.NoError(t, json.Unmarshal([]byte(heapStatsVar(cfg).String()), &stats))
+	require.Equal(t, uint64(150), stats.GCPercent)
+	require.Equal(t, uint64(1<<40), stats.MemoryLimit)
+	require.Equal(t, int64(1<<20), stats.BallastBytes)
+	require.NotZero(t, stats.HeapSys)
+}
@@ -4,40 +4,120 @@ import (
 	"context"
 	"crypto/rsa"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/cresta/gitdb/internal/gitdb"
 	"github.com/cresta/gitdb/internal/gitdb/goget"
+	"github.com/cresta/gitdb/internal/gitdb/metrics"
+	"github.com/cresta/gitdb/internal/gitdb/rediscache"
+	"github.com/cresta/gitdb/internal/gitdb/repoprovider/bitbucket"
 	"github.com/cresta/gitdb/internal/gitdb/repoprovider/github"
+	"github.com/cresta/gitdb/internal/gitdb/repoprovider/gitlab"
 	"github.com/cresta/gitdb/internal/gitdb/tracing"
-	"github.com/cresta/gitdb/internal/gitdb/tracing/datadog"
+	// Registers the prometheus and statsd metrics backends
+	_ "github.com/cresta/gitdb/internal/gitdb/metrics/prometheus"
+	_ "github.com/cresta/gitdb/internal/gitdb/metrics/statsd"
+	// Registers the datadog tracer
+	_ "github.com/cresta/gitdb/internal/gitdb/tracing/datadog"
 	"github.com/cresta/gitdb/internal/httpserver"
 	"github.com/cresta/gitdb/internal/log"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gorilla/mux"
+	"github.com/joho/godotenv"
 	"github.com/signalfx/golib/v3/httpdebug"
 	"go.uber.org/zap"
 )
 
 type config struct {
-	ListenAddr          string
-	DataDirectory       string
-	DebugListenAddr     string
-	GithubPushToken     string
-	RepoConfig          string
-	Tracer              string
-	JWTPrivateKey       string
-	JWTPrivateKeyPasswd string
-	JWTPublicKey        string
-	JWTSignInUsername   string
-	JWTSignInPassword   string
+	ListenAddr           string
+	DataDirectory        string
+	StaleDirInterval     time.Duration
+	StaleDirDryRun       bool
+	DebugListenAddr      string
+	DebugToken           string
+	DebugUsername        string
+	DebugPassword        string
+	GithubPushToken      string
+	GitlabPushToken      string
+	BitbucketSecret      string
+	WebhookBaseURL       string
+	GithubAPIToken       string
+	GithubAPIURL         string
+	GitlabAPIToken       string
+	GitlabAPIURL         string
+	RepoConfig           string
+	Tracer               string
+	Metrics              string
+	JWTPrivateKey        string
+	JWTPrivateKeyPasswd  string
+	JWTPublicKey         string
+	JWTSignInUsername    string
+	JWTSignInPassword    string
+	MaxHeaderBytes       int
+	MaxBodyBytes         int64
+	RedisURL             string
+	RedisTTL             time.Duration
+	RedisMaxObjectBytes  int
+	RedisTimeout         time.Duration
+	RouteTimeouts        map[string]time.Duration
+	DefaultRouteTimeout  time.Duration
+	RouteMaxBytes        map[string]int64
+	DefaultRouteMaxBytes int64
+	MaxArchiveBytes      int64
+	RepoKeyNaming        string
+	ProxyUpstream        string
+	ProxyToken           string
+	TrustedProxies       string
+	ErrorFormat          string
+	ErrorTemplate        string
+	ErrorContact         string
+	AdminToken           string
+	WriteToken           string
+	WriteJWT             bool
+	LogEncoding          string
+	LogLevel             string
+	LogSampleInitial     int
+	LogSampleThereafter  int
+	AccessLogSampleRate  float64
+	AccessLogExclude     string
+	DrainDelay           time.Duration
+	DrainTimeout         time.Duration
+	ReadyMaxStaleness    time.Duration
+	GCPercent            int
+	MemoryLimit          int64
+	BallastBytes         int64
+	VerifyURL            string
+	VerifyTimeout        time.Duration
+	PreflightTimeout     time.Duration
+	TokenAPIKeys         string
+	TokenDefaultTTL      time.Duration
+	TokenMaxTTL          time.Duration
+	OPAURL               string
+	OPATimeout           time.Duration
+	OPAPolicyFiles       string
+	BootstrapURL         string
+	CacheBytes           int64
+	GzipCacheBytes       int64
+	SimulateChanges      bool
+	RefreshWorkers       int
+	RefreshNiceness      int
+	RepoConfigPoll       time.Duration
+	RefJournalSize       int
 }
 
 func (c config) WithDefaults() config {
+	if c.Metrics == "" {
+		c.Metrics = "prometheus"
+	}
 	if c.ListenAddr == "" {
 		c.ListenAddr = ":8080"
 	}
@@ -45,27 +125,327 @@ func (c config) WithDefaults() config {
 		c.DataDirectory = os.TempDir()
 	}
 	if c.DebugListenAddr == "" {
-		c.DebugListenAddr = ":6060"
+		c.DebugListenAddr = "localhost:6060"
+	}
+	if c.MaxHeaderBytes == 0 {
+		c.MaxHeaderBytes = http.DefaultMaxHeaderBytes
+	}
+	if c.MaxBodyBytes == 0 {
+		c.MaxBodyBytes = 25 << 20
+	}
+	if c.LogEncoding == "" {
+		c.LogEncoding = "json"
+	}
+	if c.LogLevel == "" {
+		c.LogLevel = "info"
+	}
+	if c.LogSampleInitial == 0 {
+		c.LogSampleInitial = 100
+	}
+	if c.LogSampleThereafter == 0 {
+		c.LogSampleThereafter = 100
+	}
+	if c.AccessLogSampleRate == 0 {
+		c.AccessLogSampleRate = 1
+	}
+	if c.DrainDelay == 0 {
+		c.DrainDelay = time.Second * 5
+	}
+	if c.DrainTimeout == 0 {
+		c.DrainTimeout = time.Second * 30
+	}
+	if c.TokenDefaultTTL == 0 {
+		c.TokenDefaultTTL = time.Minute * 15
+	}
+	if c.TokenMaxTTL == 0 {
+		c.TokenMaxTTL = time.Hour
+	}
+	if c.CacheBytes == 0 {
+		c.CacheBytes = 64 << 20
+	}
+	if c.RefreshWorkers == 0 {
+		c.RefreshWorkers = 2
+	}
+	if c.RefJournalSize == 0 {
+		c.RefJournalSize = 1000
+	}
+	if c.RefreshNiceness == 0 {
+		c.RefreshNiceness = 10
+	}
+	if c.OPATimeout == 0 {
+		c.OPATimeout = time.Second * 5
+	}
+	if c.VerifyURL == "" {
+		c.VerifyURL = "http://localhost:8080"
+	}
+	if c.VerifyTimeout == 0 {
+		c.VerifyTimeout = time.Minute * 5
+	}
+	if c.PreflightTimeout == 0 {
+		c.PreflightTimeout = time.Second * 30
+	}
+	if c.RouteTimeouts == nil {
+		c.RouteTimeouts = map[string]time.Duration{
+			"get_file_handler":        time.Second * 5,
+			"public_get_file_handler": time.Second * 5,
+			"zip_dir_handler":         time.Second * 120,
+			"public_zip_dir_handler":  time.Second * 120,
+			"tar_dir_handler":         time.Second * 120,
+			"public_tar_dir_handler":  time.Second * 120,
+		}
 	}
 	return c
 }
 
+// envDuration parses a duration environment variable like "90s".  Unset or invalid values return 0 so the default is
+// used.
+func envDuration(name string) time.Duration {
+	ret, err := time.ParseDuration(os.Getenv(name))
+	if err != nil {
+		return 0
+	}
+	return ret
+}
+
+// envDurationMap parses a list like "get_file_handler=5s,zip_dir_handler=2m".  Unset values return nil so the default
+// is used.  Invalid entries are ignored.
+func envDurationMap(name string) map[string]time.Duration {
+	val := os.Getenv(name)
+	if val == "" {
+		return nil
+	}
+	ret := make(map[string]time.Duration)
+	for _, part := range strings.Split(val, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			continue
+		}
+		ret[k] = d
+	}
+	return ret
+}
+
+// envInt64Map parses a list like "zip_dir_handler=1073741824,tar_dir_handler=1073741824".  Unset values return nil.
+// Invalid entries are ignored.
+func envInt64Map(name string) map[string]int64 {
+	val := os.Getenv(name)
+	if val == "" {
+		return nil
+	}
+	ret := make(map[string]int64)
+	for _, part := range strings.Split(val, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			continue
+		}
+		ret[k] = n
+	}
+	return ret
+}
+
+// envBool parses a boolean environment variable like "true".  Unset or invalid values return false.
+func envBool(name string) bool {
+	ret, err := strconv.ParseBool(os.Getenv(name))
+	if err != nil {
+		return false
+	}
+	return ret
+}
+
+// envFloat64 parses a number environment variable like "0.01".  Unset or invalid values return 0 so the default is
+// used.
+func envFloat64(name string) float64 {
+	ret, err := strconv.ParseFloat(os.Getenv(name), 64)
+	if err != nil {
+		return 0
+	}
+	return ret
+}
+
+// envInt64 parses an integer environment variable.  Unset or invalid values return 0 so the default is used.
+func envInt64(name string) int64 {
+	ret, err := strconv.ParseInt(os.Getenv(name), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return ret
+}
+
 func getConfig() config {
 	return config{
 		// Defaults to ":8080"
 		ListenAddr:    os.Getenv("LISTEN_ADDR"),
 		DataDirectory: os.Getenv("DATA_DIRECTORY"),
-		// Defaults to ":6060"
+		// Optional: remove checkout directories under DATA_DIRECTORY that no configured repo uses, like clones left by
+		// earlier runs, at startup and then this often.  Only set when no other process shares DATA_DIRECTORY
+		StaleDirInterval: envDuration("GITDB_STALE_DIR_INTERVAL"),
+		// Only log the directories GITDB_STALE_DIR_INTERVAL would remove
+		StaleDirDryRun: envBool("GITDB_STALE_DIR_DRY_RUN"),
+		// Defaults to "localhost:6060".  Set to "-" to disable
 		DebugListenAddr: os.Getenv("GITDB_DEBUG_ADDR"),
 		Tracer:          os.Getenv("GITDB_TRACER"),
-		RepoConfig:      os.Getenv("GITDB_REPO_CONFIG"),
+		// "prometheus" (the default), "statsd" or "noop"
+		Metrics:    os.Getenv("GITDB_METRICS"),
+		RepoConfig: os.Getenv("GITDB_REPO_CONFIG"),
+		// Optional: how often to check GITDB_REPO_CONFIG for changes, like "30s", and reload it.  SIGHUP always reloads
+		// it
+		RepoConfigPoll: envDuration("GITDB_REPO_CONFIG_POLL"),
+
+		// Optional: require a bearer token, or basic auth, on the debug server
+		DebugToken:    os.Getenv("GITDB_DEBUG_TOKEN"),
+		DebugUsername: os.Getenv("GITDB_DEBUG_USERNAME"),
+		DebugPassword: os.Getenv("GITDB_DEBUG_PASSWORD"),
 
 		GithubPushToken:     os.Getenv("GITHUB_PUSH_TOKEN"),
+		GitlabPushToken:     os.Getenv("GITLAB_PUSH_TOKEN"),
+		BitbucketSecret:     os.Getenv("BITBUCKET_WEBHOOK_SECRET"),
 		JWTPrivateKey:       os.Getenv("GITDB_JWT_PRIVATE_KEY"),
 		JWTPrivateKeyPasswd: os.Getenv("GITDB_JWT_PRIVATE_KEY_PASSWD"),
 		JWTPublicKey:        os.Getenv("GITDB_JWT_PUBLIC_KEY"),
 		JWTSignInUsername:   os.Getenv("GITDB_JWT_SIGNIN_USERNAME"),
 		JWTSignInPassword:   os.Getenv("GITDB_JWT_SIGNIN_PASSWORD"),
+
+		// Optional: at startup, point the push webhook of each GitHub or GitLab repository at this server, like
+		// "https://gitdb.example.com", using the API token of the provider.  Its push token is the hook's secret
+		WebhookBaseURL: os.Getenv("GITDB_WEBHOOK_BASE_URL"),
+		GithubAPIToken: os.Getenv("GITDB_GITHUB_API_TOKEN"),
+		// Defaults to api.github.com.  Set for GitHub Enterprise, like "https://github.example.com/api/v3/"
+		GithubAPIURL:   os.Getenv("GITDB_GITHUB_API_URL"),
+		GitlabAPIToken: os.Getenv("GITDB_GITLAB_API_TOKEN"),
+		// Defaults to "https://gitlab.com"
+		GitlabAPIURL: os.Getenv("GITDB_GITLAB_API_URL"),
+
+		// Defaults to http.DefaultMaxHeaderBytes
+		MaxHeaderBytes: int(envInt64("GITDB_MAX_HEADER_BYTES")),
+		// Defaults to 25MB, the largest payload GitHub sends for webhooks
+		MaxBodyBytes: envInt64("GITDB_MAX_BODY_BYTES"),
+
+		// Optional: share blob and listing caches between replicas
+		RedisURL:            os.Getenv("GITDB_REDIS_URL"),
+		RedisTTL:            envDuration("GITDB_REDIS_TTL"),
+		RedisMaxObjectBytes: int(envInt64("GITDB_REDIS_MAX_OBJECT_BYTES")),
+		RedisTimeout:        envDuration("GITDB_REDIS_TIMEOUT"),
+
+		// Per route timeouts, keyed by mux route name.  Defaults to 5s for /file and 120s for /zip and /tar
+		RouteTimeouts: envDurationMap("GITDB_ROUTE_TIMEOUTS"),
+		// Timeout for routes not listed in GITDB_ROUTE_TIMEOUTS.  Defaults to no timeout
+		DefaultRouteTimeout: envDuration("GITDB_DEFAULT_ROUTE_TIMEOUT"),
+		// Optional: per route response size limits in bytes, keyed by mux route name, like "zip_dir_handler=1073741824".
+		// A response growing past its limit is cut off.  Unlimited by default
+		RouteMaxBytes: envInt64Map("GITDB_ROUTE_MAX_BYTES"),
+		// Optional: response size limit for routes not listed in GITDB_ROUTE_MAX_BYTES
+		DefaultRouteMaxBytes: envInt64("GITDB_DEFAULT_ROUTE_MAX_BYTES"),
+		// Optional: most bytes a zip may have.  Zips are streamed to the client, so a larger one is cut off.  Unlimited
+		// by default
+		MaxArchiveBytes: envInt64("GITDB_MAX_ARCHIVE_BYTES"),
+		// Optional: how repositories without an Alias are keyed from their URL: "name" (the default) for "repo",
+		// "org_repo" for "org_repo", or "path" for "org/repo"
+		RepoKeyNaming: os.Getenv("GITDB_REPO_KEY_NAMING"),
+		// Optional: run as a caching proxy of another gitdb, like "http://gitdb.central:8080", serving /file and /ls
+		// without cloning anything.  Content is cached by hash in GITDB_CACHE_BYTES.  Comma separated URLs of
+		// replicas spread reads across them
+		ProxyUpstream: os.Getenv("GITDB_PROXY_UPSTREAM"),
+		// Optional: bearer token sent to GITDB_PROXY_UPSTREAM
+		ProxyToken: os.Getenv("GITDB_PROXY_TOKEN"),
+		// Comma separated CIDRs of load balancers allowed to set X-Forwarded-For and X-Real-IP
+		TrustedProxies: os.Getenv("GITDB_TRUSTED_PROXIES"),
+		// Optional: "plain", "json" or "html" bodies for 403 and 404 responses, in place of each handler's own.  Unset
+		// keeps the handler's body unless GITDB_ERROR_TEMPLATE or GITDB_ERROR_CONTACT is set, which default to plain
+		ErrorFormat: os.Getenv("GITDB_ERROR_FORMAT"),
+		// Optional: file with a Go template of the error body, executed with httpserver.ErrorPageData
+		ErrorTemplate: os.Getenv("GITDB_ERROR_TEMPLATE"),
+		// Optional: who to contact about errors, like an email or a chat channel, shown in error bodies
+		ErrorContact: os.Getenv("GITDB_ERROR_CONTACT"),
+		// Bearer token for /admin endpoints.  Admin endpoints are disabled when unset
+		AdminToken: os.Getenv("GITDB_ADMIN_TOKEN"),
+		// Bearer token for PUT and DELETE /file, which commit and push upstream.  Writes are disabled unless this or
+		// WriteJWT is set
+		WriteToken: os.Getenv("GITDB_WRITE_TOKEN"),
+		// Authenticate writes with JWTs signed by GITDB_JWT_PUBLIC_KEY instead.  Tokens need a true "write" claim, are
+		// limited to their repo and path_prefix claims, and commit as their name and email
+		WriteJWT: envBool("GITDB_WRITE_JWT"),
+
+		// "json" (the default) or "console"
+		LogEncoding: os.Getenv("GITDB_LOG_ENCODING"),
+		// Defaults to "info"
+		LogLevel: os.Getenv("GITDB_LOG_LEVEL"),
+		// Per second, log the first N entries with the same message, then every Mth.  Defaults to 100 and 100.  A
+		// negative initial value disables sampling
+		LogSampleInitial:    int(envInt64("GITDB_LOG_SAMPLE_INITIAL")),
+		LogSampleThereafter: int(envInt64("GITDB_LOG_SAMPLE_THEREAFTER")),
+		// Fraction of successful requests with an access log, like 0.01.  Requests answered with an error are always
+		// logged.  Defaults to 1.  Negative logs none
+		AccessLogSampleRate: envFloat64("GITDB_ACCESS_LOG_SAMPLE_RATE"),
+		// Comma separated paths without an access log, on top of the health and metrics probes.  A trailing * matches
+		// every path with the prefix, like /public/*
+		AccessLogExclude: os.Getenv("GITDB_ACCESS_LOG_EXCLUDE"),
+
+		// On SIGTERM or SIGINT, how long /ready and /health fail before shutdown starts.  Defaults to 5s
+		DrainDelay: envDuration("GITDB_DRAIN_DELAY"),
+		// How long shutdown waits for in flight requests before closing connections.  Defaults to 30s
+		DrainTimeout: envDuration("GITDB_DRAIN_TIMEOUT"),
+		// /ready and /health fail if any repo has not fetched successfully within this long.  Unset only waits for
+		// repos to be cloned
+		ReadyMaxStaleness: envDuration("GITDB_READY_MAX_STALENESS"),
+
+		// Like GOGC, but from config.  Unset leaves the runtime default
+		GCPercent: int(envInt64("GITDB_GC_PERCENT")),
+		// Soft memory limit in bytes, like GOMEMLIMIT.  Unset leaves the runtime default
+		MemoryLimit: envInt64("GITDB_MEMORY_LIMIT"),
+		// Size of a heap ballast that makes the GC run less often on small heaps.  Unset allocates no ballast
+		BallastBytes: envInt64("GITDB_BALLAST_BYTES"),
+
+		// Server "gitdb verify" checks.  Defaults to http://localhost:8080
+		VerifyURL:     os.Getenv("GITDB_VERIFY_URL"),
+		VerifyTimeout: envDuration("GITDB_VERIFY_TIMEOUT"),
+		// How long "gitdb --preflight" waits on each remote.  Defaults to 30s
+		PreflightTimeout: envDuration("GITDB_PREFLIGHT_TIMEOUT"),
+
+		// Comma separated keys that may exchange for a scoped token at /public/token without a JWT
+		TokenAPIKeys: os.Getenv("GITDB_TOKEN_API_KEYS"),
+		// Lifetime of exchanged tokens when the request has no TTL.  Defaults to 15m
+		TokenDefaultTTL: envDuration("GITDB_TOKEN_DEFAULT_TTL"),
+		// Longest lifetime an exchanged token may have.  Defaults to 1h
+		TokenMaxTTL: envDuration("GITDB_TOKEN_MAX_TTL"),
+
+		// Optional: OPA decision to consult before serving content, like http://localhost:8181/v1/data/gitdb/allow
+		OPAURL: os.Getenv("GITDB_OPA_URL"),
+		// Defaults to 5s
+		OPATimeout: envDuration("GITDB_OPA_TIMEOUT"),
+		// Optional: comma separated Rego files loaded into the OPA server at startup, so the policy ships with gitdb
+		OPAPolicyFiles: os.Getenv("GITDB_OPA_POLICY_FILES"),
+
+		// Optional: bootstrap repositories from a bundle at this URL, like a peer's http://gitdb-0.gitdb:8080/bundle/{repo},
+		// instead of cloning them upstream
+		BootstrapURL: os.Getenv("GITDB_BOOTSTRAP_URL"),
+
+		// Bytes of file content cached in memory by commit and path.  Defaults to 64MB.  Negative disables the cache
+		CacheBytes: envInt64("GITDB_CACHE_BYTES"),
+
+		// Optional: bytes of gzip compressed text files cached in memory, to serve clients accepting gzip.  Hot files are
+		// compressed again on refresh.  Unset serves files uncompressed
+		GzipCacheBytes: envInt64("GITDB_GZIP_CACHE_BYTES"),
+
+		// Threads that run refreshes, so at most this many fetch at once.  Defaults to 2.  Negative refreshes on the
+		// goroutine asking, like a webhook's
+		RefreshWorkers: int(envInt64("GITDB_REFRESH_WORKERS")),
+		// How much lower than reads refresh threads are prioritized, from 1 to 19 like nice.  Defaults to 10.  Linux only
+		RefreshNiceness: int(envInt64("GITDB_REFRESH_NICENESS")),
+
+		// Branch updates remembered for GET /admin/refs, to tell when a commit started being served.  Defaults to 1000.
+		// Negative remembers none
+		RefJournalSize: int(envInt64("GITDB_REF_JOURNAL_SIZE")),
+
+		// Testing only: serve POST /simulate/{repo}/{branch}, which commits to a branch as if it had been pushed
+		SimulateChanges: envBool("GITDB_SIMULATE_CHANGES"),
 	}.WithDefaults()
 }
 
@@ -76,9 +456,37 @@ type RepoConfig struct {
 type Repository = gitdb.Repository
 
 func main() {
+	if err := loadEnvFile(); err != nil {
+		// Logging is configured from the environment, so is not set up yet
+		fmt.Fprintln(os.Stderr, "Unable to load env file:", err)
+		os.Exit(1)
+	}
+	instance.config = getConfig()
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		os.Exit(runVerify(instance.config, os.Stdout))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "--preflight" {
+		os.Exit(instance.runPreflight(instance.config, os.Stdout))
+	}
 	instance.Main()
 }
 
+// loadEnvFile reads GITDB_ENV_FILE, or .env if it exists, into the environment.  Variables already set in the
+// environment take precedence.
+func loadEnvFile() error {
+	envFile := os.Getenv("GITDB_ENV_FILE")
+	if envFile == "" {
+		if _, err := os.Stat(".env"); err != nil {
+			return nil
+		}
+		envFile = ".env"
+	}
+	if err := godotenv.Load(envFile); err != nil {
+		return fmt.Errorf("unable to load %s: %w", envFile, err)
+	}
+	return nil
+}
+
 type Service struct {
 	osExit     func(int)
 	config     config
@@ -86,27 +494,56 @@ type Service struct {
 	onListen   func(net.Listener)
 	server     *http.Server
 	tracers    *tracing.Registry
+	metrics    *metrics.Registry
 	repoConfig *RepoConfig
+	ballast    []byte
 }
 
 var instance = Service{
-	osExit: os.Exit,
-	config: getConfig(),
-	tracers: &tracing.Registry{
-		Constructors: map[string]tracing.Constructor{
-			"datadog": datadog.NewTracer,
-		},
-	},
+	osExit:  os.Exit,
+	tracers: tracing.DefaultRegistry,
+	metrics: metrics.DefaultRegistry,
 }
 
-func setupLogging() (*log.Logger, error) {
-	l, err := zap.NewProduction()
+func setupLogging(cfg config) (*log.Logger, error) {
+	zapCfg, err := logConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	l, err := zapCfg.Build()
 	if err != nil {
 		return nil, err
 	}
 	return log.New(l), nil
 }
 
+// logConfig is the production zap config with the encoding, level and sampling of cfg
+func logConfig(cfg config) (zap.Config, error) {
+	zapCfg := zap.NewProductionConfig()
+	level, err := zap.ParseAtomicLevel(cfg.LogLevel)
+	if err != nil {
+		return zap.Config{}, fmt.Errorf("unable to parse log level %s: %w", cfg.LogLevel, err)
+	}
+	zapCfg.Level = level
+	switch cfg.LogEncoding {
+	case "json":
+	case "console":
+		zapCfg.Encoding = "console"
+		zapCfg.EncoderConfig = zap.NewDevelopmentEncoderConfig()
+	default:
+		return zap.Config{}, fmt.Errorf("unknown log encoding %s", cfg.LogEncoding)
+	}
+	if cfg.LogSampleInitial < 0 {
+		zapCfg.Sampling = nil
+	} else {
+		zapCfg.Sampling = &zap.SamplingConfig{
+			Initial:    cfg.LogSampleInitial,
+			Thereafter: cfg.LogSampleThereafter,
+		}
+	}
+	return zapCfg, nil
+}
+
 func (m *Service) loadRepoConfig(cfg config) (RepoConfig, error) {
 	if m.repoConfig != nil {
 		return *m.repoConfig, nil
@@ -118,9 +555,13 @@ func (m *Service) loadRepoConfig(cfg config) (RepoConfig, error) {
 	if err != nil {
 		return RepoConfig{}, fmt.Errorf("unable to read file %s: %w", cfg.RepoConfig, err)
 	}
+	return parseRepoConfig(cfg.RepoConfig, b)
+}
+
+func parseRepoConfig(fileName string, b []byte) (RepoConfig, error) {
 	var ret RepoConfig
 	if err := json.Unmarshal(b, &ret); err != nil {
-		return RepoConfig{}, fmt.Errorf("unable to json unmarshal content of %s: %w", cfg.RepoConfig, err)
+		return RepoConfig{}, fmt.Errorf("unable to json unmarshal content of %s: %w", fileName, err)
 	}
 	return ret, nil
 }
@@ -129,7 +570,7 @@ func (m *Service) Main() {
 	cfg := m.config
 	if m.log == nil {
 		var err error
-		m.log, err = setupLogging()
+		m.log, err = setupLogging(cfg)
 		if err != nil {
 			fmt.Printf("Unable to run setup logging: %v", err)
 			m.osExit(1)
@@ -137,6 +578,7 @@ func (m *Service) Main() {
 		}
 	}
 	m.log.Info(context.Background(), "Starting")
+	m.ballast = setupGC(cfg, m.log)
 	rootTracer, err := m.tracers.New(m.config.Tracer, tracing.Config{
 		Log: m.log.With(zap.String("section", "setup_tracing")),
 		Env: os.Environ(),
@@ -147,6 +589,20 @@ func (m *Service) Main() {
 		return
 	}
 
+	rootMetrics, err := m.metrics.New(cfg.Metrics, metrics.Config{
+		Log: m.log.With(zap.String("section", "setup_metrics")),
+		Env: os.Environ(),
+	})
+	if err != nil {
+		m.log.IfErr(err).Error(context.Background(), "unable to setup metrics")
+		m.osExit(1)
+		return
+	}
+	if cfg.ProxyUpstream != "" {
+		m.runProxy(cfg, rootTracer, rootMetrics)
+		return
+	}
+
 	repoConfig, err := m.loadRepoConfig(cfg)
 	if err != nil {
 		m.log.IfErr(err).Error(context.Background(), "unable to load repository config")
@@ -157,9 +613,40 @@ func (m *Service) Main() {
 	goget.WrapGitProtocols(rootTracer)
 	m.log = m.log.DynamicFields(rootTracer.DynamicFields()...)
 
+	sharedCache, closeSharedCache, err := setupSharedCache(cfg, m.log)
+	if err != nil {
+		m.log.IfErr(err).Error(context.Background(), "unable to setup shared cache")
+		m.osExit(1)
+		return
+	}
+	defer closeSharedCache()
+
+	refreshPool := setupRefreshPool(cfg, m.log)
+	defer refreshPool.Close()
+
+	authorizer, err := setupAuthorizer(cfg, rootTracer, m.log)
+	if err != nil {
+		m.log.IfErr(err).Error(context.Background(), "unable to setup authorizer")
+		m.osExit(1)
+		return
+	}
+
 	co, err := gitdb.NewHandler(m.log, gitdb.Config{
-		DataDirectory: cfg.DataDirectory,
-		Repos:         repoConfig.Repositories,
+		DataDirectory:  cfg.DataDirectory,
+		Repos:          repoConfig.Repositories,
+		SharedCache:    sharedCache,
+		BlobCacheBytes: cfg.CacheBytes,
+		GzipCacheBytes: cfg.GzipCacheBytes,
+		RefJournalSize: cfg.RefJournalSize,
+		Metrics:        rootMetrics,
+		RefreshPool:    refreshPool,
+		Authorizer:     authorizer,
+		BootstrapURL:   cfg.BootstrapURL,
+		// Bundles of large repositories take a while
+		BootstrapClient: tracing.NewHTTPClient(rootTracer, time.Minute*10),
+		SaveRepos:       m.repoConfigSaver(cfg),
+		MaxArchiveBytes: cfg.MaxArchiveBytes,
+		RepoKeyNaming:   gitdb.RepoKeyNaming(cfg.RepoKeyNaming),
 	}, rootTracer)
 	if err != nil {
 		m.log.IfErr(err).Panic(context.Background(), "unable to setup git server")
@@ -167,15 +654,22 @@ func (m *Service) Main() {
 		return
 	}
 	githubListener := github.Setup(cfg.GithubPushToken, m.log, co, rootTracer)
-	m.server = setupServer(cfg, m.log, rootTracer, co, githubListener, repoConfig)
-	shutdownCallback, err := setupDebugServer(m.log, cfg.DebugListenAddr, m)
+	gitlabListener := gitlab.Setup(cfg.GitlabPushToken, m.log, co, rootTracer)
+	bitbucketListener := bitbucket.Setup(cfg.BitbucketSecret, m.log, co, rootTracer)
+	drainer := &httpserver.Drainer{
+		Delay:   cfg.DrainDelay,
+		Timeout: cfg.DrainTimeout,
+		Log:     m.log.With(zap.String("section", "drain")),
+	}
+	m.server = setupServer(cfg, m.log, rootTracer, rootMetrics, co, githubListener, gitlabListener, bitbucketListener, repoConfig, drainer)
+	shutdownCallback, err := setupDebugServer(m.log, cfg, m, rootMetrics)
 	if err != nil {
 		m.log.IfErr(err).Panic(context.Background(), "unable to setup debug server")
 		m.osExit(1)
 		return
 	}
 
-	ln, err := net.Listen("tcp", m.server.Addr)
+	ln, err := listen(m.server.Addr, m.log)
 	if err != nil {
 		m.log.Panic(context.Background(), "unable to listen to port", zap.Error(err), zap.String("addr", m.server.Addr))
 		m.osExit(1)
@@ -184,19 +678,61 @@ func (m *Service) Main() {
 	if m.onListen != nil {
 		m.onListen(ln)
 	}
+	systemdCtx, stopSystemd := context.WithCancel(context.Background())
+	defer stopSystemd()
+	notifySystemd(systemdCtx, m.log)
 	onEnd := make(chan struct{})
+	providers := webhookProviders(githubListener, gitlabListener, bitbucketListener)
+	// Both config reloads and the admin API change the repositories
+	co.OnReload(func(checkouts map[string]gitdb.Checkout) {
+		for _, p := range providers {
+			p.SetCheckouts(checkouts)
+		}
+	})
+	go m.watchRepoConfig(onEnd, cfg, co)
+	// Cancelled on shutdown, interrupting a running refresh
+	refreshCtx, stopRefresh := context.WithCancel(context.Background())
+	defer stopRefresh()
+	refreshStopped := make(chan struct{})
 	go func() {
+		defer close(refreshStopped)
 		for {
 			select {
-			case <-onEnd:
+			case <-refreshCtx.Done():
 				return
 			case <-time.After(time.Second * 30):
-				refreshAllRepos(co.CheckoutsByRepo(), m.log)
+				refreshAllRepos(refreshCtx, co.CheckoutsByRepo(), m.log)
 			}
 		}
 	}()
+	go m.collectStaleDirs(refreshCtx, cfg, co)
+	go m.registerWebhooks(refreshCtx, cfg, co, rootTracer)
+	drained := make(chan struct{})
+	shutdownSignal := make(chan os.Signal, 1)
+	signal.Notify(shutdownSignal, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(shutdownSignal)
+	go func() {
+		defer close(drained)
+		var sig os.Signal
+		select {
+		case <-onEnd:
+			return
+		case sig = <-shutdownSignal:
+		}
+		m.log.Info(context.Background(), "shutting down", zap.Stringer("signal", sig))
+		stopSystemd()
+		stopRefresh()
+		m.log.IfErr(drainer.Drain(context.Background(), m.server)).Error(context.Background(), "unable to drain server")
+	}()
 	serveErr := m.server.Serve(ln)
+	if drainer.Draining() {
+		<-drained
+	}
 	close(onEnd)
+	stopRefresh()
+	<-refreshStopped
+	// Nothing is served or refreshed anymore
+	m.log.IfErr(co.Close()).Warn(context.Background(), "unable to close checkouts")
 	if serveErr != http.ErrServerClosed {
 		m.log.IfErr(serveErr).Error(context.Background(), "server existed")
 	}
@@ -207,18 +743,96 @@ func (m *Service) Main() {
 	}
 }
 
-func refreshAllRepos(checkouts map[string]*goget.GitCheckout, logger *log.Logger) {
-	ctx1 := context.Background()
-	ctx, onCancel := context.WithTimeout(ctx1, time.Second*60)
+func setupAuthorizer(cfg config, tracer tracing.Tracing, logger *log.Logger) (httpserver.Authorizer, error) {
+	if cfg.OPAURL == "" {
+		if cfg.OPAPolicyFiles != "" {
+			return nil, errors.New("GITDB_OPA_POLICY_FILES needs GITDB_OPA_URL")
+		}
+		logger.Info(context.Background(), "no opa url set, skipping authorizer")
+		return nil, nil
+	}
+	logger.Info(context.Background(), "opa authorizer enabled", zap.String("url", cfg.OPAURL))
+	ret := &httpserver.OPAAuthorizer{
+		URL:    cfg.OPAURL,
+		Client: tracing.NewHTTPClient(tracer, cfg.OPATimeout),
+	}
+	if files := splitNonEmpty(cfg.OPAPolicyFiles); len(files) > 0 {
+		if err := ret.LoadPolicies(context.Background(), files); err != nil {
+			return nil, err
+		}
+		logger.Info(context.Background(), "loaded opa policies", zap.Strings("files", files))
+	}
+	return ret, nil
+}
+
+func setupSharedCache(cfg config, logger *log.Logger) (goget.SharedCache, func(), error) {
+	if cfg.RedisURL == "" {
+		logger.Info(context.Background(), "no redis url set, skipping shared cache")
+		return nil, func() {}, nil
+	}
+	c, err := rediscache.New(rediscache.Config{
+		URL:            cfg.RedisURL,
+		TTL:            cfg.RedisTTL,
+		MaxObjectBytes: cfg.RedisMaxObjectBytes,
+		Timeout:        cfg.RedisTimeout,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to create redis cache: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	if err := c.Ping(ctx); err != nil {
+		logger.IfErr(c.Close()).Warn(ctx, "unable to close redis cache")
+		return nil, nil, fmt.Errorf("unable to ping redis: %w", err)
+	}
+	logger.Info(ctx, "redis shared cache enabled")
+	return c, func() {
+		logger.IfErr(c.Close()).Warn(context.Background(), "unable to close redis cache")
+	}, nil
+}
+
+// setupRefreshPool starts the threads refreshes run on, or returns nil to refresh on the goroutine asking
+func setupRefreshPool(cfg config, logger *log.Logger) *goget.RefreshPool {
+	if cfg.RefreshWorkers < 0 {
+		logger.Info(context.Background(), "no refresh workers, refreshing at normal priority")
+		return nil
+	}
+	return goget.NewRefreshPool(cfg.RefreshWorkers, cfg.RefreshNiceness, logger.With(zap.String("class", "goget.RefreshPool")))
+}
+
+// refreshAllRepos refreshes every checkout, stopping early once ctx is done
+func refreshAllRepos(ctx context.Context, checkouts map[string]gitdb.Checkout, logger *log.Logger) {
+	ctx, onCancel := context.WithTimeout(goget.WithTrigger(ctx, goget.TriggerPoll), time.Second*60)
 	defer onCancel()
 	for _, c := range checkouts {
+		if ctx.Err() != nil {
+			return
+		}
+		if c.InMaintenance(time.Now()) {
+			logger.Debug(ctx, "skipping refresh during maintenance window", zap.String("repo", c.RemoteURL()))
+			continue
+		}
 		if err := c.Refresh(ctx); err != nil {
 			logger.Warn(ctx, "unable to refresh repo")
 		}
 	}
 }
 
-func setupDebugServer(l *log.Logger, listenAddr string, obj interface{}) (func(), error) {
+func debugAuth(cfg config, l *log.Logger) func(http.Handler) http.Handler {
+	if cfg.DebugToken != "" {
+		return httpserver.BearerTokenMiddleware(cfg.DebugToken, l)
+	}
+	if cfg.DebugPassword != "" {
+		return httpserver.BasicAuthMiddleware(cfg.DebugUsername, cfg.DebugPassword, l)
+	}
+	l.Info(context.Background(), "no debug token or password set, skipping debug server auth")
+	return func(h http.Handler) http.Handler {
+		return h
+	}
+}
+
+func setupDebugServer(l *log.Logger, cfg config, obj interface{}, rootMetrics metrics.Metrics) (func(), error) {
+	listenAddr := cfg.DebugListenAddr
 	if listenAddr == "" || listenAddr == "-" {
 		return func() {
 		}, nil
@@ -227,6 +841,11 @@ func setupDebugServer(l *log.Logger, listenAddr string, obj interface{}) (func()
 		Logger:        &log.FieldLogger{Logger: l},
 		ExplorableObj: obj,
 	})
+	ret.Exp2.Exported["heap"] = heapStatsVar(cfg)
+	if h := rootMetrics.Handler(); h != nil {
+		ret.Mux.Handle("/metrics", h)
+	}
+	ret.Server.Handler = debugAuth(cfg, l.With(zap.String("handler", "debug")))(ret.Mux)
 	ln, err := net.Listen("tcp", listenAddr)
 	if err != nil {
 		return nil, fmt.Errorf("unable to listen to %s: %w", listenAddr, err)
@@ -244,24 +863,29 @@ func setupDebugServer(l *log.Logger, listenAddr string, obj interface{}) (func()
 	}, nil
 }
 
-func setupJWT(cfg config, m *mux.Router, h *gitdb.CheckoutHandler, logger *log.Logger, repoConfig RepoConfig) error {
+// jwtKeyFunc loads the public key JWTs are checked with, or returns nil if none is configured
+func jwtKeyFunc(cfg config) (jwt.Keyfunc, error) {
 	if cfg.JWTPublicKey == "" {
-		logger.Info(context.Background(), "skipping public JWT handler: no public key")
-		return nil
+		return nil, nil
 	}
 	fileContent, err := os.ReadFile(cfg.JWTPublicKey)
 	if err != nil {
-		return fmt.Errorf("unable to read jwt file %s: %w", cfg.JWTPublicKey, err)
+		return nil, fmt.Errorf("unable to read jwt file %s: %w", cfg.JWTPublicKey, err)
 	}
 	parsedPublicKey, err := jwt.ParseRSAPublicKeyFromPEM(fileContent)
 	if err != nil {
-		return fmt.Errorf("unable to parse public key in file %s: %w", cfg.JWTPublicKey, err)
+		return nil, fmt.Errorf("unable to parse public key in file %s: %w", cfg.JWTPublicKey, err)
 	}
-	keyFunc := func(_ *jwt.Token) (interface{}, error) {
+	return func(_ *jwt.Token) (interface{}, error) {
 		return parsedPublicKey, nil
+	}, nil
+}
+
+func setupJWT(keyFunc jwt.Keyfunc, m *mux.Router, h *gitdb.CheckoutHandler, logger *log.Logger, repoConfig RepoConfig) {
+	if keyFunc == nil {
+		logger.Info(context.Background(), "no JWT public key: only serving anonymous public repos")
 	}
 	h.SetupPublicJWTHandler(m, keyFunc, repoConfig.Repositories)
-	return nil
 }
 
 func setupJWTSigning(ctx context.Context, cfg config, log *log.Logger, m *mux.Router) error {
@@ -305,28 +929,180 @@ func setupJWTSigning(ctx context.Context, cfg config, log *log.Logger, m *mux.Ro
 		},
 	}
 	m.Handle("/public/signin", signIn).Methods(http.MethodPost).Name("signin")
+	tokenExchange := &httpserver.JWTTokenExchange{
+		Logger:     log.With(zap.String("handler", "jwt_token_exchange")),
+		PrivateKey: pKey,
+		APIKeys:    splitNonEmpty(cfg.TokenAPIKeys),
+		DefaultTTL: cfg.TokenDefaultTTL,
+		MaxTTL:     cfg.TokenMaxTTL,
+	}
+	m.Handle("/public/token", tokenExchange).Methods(http.MethodPost).Name("token_exchange")
+	return nil
+}
+
+func splitNonEmpty(csv string) []string {
+	var ret []string
+	for _, s := range strings.Split(csv, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			ret = append(ret, s)
+		}
+	}
+	return ret
+}
+
+// setupAdmin serves the admin routes, which chain authenticates, if an admin token is configured
+func setupAdmin(cfg config, m *mux.Router, h *gitdb.CheckoutHandler, logger *log.Logger, chain httpserver.Chain) {
+	if cfg.AdminToken == "" {
+		logger.Info(context.Background(), "no admin token set, skipping admin endpoints")
+		return
+	}
+	chain.Group(m, func(m *mux.Router) {
+		m.Methods(http.MethodGet).Path("/admin/routes").Handler(httpserver.RoutesHandler(m, logger.With(zap.String("handler", "admin")))).Name("admin_routes")
+		h.SetupAdminMux(m)
+	})
+}
+
+// accessLog skips the health and metrics probes, and whatever else cfg excludes
+func accessLog(cfg config) httpserver.AccessLog {
+	ret := httpserver.AccessLog{
+		Exclude:           append([]string(nil), httpserver.ProbePaths...),
+		SuccessSampleRate: cfg.AccessLogSampleRate,
+	}
+	for _, p := range strings.Split(cfg.AccessLogExclude, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			ret.Exclude = append(ret.Exclude, p)
+		}
+	}
+	return ret
+}
+
+// setupWrite serves PUT and DELETE /file, authenticated with the write token or a JWT, if either is configured
+func setupWrite(cfg config, keyFunc jwt.Keyfunc, m *mux.Router, h *gitdb.CheckoutHandler, logger *log.Logger, chain httpserver.Chain) error {
+	writeLogger := logger.With(zap.String("handler", "write"))
+	var auth httpserver.Chain
+	switch {
+	case cfg.WriteJWT && cfg.WriteToken != "":
+		return errors.New("set only one of GITDB_WRITE_TOKEN and GITDB_WRITE_JWT")
+	case cfg.WriteJWT:
+		if keyFunc == nil {
+			return errors.New("GITDB_WRITE_JWT needs GITDB_JWT_PUBLIC_KEY")
+		}
+		auth = httpserver.NewChain(httpserver.JWTMiddleware(keyFunc, writeLogger))
+	case cfg.WriteToken != "":
+		auth = httpserver.NewChain(httpserver.BearerTokenMiddleware(cfg.WriteToken, writeLogger))
+	default:
+		logger.Info(context.Background(), "no write token or JWT, skipping write endpoints")
+		return nil
+	}
+	// Authenticate before anything else the data chain does
+	h.SetupWriteMux(m, auth.Append(chain...).Then)
 	return nil
 }
 
-func setupServer(cfg config, z *log.Logger, rootTracer tracing.Tracing, coHandler *gitdb.CheckoutHandler, githubProvider *github.Provider, repoConfig RepoConfig) *http.Server {
+// loadErrorPages returns nil, leaving error bodies to handlers, unless error pages are configured
+func loadErrorPages(cfg config, logger *log.Logger) (*httpserver.ErrorPages, error) {
+	if cfg.ErrorFormat == "" && cfg.ErrorTemplate == "" && cfg.ErrorContact == "" {
+		return nil, nil
+	}
+	var tmpl []byte
+	if cfg.ErrorTemplate != "" {
+		var err error
+		if tmpl, err = os.ReadFile(cfg.ErrorTemplate); err != nil {
+			return nil, fmt.Errorf("unable to read error template %s: %w", cfg.ErrorTemplate, err)
+		}
+	}
+	return httpserver.NewErrorPages(cfg.ErrorFormat, string(tmpl), cfg.ErrorContact, logger.With(zap.String("section", "error_pages")))
+}
+
+// routeChains are the middlewares of each group of routes, which run after the root chain every route shares.  A
+// cross-cutting feature attaches to the chains of the routes it applies to.
+type routeChains struct {
+	// /public routes, for clients outside the cluster
+	public httpserver.Chain
+	// /admin routes, starting with the admin token check
+	admin httpserver.Chain
+	// Routes serving repository content, like /file and /zip
+	data httpserver.Chain
+}
+
+func newRouteChains(cfg config, h *gitdb.CheckoutHandler, logger *log.Logger) routeChains {
+	return routeChains{
+		admin: httpserver.NewChain(httpserver.BearerTokenMiddleware(cfg.AdminToken, logger.With(zap.String("handler", "admin")))),
+		data:  httpserver.NewChain(h.ReadLimitMiddleware()),
+	}
+}
+
+func setupServer(cfg config, z *log.Logger, rootTracer tracing.Tracing, rootMetrics metrics.Metrics, coHandler *gitdb.CheckoutHandler, githubProvider *github.Provider, gitlabProvider *gitlab.Provider, bitbucketProvider *bitbucket.Provider, repoConfig RepoConfig, drainer *httpserver.Drainer) *http.Server {
 	rootMux, rootHandler := rootTracer.CreateRootMux()
-	rootMux.Use(httpserver.MuxMiddleware())
-	rootMux.Use(httpserver.LogMiddleware(z, func(req *http.Request) bool {
-		return req.URL.Path == "/health"
-	}))
-	rootMux.Handle("/health", httpserver.HealthHandler(z.With(zap.String("handler", "health")), rootTracer)).Name("health")
-	coHandler.SetupMux(rootMux)
+	trustedProxies, err := httpserver.ParseTrustedProxies(cfg.TrustedProxies)
+	z.IfErr(err).Panic(context.Background(), "unable to parse trusted proxies")
+	keyFunc, err := jwtKeyFunc(cfg)
+	z.IfErr(err).Panic(context.Background(), "unable to load JWT public key")
+	errorPages, err := loadErrorPages(cfg, z)
+	z.IfErr(err).Panic(context.Background(), "unable to load error pages")
+	if gitdb.RepoKeyNaming(cfg.RepoKeyNaming) == gitdb.RepoKeyPath {
+		// Keys like org/repo come escaped, as org%2Frepo, and must stay one variable
+		httpserver.UnescapedVars(rootMux)
+	}
+	root := httpserver.NewChain(
+		httpserver.RecoveryMiddleware(z.With(zap.String("section", "recovery"))),
+		errorPages.Middleware(),
+		drainer.Middleware(),
+		httpserver.ClientIPMiddleware(trustedProxies),
+		httpserver.IdentityMiddleware(rootTracer, keyFunc),
+		httpserver.MuxMiddleware(),
+		httpserver.MetricsMiddleware(rootMetrics),
+		httpserver.ResponseSizeMiddleware(rootMetrics, cfg.RouteMaxBytes, cfg.DefaultRouteMaxBytes, z),
+		httpserver.MaxBodyMiddleware(cfg.MaxBodyBytes, z),
+		httpserver.TimeoutMiddleware(cfg.RouteTimeouts, cfg.DefaultRouteTimeout),
+		coHandler.AliasMiddleware(),
+		coHandler.PinnedReadMiddleware(),
+		coHandler.SubmoduleMiddleware(),
+		coHandler.ResponseHeadersMiddleware(),
+		httpserver.LogMiddleware(z, accessLog(cfg)),
+		tracing.MuxTagging(rootTracer),
+	)
+	root.Use(rootMux)
+	chains := newRouteChains(cfg, coHandler, z)
+	ready := func(ctx context.Context) error {
+		return coHandler.Ready(ctx, cfg.ReadyMaxStaleness)
+	}
+	rootMux.Handle("/live", httpserver.LiveHandler(z.With(zap.String("handler", "live")), rootTracer)).Name("live")
+	rootMux.Handle("/ready", httpserver.ReadyHandler(z.With(zap.String("handler", "ready")), rootTracer, drainer, ready)).Name("ready")
+	// Kept for probes configured before /live and /ready: the same as /ready
+	rootMux.Handle("/health", httpserver.ReadyHandler(z.With(zap.String("handler", "health")), rootTracer, drainer, ready)).Name("health")
+	if h := rootMetrics.Handler(); h != nil {
+		rootMux.Methods(http.MethodGet).Path("/metrics").Handler(h).Name("metrics")
+	}
+	chains.data.Group(rootMux, coHandler.SetupMux)
 	if githubProvider != nil {
 		z.Info(context.Background(), "setting up github provider path")
 		githubProvider.SetupMux(rootMux)
 	}
-	z.IfErr(setupJWT(cfg, rootMux, coHandler, z, repoConfig)).Panic(context.Background(), "unable to public JWT endpoint")
-	z.IfErr(setupJWTSigning(context.Background(), cfg, z, rootMux)).Panic(context.Background(), "unable to setup JWT signing")
-	rootMux.NotFoundHandler = httpserver.NotFoundHandler(z)
-	rootMux.Use(tracing.MuxTagging(rootTracer))
+	if gitlabProvider != nil {
+		z.Info(context.Background(), "setting up gitlab provider path")
+		gitlabProvider.SetupMux(rootMux)
+	}
+	if bitbucketProvider != nil {
+		z.Info(context.Background(), "setting up bitbucket provider path")
+		bitbucketProvider.SetupMux(rootMux)
+	}
+	chains.public.Group(rootMux, func(m *mux.Router) {
+		setupJWT(keyFunc, m, coHandler, z, repoConfig)
+		z.IfErr(setupJWTSigning(context.Background(), cfg, z, m)).Panic(context.Background(), "unable to setup JWT signing")
+	})
+	setupAdmin(cfg, rootMux, coHandler, z, chains.admin)
+	z.IfErr(setupWrite(cfg, keyFunc, rootMux, coHandler, z, chains.data)).Panic(context.Background(), "unable to setup writes")
+	if cfg.SimulateChanges {
+		z.Warn(context.Background(), "serving /simulate, which lets any client change what is served.  Only use for testing")
+		chains.data.Group(rootMux, coHandler.SetupSimulationMux)
+	}
+	// Unmatched requests skip the root chain
+	rootMux.NotFoundHandler = errorPages.Middleware()(httpserver.NotFoundHandler(z))
 	return &http.Server{
 		Handler:           rootHandler,
 		Addr:              cfg.ListenAddr,
 		ReadHeaderTimeout: time.Second * 30,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
 	}
 }
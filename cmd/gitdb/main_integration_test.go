@@ -4,8 +4,10 @@
 package main
 
 import (
+	"archive/tar"
 	"archive/zip"
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -18,7 +20,9 @@ import (
 	"sync/atomic"
 	"testing"
 
+	"github.com/cresta/gitdb/internal/gitdb"
 	"github.com/cresta/gitdb/internal/gitdb/goget"
+	"github.com/cresta/gitdb/internal/gitdb/metrics"
 
 	"github.com/cresta/gitdb/internal/testhelp"
 
@@ -33,16 +37,25 @@ func TestServer(t *testing.T) {
 		osExit: func(i int) {
 			atomic.StoreInt64(&atomicOnExit, int64(i))
 		},
-		log: testhelp.ZapTestingLogger(t),
+		log:     testhelp.ZapTestingLogger(t),
+		metrics: metrics.DefaultRegistry,
 		config: config{
 			ListenAddr:      ":0",
 			DataDirectory:   "",
 			GithubPushToken: "abc123",
+			GitlabPushToken: "abc123",
+			Metrics:         "prometheus",
 		},
 		repoConfig: &RepoConfig{
 			Repositories: []Repository{
 				{
 					URL: "git@github.com:cresta/gitdb-reference.git",
+					ResponseHeaders: map[string]string{
+						"X-Robots-Tag": "noindex",
+					},
+					DeprecatedAliases: []gitdb.DeprecatedAlias{
+						{Name: "old-reference", Sunset: "2030-01-01T00:00:00Z"},
+					},
 				},
 			},
 		},
@@ -63,17 +76,74 @@ func TestServer(t *testing.T) {
 		require.Equal(t, http.StatusOK, resp.StatusCode)
 		require.Equal(t, "OK", requiredRead(t, resp.Body))
 	})
+	t.Run("metrics", func(t *testing.T) {
+		resp, err := http.Get(fmt.Sprintf("http://localhost:%d/metrics", sendPort))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		body := requiredRead(t, resp.Body)
+		require.Contains(t, body, "gitdb_checkouts 1")
+		require.Contains(t, body, `gitdb_http_requests_total{code="200",method="GET",route="health"}`)
+	})
 	t.Run("test_refresh", func(t *testing.T) {
 		resp, err := http.Post(fmt.Sprintf("http://localhost:%d/refresh/gitdb-reference", sendPort), "", nil)
 		require.NoError(t, err)
 		require.Equal(t, http.StatusOK, resp.StatusCode)
 		require.Equal(t, "OK", requiredRead(t, resp.Body))
 	})
+	t.Run("test_refresh_repos", func(t *testing.T) {
+		resp, err := http.Post(fmt.Sprintf("http://localhost:%d/refresh", sendPort), "application/json", strings.NewReader(`{"Repos":["gitdb-reference"]}`))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var results []gitdb.RefreshResult
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&results))
+		require.Equal(t, []gitdb.RefreshResult{{Repo: "gitdb-reference"}}, results)
+	})
+	t.Run("test_refresh_repos_unknown", func(t *testing.T) {
+		resp, err := http.Post(fmt.Sprintf("http://localhost:%d/refresh", sendPort), "application/json", strings.NewReader(`{"Repos":["unknown"]}`))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
 	t.Run("fetch_file", func(t *testing.T) {
 		resp, err := http.Get(fmt.Sprintf("http://localhost:%d/file/gitdb-reference/master/on_master.txt", sendPort))
 		require.NoError(t, err)
 		require.Equal(t, http.StatusOK, resp.StatusCode)
 		require.Equal(t, "true\n", requiredRead(t, resp.Body))
+		require.Len(t, resp.Header.Get("X-Gitdb-Commit"), 40)
+		require.Len(t, resp.Header.Get("X-Gitdb-Blob-Hash"), 40)
+		require.NotEmpty(t, resp.Header.Get("X-Gitdb-Commit-Time"))
+		require.Equal(t, "noindex", resp.Header.Get("X-Robots-Tag"))
+		digest := resp.Header.Get("Digest")
+		if digest == "" {
+			digest = resp.Trailer.Get("Digest")
+		}
+		require.Equal(t, "sha-256=oX/PCi9Q4tSV5PkM4mNBDtwYOt1sYmmaL6y8z2BBD3Q=", digest)
+	})
+	t.Run("fetch_file_not_modified", func(t *testing.T) {
+		fileURL := fmt.Sprintf("http://localhost:%d/file/gitdb-reference/master/on_master.txt", sendPort)
+		resp, err := http.Get(fileURL)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		etag := resp.Header.Get("ETag")
+		require.Equal(t, `"`+resp.Header.Get("X-Gitdb-Blob-Hash")+`"`, etag)
+		req, err := http.NewRequest(http.MethodGet, fileURL, nil)
+		require.NoError(t, err)
+		req.Header.Set("If-None-Match", etag)
+		resp, err = http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusNotModified, resp.StatusCode)
+		require.Equal(t, etag, resp.Header.Get("ETag"))
+		require.Empty(t, requiredRead(t, resp.Body))
+	})
+	t.Run("batch", func(t *testing.T) {
+		resp, err := http.Post(fmt.Sprintf("http://localhost:%d/batch/gitdb-reference/master", sendPort), "application/json", strings.NewReader(`{"Paths":["on_master.txt","missing.txt"]}`))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var batch gitdb.BatchResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&batch))
+		require.Len(t, batch.Commit, 40)
+		require.Len(t, batch.Files, 2)
+		require.Equal(t, "true\n", string(batch.Files[0].Content))
+		require.True(t, batch.Files[1].NotFound)
 	})
 	t.Run("zip_dir_missing", func(t *testing.T) {
 		resp, err := http.Get(fmt.Sprintf("http://localhost:%d/zip/gitdb-reference/master/baddir", sendPort))
@@ -110,6 +180,140 @@ func TestServer(t *testing.T) {
 			"subdir_file2.txt": "file2\n",
 		}, contents)
 	})
+	t.Run("tar_dir", func(t *testing.T) {
+		resp, err := http.Get(fmt.Sprintf("http://localhost:%d/tar/gitdb-reference/master/adir/subdir", sendPort))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, "application/gzip", resp.Header.Get("Content-Type"))
+		gz, err := gzip.NewReader(resp.Body)
+		require.NoError(t, err)
+		tr := tar.NewReader(gz)
+		contents := make(map[string]string)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			contents[hdr.Name] = requiredRead(t, tr)
+		}
+		require.Equal(t, map[string]string{
+			"subdir_file.txt":  "file1\n",
+			"subdir_file2.txt": "file2\n",
+		}, contents)
+	})
+	t.Run("zip_multiple_dirs", func(t *testing.T) {
+		resp, err := http.Get(fmt.Sprintf("http://localhost:%d/zip/gitdb-reference/master/?dirs=adir/subdir&naming=base", sendPort))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var buf bytes.Buffer
+		_, err2 := io.Copy(&buf, resp.Body)
+		require.NoError(t, err2)
+		r, err := zip.NewReader(strings.NewReader(buf.String()), int64(buf.Len()))
+		require.NoError(t, err)
+		names := make([]string, 0, len(r.File))
+		for _, f := range r.File {
+			names = append(names, f.Name)
+		}
+		require.ElementsMatch(t, []string{"subdir/subdir_file.txt", "subdir/subdir_file2.txt"}, names)
+	})
+	t.Run("zip_multiple_dirs_collide", func(t *testing.T) {
+		resp, err := http.Get(fmt.Sprintf("http://localhost:%d/zip/gitdb-reference/master/?dirs=adir,adir", sendPort))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+	t.Run("bundle", func(t *testing.T) {
+		resp, err := http.Get(fmt.Sprintf("http://localhost:%d/bundle/gitdb-reference/master", sendPort))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.True(t, strings.HasPrefix(requiredRead(t, resp.Body), "# v2 git bundle\n"))
+	})
+	t.Run("bundle_all", func(t *testing.T) {
+		resp, err := http.Get(fmt.Sprintf("http://localhost:%d/bundle/gitdb-reference", sendPort))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		body := requiredRead(t, resp.Body)
+		require.True(t, strings.HasPrefix(body, "# v2 git bundle\n"))
+		require.Contains(t, body, " refs/heads/staging\n")
+	})
+	t.Run("bundle_bad_branch", func(t *testing.T) {
+		resp, err := http.Get(fmt.Sprintf("http://localhost:%d/bundle/gitdb-reference/badbranch", sendPort))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+	t.Run("commits", func(t *testing.T) {
+		resp, err := http.Get(fmt.Sprintf("http://localhost:%d/commits/gitdb-reference/master?limit=1", sendPort))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var commits []goget.CommitInfo
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&commits))
+		require.Len(t, commits, 1)
+		require.Len(t, commits[0].Hash, 40)
+	})
+	t.Run("commit", func(t *testing.T) {
+		resp, err := http.Get(fmt.Sprintf("http://localhost:%d/commit/gitdb-reference/master", sendPort))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var commit goget.CommitInfo
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&commit))
+		require.Len(t, commit.Hash, 40)
+		require.Equal(t, commit.Hash, resp.Header.Get("X-Gitdb-Commit"))
+		require.NotEmpty(t, commit.Committer)
+		require.False(t, commit.Time.IsZero())
+	})
+	t.Run("commit_bad_branch", func(t *testing.T) {
+		resp, err := http.Get(fmt.Sprintf("http://localhost:%d/commit/gitdb-reference/badbranch", sendPort))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+	t.Run("log", func(t *testing.T) {
+		resp, err := http.Get(fmt.Sprintf("http://localhost:%d/log/gitdb-reference/master/on_master.txt?limit=5", sendPort))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var commits []goget.CommitInfo
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&commits))
+		require.NotEmpty(t, commits)
+		require.LessOrEqual(t, len(commits), 5)
+	})
+	t.Run("diff", func(t *testing.T) {
+		resp, err := http.Get(fmt.Sprintf("http://localhost:%d/diff/gitdb-reference/master/master?patch=true", sendPort))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var diff goget.TreeDiff
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&diff))
+		require.Equal(t, diff.From, diff.To)
+		require.Empty(t, diff.Files)
+	})
+	t.Run("diff_bad_branch", func(t *testing.T) {
+		resp, err := http.Get(fmt.Sprintf("http://localhost:%d/diff/gitdb-reference/master/badbranch", sendPort))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+	t.Run("deprecated_alias", func(t *testing.T) {
+		resp, err := http.Get(fmt.Sprintf("http://localhost:%d/file/old-reference/master/on_master.txt", sendPort))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, "true", resp.Header.Get("Deprecation"))
+		require.Equal(t, "Tue, 01 Jan 2030 00:00:00 GMT", resp.Header.Get("Sunset"))
+		require.Equal(t, "noindex", resp.Header.Get("X-Robots-Tag"))
+	})
+	t.Run("commits_bad_limit", func(t *testing.T) {
+		resp, err := http.Get(fmt.Sprintf("http://localhost:%d/commits/gitdb-reference/master?limit=abc", sendPort))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+	t.Run("repos", func(t *testing.T) {
+		resp, err := http.Get(fmt.Sprintf("http://localhost:%d/repos", sendPort))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var repos []gitdb.RepoInfo
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&repos))
+		require.Len(t, repos, 1)
+		require.Equal(t, "gitdb-reference", repos[0].Key)
+		require.False(t, repos[0].Public)
+		require.Equal(t, []string{gitdb.AuthInternal}, repos[0].Auth)
+		require.Contains(t, repos[0].Endpoints, gitdb.EndpointZip)
+	})
 	t.Run("not_found_file", func(t *testing.T) {
 		resp, err := http.Get(fmt.Sprintf("http://localhost:%d/file/gitdb-reference/master/not_there.txt", sendPort))
 		require.NoError(t, err)
@@ -129,6 +333,31 @@ func TestServer(t *testing.T) {
 		require.Len(t, bodyResp, 3)
 		require.Equal(t, bodyResp[1].Name, "adir")
 	})
+	t.Run("ls_dir_root_text", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://localhost:%d/ls/gitdb-reference/master/", sendPort), nil)
+		require.NoError(t, err)
+		req.Header.Set("Accept", "text/plain")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, "README.md\nadir\non_master.txt\n", requiredRead(t, resp.Body))
+	})
+	t.Run("tree_adir_ndjson", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://localhost:%d/tree/gitdb-reference/master/adir", sendPort), nil)
+		require.NoError(t, err)
+		req.Header.Set("Accept", "application/x-ndjson")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		dec := json.NewDecoder(resp.Body)
+		var names []string
+		for dec.More() {
+			var stat goget.FileStat
+			require.NoError(t, dec.Decode(&stat))
+			names = append(names, stat.Name)
+		}
+		require.Contains(t, names, "subdir/subdir_file.txt")
+	})
 	t.Run("ls_dir_adir", func(t *testing.T) {
 		resp, err := http.Get(fmt.Sprintf("http://localhost:%d/ls/gitdb-reference/master/adir", sendPort))
 		require.NoError(t, err)
@@ -167,6 +396,25 @@ func TestServer(t *testing.T) {
 		require.NoError(t, err)
 		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
 	})
+	t.Run("gitlab_event_invalid_token", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://localhost:%d/public/gitlab/webhook", sendPort), strings.NewReader("{}"))
+		require.NoError(t, err)
+		req.Header.Set("X-Gitlab-Event", "Push Hook")
+		req.Header.Set("X-Gitlab-Token", "wrong")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+	t.Run("gitlab_event_push", func(t *testing.T) {
+		body := `{"object_kind":"push","ref":"refs/heads/master","project":{"git_ssh_url":"git@github.com:cresta/gitdb-reference.git"}}`
+		req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://localhost:%d/public/gitlab/webhook", sendPort), strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("X-Gitlab-Event", "Push Hook")
+		req.Header.Set("X-Gitlab-Token", "abc123")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	})
 
 	require.NoError(t, s.server.Shutdown(context.Background()))
 	wg.Wait()
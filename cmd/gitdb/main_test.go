@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestLogConfig(t *testing.T) {
+	zapCfg, err := logConfig(config{}.WithDefaults())
+	require.NoError(t, err)
+	require.Equal(t, "json", zapCfg.Encoding)
+	require.Equal(t, zap.InfoLevel, zapCfg.Level.Level())
+	require.Equal(t, &zap.SamplingConfig{Initial: 100, Thereafter: 100}, zapCfg.Sampling)
+
+	zapCfg, err = logConfig(config{LogEncoding: "console", LogLevel: "debug", LogSampleInitial: -1}.WithDefaults())
+	require.NoError(t, err)
+	require.Equal(t, "console", zapCfg.Encoding)
+	require.Equal(t, zap.DebugLevel, zapCfg.Level.Level())
+	require.Nil(t, zapCfg.Sampling)
+	_, err = zapCfg.Build()
+	require.NoError(t, err)
+
+	_, err = logConfig(config{LogLevel: "loud"}.WithDefaults())
+	require.ErrorContains(t, err, "unable to parse log level loud")
+	_, err = logConfig(config{LogEncoding: "xml"}.WithDefaults())
+	require.ErrorContains(t, err, "unknown log encoding xml")
+}
+
+func TestLoadEnvFile(t *testing.T) {
+	envFile := filepath.Join(t.TempDir(), "gitdb.env")
+	require.NoError(t, os.WriteFile(envFile, []byte("GITDB_TEST_SET=from_file\nGITDB_TEST_UNSET=from_file\n"), 0o600))
+	t.Setenv("GITDB_ENV_FILE", envFile)
+	t.Setenv("GITDB_TEST_SET", "from_env")
+	// Restored to unset once the test ends
+	t.Setenv("GITDB_TEST_UNSET", "")
+	require.NoError(t, os.Unsetenv("GITDB_TEST_UNSET"))
+
+	require.NoError(t, loadEnvFile())
+	require.Equal(t, "from_env", os.Getenv("GITDB_TEST_SET"))
+	require.Equal(t, "from_file", os.Getenv("GITDB_TEST_UNSET"))
+
+	t.Setenv("GITDB_ENV_FILE", filepath.Join(t.TempDir(), "missing.env"))
+	require.ErrorContains(t, loadEnvFile(), "missing.env")
+}
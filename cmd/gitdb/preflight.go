@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cresta/gitdb/internal/gitdb"
+	"github.com/cresta/gitdb/internal/gitdb/tracing"
+	"github.com/cresta/gitdb/internal/log"
+	"go.uber.org/zap"
+)
+
+// runPreflight implements "gitdb --preflight": check every configured remote can be listed with its credentials and
+// the tracer's agent is reachable, print a table of the results, and exit non zero if any failed.  Meant to run as an
+// init container, so a bad key or missing socket fails before the server starts cloning.
+func (m *Service) runPreflight(cfg config, out io.Writer) int {
+	repoConfig, err := m.loadRepoConfig(cfg)
+	if err != nil {
+		_, _ = fmt.Fprintf(out, "unable to load repository config: %v\n", err)
+		return 2
+	}
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "CHECK\tTARGET\tDURATION\tRESULT")
+	failed := false
+	for _, r := range gitdb.Preflight(context.Background(), repoConfig.Repositories, gitdb.RepoKeyNaming(cfg.RepoKeyNaming), cfg.PreflightTimeout) {
+		result := fmt.Sprintf("ok: %d refs", r.Refs)
+		if r.Err != nil {
+			result = r.Err.Error()
+			failed = true
+		}
+		_, _ = fmt.Fprintf(tw, "remote\t%s\t%s\t%s\n", r.Repo, r.Duration.Round(time.Millisecond), result)
+	}
+	if cfg.Tracer != "" {
+		start := time.Now()
+		result := "ok"
+		if err := m.tracers.Preflight(cfg.Tracer, tracing.Config{
+			Log: log.New(zap.NewNop()),
+			Env: os.Environ(),
+		}); err != nil {
+			result = err.Error()
+			failed = true
+		}
+		_, _ = fmt.Fprintf(tw, "tracer\t%s\t%s\t%s\n", cfg.Tracer, time.Since(start).Round(time.Millisecond), result)
+	}
+	if err := tw.Flush(); err != nil {
+		return 2
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
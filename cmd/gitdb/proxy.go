@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/cresta/gitdb/client"
+	"github.com/cresta/gitdb/internal/gitdb/metrics"
+	"github.com/cresta/gitdb/internal/gitdb/proxy"
+	"github.com/cresta/gitdb/internal/gitdb/tracing"
+	"github.com/cresta/gitdb/internal/httpserver"
+	"go.uber.org/zap"
+)
+
+// proxyClient reads from the comma separated base URLs of cfg.ProxyUpstream, spread by rendezvous hashing when there
+// are several
+func proxyClient(cfg config, rootTracer tracing.Tracing) *client.Client {
+	ret := &client.Client{
+		HTTPClient: tracing.NewHTTPClient(rootTracer, time.Minute),
+		Token:      cfg.ProxyToken,
+	}
+	var bases []string
+	for _, b := range strings.Split(cfg.ProxyUpstream, ",") {
+		if b = strings.TrimSpace(b); b != "" {
+			bases = append(bases, b)
+		}
+	}
+	if len(bases) == 1 {
+		ret.BaseURL = bases[0]
+	} else {
+		ret.Replicas = bases
+	}
+	return ret
+}
+
+// runProxy serves /file and /ls from the gitdb at cfg.ProxyUpstream, cloning nothing, until SIGTERM or SIGINT
+func (m *Service) runProxy(cfg config, rootTracer tracing.Tracing, rootMetrics metrics.Metrics) {
+	z := m.log
+	p, err := proxy.New(z, proxy.Config{
+		Upstream:   proxyClient(cfg, rootTracer),
+		CacheBytes: cfg.CacheBytes,
+		Metrics:    rootMetrics,
+	})
+	if err != nil {
+		z.IfErr(err).Error(context.Background(), "unable to setup proxy")
+		m.osExit(1)
+		return
+	}
+	drainer := &httpserver.Drainer{
+		Delay:   cfg.DrainDelay,
+		Timeout: cfg.DrainTimeout,
+		Log:     z.With(zap.String("section", "drain")),
+	}
+	rootMux, rootHandler := rootTracer.CreateRootMux()
+	httpserver.NewChain(
+		httpserver.RecoveryMiddleware(z.With(zap.String("section", "recovery"))),
+		drainer.Middleware(),
+		httpserver.MuxMiddleware(),
+		httpserver.MetricsMiddleware(rootMetrics),
+		httpserver.LogMiddleware(z, accessLog(cfg)),
+		tracing.MuxTagging(rootTracer),
+	).Use(rootMux)
+	rootMux.Handle("/live", httpserver.LiveHandler(z.With(zap.String("handler", "live")), rootTracer)).Name("live")
+	// Ready without the upstream, so the edge keeps answering what it can while the upstream is away
+	rootMux.Handle("/ready", httpserver.ReadyHandler(z.With(zap.String("handler", "ready")), rootTracer, drainer, nil)).Name("ready")
+	rootMux.Handle("/health", httpserver.ReadyHandler(z.With(zap.String("handler", "health")), rootTracer, drainer, nil)).Name("health")
+	if h := rootMetrics.Handler(); h != nil {
+		rootMux.Methods(http.MethodGet).Path("/metrics").Handler(h).Name("metrics")
+	}
+	p.SetupMux(rootMux)
+	rootMux.NotFoundHandler = httpserver.NotFoundHandler(z)
+	m.server = &http.Server{
+		Handler:           rootHandler,
+		Addr:              cfg.ListenAddr,
+		ReadHeaderTimeout: time.Second * 30,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+	}
+
+	ln, err := listen(m.server.Addr, z)
+	if err != nil {
+		z.Panic(context.Background(), "unable to listen to port", zap.Error(err), zap.String("addr", m.server.Addr))
+		m.osExit(1)
+		return
+	}
+	if m.onListen != nil {
+		m.onListen(ln)
+	}
+	z.Info(context.Background(), "proxying", zap.String("upstream", cfg.ProxyUpstream))
+	onEnd := make(chan struct{})
+	drained := make(chan struct{})
+	shutdownSignal := make(chan os.Signal, 1)
+	signal.Notify(shutdownSignal, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(shutdownSignal)
+	go func() {
+		defer close(drained)
+		var sig os.Signal
+		select {
+		case <-onEnd:
+			return
+		case sig = <-shutdownSignal:
+		}
+		z.Info(context.Background(), "shutting down", zap.Stringer("signal", sig))
+		z.IfErr(drainer.Drain(context.Background(), m.server)).Error(context.Background(), "unable to drain server")
+	}()
+	serveErr := m.server.Serve(ln)
+	if drainer.Draining() {
+		<-drained
+	}
+	close(onEnd)
+	z.Info(context.Background(), "Server finished")
+	if serveErr != http.ErrServerClosed {
+		z.IfErr(serveErr).Error(context.Background(), "server exited")
+		m.osExit(1)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/cresta/gitdb/internal/gitdb"
+	"github.com/cresta/gitdb/internal/gitdb/repoprovider/bitbucket"
+	"github.com/cresta/gitdb/internal/gitdb/repoprovider/github"
+	"github.com/cresta/gitdb/internal/gitdb/repoprovider/gitlab"
+	"go.uber.org/zap"
+)
+
+// checkoutSetter is a webhook provider, which keeps its own checkouts by remote URL
+type checkoutSetter interface {
+	SetCheckouts(checkouts map[string]gitdb.Checkout)
+}
+
+// webhookProviders lists the providers that are set up
+func webhookProviders(githubProvider *github.Provider, gitlabProvider *gitlab.Provider, bitbucketProvider *bitbucket.Provider) []checkoutSetter {
+	var ret []checkoutSetter
+	if githubProvider != nil {
+		ret = append(ret, githubProvider)
+	}
+	if gitlabProvider != nil {
+		ret = append(ret, gitlabProvider)
+	}
+	if bitbucketProvider != nil {
+		ret = append(ret, bitbucketProvider)
+	}
+	return ret
+}
+
+// watchRepoConfig reloads the repository config on SIGHUP, and when it changes if cfg.RepoConfigPoll is set, until
+// done is closed.  A config that fails to load is logged and the current repositories keep being served.
+func (m *Service) watchRepoConfig(done <-chan struct{}, cfg config, co *gitdb.CheckoutHandler) {
+	if cfg.RepoConfig == "" || m.repoConfig != nil {
+		return
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	var poll <-chan time.Time
+	if cfg.RepoConfigPoll > 0 {
+		ticker := time.NewTicker(cfg.RepoConfigPoll)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+	logger := m.log.With(zap.String("file", cfg.RepoConfig))
+	loaded, err := os.ReadFile(cfg.RepoConfig)
+	logger.IfErr(err).Warn(context.Background(), "unable to read repo config")
+	for {
+		select {
+		case <-done:
+			return
+		case <-hup:
+			logger.Info(context.Background(), "reloading repo config on SIGHUP")
+		case <-poll:
+		}
+		b, err := os.ReadFile(cfg.RepoConfig)
+		if err != nil {
+			logger.Warn(context.Background(), "unable to read repo config", zap.Error(err))
+			continue
+		}
+		if bytes.Equal(b, loaded) {
+			continue
+		}
+		if err := reloadRepoConfig(context.Background(), cfg.RepoConfig, b, co); err != nil {
+			logger.Error(context.Background(), "unable to reload repo config", zap.Error(err))
+			continue
+		}
+		loaded = b
+	}
+}
+
+// repoConfigSaver writes repositories added or removed with the admin API back to the repo config file, if it was read
+// from one
+func (m *Service) repoConfigSaver(cfg config) func(ctx context.Context, repos []gitdb.Repository) error {
+	if cfg.RepoConfig == "" || m.repoConfig != nil {
+		return nil
+	}
+	return func(_ context.Context, repos []gitdb.Repository) error {
+		return saveRepoConfig(cfg.RepoConfig, RepoConfig{Repositories: repos})
+	}
+}
+
+// saveRepoConfig replaces fileName with repoConfig.  It writes a temporary file and renames it over fileName, so
+// readers never see a partial config.
+func saveRepoConfig(fileName string, repoConfig RepoConfig) error {
+	b, err := json.MarshalIndent(repoConfig, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to json marshal repo config: %w", err)
+	}
+	mode := os.FileMode(0o600)
+	if st, err := os.Stat(fileName); err == nil {
+		mode = st.Mode().Perm()
+	}
+	f, err := os.CreateTemp(filepath.Dir(fileName), filepath.Base(fileName)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("unable to create temporary file for %s: %w", fileName, err)
+	}
+	defer func() {
+		// Fails once the file is renamed
+		_ = os.Remove(f.Name())
+	}()
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("unable to write %s: %w", f.Name(), err)
+	}
+	if err := f.Chmod(mode); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("unable to chmod %s: %w", f.Name(), err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("unable to close %s: %w", f.Name(), err)
+	}
+	if err := os.Rename(f.Name(), fileName); err != nil {
+		return fmt.Errorf("unable to replace %s: %w", fileName, err)
+	}
+	return nil
+}
+
+func reloadRepoConfig(ctx context.Context, fileName string, b []byte, co *gitdb.CheckoutHandler) error {
+	repoConfig, err := parseRepoConfig(fileName, b)
+	if err != nil {
+		return err
+	}
+	if err := co.Reload(ctx, repoConfig.Repositories); err != nil {
+		return fmt.Errorf("unable to set up repos: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/cresta/gitdb/internal/gitdb"
+	"go.uber.org/zap"
+)
+
+// collectStaleDirs removes stale checkout directories now and every cfg.StaleDirInterval, until ctx is done
+func (m *Service) collectStaleDirs(ctx context.Context, cfg config, co *gitdb.CheckoutHandler) {
+	if cfg.StaleDirInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(cfg.StaleDirInterval)
+	defer ticker.Stop()
+	for {
+		removed, err := co.RemoveStaleDirs(ctx, cfg.StaleDirDryRun)
+		if err != nil {
+			m.log.Warn(ctx, "unable to collect stale checkout directories", zap.Error(err))
+		} else {
+			m.log.Info(ctx, "collected stale checkout directories", zap.Int("stale_dirs", len(removed)), zap.Bool("dry_run", cfg.StaleDirDryRun))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
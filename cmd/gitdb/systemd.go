@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/activation"
+	"github.com/coreos/go-systemd/v22/daemon"
+	"github.com/cresta/gitdb/internal/log"
+	"go.uber.org/zap"
+)
+
+// listen uses the socket passed by systemd socket activation (LISTEN_FDS) if there is one, otherwise listens on addr
+func listen(addr string, logger *log.Logger) (net.Listener, error) {
+	listeners, err := activation.Listeners()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read systemd listeners: %w", err)
+	}
+	for _, l := range listeners {
+		if l != nil {
+			logger.Info(context.Background(), "using systemd socket activation", zap.Stringer("addr", l.Addr()))
+			return l, nil
+		}
+	}
+	return net.Listen("tcp", addr)
+}
+
+// notifySystemd sends READY=1 and, if the unit sets WatchdogSec, watchdog pings until ctx ends.  It does nothing when
+// not run by systemd.
+func notifySystemd(ctx context.Context, logger *log.Logger) {
+	sent, err := daemon.SdNotify(false, daemon.SdNotifyReady)
+	if err != nil {
+		logger.Warn(ctx, "unable to notify systemd", zap.Error(err))
+		return
+	}
+	if !sent {
+		return
+	}
+	interval, err := daemon.SdWatchdogEnabled(false)
+	if err != nil || interval == 0 {
+		logger.IfErr(err).Warn(ctx, "unable to read systemd watchdog interval")
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				_, err := daemon.SdNotify(false, daemon.SdNotifyStopping)
+				logger.IfErr(err).Warn(context.Background(), "unable to notify systemd of stop")
+				return
+			case <-ticker.C:
+				_, err := daemon.SdNotify(false, daemon.SdNotifyWatchdog)
+				logger.IfErr(err).Warn(ctx, "unable to send systemd watchdog")
+			}
+		}
+	}()
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cresta/gitdb/internal/testhelp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListen(t *testing.T) {
+	t.Setenv("LISTEN_FDS", "")
+	l, err := listen("127.0.0.1:0", testhelp.ZapTestingLogger(t))
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+}
+
+func TestNotifySystemd(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: sock, Net: "unixgram"})
+	require.NoError(t, err)
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", sock)
+	t.Setenv("WATCHDOG_USEC", strconv.Itoa(int((20 * time.Millisecond).Microseconds())))
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	next := func() string {
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		buf := make([]byte, 64)
+		n, err := conn.Read(buf)
+		require.NoError(t, err)
+		return string(buf[:n])
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	notifySystemd(ctx, testhelp.ZapTestingLogger(t))
+	require.Equal(t, "READY=1", next())
+	require.Equal(t, "WATCHDOG=1", next())
+	cancel()
+	for {
+		if msg := next(); msg != "WATCHDOG=1" {
+			require.Equal(t, "STOPPING=1", msg)
+			return
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// runVerify implements "gitdb verify": ask a running gitdb to compare its checkouts against their remotes, print the
+// report, and exit non zero on drift.  Meant to run from cron next to the server.
+func runVerify(cfg config, out io.Writer) int {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.VerifyTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(cfg.VerifyURL, "/")+"/admin/verify", nil)
+	if err != nil {
+		_, _ = fmt.Fprintf(out, "unable to create request: %v\n", err)
+		return 2
+	}
+	if cfg.AdminToken != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.AdminToken)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		_, _ = fmt.Fprintf(out, "unable to reach %s: %v\n", cfg.VerifyURL, err)
+		return 2
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		_, _ = fmt.Fprintf(out, "unable to read response: %v\n", err)
+		return 2
+	}
+	_, _ = fmt.Fprintln(out)
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return 0
+	case http.StatusConflict:
+		return 1
+	default:
+		return 2
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/cresta/gitdb/internal/gitdb"
+	"github.com/cresta/gitdb/internal/gitdb/repoprovider/github"
+	"github.com/cresta/gitdb/internal/gitdb/repoprovider/gitlab"
+	"github.com/cresta/gitdb/internal/gitdb/tracing"
+	"go.uber.org/zap"
+)
+
+// hookRegistrar is a provider's HookRegistrar
+type hookRegistrar interface {
+	Register(ctx context.Context, remoteURLs []string) error
+}
+
+// registerWebhooks points the push webhook of each repository at this server, for the providers with an API token and
+// a push token.  Failures are logged: those repositories are then only refreshed by polling.
+func (m *Service) registerWebhooks(ctx context.Context, cfg config, co *gitdb.CheckoutHandler, tracer tracing.Tracing) {
+	if cfg.WebhookBaseURL == "" {
+		return
+	}
+	client := tracing.NewHTTPClient(tracer, time.Second*30)
+	registrars := make(map[string]hookRegistrar)
+	if cfg.GithubAPIToken != "" && cfg.GithubPushToken != "" {
+		r, err := github.NewHookRegistrar(cfg.GithubAPIURL, cfg.GithubAPIToken, cfg.WebhookBaseURL, cfg.GithubPushToken, client, m.log)
+		if err != nil {
+			m.log.Error(ctx, "unable to set up github webhook registration", zap.Error(err))
+		} else {
+			registrars["github"] = r
+		}
+	}
+	if cfg.GitlabAPIToken != "" && cfg.GitlabPushToken != "" {
+		registrars["gitlab"] = gitlab.NewHookRegistrar(cfg.GitlabAPIURL, cfg.GitlabAPIToken, cfg.WebhookBaseURL, cfg.GitlabPushToken, client, m.log)
+	}
+	remoteURLs := make([]string, 0)
+	for u := range co.CheckoutsByRepo() {
+		remoteURLs = append(remoteURLs, u)
+	}
+	sort.Strings(remoteURLs)
+	for name, r := range registrars {
+		if err := r.Register(ctx, remoteURLs); err != nil {
+			m.log.Error(ctx, "unable to register webhooks", zap.String("provider", name), zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,349 @@
+package gitdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/cresta/gitdb/internal/gitdb/goget"
+	"github.com/cresta/gitdb/internal/httpserver"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// SetupAdminMux adds operational endpoints under /admin.  They have no auth of their own: callers must only add them
+// behind a chain that authenticates, like main's admin chain.
+func (h *CheckoutHandler) SetupAdminMux(muxRouter *mux.Router) {
+	muxRouter.Methods(http.MethodPost).Path("/admin/repo").Handler(httpserver.BasicHandler(h.addRepoHandler, h.Log)).Name("admin_add_repo")
+	muxRouter.Methods(http.MethodDelete).Path("/admin/repo/{repo}").Handler(httpserver.BasicHandler(h.removeRepoHandler, h.Log)).Name("admin_remove_repo")
+	muxRouter.Methods(http.MethodGet).Path("/admin/refs").Handler(httpserver.BasicHandler(h.refsHandler, h.Log)).Name("admin_refs")
+	muxRouter.Methods(http.MethodGet).Path("/admin/clones").Handler(httpserver.BasicHandler(h.clonesHandler, h.Log)).Name("admin_clones")
+	muxRouter.Methods(http.MethodGet).Path("/admin/worktree/{repo}").Handler(httpserver.BasicHandler(h.workTreeHandler, h.Log)).Name("admin_worktree")
+	muxRouter.Methods(http.MethodGet).Path("/admin/verify").Handler(httpserver.BasicHandler(h.verifyHandler, h.Log)).Name("admin_verify")
+	muxRouter.Methods(http.MethodPost).Path("/admin/rotate_key/{repo}").Handler(httpserver.BasicHandler(h.rotateKeyHandler, h.Log)).Name("admin_rotate_key")
+	muxRouter.Methods(http.MethodPost).Path("/admin/heal/{repo}").Handler(httpserver.BasicHandler(h.healHandler, h.Log)).Name("admin_heal")
+}
+
+// addRepoHandler clones the Repository in the body and serves it, once the clone finishes
+func (h *CheckoutHandler) addRepoHandler(req *http.Request) httpserver.CanHTTPWrite {
+	var repo Repository
+	if err := json.NewDecoder(req.Body).Decode(&repo); err != nil {
+		return &httpserver.BasicResponse{
+			Code: http.StatusBadRequest,
+			Msg:  strings.NewReader(fmt.Sprintf("unable to decode body: %v", err)),
+		}
+	}
+	// A LocalPath would serve any directory the server can read to whoever holds the admin token
+	if strings.TrimSpace(repo.LocalPath) != "" {
+		return &httpserver.BasicResponse{
+			Code: http.StatusBadRequest,
+			Msg:  strings.NewReader("repos with a LocalPath can only be added in the repo config"),
+		}
+	}
+	if strings.TrimSpace(repo.URL) == "" {
+		return &httpserver.BasicResponse{
+			Code: http.StatusBadRequest,
+			Msg:  strings.NewReader("repo needs a URL"),
+		}
+	}
+	key := repo.key(h.repoKeyNaming)
+	h.reloadMu.Lock()
+	defer h.reloadMu.Unlock()
+	current := h.state()
+	if _, exists := current.checkouts[key]; exists {
+		return &httpserver.BasicResponse{
+			Code: http.StatusConflict,
+			Msg:  strings.NewReader(fmt.Sprintf("repo %s already exists", key)),
+		}
+	}
+	repos := append(append(make([]Repository, 0, len(current.repos)+1), current.repos...), repo)
+	if resp := h.updateReposNoLock(req.Context(), current.repos, repos); resp != nil {
+		return resp
+	}
+	h.Log.Info(req.Context(), "added repo", zap.String("repo", key))
+	b, err := json.Marshal(h.repoInfo(key))
+	if err != nil {
+		return &httpserver.BasicResponse{
+			Code: http.StatusInternalServerError,
+			Msg:  strings.NewReader(fmt.Sprintf("unable to encode repo: %v", err)),
+		}
+	}
+	return &httpserver.BasicResponse{
+		Code: http.StatusCreated,
+		Msg:  bytes.NewReader(b),
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+	}
+}
+
+// removeRepoHandler stops serving a repository.  Its clone is left on disk.
+func (h *CheckoutHandler) removeRepoHandler(req *http.Request) httpserver.CanHTTPWrite {
+	key := mux.Vars(req)["repo"]
+	h.reloadMu.Lock()
+	defer h.reloadMu.Unlock()
+	current := h.state()
+	repos := make([]Repository, 0, len(current.repos))
+	for _, r := range current.repos {
+		if r.key(h.repoKeyNaming) != key {
+			repos = append(repos, r)
+		}
+	}
+	if len(repos) == len(current.repos) {
+		return &httpserver.BasicResponse{
+			Code: http.StatusNotFound,
+			Msg:  strings.NewReader(fmt.Sprintf("unknown repo %s", key)),
+		}
+	}
+	if resp := h.updateReposNoLock(req.Context(), current.repos, repos); resp != nil {
+		return resp
+	}
+	h.Log.Info(req.Context(), "removed repo", zap.String("repo", key))
+	return &httpserver.BasicResponse{
+		Code: http.StatusOK,
+		Msg:  strings.NewReader("OK"),
+	}
+}
+
+// updateReposNoLock serves repos in place of previous and saves them.  If they cannot be saved, previous is served
+// again, so what is served matches the repo config.  It returns nil on success.  Must hold h.reloadMu.
+func (h *CheckoutHandler) updateReposNoLock(ctx context.Context, previous []Repository, repos []Repository) httpserver.CanHTTPWrite {
+	if err := h.reloadNoLock(ctx, repos); err != nil {
+		h.Log.Warn(ctx, "unable to update repos", zap.Error(err))
+		return &httpserver.BasicResponse{
+			Code: http.StatusBadRequest,
+			Msg:  strings.NewReader(fmt.Sprintf("unable to update repos: %v", err)),
+		}
+	}
+	if h.setup.cfg.SaveRepos == nil {
+		return nil
+	}
+	if err := h.setup.cfg.SaveRepos(ctx, repos); err != nil {
+		h.Log.Error(ctx, "unable to save repos", zap.Error(err))
+		h.Log.IfErr(h.reloadNoLock(ctx, previous)).Error(ctx, "unable to restore repos")
+		return &httpserver.BasicResponse{
+			Code: http.StatusInternalServerError,
+			Msg:  strings.NewReader(fmt.Sprintf("unable to save repos: %v", err)),
+		}
+	}
+	return nil
+}
+
+// healHandler re-clones a repository and swaps the fresh clone in.  It waits for the clone to finish.
+func (h *CheckoutHandler) healHandler(req *http.Request) httpserver.CanHTTPWrite {
+	repo := mux.Vars(req)["repo"]
+	logger := h.Log.With(zap.String("repo", repo))
+	r, exists := h.checkout(repo)
+	if !exists {
+		return &httpserver.BasicResponse{
+			Code: http.StatusNotFound,
+			Msg:  strings.NewReader(fmt.Sprintf("unknown repo %s", repo)),
+		}
+	}
+	if err := r.Heal(req.Context()); err != nil {
+		if errors.Is(err, goget.ErrHealing) {
+			return &httpserver.BasicResponse{
+				Code: http.StatusConflict,
+				Msg:  strings.NewReader(err.Error()),
+			}
+		}
+		logger.Warn(req.Context(), "unable to heal repo", zap.Error(err))
+		return &httpserver.BasicResponse{
+			Code: http.StatusInternalServerError,
+			Msg:  strings.NewReader(fmt.Sprintf("unable to heal repo %s: %v", repo, err)),
+		}
+	}
+	logger.Info(req.Context(), "healed repo", zap.String("into", r.AbsPath()))
+	return &httpserver.BasicResponse{
+		Code: http.StatusOK,
+		Msg:  strings.NewReader("OK"),
+	}
+}
+
+// rotateKeyRequest overrides the credentials in the repository config.  Empty fields keep their configured value, so
+// an empty body re-reads the configured key file.
+type rotateKeyRequest struct {
+	PrivateKey         string
+	PrivateKeyPassword string
+}
+
+func (h *CheckoutHandler) rotateKeyHandler(req *http.Request) httpserver.CanHTTPWrite {
+	vars := mux.Vars(req)
+	repo := vars["repo"]
+	logger := h.Log.With(zap.String("repo", repo))
+	r, exists := h.checkout(repo)
+	if !exists {
+		return &httpserver.BasicResponse{
+			Code: http.StatusNotFound,
+			Msg:  strings.NewReader(fmt.Sprintf("unknown repo %s", repo)),
+		}
+	}
+	var body rotateKeyRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		return &httpserver.BasicResponse{
+			Code: http.StatusBadRequest,
+			Msg:  strings.NewReader(fmt.Sprintf("unable to decode body: %v", err)),
+		}
+	}
+	repoCfg, _ := h.repoConfig(repo)
+	if body.PrivateKey != "" {
+		repoCfg.PrivateKey = body.PrivateKey
+	}
+	if body.PrivateKeyPassword != "" {
+		repoCfg.PrivateKeyPassword = body.PrivateKeyPassword
+	}
+	authMethod, err := getAuthMethod(repoCfg)
+	if err != nil {
+		logger.Warn(req.Context(), "unable to load private key", zap.Error(err))
+		return &httpserver.BasicResponse{
+			Code: http.StatusBadRequest,
+			Msg:  strings.NewReader(fmt.Sprintf("unable to load private key: %v", err)),
+		}
+	}
+	if err := r.RotateAuth(req.Context(), authMethod); err != nil {
+		logger.Warn(req.Context(), "unable to rotate key", zap.Error(err))
+		return &httpserver.BasicResponse{
+			Code: http.StatusBadRequest,
+			Msg:  strings.NewReader(err.Error()),
+		}
+	}
+	return &httpserver.BasicResponse{
+		Code: http.StatusOK,
+		Msg:  strings.NewReader("OK"),
+	}
+}
+
+func (h *CheckoutHandler) workTreeHandler(req *http.Request) httpserver.CanHTTPWrite {
+	repo := mux.Vars(req)["repo"]
+	r, exists := h.checkout(repo)
+	if !exists {
+		return &httpserver.BasicResponse{
+			Code: http.StatusNotFound,
+			Msg:  strings.NewReader(fmt.Sprintf("unknown repo %s", repo)),
+		}
+	}
+	info, exists := r.WorkTree()
+	if !exists {
+		return &httpserver.BasicResponse{
+			Code: http.StatusNotFound,
+			Msg:  strings.NewReader(fmt.Sprintf("repo %s has no work tree", repo)),
+		}
+	}
+	b, err := json.Marshal(info)
+	if err != nil {
+		return &httpserver.BasicResponse{
+			Code: http.StatusInternalServerError,
+			Msg:  strings.NewReader(fmt.Sprintf("unable to encode work tree: %v", err)),
+		}
+	}
+	return &httpserver.BasicResponse{
+		Code: http.StatusOK,
+		Msg:  bytes.NewReader(b),
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+	}
+}
+
+// refsHandler lists recent branch updates, newest first.  The repo, branch and commit query parameters narrow it
+// down, so "?repo=x&commit=abc123" tells when repo x started serving commit abc123.
+func (h *CheckoutHandler) refsHandler(req *http.Request) httpserver.CanHTTPWrite {
+	query := req.URL.Query()
+	filter := goget.RefJournalFilter{
+		Branch: query.Get("branch"),
+		Commit: query.Get("commit"),
+	}
+	if repo := query.Get("repo"); repo != "" {
+		r, exists := h.checkout(repo)
+		if !exists {
+			return &httpserver.BasicResponse{
+				Code: http.StatusNotFound,
+				Msg:  strings.NewReader(fmt.Sprintf("unknown repo %s", repo)),
+			}
+		}
+		filter.Repo = r.RemoteURL()
+	}
+	b, err := json.Marshal(h.refJournal.Entries(filter))
+	if err != nil {
+		return &httpserver.BasicResponse{
+			Code: http.StatusInternalServerError,
+			Msg:  strings.NewReader(fmt.Sprintf("unable to encode ref updates: %v", err)),
+		}
+	}
+	return &httpserver.BasicResponse{
+		Code: http.StatusOK,
+		Msg:  bytes.NewReader(b),
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+	}
+}
+
+func (h *CheckoutHandler) clonesHandler(_ *http.Request) httpserver.CanHTTPWrite {
+	b, err := json.Marshal(h.cloneTracker.Snapshot())
+	if err != nil {
+		return &httpserver.BasicResponse{
+			Code: http.StatusInternalServerError,
+			Msg:  strings.NewReader(fmt.Sprintf("unable to encode clones: %v", err)),
+		}
+	}
+	return &httpserver.BasicResponse{
+		Code: http.StatusOK,
+		Msg:  bytes.NewReader(b),
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+	}
+}
+
+// RepoVerifyReport is the result of verifying one repository against its remote
+type RepoVerifyReport struct {
+	goget.VerifyReport
+	Repo  string
+	Error string `json:",omitempty"`
+}
+
+// verifyHandler checks every checkout against its remote.  It responds 409 if any repository drifted or failed to
+// verify, so a cron job can alert on the status code alone.
+func (h *CheckoutHandler) verifyHandler(req *http.Request) httpserver.CanHTTPWrite {
+	checkouts := h.checkouts()
+	repos := make([]string, 0, len(checkouts))
+	for repo := range checkouts {
+		repos = append(repos, repo)
+	}
+	sort.Strings(repos)
+	code := http.StatusOK
+	reports := make([]RepoVerifyReport, 0, len(repos))
+	for _, repo := range repos {
+		report, err := checkouts[repo].Verify(req.Context())
+		r := RepoVerifyReport{VerifyReport: report, Repo: repo}
+		if err != nil {
+			h.Log.Warn(req.Context(), "unable to verify repo", zap.String("repo", repo), zap.Error(err))
+			r.Error = err.Error()
+		}
+		if err != nil || report.Drifted() {
+			code = http.StatusConflict
+		}
+		reports = append(reports, r)
+	}
+	b, err := json.Marshal(reports)
+	if err != nil {
+		return &httpserver.BasicResponse{
+			Code: http.StatusInternalServerError,
+			Msg:  strings.NewReader(fmt.Sprintf("unable to encode reports: %v", err)),
+		}
+	}
+	return &httpserver.BasicResponse{
+		Code: code,
+		Msg:  bytes.NewReader(b),
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+	}
+}
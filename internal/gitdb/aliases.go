@@ -0,0 +1,83 @@
+package gitdb
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cresta/gitdb/internal/gitdb/metrics"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// DeprecatedAlias is another key a repository is served under while consumers move to its main key
+type DeprecatedAlias struct {
+	Name string
+	// Optional: when the alias was deprecated, in RFC 3339.  Unset sends "Deprecation: true".
+	Since string
+	// Optional: when the alias will stop being served, in RFC 3339
+	Sunset string
+}
+
+// deprecatedAlias is a DeprecatedAlias parsed into what it resolves to and the headers it adds
+type deprecatedAlias struct {
+	repoKey     string
+	deprecation string
+	sunset      string
+}
+
+func parseDeprecatedAlias(a DeprecatedAlias, repoKey string) (deprecatedAlias, error) {
+	ret := deprecatedAlias{
+		repoKey:     repoKey,
+		deprecation: "true",
+	}
+	if a.Name == "" {
+		return ret, fmt.Errorf("deprecated alias needs a Name")
+	}
+	if a.Since != "" {
+		since, err := time.Parse(time.RFC3339, a.Since)
+		if err != nil {
+			return ret, fmt.Errorf("invalid Since for deprecated alias %s: %w", a.Name, err)
+		}
+		// RFC 9745 structured date
+		ret.deprecation = "@" + strconv.FormatInt(since.Unix(), 10)
+	}
+	if a.Sunset != "" {
+		sunset, err := time.Parse(time.RFC3339, a.Sunset)
+		if err != nil {
+			return ret, fmt.Errorf("invalid Sunset for deprecated alias %s: %w", a.Name, err)
+		}
+		ret.sunset = sunset.UTC().Format(http.TimeFormat)
+	}
+	return ret, nil
+}
+
+// AliasMiddleware serves requests for a repository's DeprecatedAliases as if they used its key.  Responses carry
+// Deprecation and Sunset headers, and each request is counted so remaining consumers can be found before the alias is
+// removed.
+func (h *CheckoutHandler) AliasMiddleware() func(http.Handler) http.Handler {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			vars := mux.Vars(request)
+			alias, exists := h.deprecatedAlias(vars["repo"])
+			if !exists {
+				handler.ServeHTTP(writer, request)
+				return
+			}
+			h.Log.Debug(request.Context(), "request for deprecated alias", zap.String("alias", vars["repo"]), zap.String("repo", alias.repoKey))
+			h.metrics.Count("gitdb_deprecated_alias_requests_total", 1, metrics.Tags{"alias": vars["repo"], "repo": alias.repoKey})
+			writer.Header().Set("Deprecation", alias.deprecation)
+			if alias.sunset != "" {
+				writer.Header().Set("Sunset", alias.sunset)
+			}
+			resolved := make(map[string]string, len(vars))
+			for k, v := range vars {
+				resolved[k] = v
+			}
+			resolved["repo"] = alias.repoKey
+			// The route already matched, so handlers only see the new variables
+			handler.ServeHTTP(writer, mux.SetURLVars(request, resolved))
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package gitdb
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/cresta/gitdb/internal/gitdb/goget"
+	"github.com/cresta/gitdb/internal/gitdb/metrics"
+	"github.com/cresta/gitdb/internal/httpserver"
+	"github.com/cresta/gitdb/internal/log"
+	"go.uber.org/zap"
+)
+
+// Bytes of an archive held back before the response starts, so small archives and early failures, like an unknown
+// branch or no files, still get a proper status
+const zipStartBytes = 32 * 1024
+
+// ErrArchiveTooLarge is returned by writes past Config.MaxArchiveBytes
+var ErrArchiveTooLarge = errors.New("archive too large")
+
+// streamedArchive writes a zip or tarball to the client as it is built, instead of in memory, with its Digest as a
+// trailer.  Errors once the response started drop the connection, so the client cannot mistake a truncated archive for
+// a whole one.
+type streamedArchive struct {
+	// Writes the archive into w and returns how many files it holds
+	write       func(ctx context.Context, w io.Writer) (int, error)
+	contentType string
+	// Extra headers, like Vary for negotiated formats
+	headers map[string]string
+	branch  string
+	dir     string
+	// Zero is unlimited
+	maxBytes int64
+	metrics  metrics.Metrics
+	tags     metrics.Tags
+	log      *log.Logger
+}
+
+func (s *streamedArchive) HTTPWrite(ctx context.Context, w http.ResponseWriter, l *log.Logger) {
+	out := &archiveResponseWriter{w: w, hash: sha256.New(), limit: s.maxBytes, contentType: s.contentType, headers: s.headers}
+	buf := bufio.NewWriterSize(out, zipStartBytes)
+	// zip.Writer flushes a *bufio.Writer it is given on Close, which would start an empty zip's response
+	numFiles, err := s.write(ctx, struct{ io.Writer }{buf})
+	if err == nil && numFiles > 0 {
+		err = buf.Flush()
+	}
+	if !out.started {
+		s.errorResponse(ctx, err, numFiles).HTTPWrite(ctx, w, l)
+		return
+	}
+	if err != nil {
+		s.log.Error(ctx, "unable to stream archive", zap.Error(err), zap.Int("files", numFiles), zap.Int64("bytes", out.n))
+		panic(http.ErrAbortHandler)
+	}
+	w.Header().Set("Digest", httpserver.DigestValue(out.hash.Sum(nil)))
+	s.metrics.Observe("gitdb_archive_size_bytes", float64(out.n), s.tags)
+}
+
+// errorResponse is sent when the archive failed, or had no files, before any of it was
+func (s *streamedArchive) errorResponse(ctx context.Context, err error, numFiles int) httpserver.CanHTTPWrite {
+	if err == nil && numFiles == 0 {
+		s.log.Warn(ctx, "no files in path")
+		return &httpserver.BasicResponse{
+			Code: http.StatusNotFound,
+			Msg:  strings.NewReader(fmt.Sprintf("no files in path %s", s.dir)),
+		}
+	}
+	if errors.Is(err, goget.ErrUnknownBranch) {
+		return &httpserver.BasicResponse{
+			Code: http.StatusNotFound,
+			Msg:  strings.NewReader(fmt.Sprintf("branch not found %s", s.branch)),
+		}
+	}
+	if errors.Is(err, ErrArchiveTooLarge) {
+		s.log.Warn(ctx, "archive too large", zap.Int64("limit", s.maxBytes))
+		return &httpserver.BasicResponse{
+			Code: http.StatusRequestEntityTooLarge,
+			Msg:  strings.NewReader(fmt.Sprintf("unable to archive %s: %v", s.dir, err)),
+		}
+	}
+	s.log.Warn(ctx, "unable to archive content", zap.Error(err))
+	return &httpserver.BasicResponse{
+		Code: http.StatusInternalServerError,
+		Msg:  strings.NewReader(fmt.Sprintf("unable to archive content for %s: %v", s.dir, err)),
+	}
+}
+
+var _ httpserver.CanHTTPWrite = &streamedArchive{}
+
+// archiveResponseWriter starts the response on the first write, hashing and counting what it sends
+type archiveResponseWriter struct {
+	w           http.ResponseWriter
+	hash        hash.Hash
+	limit       int64
+	contentType string
+	headers     map[string]string
+	n           int64
+	started     bool
+}
+
+func (z *archiveResponseWriter) Write(p []byte) (int, error) {
+	if z.limit > 0 && z.n+int64(len(p)) > z.limit {
+		return 0, fmt.Errorf("%w: limit is %d bytes", ErrArchiveTooLarge, z.limit)
+	}
+	if !z.started {
+		for k, v := range z.headers {
+			z.w.Header().Set(k, v)
+		}
+		z.w.Header().Set("Content-Type", z.contentType)
+		z.w.Header().Set("Trailer", "Digest")
+		z.w.WriteHeader(http.StatusOK)
+		z.started = true
+	}
+	n, err := z.w.Write(p)
+	z.hash.Write(p[:n])
+	z.n += int64(n)
+	return n, err
+}
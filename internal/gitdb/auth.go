@@ -0,0 +1,128 @@
+package gitdb
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cresta/gitdb/internal/gitdb/githubapp"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+)
+
+func getAuthMethod(repo Repository) (transport.AuthMethod, error) {
+	basicAuth, err := getBasicAuth(repo)
+	if err != nil {
+		return nil, err
+	}
+	pKey := strings.TrimSpace(repo.PrivateKey)
+	if repo.GitHubAppID != 0 {
+		if basicAuth != nil || pKey != "" {
+			return nil, fmt.Errorf("GitHubAppID cannot be combined with PrivateKey, Username, Password, PasswordFile or TokenEnvVar")
+		}
+		return getGitHubAppAuth(repo)
+	}
+	if basicAuth != nil {
+		if pKey != "" {
+			return nil, fmt.Errorf("PrivateKey cannot be combined with Username, Password, PasswordFile or TokenEnvVar")
+		}
+		return basicAuth, nil
+	}
+	if pKey == "" {
+		return nil, nil
+	}
+	sshKey, err := os.ReadFile(pKey)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read file %s: %w", pKey, err)
+	}
+	publicKey, err := ssh.NewPublicKeys("git", sshKey, repo.PrivateKeyPassword)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load public keys: %w", err)
+	}
+	return publicKey, nil
+}
+
+// gitHubAppMintTimeout bounds minting the first installation token, which checks the app's config at startup
+const gitHubAppMintTimeout = 30 * time.Second
+
+// getGitHubAppAuth authenticates as a GitHub App installation.  A first token is minted right away, so a wrong app,
+// installation or key fails at startup rather than as a rejected clone.
+func getGitHubAppAuth(repo Repository) (*githubapp.Auth, error) {
+	if err := requireHTTPS(repo.URL); err != nil {
+		return nil, err
+	}
+	keyFile := strings.TrimSpace(repo.GitHubAppPrivateKey)
+	if keyFile == "" {
+		return nil, fmt.Errorf("GitHubAppID needs GitHubAppPrivateKey")
+	}
+	key, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read file %s: %w", keyFile, err)
+	}
+	auth, err := githubapp.New(githubapp.Config{
+		AppID:          repo.GitHubAppID,
+		InstallationID: repo.GitHubAppInstallationID,
+		PrivateKey:     key,
+		APIURL:         strings.TrimSpace(repo.GitHubAPIURL),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid GitHub App config: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), gitHubAppMintTimeout)
+	defer cancel()
+	if _, err := auth.Token(ctx); err != nil {
+		return nil, err
+	}
+	return auth, nil
+}
+
+// requireHTTPS rejects remotes that cannot take username and password or token auth, like SSH ones
+func requireHTTPS(remoteURL string) error {
+	u := strings.ToLower(strings.TrimSpace(remoteURL))
+	if !strings.HasPrefix(u, "https://") && !strings.HasPrefix(u, "http://") {
+		return fmt.Errorf("HTTPS auth needs an https:// URL, not %s", remoteURL)
+	}
+	return nil
+}
+
+// getBasicAuth is the username and password or token for an https:// URL, or nil if none is configured
+func getBasicAuth(repo Repository) (*githttp.BasicAuth, error) {
+	username := strings.TrimSpace(repo.Username)
+	sources := 0
+	var password string
+	if repo.Password != "" {
+		sources++
+		password = repo.Password
+	}
+	if f := strings.TrimSpace(repo.PasswordFile); f != "" {
+		sources++
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read file %s: %w", f, err)
+		}
+		password = strings.TrimSpace(string(b))
+	}
+	if env := strings.TrimSpace(repo.TokenEnvVar); env != "" {
+		sources++
+		password = strings.TrimSpace(os.Getenv(env))
+		if password == "" {
+			return nil, fmt.Errorf("environment variable %s is empty", env)
+		}
+	}
+	if sources > 1 {
+		return nil, fmt.Errorf("only one of Password, PasswordFile and TokenEnvVar may be set")
+	}
+	if username == "" && sources == 0 {
+		return nil, nil
+	}
+	if err := requireHTTPS(repo.URL); err != nil {
+		return nil, err
+	}
+	if username == "" {
+		username = "git"
+	}
+	return &githttp.BasicAuth{Username: username, Password: password}, nil
+}
@@ -0,0 +1,45 @@
+package gitdb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetAuthMethod(t *testing.T) {
+	const url = "https://github.com/cresta/gitdb-reference.git"
+	auth, err := getAuthMethod(Repository{URL: url})
+	require.NoError(t, err)
+	require.Nil(t, auth)
+
+	auth, err = getAuthMethod(Repository{URL: url, Username: "bot", Password: "secret"})
+	require.NoError(t, err)
+	require.Equal(t, &githttp.BasicAuth{Username: "bot", Password: "secret"}, auth)
+
+	passwordFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(passwordFile, []byte("from-file\n"), 0o600))
+	auth, err = getAuthMethod(Repository{URL: url, PasswordFile: passwordFile})
+	require.NoError(t, err)
+	require.Equal(t, &githttp.BasicAuth{Username: "git", Password: "from-file"}, auth)
+
+	t.Setenv("GITDB_TEST_TOKEN", "from-env")
+	auth, err = getAuthMethod(Repository{URL: url, TokenEnvVar: "GITDB_TEST_TOKEN"})
+	require.NoError(t, err)
+	require.Equal(t, &githttp.BasicAuth{Username: "git", Password: "from-env"}, auth)
+
+	_, err = getAuthMethod(Repository{URL: url, TokenEnvVar: "GITDB_TEST_UNSET_TOKEN"})
+	require.Error(t, err)
+	_, err = getAuthMethod(Repository{URL: url, Password: "secret", TokenEnvVar: "GITDB_TEST_TOKEN"})
+	require.Error(t, err)
+	_, err = getAuthMethod(Repository{URL: "git@github.com:cresta/gitdb-reference.git", Password: "secret"})
+	require.Error(t, err)
+	_, err = getAuthMethod(Repository{URL: url, Password: "secret", PrivateKey: "/tmp/id_rsa"})
+	require.Error(t, err)
+	_, err = getAuthMethod(Repository{URL: url, Password: "secret", GitHubAppID: 7})
+	require.Error(t, err)
+	_, err = getAuthMethod(Repository{URL: url, GitHubAppID: 7, GitHubAppInstallationID: 42})
+	require.Error(t, err)
+}
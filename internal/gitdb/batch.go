@@ -0,0 +1,153 @@
+package gitdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/cresta/gitdb/internal/gitdb/goget"
+	"github.com/cresta/gitdb/internal/httpserver"
+	"github.com/cresta/gitdb/internal/log"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// Most paths one batch request may ask for
+const maxBatchPaths = 1000
+
+// BatchRequest is the body of POST /batch/{repo}/{branch}
+type BatchRequest struct {
+	Paths []string
+}
+
+// BatchResponse is every requested file, read at the same Commit
+type BatchResponse struct {
+	Commit string
+	Files  []goget.BatchFile
+}
+
+// batchHandler reads many files at one commit in a single request.  Files that fail to read carry an Error in the
+// response instead of failing the whole batch, but a path the Authorizer denies fails it.  Clients that Accept
+// application/x-ndjson get each BatchFile on its own line as it is read, with the commit only in X-Gitdb-Commit.
+func (h *CheckoutHandler) batchHandler(req *http.Request) httpserver.CanHTTPWrite {
+	vars := mux.Vars(req)
+	repo := vars["repo"]
+	branch := vars["branch"]
+	logger := h.Log.With(zap.String("repo", repo), zap.String("branch", branch))
+	r, exists := h.checkout(repo)
+	if !exists {
+		logger.Warn(req.Context(), "invalid repo")
+		return &httpserver.BasicResponse{Code: http.StatusNotFound, Msg: strings.NewReader(fmt.Sprintf("unable to find repo %s", repo))}
+	}
+	var body BatchRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		return &httpserver.BasicResponse{
+			Code: http.StatusBadRequest,
+			Msg:  strings.NewReader(fmt.Sprintf("unable to decode body: %v", err)),
+		}
+	}
+	if len(body.Paths) == 0 || len(body.Paths) > maxBatchPaths {
+		return &httpserver.BasicResponse{
+			Code: http.StatusBadRequest,
+			Msg:  strings.NewReader(fmt.Sprintf("expected between 1 and %d paths, got %d", maxBatchPaths, len(body.Paths))),
+		}
+	}
+	// Each path is authorized as if it were read from /file, so a batch cannot read what the policy denies there
+	for _, p := range body.Paths {
+		if resp := h.authorizePath(req, EndpointFile, p); resp != nil {
+			return resp
+		}
+	}
+	contentType := httpserver.NegotiateContentType(req, "application/json", "application/x-ndjson")
+	if contentType == "application/x-ndjson" {
+		return &streamedBatch{
+			checkout: r,
+			branch:   branch,
+			paths:    body.Paths,
+			log:      logger,
+		}
+	}
+	commit, files, err := r.GetFiles(req.Context(), branch, body.Paths)
+	if err != nil {
+		return batchError(req.Context(), err, branch, logger)
+	}
+	b, err := json.Marshal(BatchResponse{Commit: commit, Files: files})
+	if err != nil {
+		return &httpserver.BasicResponse{
+			Code: http.StatusInternalServerError,
+			Msg:  strings.NewReader(fmt.Sprintf("unable to encode files: %v", err)),
+		}
+	}
+	return &httpserver.BasicResponse{
+		Code: http.StatusOK,
+		Msg:  bytes.NewReader(b),
+		Headers: map[string]string{
+			"Content-Type":   "application/json",
+			"Vary":           "Accept",
+			"X-Gitdb-Commit": commit,
+		},
+	}
+}
+
+func batchError(ctx context.Context, err error, branch string, logger *log.Logger) httpserver.CanHTTPWrite {
+	if errors.Is(err, goget.ErrUnknownBranch) {
+		return &httpserver.BasicResponse{
+			Code: http.StatusNotFound,
+			Msg:  strings.NewReader(fmt.Sprintf("branch not found %s", branch)),
+		}
+	}
+	logger.Warn(ctx, "unable to read batch", zap.Error(err))
+	return &httpserver.BasicResponse{
+		Code: http.StatusInternalServerError,
+		Msg:  strings.NewReader(fmt.Sprintf("unable to read files: %v", err)),
+	}
+}
+
+// streamedBatch writes NDJSON files to the client as they are read.  The status line waits for the first file, so
+// failing to resolve the branch is still a proper error; errors after it drop the connection, so the client cannot
+// mistake a truncated batch for a whole one.
+type streamedBatch struct {
+	checkout Checkout
+	branch   string
+	paths    []string
+	log      *log.Logger
+}
+
+func (s *streamedBatch) HTTPWrite(ctx context.Context, w http.ResponseWriter, l *log.Logger) {
+	flusher, _ := w.(http.Flusher)
+	cw := &countingWriter{w: w}
+	enc := json.NewEncoder(cw)
+	numFiles := 0
+	err := s.checkout.StreamFiles(ctx, s.branch, s.paths, func(commit string, f goget.BatchFile) error {
+		if numFiles == 0 {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.Header().Set("Vary", "Accept")
+			w.Header().Set("X-Gitdb-Commit", commit)
+			w.WriteHeader(http.StatusOK)
+		}
+		if err := enc.Encode(f); err != nil {
+			return fmt.Errorf("unable to encode file %s: %w", f.Path, err)
+		}
+		numFiles++
+		// Flush the first file at once, for first-byte latency
+		if flusher != nil && (numFiles == 1 || numFiles%streamFlushEvery == 0) {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if numFiles == 0 && err != nil {
+		batchError(ctx, err, s.branch, s.log).HTTPWrite(ctx, w, l)
+		return
+	}
+	if err != nil {
+		s.log.Error(ctx, "unable to stream batch", zap.Error(err), zap.Int("files", numFiles), zap.Int64("bytes", cw.n))
+		panic(http.ErrAbortHandler)
+	}
+	s.log.Debug(ctx, "streamed batch", zap.Int("files", numFiles))
+}
+
+var _ httpserver.CanHTTPWrite = &streamedBatch{}
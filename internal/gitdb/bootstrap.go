@@ -0,0 +1,155 @@
+package gitdb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cresta/gitdb/internal/gitdb/goget"
+	"github.com/cresta/gitdb/internal/httpserver"
+	"github.com/cresta/gitdb/internal/log"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// bundleAllHandler serves a bundle of every branch and tag of a repository, which new replicas bootstrap from
+func (h *CheckoutHandler) bundleAllHandler(req *http.Request) httpserver.CanHTTPWrite {
+	repo := mux.Vars(req)["repo"]
+	logger := h.Log.With(zap.String("repo", repo))
+	r, exists := h.checkout(repo)
+	if !exists {
+		logger.Warn(req.Context(), "invalid repo")
+		return &httpserver.BasicResponse{Code: http.StatusNotFound, Msg: strings.NewReader(fmt.Sprintf("unable to find repo %s", repo))}
+	}
+	var buf bytes.Buffer
+	if err := r.BundleAll(req.Context(), &buf); err != nil {
+		logger.Warn(req.Context(), "unable to bundle content", zap.Error(err))
+		return &httpserver.BasicResponse{
+			Code: http.StatusInternalServerError,
+			Msg:  strings.NewReader(fmt.Sprintf("unable to bundle repo %s: %v", repo, err)),
+		}
+	}
+	return &httpserver.DigestResponse{BasicResponse: httpserver.BasicResponse{
+		Code: http.StatusOK,
+		Msg:  &buf,
+		Headers: map[string]string{
+			"Content-Type":        "application/x-git-bundle",
+			"Content-Disposition": fmt.Sprintf("attachment; filename=%q", sanitizeDir(repo)+".bundle"),
+		},
+	}}
+}
+
+// cloneRepo clones remoteURL, from the bundle at Config.BootstrapURL when one is set.  A bootstrapped checkout is then
+// refreshed from upstream.  Failing to bootstrap falls back to cloning upstream.  cloneOpts restrict what is fetched
+// from upstream.
+func (c Config) cloneRepo(ctx context.Context, g *goget.GitOperator, s goget.Storage, repoKey string, remoteURL string, auth transport.AuthMethod, cloneOpts goget.CloneOptions, logger *log.Logger) (*goget.GitCheckout, error) {
+	name := cloneName(remoteURL)
+	if c.BootstrapURL == "" {
+		return g.CloneStorageOptions(ctx, s, name, remoteURL, auth, cloneOpts)
+	}
+	co, err := c.bootstrap(ctx, g, s, name, repoKey, remoteURL, auth)
+	if err != nil {
+		logger.Warn(ctx, "unable to bootstrap repo, cloning from upstream", zap.String("repo", remoteURL), zap.Error(err))
+		return g.CloneStorageOptions(ctx, s, name, remoteURL, auth, cloneOpts)
+	}
+	if err := co.SetCloneOptions(cloneOpts); err != nil {
+		return nil, fmt.Errorf("unable to set clone options: %w", err)
+	}
+	// Serving slightly old content beats failing to start while upstream is down
+	logger.IfErr(co.Refresh(goget.WithTrigger(ctx, goget.TriggerBootstrap))).Warn(ctx, "unable to refresh bootstrapped repo", zap.String("repo", remoteURL))
+	return co, nil
+}
+
+// setupClone clones repo with cloneRepo and applies the settings only a clone has, like healing and maintenance windows
+func (c Config) setupClone(ctx context.Context, g *goget.GitOperator, s goget.Storage, repo Repository, repoKey string, trimmedRepoURL string, authMethod transport.AuthMethod, cloneOpts goget.CloneOptions, dataDir string, logger *log.Logger) (*goget.GitCheckout, error) {
+	co, err := c.cloneRepo(ctx, g, s, repoKey, trimmedRepoURL, authMethod, cloneOpts, logger)
+	if err != nil {
+		return nil, fmt.Errorf("unable to clone repo %s: %w", trimmedRepoURL, err)
+	}
+	co.SetRecloner(func(ctx context.Context, auth transport.AuthMethod) (*goget.GitCheckout, error) {
+		return g.CloneStorageOptions(ctx, s, cloneName(trimmedRepoURL), trimmedRepoURL, auth, cloneOpts)
+	})
+	co.SetFetchOnMiss(repo.FetchOnMiss)
+	if repo.DeletedBranchGracePeriod != "" {
+		grace, err := time.ParseDuration(repo.DeletedBranchGracePeriod)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DeletedBranchGracePeriod for repo %s: %w", trimmedRepoURL, err)
+		}
+		co.SetDeletedBranchGrace(grace)
+	}
+	if len(repo.MaintenanceWindows) > 0 {
+		windows := make([]goget.MaintenanceWindow, 0, len(repo.MaintenanceWindows))
+		for _, w := range repo.MaintenanceWindows {
+			window, err := goget.ParseMaintenanceWindow(w)
+			if err != nil {
+				return nil, fmt.Errorf("invalid MaintenanceWindows for repo %s: %w", trimmedRepoURL, err)
+			}
+			windows = append(windows, window)
+		}
+		co.SetMaintenanceWindows(windows)
+	}
+	if repo.WorkTreeBranch != "" {
+		workTreeDir, err := os.MkdirTemp(dataDir, "gitdb_worktree_"+sanitizeDir(trimmedRepoURL))
+		if err != nil {
+			return nil, fmt.Errorf("unable to make work tree dir for %s: %w", trimmedRepoURL, err)
+		}
+		if err := co.EnableWorkTree(ctx, workTreeDir, repo.WorkTreeBranch); err != nil {
+			return nil, fmt.Errorf("unable to set up work tree for %s: %w", trimmedRepoURL, err)
+		}
+	}
+	return co, nil
+}
+
+// cloneOptions is what clones of the repository fetch
+func (r Repository) cloneOptions() (goget.CloneOptions, error) {
+	specs := append([]string{}, r.FetchRefSpecs...)
+	for _, b := range r.Branches {
+		specs = append(specs, "+refs/heads/"+strings.TrimPrefix(strings.TrimSpace(b), "refs/heads/"))
+	}
+	refSpecs, err := goget.ParseFetchRefSpecs(specs)
+	if err != nil {
+		return goget.CloneOptions{}, fmt.Errorf("invalid FetchRefSpecs or Branches: %w", err)
+	}
+	if r.Depth < 0 {
+		return goget.CloneOptions{}, fmt.Errorf("invalid Depth %d, expected a number of commits", r.Depth)
+	}
+	if r.SingleBranch && len(refSpecs) > 0 {
+		return goget.CloneOptions{}, fmt.Errorf("SingleBranch cannot be combined with FetchRefSpecs or Branches")
+	}
+	return goget.CloneOptions{RefSpecs: refSpecs, SingleBranch: r.SingleBranch, Depth: r.Depth}, nil
+}
+
+// cloneName names the storage of a clone of remoteURL
+func cloneName(remoteURL string) string {
+	return "gitdb_repo_" + sanitizeDir(remoteURL)
+}
+
+func (c Config) bootstrap(ctx context.Context, g *goget.GitOperator, s goget.Storage, name string, repoKey string, remoteURL string, auth transport.AuthMethod) (*goget.GitCheckout, error) {
+	bundleURL := strings.ReplaceAll(c.BootstrapURL, "{repo}", url.PathEscape(repoKey))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, bundleURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create request for %s: %w", bundleURL, err)
+	}
+	client := c.BootstrapClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch %s: %w", bundleURL, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("unexpected status " + resp.Status + " from " + bundleURL)
+	}
+	return g.CloneStorageFromBundle(ctx, s, name, remoteURL, auth, resp.Body)
+}
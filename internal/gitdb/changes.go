@@ -0,0 +1,48 @@
+package gitdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/cresta/gitdb/internal/httpserver"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+func (h *CheckoutHandler) changesHandler(req *http.Request) httpserver.CanHTTPWrite {
+	vars := mux.Vars(req)
+	repo := vars["repo"]
+	branch := vars["branch"]
+	logger := h.Log.With(zap.String("repo", repo), zap.String("branch", branch))
+	logger.Debug(req.Context(), "changes handler")
+	r, exists := h.checkout(repo)
+	if !exists {
+		buf := strings.NewReader(fmt.Sprintf("unable to find repo %s", repo))
+		logger.Warn(req.Context(), "invalid repo")
+		return &httpserver.BasicResponse{Code: http.StatusNotFound, Msg: buf}
+	}
+	change, exists := r.Changes(branch)
+	if !exists {
+		return &httpserver.BasicResponse{
+			Code: http.StatusNotFound,
+			Msg:  strings.NewReader(fmt.Sprintf("no changes recorded for branch %s", branch)),
+		}
+	}
+	b, err := json.Marshal(change)
+	if err != nil {
+		return &httpserver.BasicResponse{
+			Code: http.StatusInternalServerError,
+			Msg:  strings.NewReader(fmt.Sprintf("unable to encode changes: %v", err)),
+		}
+	}
+	return &httpserver.BasicResponse{
+		Code: http.StatusOK,
+		Msg:  bytes.NewReader(b),
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+	}
+}
@@ -0,0 +1,63 @@
+package gitdb
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/cresta/gitdb/internal/gitdb/goget"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// Checkout is a repository as CheckoutHandler serves it.  *goget.GitCheckout is the real one; handler tests use the
+// in memory one in testhelp/fakecheckout so they need no clone.
+type Checkout interface {
+	RemoteURL() string
+	AbsPath() string
+
+	// Files
+	GetFile(ctx context.Context, branch string, path string) (io.WriterTo, error)
+	GetFileWithInfo(ctx context.Context, branch string, path string) (io.WriterTo, goget.FileInfo, error)
+	GetFiles(ctx context.Context, branch string, paths []string) (string, []goget.BatchFile, error)
+	StreamFiles(ctx context.Context, branch string, paths []string, callback func(commit string, f goget.BatchFile) error) error
+	StatFile(ctx context.Context, branch string, path string) (goget.FileInfo, int64, error)
+	Gzipped(branch string, path string, info goget.FileInfo, content []byte) ([]byte, bool)
+
+	// Directories
+	LsDir(ctx context.Context, dir string, branch string) ([]goget.FileStat, error)
+	LastModified(ctx context.Context, dir string, branch string) (map[string]time.Time, error)
+	LsFiles(ctx context.Context, branch string) ([]string, error)
+	WalkDir(ctx context.Context, dir string, branch string, recursive bool, callback func(goget.FileStat) error) error
+	WalkFiles(ctx context.Context, branch string, callback func(f *object.File) error) (string, error)
+
+	// Archives
+	ZipContent(ctx context.Context, into io.Writer, prefix string, branch string) (int, error)
+	ZipContents(ctx context.Context, into io.Writer, prefixes []goget.ZipPrefix, branch string) (int, error)
+	Tar(ctx context.Context, into io.Writer, prefixes []goget.ZipPrefix, branch string) (int, error)
+	Bundle(ctx context.Context, into io.Writer, branch string) error
+	BundleAll(ctx context.Context, into io.Writer) error
+
+	// History
+	Commit(ctx context.Context, branch string) (goget.CommitInfo, error)
+	Commits(ctx context.Context, branch string, limit int) ([]goget.CommitInfo, error)
+	Log(ctx context.Context, branch string, filePath string, limit int) ([]goget.CommitInfo, error)
+	Diff(ctx context.Context, from string, to string, dir string, withPatch bool) (goget.TreeDiff, error)
+	Changes(branch string) (goget.BranchChange, bool)
+	HasHead(branch string, hash string) bool
+	BranchDeleted(branch string) (time.Time, bool)
+	Submodule(ctx context.Context, branch string, path string) (goget.Submodule, error)
+
+	// Upkeep
+	Refresh(ctx context.Context) error
+	RefreshedAt() time.Time
+	Revalidate(maxAge time.Duration) bool
+	InMaintenance(now time.Time) bool
+	Verify(ctx context.Context) (goget.VerifyReport, error)
+	Heal(ctx context.Context) error
+	RotateAuth(ctx context.Context, auth transport.AuthMethod) error
+	WorkTree() (goget.WorkTreeInfo, bool)
+	Close() error
+}
+
+var _ Checkout = &goget.GitCheckout{}
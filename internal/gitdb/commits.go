@@ -0,0 +1,178 @@
+package gitdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cresta/gitdb/internal/gitdb/goget"
+	"github.com/cresta/gitdb/internal/httpserver"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultCommitsLimit = 20
+	maxCommitsLimit     = 1000
+)
+
+// commitHandler describes the commit a branch points to, so clients can record which revision they consumed
+func (h *CheckoutHandler) commitHandler(req *http.Request) httpserver.CanHTTPWrite {
+	vars := mux.Vars(req)
+	repo := vars["repo"]
+	branch := vars["branch"]
+	logger := h.Log.With(zap.String("repo", repo), zap.String("branch", branch))
+	logger.Debug(req.Context(), "commit handler")
+	r, exists := h.checkout(repo)
+	if !exists {
+		buf := strings.NewReader(fmt.Sprintf("unable to find repo %s", repo))
+		logger.Warn(req.Context(), "invalid repo")
+		return &httpserver.BasicResponse{Code: http.StatusNotFound, Msg: buf}
+	}
+	commit, err := r.Commit(req.Context(), branch)
+	if err != nil {
+		if errors.Is(err, goget.ErrUnknownBranch) {
+			return &httpserver.BasicResponse{
+				Code: http.StatusNotFound,
+				Msg:  strings.NewReader(fmt.Sprintf("branch not found %s", branch)),
+			}
+		}
+		logger.Warn(req.Context(), "unable to read commit", zap.Error(err))
+		return &httpserver.BasicResponse{
+			Code: http.StatusInternalServerError,
+			Msg:  strings.NewReader(fmt.Sprintf("unable to read commit: %v", err)),
+		}
+	}
+	b, err := json.Marshal(commit)
+	if err != nil {
+		return &httpserver.BasicResponse{
+			Code: http.StatusInternalServerError,
+			Msg:  strings.NewReader(fmt.Sprintf("unable to encode commit: %v", err)),
+		}
+	}
+	return &httpserver.BasicResponse{
+		Code: http.StatusOK,
+		Msg:  bytes.NewReader(b),
+		Headers: map[string]string{
+			"Content-Type":   "application/json",
+			"X-Gitdb-Commit": commit.Hash,
+		},
+	}
+}
+
+func (h *CheckoutHandler) commitsHandler(req *http.Request) httpserver.CanHTTPWrite {
+	vars := mux.Vars(req)
+	repo := vars["repo"]
+	branch := vars["branch"]
+	logger := h.Log.With(zap.String("repo", repo), zap.String("branch", branch))
+	logger.Debug(req.Context(), "commits handler")
+	r, exists := h.checkout(repo)
+	if !exists {
+		buf := strings.NewReader(fmt.Sprintf("unable to find repo %s", repo))
+		logger.Warn(req.Context(), "invalid repo")
+		return &httpserver.BasicResponse{Code: http.StatusNotFound, Msg: buf}
+	}
+	limit, ok := commitsLimit(req)
+	if !ok {
+		return &httpserver.BasicResponse{
+			Code: http.StatusBadRequest,
+			Msg:  strings.NewReader(fmt.Sprintf("limit must be between 1 and %d", maxCommitsLimit)),
+		}
+	}
+	commits, err := r.Commits(req.Context(), branch, limit)
+	if err != nil {
+		if errors.Is(err, goget.ErrUnknownBranch) {
+			return &httpserver.BasicResponse{
+				Code: http.StatusNotFound,
+				Msg:  strings.NewReader(fmt.Sprintf("branch not found %s", branch)),
+			}
+		}
+		logger.Warn(req.Context(), "unable to list commits", zap.Error(err))
+		return &httpserver.BasicResponse{
+			Code: http.StatusInternalServerError,
+			Msg:  strings.NewReader(fmt.Sprintf("unable to list commits: %v", err)),
+		}
+	}
+	b, err := json.Marshal(commits)
+	if err != nil {
+		return &httpserver.BasicResponse{
+			Code: http.StatusInternalServerError,
+			Msg:  strings.NewReader(fmt.Sprintf("unable to encode commits: %v", err)),
+		}
+	}
+	return &httpserver.BasicResponse{
+		Code: http.StatusOK,
+		Msg:  bytes.NewReader(b),
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+	}
+}
+
+// logHandler lists the commits that changed a file or directory, newest first
+func (h *CheckoutHandler) logHandler(req *http.Request) httpserver.CanHTTPWrite {
+	vars := mux.Vars(req)
+	repo := vars["repo"]
+	branch := vars["branch"]
+	path := vars["path"]
+	logger := h.Log.With(zap.String("repo", repo), zap.String("branch", branch), zap.String("path", path))
+	logger.Debug(req.Context(), "log handler")
+	r, exists := h.checkout(repo)
+	if !exists {
+		buf := strings.NewReader(fmt.Sprintf("unable to find repo %s", repo))
+		logger.Warn(req.Context(), "invalid repo")
+		return &httpserver.BasicResponse{Code: http.StatusNotFound, Msg: buf}
+	}
+	limit, ok := commitsLimit(req)
+	if !ok {
+		return &httpserver.BasicResponse{
+			Code: http.StatusBadRequest,
+			Msg:  strings.NewReader(fmt.Sprintf("limit must be between 1 and %d", maxCommitsLimit)),
+		}
+	}
+	commits, err := r.Log(req.Context(), branch, path, limit)
+	if err != nil {
+		if errors.Is(err, goget.ErrUnknownBranch) {
+			return &httpserver.BasicResponse{
+				Code: http.StatusNotFound,
+				Msg:  strings.NewReader(fmt.Sprintf("branch not found %s", branch)),
+			}
+		}
+		logger.Warn(req.Context(), "unable to read log", zap.Error(err))
+		return &httpserver.BasicResponse{
+			Code: http.StatusInternalServerError,
+			Msg:  strings.NewReader(fmt.Sprintf("unable to read log: %v", err)),
+		}
+	}
+	b, err := json.Marshal(commits)
+	if err != nil {
+		return &httpserver.BasicResponse{
+			Code: http.StatusInternalServerError,
+			Msg:  strings.NewReader(fmt.Sprintf("unable to encode commits: %v", err)),
+		}
+	}
+	return &httpserver.BasicResponse{
+		Code: http.StatusOK,
+		Msg:  bytes.NewReader(b),
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+	}
+}
+
+// commitsLimit reads ?limit, defaulting to defaultCommitsLimit.  It is not ok if out of range.
+func commitsLimit(req *http.Request) (int, bool) {
+	l := req.URL.Query().Get("limit")
+	if l == "" {
+		return defaultCommitsLimit, true
+	}
+	parsed, err := strconv.Atoi(l)
+	if err != nil || parsed <= 0 || parsed > maxCommitsLimit {
+		return 0, false
+	}
+	return parsed, true
+}
@@ -0,0 +1,71 @@
+package gitdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cresta/gitdb/internal/gitdb/goget"
+	"github.com/cresta/gitdb/internal/httpserver"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// diffHandler lists the files that differ between two branches, tags or commits, like to preview promoting staging to
+// master.  ?dir limits the diff to a directory and ?patch=true adds unified diffs.
+func (h *CheckoutHandler) diffHandler(req *http.Request) httpserver.CanHTTPWrite {
+	vars := mux.Vars(req)
+	repo := vars["repo"]
+	from := vars["from"]
+	to := vars["to"]
+	logger := h.Log.With(zap.String("repo", repo), zap.String("from", from), zap.String("to", to))
+	logger.Debug(req.Context(), "diff handler")
+	r, exists := h.checkout(repo)
+	if !exists {
+		buf := strings.NewReader(fmt.Sprintf("unable to find repo %s", repo))
+		logger.Warn(req.Context(), "invalid repo")
+		return &httpserver.BasicResponse{Code: http.StatusNotFound, Msg: buf}
+	}
+	withPatch := false
+	if p := req.URL.Query().Get("patch"); p != "" {
+		var err error
+		if withPatch, err = strconv.ParseBool(p); err != nil {
+			return &httpserver.BasicResponse{
+				Code: http.StatusBadRequest,
+				Msg:  strings.NewReader(fmt.Sprintf("invalid patch %s: %v", p, err)),
+			}
+		}
+	}
+	diff, err := r.Diff(req.Context(), from, to, req.URL.Query().Get("dir"), withPatch)
+	if err != nil {
+		if errors.Is(err, goget.ErrUnknownBranch) {
+			return &httpserver.BasicResponse{
+				Code: http.StatusNotFound,
+				Msg:  strings.NewReader(fmt.Sprintf("unable to diff: %v", err)),
+			}
+		}
+		logger.Warn(req.Context(), "unable to diff", zap.Error(err))
+		return &httpserver.BasicResponse{
+			Code: http.StatusInternalServerError,
+			Msg:  strings.NewReader(fmt.Sprintf("unable to diff: %v", err)),
+		}
+	}
+	b, err := json.Marshal(diff)
+	if err != nil {
+		return &httpserver.BasicResponse{
+			Code: http.StatusInternalServerError,
+			Msg:  strings.NewReader(fmt.Sprintf("unable to encode diff: %v", err)),
+		}
+	}
+	return &httpserver.BasicResponse{
+		Code: http.StatusOK,
+		Msg:  bytes.NewReader(b),
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+	}
+}
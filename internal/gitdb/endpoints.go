@@ -0,0 +1,240 @@
+package gitdb
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cresta/gitdb/internal/httpserver"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// Endpoints a repository can turn off with Repository.DisabledEndpoints
+const (
+	EndpointFile     = "file"
+	EndpointLs       = "ls"
+	EndpointTree     = "tree"
+	EndpointZip      = "zip"
+	EndpointTar      = "tar"
+	EndpointBundle   = "bundle"
+	EndpointSqlite   = "sqlite"
+	EndpointLog      = "log"
+	EndpointDiff     = "diff"
+	EndpointSnapshot = "snapshot"
+	EndpointWrite    = "write"
+	EndpointCommits  = "commits"
+	EndpointChanges  = "changes"
+)
+
+// EndpointRepos lists every repository, so it is only given to the Authorizer and cannot be turned off per repository
+const EndpointRepos = "repos"
+
+var knownEndpoints = map[string]struct{}{
+	EndpointFile:     {},
+	EndpointLs:       {},
+	EndpointTree:     {},
+	EndpointZip:      {},
+	EndpointTar:      {},
+	EndpointBundle:   {},
+	EndpointSqlite:   {},
+	EndpointLog:      {},
+	EndpointDiff:     {},
+	EndpointSnapshot: {},
+	EndpointWrite:    {},
+	EndpointCommits:  {},
+	EndpointChanges:  {},
+}
+
+func validateDisabledEndpoints(repo Repository) error {
+	for _, e := range repo.DisabledEndpoints {
+		if _, exists := knownEndpoints[e]; !exists {
+			known := make([]string, 0, len(knownEndpoints))
+			for k := range knownEndpoints {
+				known = append(known, k)
+			}
+			sort.Strings(known)
+			return fmt.Errorf("unknown endpoint %s in DisabledEndpoints, expected one of %s", e, strings.Join(known, ","))
+		}
+	}
+	return nil
+}
+
+func (r Repository) endpointDisabled(endpoint string) bool {
+	for _, e := range r.DisabledEndpoints {
+		if e == endpoint {
+			return true
+		}
+	}
+	return false
+}
+
+// endpointGate rejects requests for endpoints the repository has disabled, or that the Authorizer denies, before the
+// handler does any work
+func (h *CheckoutHandler) endpointGate(endpoint string, handler func(req *http.Request) httpserver.CanHTTPWrite) func(req *http.Request) httpserver.CanHTTPWrite {
+	return func(req *http.Request) httpserver.CanHTTPWrite {
+		repo := mux.Vars(req)["repo"]
+		if repoCfg, exists := h.repoConfig(repo); exists && repoCfg.endpointDisabled(endpoint) {
+			h.Log.Info(req.Context(), "endpoint disabled for repo", zap.String("repo", repo), zap.String("endpoint", endpoint))
+			return &httpserver.BasicResponse{
+				Code: http.StatusForbidden,
+				Msg:  strings.NewReader(fmt.Sprintf("%s is disabled for repo %s", endpoint, repo)),
+			}
+		}
+		if resp := h.authorize(req, endpoint); resp != nil {
+			return resp
+		}
+		h.revalidate(req, repo)
+		return handler(req)
+	}
+}
+
+// revalidate starts a background refresh of repo if it is older than its RevalidateAfter.  The request is served from
+// the current checkout without waiting.
+func (h *CheckoutHandler) revalidate(req *http.Request, repo string) {
+	maxAge, exists := h.revalidateAge(repo)
+	if !exists {
+		return
+	}
+	if co, exists := h.checkout(repo); exists && co.Revalidate(maxAge) {
+		h.Log.Debug(req.Context(), "revalidating stale repo", zap.String("repo", repo), zap.Time("refreshed_at", co.RefreshedAt()))
+	}
+}
+
+// authorize returns a response rejecting req if the Authorizer denies it, or nil to serve it.  Requests fail closed
+// when the Authorizer is unavailable.
+func (h *CheckoutHandler) authorize(req *http.Request, endpoint string) httpserver.CanHTTPWrite {
+	return h.authorizePath(req, endpoint, "")
+}
+
+// authorizePath is authorize for one of many paths a request reads, like the files of a batch, given to the
+// Authorizer as the path variable.  An empty path authorizes req as routed.
+func (h *CheckoutHandler) authorizePath(req *http.Request, endpoint string, path string) httpserver.CanHTTPWrite {
+	if h.authorizer == nil {
+		return nil
+	}
+	input, err := httpserver.NewAuthzInput(req, endpoint)
+	if path != "" {
+		vars := make(map[string]string, len(input.Vars)+1)
+		for k, v := range input.Vars {
+			vars[k] = v
+		}
+		vars["path"] = path
+		input.Vars = vars
+	}
+	if err != nil {
+		h.Log.Warn(req.Context(), "unable to describe request for authorizer", zap.Error(err))
+		return &httpserver.BasicResponse{
+			Code: http.StatusInternalServerError,
+			Msg:  strings.NewReader("unable to authorize request"),
+		}
+	}
+	allowed, err := h.authorizer.Authorize(req.Context(), input)
+	if err != nil {
+		h.Log.Warn(req.Context(), "unable to authorize request", zap.Error(err))
+		return &httpserver.BasicResponse{
+			Code: http.StatusServiceUnavailable,
+			Msg:  strings.NewReader("unable to authorize request"),
+		}
+	}
+	if !allowed {
+		h.Log.Info(req.Context(), "request denied by authorizer", zap.String("endpoint", endpoint), zap.String("path", path))
+		msg := "request denied by policy"
+		if path != "" {
+			msg = fmt.Sprintf("path %s denied by policy", path)
+		}
+		return &httpserver.BasicResponse{
+			Code: http.StatusForbidden,
+			Msg:  strings.NewReader(msg),
+		}
+	}
+	return nil
+}
+
+// readLimitedRoutes are the routes of SetupMux expensive enough to count against MaxConcurrentReads
+var readLimitedRoutes = map[string]struct{}{
+	"tree_handler":       {},
+	"zip_dir_handler":    {},
+	"tar_dir_handler":    {},
+	"bundle_all_handler": {},
+	"bundle_handler":     {},
+	"sqlite_handler":     {},
+	"log_handler":        {},
+	"diff_handler":       {},
+	"snapshot_handler":   {},
+}
+
+// ReadLimitMiddleware applies limitReads to the expensive routes of SetupMux, for the chain of the routes serving
+// repository content
+func (h *CheckoutHandler) ReadLimitMiddleware() func(http.Handler) http.Handler {
+	return func(handler http.Handler) http.Handler {
+		limited := h.limitReads(handler)
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			if route := mux.CurrentRoute(request); route != nil {
+				if _, exists := readLimitedRoutes[route.GetName()]; exists {
+					limited.ServeHTTP(writer, request)
+					return
+				}
+			}
+			handler.ServeHTTP(writer, request)
+		})
+	}
+}
+
+// limitReads bounds how many expensive reads run at once for a repository with MaxConcurrentReads set.  The limit is
+// held until the response is written, since streamed responses do their work while writing.  Requests wait for a slot
+// until their context ends.
+func (h *CheckoutHandler) limitReads(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		repo := mux.Vars(request)["repo"]
+		sem, exists := h.readSemaphore(repo)
+		if !exists {
+			handler.ServeHTTP(writer, request)
+			return
+		}
+		select {
+		case sem <- struct{}{}:
+		case <-request.Context().Done():
+			h.Log.Warn(request.Context(), "gave up waiting for read slot", zap.String("repo", repo))
+			resp := httpserver.BasicResponse{
+				Code:    http.StatusServiceUnavailable,
+				Msg:     strings.NewReader(fmt.Sprintf("too many concurrent reads for repo %s", repo)),
+				Headers: map[string]string{"Retry-After": "1"},
+			}
+			resp.HTTPWrite(request.Context(), writer, h.Log)
+			return
+		}
+		defer func() {
+			<-sem
+		}()
+		handler.ServeHTTP(writer, request)
+	})
+}
+
+// ResponseHeadersMiddleware adds each repository's ResponseHeaders to responses for routes with a {repo} variable,
+// X-Gitdb-Branch-Deleted when the {branch} is only served because of DeletedBranchGracePeriod, and a Cache-Control for
+// RevalidateAfter unless ResponseHeaders sets one.  Headers a handler sets itself take precedence.
+func (h *CheckoutHandler) ResponseHeadersMiddleware() func(http.Handler) http.Handler {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			vars := mux.Vars(request)
+			if repoCfg, exists := h.repoConfig(vars["repo"]); exists {
+				if maxAge, exists := h.revalidateAge(vars["repo"]); exists && request.Method == http.MethodGet {
+					secs := int(maxAge.Seconds())
+					writer.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d, stale-while-revalidate=%d", secs, secs))
+				}
+				for k, v := range repoCfg.ResponseHeaders {
+					writer.Header().Set(k, v)
+				}
+				if co, exists := h.checkout(vars["repo"]); exists {
+					if deletedAt, deleted := co.BranchDeleted(vars["branch"]); deleted {
+						writer.Header().Set("X-Gitdb-Branch-Deleted", deletedAt.UTC().Format(time.RFC3339))
+					}
+				}
+			}
+			handler.ServeHTTP(writer, request)
+		})
+	}
+}
@@ -154,7 +154,107 @@ func TestGitgitCheckout_FileContent(t *testing.T) {
 	t.Run("file_in_dir", mustExist(defaultCheckout, "adir/file_in_directory.txt", "file_in_directory\n", "master"))
 	t.Run("on_master", mustExist(defaultCheckout, "on_master.txt", "true\n", "master"))
 	t.Run("on_staging", mustExist(defaultCheckout, "on_staging.txt", "staging\n", "staging"))
+	t.Run("default_branch", mustExist(defaultCheckout, "on_master.txt", "true\n", goget.DefaultBranch))
 
 	t.Run("bad_name", mustNotExist(defaultCheckout, "must_not_exist", "master"))
 	t.Run("bad_name_for_master", mustNotExist(defaultCheckout, "on_master.txt", "staging"))
 }
+
+func TestGitOperator_CloneStorageMemory(t *testing.T) {
+	repo := os.Getenv("TEST_REPO")
+	if repo == "" {
+		repo = "git@github.com:cresta/gitdb-reference.git"
+	}
+	g := goget.GitOperator{
+		Log:    testhelp.ZapTestingLogger(t),
+		Tracer: tracing.Noop{},
+	}
+	c, err := g.CloneStorage(context.Background(), goget.MemoryStorage{}, "memory", repo, nil)
+	require.NoError(t, err)
+	require.Empty(t, c.AbsPath())
+	content, err := c.GetFile(context.Background(), "master", "README.md")
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	_, err = content.WriteTo(&buf)
+	require.NoError(t, err)
+	require.NotEmpty(t, buf.String())
+}
+
+func TestGitCheckout_EnableWorkTree(t *testing.T) {
+	c := withRepo(t)
+	defer cleanupRepo(t, c)
+	dir, err := ioutil.TempDir("", "TestWorkTree")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(dir))
+	}()
+	require.NoError(t, c.EnableWorkTree(context.Background(), dir, "staging"))
+	content, err := os.ReadFile(dir + "/on_staging.txt")
+	require.NoError(t, err)
+	require.Equal(t, "staging\n", string(content))
+	info, exists := c.WorkTree()
+	require.True(t, exists)
+	require.Equal(t, dir, info.Path)
+	require.Len(t, info.Commit, 40)
+}
+
+func TestGitCheckout_CommitAddressing(t *testing.T) {
+	c := withRepo(t)
+	defer cleanupRepo(t, c)
+	ctx := context.Background()
+	_, info, err := c.GetFileWithInfo(ctx, "master", "on_master.txt")
+	require.NoError(t, err)
+	for _, ref := range []string{info.Commit, info.Commit[:7], strings.ToUpper(info.Commit[:9])} {
+		_, pinned, err := c.GetFileWithInfo(ctx, ref, "on_master.txt")
+		require.NoError(t, err, ref)
+		require.Equal(t, info.Commit, pinned.Commit)
+	}
+	_, err = c.GetFile(ctx, "0000000", "on_master.txt")
+	require.True(t, errors.Is(err, goget.ErrUnknownBranch))
+}
+
+func TestGitOperator_CloneStorageRefSpecs(t *testing.T) {
+	repo := os.Getenv("TEST_REPO")
+	if repo == "" {
+		repo = "git@github.com:cresta/gitdb-reference.git"
+	}
+	g := goget.GitOperator{
+		Log:    testhelp.ZapTestingLogger(t),
+		Tracer: tracing.Noop{},
+	}
+	refSpecs, err := goget.ParseFetchRefSpecs([]string{"+refs/heads/master"})
+	require.NoError(t, err)
+	ctx := context.Background()
+	c, err := g.CloneStorageRefSpecs(ctx, goget.MemoryStorage{}, "memory", repo, nil, refSpecs)
+	require.NoError(t, err)
+	_, err = c.GetFile(ctx, "master", "on_master.txt")
+	require.NoError(t, err)
+	_, err = c.GetFile(ctx, "staging", "on_staging.txt")
+	require.True(t, errors.Is(err, goget.ErrUnknownBranch))
+	require.NoError(t, c.Refresh(ctx))
+	report, err := c.Verify(ctx)
+	require.NoError(t, err)
+	require.False(t, report.Drifted())
+}
+
+func TestGitOperator_CloneStorageOptions(t *testing.T) {
+	repo := os.Getenv("TEST_REPO")
+	if repo == "" {
+		repo = "git@github.com:cresta/gitdb-reference.git"
+	}
+	g := goget.GitOperator{
+		Log:    testhelp.ZapTestingLogger(t),
+		Tracer: tracing.Noop{},
+	}
+	ctx := context.Background()
+	c, err := g.CloneStorageOptions(ctx, goget.MemoryStorage{}, "memory", repo, nil, goget.CloneOptions{SingleBranch: true, Depth: 1})
+	require.NoError(t, err)
+	_, err = c.GetFile(ctx, "master", "on_master.txt")
+	require.NoError(t, err)
+	_, err = c.GetFile(ctx, "staging", "on_staging.txt")
+	require.True(t, errors.Is(err, goget.ErrUnknownBranch))
+	commits, err := c.Commits(ctx, "master", 10)
+	require.NoError(t, err)
+	require.Len(t, commits, 1)
+	require.NoError(t, c.Refresh(ctx))
+}
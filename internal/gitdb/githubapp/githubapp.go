@@ -0,0 +1,140 @@
+// Package githubapp authenticates git over HTTPS as an installation of a GitHub App, so clones need no machine user
+package githubapp
+
+import (
+	"context"
+	"crypto/rsa"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/google/go-github/v54/github"
+)
+
+// refreshBefore is how long before an installation token expires a new one is minted.  Tokens last an hour.
+const refreshBefore = 5 * time.Minute
+
+type Config struct {
+	AppID          int64
+	InstallationID int64
+	// PEM encoded private key of the app
+	PrivateKey []byte
+	// Optional: the API of a GitHub Enterprise server, like "https://github.example.com/api/v3/".  Defaults to GitHub's.
+	APIURL string
+	// Defaults to http.DefaultClient
+	Client *http.Client
+}
+
+// Auth is a go-git http auth method sending an installation token, minted again shortly before it expires
+type Auth struct {
+	appID          int64
+	installationID int64
+	key            *rsa.PrivateKey
+	apiURL         string
+	client         *http.Client
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+var _ githttp.AuthMethod = &Auth{}
+
+func New(cfg Config) (*Auth, error) {
+	if cfg.AppID == 0 || cfg.InstallationID == 0 {
+		return nil, fmt.Errorf("both an app ID and an installation ID are needed")
+	}
+	key, err := jwt.ParseRSAPrivateKeyFromPEM(cfg.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse app private key: %w", err)
+	}
+	client := cfg.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Auth{
+		appID:          cfg.AppID,
+		installationID: cfg.InstallationID,
+		key:            key,
+		apiURL:         cfg.APIURL,
+		client:         client,
+	}, nil
+}
+
+func (a *Auth) Name() string {
+	return "github-app"
+}
+
+func (a *Auth) String() string {
+	return fmt.Sprintf("%s - app %d installation %d", a.Name(), a.appID, a.installationID)
+}
+
+// SetAuth adds the installation token to a request to the git server.  Failing to mint one sends the request without
+// it, which the server rejects.
+func (a *Auth) SetAuth(r *http.Request) {
+	token, err := a.Token(r.Context())
+	if err != nil {
+		return
+	}
+	r.SetBasicAuth("x-access-token", token)
+}
+
+// Token returns the current installation token, minting a new one if it is about to expire
+func (a *Auth) Token(ctx context.Context) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.token != "" && time.Until(a.expiresAt) > refreshBefore {
+		return a.token, nil
+	}
+	client, err := a.appClient()
+	if err != nil {
+		return "", err
+	}
+	t, _, err := client.Apps.CreateInstallationToken(ctx, a.installationID, nil)
+	if err != nil {
+		return "", fmt.Errorf("unable to mint token for installation %d: %w", a.installationID, err)
+	}
+	a.token = t.GetToken()
+	a.expiresAt = t.GetExpiresAt().Time
+	return a.token, nil
+}
+
+// appClient is a GitHub client authenticated as the app itself, which may only mint installation tokens.  Must hold
+// a.mu.
+func (a *Auth) appClient() (*github.Client, error) {
+	now := time.Now()
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.StandardClaims{
+		Issuer: strconv.FormatInt(a.appID, 10),
+		// Allows for clock drift, as GitHub recommends
+		IssuedAt:  now.Add(-time.Minute).Unix(),
+		ExpiresAt: now.Add(9 * time.Minute).Unix(),
+	}).SignedString(a.key)
+	if err != nil {
+		return nil, fmt.Errorf("unable to sign app JWT: %w", err)
+	}
+	httpClient := *a.client
+	httpClient.Transport = &bearerTransport{token: signed, wrapped: a.client.Transport}
+	if a.apiURL == "" {
+		return github.NewClient(&httpClient), nil
+	}
+	return github.NewEnterpriseClient(a.apiURL, a.apiURL, &httpClient)
+}
+
+type bearerTransport struct {
+	token   string
+	wrapped http.RoundTripper
+}
+
+func (b *bearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+b.token)
+	wrapped := b.wrapped
+	if wrapped == nil {
+		wrapped = http.DefaultTransport
+	}
+	return wrapped.RoundTrip(req)
+}
@@ -0,0 +1,73 @@
+package githubapp
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuth_Token(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	var minted atomic.Int32
+	expiresIn := time.Hour
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/v3/app/installations/42/access_tokens", r.URL.Path)
+		signed, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		require.True(t, found)
+		claims := &jwt.StandardClaims{}
+		_, err := jwt.ParseWithClaims(signed, claims, func(*jwt.Token) (interface{}, error) {
+			return &key.PublicKey, nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, "7", claims.Issuer)
+		n := minted.Add(1)
+		w.WriteHeader(http.StatusCreated)
+		require.NoError(t, json.NewEncoder(w).Encode(map[string]string{
+			"token":      fmt.Sprintf("token-%d", n),
+			"expires_at": time.Now().Add(expiresIn).UTC().Format(time.RFC3339),
+		}))
+	}))
+	defer srv.Close()
+
+	a, err := New(Config{AppID: 7, InstallationID: 42, PrivateKey: keyPEM, APIURL: srv.URL})
+	require.NoError(t, err)
+	ctx := context.Background()
+	token, err := a.Token(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "token-1", token)
+	token, err = a.Token(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "token-1", token)
+
+	req := httptest.NewRequest(http.MethodGet, "https://github.com/org/repo.git/info/refs", nil)
+	a.SetAuth(req)
+	user, pass, ok := req.BasicAuth()
+	require.True(t, ok)
+	require.Equal(t, "x-access-token", user)
+	require.Equal(t, "token-1", pass)
+
+	// About to expire: minted again
+	a.expiresAt = time.Now().Add(time.Minute)
+	token, err = a.Token(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "token-2", token)
+
+	_, err = New(Config{AppID: 7, PrivateKey: keyPEM})
+	require.Error(t, err)
+}
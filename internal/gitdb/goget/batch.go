@@ -0,0 +1,76 @@
+package goget
+
+import (
+	"context"
+	"errors"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// BatchFile is one file read by GetFiles, or why it could not be read
+type BatchFile struct {
+	Path string
+	// Encoded as base64 in JSON
+	Content  []byte `json:",omitempty"`
+	BlobHash string `json:",omitempty"`
+	// Set if the file could not be read.  Other files are still read.
+	Error string `json:",omitempty"`
+	// Set with Error when the file does not exist at the commit
+	NotFound bool `json:",omitempty"`
+}
+
+// GetFiles reads every path at the commit branch points to when called, so the files are consistent with each other
+// even if a refresh moves the branch meanwhile.  A file that fails to read is reported in its BatchFile; only failing
+// to resolve branch returns an error.
+func (g *GitCheckout) GetFiles(ctx context.Context, branch string, paths []string) (string, []BatchFile, error) {
+	ret := make([]BatchFile, 0, len(paths))
+	var commit string
+	err := g.StreamFiles(ctx, branch, paths, func(c string, f BatchFile) error {
+		commit = c
+		ret = append(ret, f)
+		return nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return commit, ret, nil
+}
+
+// StreamFiles is GetFiles calling callback with each file as it is read, so only one file is in memory at a time.
+// The callback gets the commit every file is read at.  g.mu is only held while each file is read, never while
+// callback runs, so a slow consumer like a client stalls no one else.
+func (g *GitCheckout) StreamFiles(ctx context.Context, branch string, paths []string, callback func(commit string, f BatchFile) error) error {
+	g.mu.Lock()
+	r, err := g.resolveRef(ctx, branch)
+	g.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	commit := r.Hash().String()
+	for _, p := range paths {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := callback(commit, g.readBatchFile(ctx, commit, p)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readBatchFile reads p at commit, holding g.mu only for the read
+func (g *GitCheckout) readBatchFile(ctx context.Context, commit string, p string) BatchFile {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	f := BatchFile{Path: p}
+	// The full SHA resolves to the same commit however the branch moves
+	buf, info, err := g.getFileNoLock(ctx, commit, p)
+	if err != nil {
+		f.Error = err.Error()
+		f.NotFound = errors.Is(err, object.ErrFileNotFound)
+		return f
+	}
+	f.Content = buf.Bytes()
+	f.BlobHash = info.BlobHash
+	return f
+}
@@ -0,0 +1,28 @@
+package goget
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cresta/gitdb/internal/gitdb/tracing"
+	"github.com/cresta/gitdb/internal/testhelp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitCheckout_GetFiles(t *testing.T) {
+	g := &GitOperator{Log: testhelp.ZapTestingLogger(t), Tracer: tracing.Noop{}}
+	repo, commit := newTestRepo(t)
+	co, err := g.newCheckout(repo, "", "git@example.com:org/repo.git", nil)
+	require.NoError(t, err)
+	gotCommit, files, err := co.GetFiles(context.Background(), "master", []string{"a.txt", "missing.txt"})
+	require.NoError(t, err)
+	require.Equal(t, commit.String(), gotCommit)
+	require.Len(t, files, 2)
+	require.Equal(t, "hello\n", string(files[0].Content))
+	require.Empty(t, files[0].Error)
+	require.True(t, files[1].NotFound)
+	require.NotEmpty(t, files[1].Error)
+
+	_, _, err = co.GetFiles(context.Background(), "nope", []string{"a.txt"})
+	require.ErrorIs(t, err, ErrUnknownBranch)
+}
@@ -0,0 +1,76 @@
+package goget
+
+import (
+	"expvar"
+	"sync"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// BlobCacheStats counts blob cache hits, misses and evictions
+var BlobCacheStats = expvar.NewMap("gitdb_blob_cache")
+
+// BlobCache is an LRU of file contents keyed by commit and path, bounded by the bytes of content it holds.  Commits
+// never change, so entries never go stale.  One cache is shared by every checkout.
+type BlobCache struct {
+	mu  sync.Mutex
+	lru *sizedLRU[blobCacheKey, blobCacheEntry]
+}
+
+type blobCacheKey struct {
+	commit plumbing.Hash
+	path   string
+}
+
+type blobCacheEntry struct {
+	data []byte
+	info FileInfo
+}
+
+// NewBlobCache returns a cache holding up to maxBytes of content, or nil (which caches nothing) if maxBytes is not
+// positive
+func NewBlobCache(maxBytes int64) *BlobCache {
+	if maxBytes <= 0 {
+		return nil
+	}
+	return &BlobCache{
+		lru: newSizedLRU[blobCacheKey, blobCacheEntry](maxBytes),
+	}
+}
+
+// Bytes is how much content the cache holds
+func (b *BlobCache) Bytes() int64 {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.lru.bytes
+}
+
+func (b *BlobCache) get(commit plumbing.Hash, path string) ([]byte, FileInfo, bool) {
+	if b == nil {
+		return nil, FileInfo{}, false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	entry, exists := b.lru.get(blobCacheKey{commit: commit, path: path})
+	if !exists {
+		BlobCacheStats.Add("misses", 1)
+		return nil, FileInfo{}, false
+	}
+	BlobCacheStats.Add("hits", 1)
+	return entry.data, entry.info, true
+}
+
+// add caches data, which must not be modified afterwards.  Content larger than the whole cache is not cached.
+func (b *BlobCache) add(commit plumbing.Hash, path string, data []byte, info FileInfo) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if evicted := b.lru.add(blobCacheKey{commit: commit, path: path}, blobCacheEntry{data: data, info: info}, int64(len(data))); evicted > 0 {
+		BlobCacheStats.Add("evictions", int64(evicted))
+	}
+}
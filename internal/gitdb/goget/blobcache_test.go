@@ -0,0 +1,35 @@
+package goget
+
+import (
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlobCache(t *testing.T) {
+	commit := plumbing.NewHash("1111111111111111111111111111111111111111")
+	b := NewBlobCache(10)
+	b.add(commit, "a.txt", []byte("12345"), FileInfo{BlobHash: "a"})
+	b.add(commit, "b.txt", []byte("12345"), FileInfo{BlobHash: "b"})
+	data, info, ok := b.get(commit, "a.txt")
+	require.True(t, ok)
+	require.Equal(t, "12345", string(data))
+	require.Equal(t, "a", info.BlobHash)
+	// b.txt is now least recently used
+	b.add(commit, "c.txt", []byte("123"), FileInfo{})
+	_, _, ok = b.get(commit, "b.txt")
+	require.False(t, ok)
+	_, _, ok = b.get(commit, "a.txt")
+	require.True(t, ok)
+	require.Equal(t, int64(8), b.Bytes())
+	b.add(commit, "big.txt", []byte("12345678901"), FileInfo{})
+	_, _, ok = b.get(commit, "big.txt")
+	require.False(t, ok)
+
+	var disabled *BlobCache
+	disabled.add(commit, "a.txt", []byte("1"), FileInfo{})
+	_, _, ok = disabled.get(commit, "a.txt")
+	require.False(t, ok)
+	require.Nil(t, NewBlobCache(0))
+}
@@ -0,0 +1,203 @@
+package goget
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/cresta/gitdb/internal/gitdb/tracing"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/revlist"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"go.uber.org/zap"
+)
+
+const bundleSignature = "# v2 git bundle\n"
+
+// Bundle writes a v2 git bundle containing every object reachable from branch.  The bundle advertises the
+// branch as refs/heads/<branch>, so `git clone file.bundle` or `git fetch file.bundle` works offline.
+func (g *GitCheckout) Bundle(ctx context.Context, into io.Writer, branch string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	r, err := g.resolveRef(ctx, branch)
+	if err != nil {
+		return err
+	}
+	g.log.Debug(ctx, "asked to bundle", zap.String("branch", branch))
+	return g.writeBundle(ctx, into, []*plumbing.Reference{plumbing.NewHashReference(plumbing.NewBranchReferenceName(branch), r.Hash())})
+}
+
+// BundleAll writes a v2 git bundle of every origin branch, as refs/heads/<branch>, and every tag.  HEAD is advertised
+// too, after the branch it points at, so CloneStorageFromBundle keeps the same default branch.
+func (g *GitCheckout) BundleAll(ctx context.Context, into io.Writer) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	heads, err := g.remoteHeads()
+	if err != nil {
+		return err
+	}
+	var defaultBranch string
+	if head, err := g.repo.Storer.Reference(plumbing.HEAD); err == nil {
+		defaultBranch = head.Target().Short()
+	}
+	refs := make([]*plumbing.Reference, 0, len(heads)+1)
+	for branch, hash := range heads {
+		refs = append(refs, plumbing.NewHashReference(plumbing.NewBranchReferenceName(branch), hash))
+	}
+	// The default branch goes first, so it is the first branch matching HEAD
+	sort.Slice(refs, func(i, j int) bool {
+		if (refs[i].Name().Short() == defaultBranch) != (refs[j].Name().Short() == defaultBranch) {
+			return refs[i].Name().Short() == defaultBranch
+		}
+		return refs[i].Name() < refs[j].Name()
+	})
+	if hash, exists := heads[defaultBranch]; exists {
+		refs = append(refs, plumbing.NewHashReference(plumbing.HEAD, hash))
+	}
+	tags, err := g.repo.Tags()
+	if err != nil {
+		return fmt.Errorf("unable to list tags: %w", err)
+	}
+	if err := tags.ForEach(func(r *plumbing.Reference) error {
+		refs = append(refs, r)
+		return nil
+	}); err != nil {
+		return fmt.Errorf("unable to list tags: %w", err)
+	}
+	g.log.Debug(ctx, "asked to bundle all refs", zap.Int("refs", len(refs)))
+	return g.writeBundle(ctx, into, refs)
+}
+
+// writeBundle writes a bundle advertising refs, with every object reachable from them.  Must hold g.mu.
+func (g *GitCheckout) writeBundle(ctx context.Context, into io.Writer, refs []*plumbing.Reference) error {
+	return g.tracing.StartSpanFromContext(ctx, tracing.SpanConfig{OperationName: "bundle"}, func(ctx context.Context) error {
+		tips := make([]plumbing.Hash, 0, len(refs))
+		var header strings.Builder
+		header.WriteString(bundleSignature)
+		for _, r := range refs {
+			tips = append(tips, r.Hash())
+			fmt.Fprintf(&header, "%s %s\n", r.Hash(), r.Name())
+		}
+		header.WriteString("\n")
+		hashes, err := revlist.Objects(g.repo.Storer, tips, nil)
+		if err != nil {
+			return fmt.Errorf("unable to list objects reachable from %d refs: %w", len(tips), err)
+		}
+		g.tracing.AttachTag(ctx, "git.bundle.objects", len(hashes))
+		if _, err := io.WriteString(into, header.String()); err != nil {
+			return fmt.Errorf("unable to write bundle header: %w", err)
+		}
+		if _, err := packfile.NewEncoder(into, g.repo.Storer, false).Encode(hashes, 10); err != nil {
+			return fmt.Errorf("unable to encode packfile: %w", err)
+		}
+		return nil
+	})
+}
+
+// CloneStorageFromBundle creates a checkout of remoteURL from a bundle written by BundleAll, without contacting
+// remoteURL.  Branches in the bundle become origin branches, as if they were fetched.  Refresh afterwards to catch up
+// with anything pushed since the bundle was written.
+func (g *GitOperator) CloneStorageFromBundle(ctx context.Context, s Storage, name string, remoteURL string, auth transport.AuthMethod, bundle io.Reader) (*GitCheckout, error) {
+	var ret *GitCheckout
+	err := g.Tracer.StartSpanFromContext(ctx, tracing.SpanConfig{OperationName: "clone_from_bundle"}, func(ctx context.Context) error {
+		st, location, err := s.NewStorer(name)
+		if err != nil {
+			return fmt.Errorf("unable to create storage: %w", err)
+		}
+		br := bufio.NewReader(bundle)
+		refs, err := readBundleHeader(br)
+		if err != nil {
+			return err
+		}
+		if err := packfile.UpdateObjectStorage(st, br); err != nil {
+			return fmt.Errorf("unable to store bundle objects: %w", err)
+		}
+		defaultBranch := plumbing.Master
+		var headHash plumbing.Hash
+		for _, r := range refs {
+			if r.Name() == plumbing.HEAD {
+				headHash = r.Hash()
+			}
+		}
+		for _, r := range refs {
+			if r.Name().IsBranch() && r.Hash() == headHash {
+				defaultBranch = r.Name()
+				break
+			}
+		}
+		repo, err := git.InitWithOptions(st, nil, git.InitOptions{DefaultBranch: defaultBranch})
+		if err != nil {
+			return fmt.Errorf("unable to init repository: %w", err)
+		}
+		if _, err := repo.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{remoteURL}}); err != nil {
+			return fmt.Errorf("unable to create origin remote: %w", err)
+		}
+		if err := setBundleRefs(st, refs, defaultBranch); err != nil {
+			return err
+		}
+		g.Log.Info(ctx, "cloned from bundle", zap.String("repo", remoteURL), zap.Int("refs", len(refs)), zap.String("default_branch", defaultBranch.Short()))
+		ret, err = g.newCheckout(repo, location, remoteURL, auth)
+		return err
+	})
+	return ret, err
+}
+
+func readBundleHeader(br *bufio.Reader) ([]*plumbing.Reference, error) {
+	sig, err := br.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("unable to read bundle signature: %w", err)
+	}
+	if sig != bundleSignature {
+		return nil, fmt.Errorf("unsupported bundle signature %q", strings.TrimSpace(sig))
+	}
+	var refs []*plumbing.Reference
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("unable to read bundle header: %w", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return refs, nil
+		}
+		if strings.HasPrefix(line, "-") {
+			return nil, fmt.Errorf("bundles with prerequisites are not supported")
+		}
+		hash, name, ok := strings.Cut(line, " ")
+		if !ok || !plumbing.IsHash(hash) {
+			return nil, fmt.Errorf("invalid bundle ref line %q", line)
+		}
+		refs = append(refs, plumbing.NewHashReference(plumbing.ReferenceName(name), plumbing.NewHash(hash)))
+	}
+}
+
+// setBundleRefs stores branches as origin branches and tags as tags, plus the local default branch a clone would have
+func setBundleRefs(st storer.ReferenceStorer, refs []*plumbing.Reference, defaultBranch plumbing.ReferenceName) error {
+	for _, r := range refs {
+		var name plumbing.ReferenceName
+		switch {
+		case r.Name().IsBranch():
+			name = plumbing.NewRemoteReferenceName("origin", r.Name().Short())
+		case r.Name().IsTag():
+			name = r.Name()
+		default:
+			continue
+		}
+		if err := st.SetReference(plumbing.NewHashReference(name, r.Hash())); err != nil {
+			return fmt.Errorf("unable to set reference %s: %w", name, err)
+		}
+		if r.Name() == defaultBranch {
+			if err := st.SetReference(plumbing.NewHashReference(defaultBranch, r.Hash())); err != nil {
+				return fmt.Errorf("unable to set reference %s: %w", defaultBranch, err)
+			}
+		}
+	}
+	return nil
+}
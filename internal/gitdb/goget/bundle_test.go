@@ -0,0 +1,62 @@
+package goget
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cresta/gitdb/internal/gitdb/tracing"
+	"github.com/cresta/gitdb/internal/testhelp"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestRepo makes an in memory repository with a.txt committed on origin/master and tagged v1
+func newTestRepo(t *testing.T) (*git.Repository, plumbing.Hash) {
+	fs := memfs.New()
+	repo, err := git.Init(memory.NewStorage(), fs)
+	require.NoError(t, err)
+	f, err := fs.Create("a.txt")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("hello\n"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+	_, err = wt.Add("a.txt")
+	require.NoError(t, err)
+	sig := &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()}
+	commit, err := wt.Commit("first", &git.CommitOptions{Author: sig})
+	require.NoError(t, err)
+	require.NoError(t, repo.Storer.SetReference(plumbing.NewHashReference(plumbing.NewRemoteReferenceName("origin", "master"), commit)))
+	_, err = repo.CreateTag("v1", commit, &git.CreateTagOptions{Tagger: sig, Message: "v1"})
+	require.NoError(t, err)
+	return repo, commit
+}
+
+func TestCloneStorageFromBundle(t *testing.T) {
+	ctx := context.Background()
+	g := &GitOperator{Log: testhelp.ZapTestingLogger(t), Tracer: tracing.Noop{}}
+	repo, commit := newTestRepo(t)
+	src, err := g.newCheckout(repo, "", "git@example.com:org/repo.git", nil)
+	require.NoError(t, err)
+
+	var bundle bytes.Buffer
+	require.NoError(t, src.BundleAll(ctx, &bundle))
+	co, err := g.CloneStorageFromBundle(ctx, MemoryStorage{}, "repo", "git@example.com:org/repo.git", nil, &bundle)
+	require.NoError(t, err)
+	for _, ref := range []string{"master", "v1", DefaultBranch, commit.String()[:7]} {
+		content, err := co.GetFile(ctx, ref, "a.txt")
+		require.NoError(t, err, ref)
+		var b bytes.Buffer
+		_, err = content.WriteTo(&b)
+		require.NoError(t, err)
+		require.Equal(t, "hello\n", b.String())
+	}
+	require.True(t, co.RemoteExists("origin"))
+}
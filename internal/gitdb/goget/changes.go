@@ -0,0 +1,145 @@
+package goget
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"go.uber.org/zap"
+)
+
+// BranchChange is how a branch moved during the most recent refresh that changed it
+type BranchChange struct {
+	Branch string
+	// Empty if the branch is new
+	From string
+	To   string
+	// Files added, modified or removed between From and To
+	Paths []string
+	Time  time.Time
+}
+
+// Changes returns the paths changed by the last refresh that moved branch
+func (g *GitCheckout) Changes(branch string) (BranchChange, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, err := g.branchRef(branch); err != nil {
+		return BranchChange{}, false
+	}
+	c, exists := g.changes[branch]
+	return c, exists
+}
+
+// remoteHeads returns the commit of every origin branch, or every local branch of an OpenLocal checkout.  Must hold
+// g.mu.
+func (g *GitCheckout) remoteHeads() (map[string]plumbing.Hash, error) {
+	refs, err := g.repo.References()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list references: %w", err)
+	}
+	defer refs.Close()
+	ret := make(map[string]plumbing.Hash)
+	prefix := "refs/remotes/origin/"
+	if g.local {
+		prefix = "refs/heads/"
+	}
+	err = refs.ForEach(func(r *plumbing.Reference) error {
+		name := r.Name().String()
+		if r.Type() == plumbing.HashReference && strings.HasPrefix(name, prefix) {
+			ret[strings.TrimPrefix(name, prefix)] = r.Hash()
+		}
+		return nil
+	})
+	return ret, err
+}
+
+// recordChanges diffs each origin branch against its head in before.  Must hold g.mu.
+func (g *GitCheckout) recordChanges(ctx context.Context, before map[string]plumbing.Hash) error {
+	after, err := g.remoteHeads()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for branch, to := range after {
+		from, existed := before[branch]
+		if existed && from == to {
+			continue
+		}
+		paths, err := g.changedPaths(from, to)
+		if err != nil {
+			return fmt.Errorf("unable to diff branch %s: %w", branch, err)
+		}
+		change := BranchChange{
+			Branch: branch,
+			To:     to.String(),
+			Paths:  paths,
+			Time:   now,
+		}
+		if existed {
+			change.From = from.String()
+		}
+		if g.changes == nil {
+			g.changes = make(map[string]BranchChange)
+		}
+		g.changes[branch] = change
+		g.journal.record(RefUpdate{
+			Repo:    g.remoteURL,
+			Branch:  branch,
+			From:    change.From,
+			To:      change.To,
+			Trigger: triggerOf(ctx),
+			Time:    now,
+		})
+		g.log.Info(ctx, "branch changed", zap.String("branch", branch), zap.String("from", change.From), zap.String("to", change.To), zap.Int("paths", len(paths)))
+	}
+	for branch := range g.changes {
+		if _, exists := after[branch]; !exists {
+			delete(g.changes, branch)
+		}
+	}
+	g.recordDeletedBranches(ctx, before, after, now)
+	return nil
+}
+
+func (g *GitCheckout) changedPaths(from plumbing.Hash, to plumbing.Hash) ([]string, error) {
+	var fromTree *object.Tree
+	if !from.IsZero() {
+		c, err := g.repo.CommitObject(from)
+		if err != nil {
+			return nil, fmt.Errorf("unable to find commit %s: %w", from, err)
+		}
+		if fromTree, err = c.Tree(); err != nil {
+			return nil, fmt.Errorf("unable to find tree for %s: %w", from, err)
+		}
+	}
+	c, err := g.repo.CommitObject(to)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find commit %s: %w", to, err)
+	}
+	toTree, err := c.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("unable to find tree for %s: %w", to, err)
+	}
+	changes, err := object.DiffTree(fromTree, toTree)
+	if err != nil {
+		return nil, fmt.Errorf("unable to diff trees: %w", err)
+	}
+	seen := make(map[string]struct{})
+	for _, ch := range changes {
+		for _, name := range []string{ch.From.Name, ch.To.Name} {
+			if name != "" {
+				seen[name] = struct{}{}
+			}
+		}
+	}
+	ret := make([]string, 0, len(seen))
+	for p := range seen {
+		ret = append(ret, p)
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
@@ -0,0 +1,117 @@
+package goget
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/cresta/gitdb/internal/gitdb/tracing"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+type CommitInfo struct {
+	Hash           string
+	Author         string
+	AuthorEmail    string
+	AuthorTime     time.Time
+	Committer      string
+	CommitterEmail string
+	Message        string
+	// When the commit was committed
+	Time time.Time
+}
+
+func newCommitInfo(c *object.Commit) CommitInfo {
+	return CommitInfo{
+		Hash:           c.Hash.String(),
+		Author:         c.Author.Name,
+		AuthorEmail:    c.Author.Email,
+		AuthorTime:     c.Author.When,
+		Committer:      c.Committer.Name,
+		CommitterEmail: c.Committer.Email,
+		Message:        c.Message,
+		Time:           c.Committer.When,
+	}
+}
+
+// Commit returns the commit branch points to
+func (g *GitCheckout) Commit(ctx context.Context, branch string) (CommitInfo, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var ret CommitInfo
+	err := g.tracing.StartSpanFromContext(ctx, tracing.SpanConfig{OperationName: "commit"}, func(ctx context.Context) error {
+		r, err := g.resolveRef(ctx, branch)
+		if err != nil {
+			return err
+		}
+		c, err := g.repo.CommitObject(r.Hash())
+		if err != nil {
+			return fmt.Errorf("unable to read commit %s: %w", r.Hash(), err)
+		}
+		ret = newCommitInfo(c)
+		return nil
+	})
+	return ret, err
+}
+
+// Commits returns up to limit commits reachable from branch, newest first
+func (g *GitCheckout) Commits(ctx context.Context, branch string, limit int) ([]CommitInfo, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var ret []CommitInfo
+	err := g.tracing.StartSpanFromContext(ctx, tracing.SpanConfig{OperationName: "commits"}, func(ctx context.Context) error {
+		var err error
+		ret, err = g.logNoLock(ctx, branch, nil, limit)
+		g.tracing.AttachTag(ctx, "git.commits", len(ret))
+		return err
+	})
+	return ret, err
+}
+
+// Log returns up to limit commits reachable from branch that change filePath, a file or a directory, newest first
+func (g *GitCheckout) Log(ctx context.Context, branch string, filePath string, limit int) ([]CommitInfo, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	filePath = strings.Trim(filePath, "/")
+	var ret []CommitInfo
+	err := g.tracing.StartSpanFromContext(ctx, tracing.SpanConfig{OperationName: "log"}, func(ctx context.Context) error {
+		g.tracing.AttachTag(ctx, "git.path", filePath)
+		var err error
+		ret, err = g.logNoLock(ctx, branch, func(p string) bool {
+			return filePath == "" || p == filePath || strings.HasPrefix(p, filePath+"/")
+		}, limit)
+		g.tracing.AttachTag(ctx, "git.commits", len(ret))
+		return err
+	})
+	return ret, err
+}
+
+// logNoLock walks the log of branch, keeping commits that change a path matching pathFilter, or every commit if
+// pathFilter is nil.  Must hold g.mu.
+func (g *GitCheckout) logNoLock(ctx context.Context, branch string, pathFilter func(string) bool, limit int) ([]CommitInfo, error) {
+	r, err := g.resolveRef(ctx, branch)
+	if err != nil {
+		return nil, err
+	}
+	iter, err := g.repo.Log(&git.LogOptions{From: r.Hash(), PathFilter: pathFilter})
+	if err != nil {
+		return nil, fmt.Errorf("unable to read log of %s: %w", branch, err)
+	}
+	defer iter.Close()
+	ret := make([]CommitInfo, 0, limit)
+	for len(ret) < limit {
+		c, err := iter.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to iterate log of %s: %w", branch, err)
+		}
+		ret = append(ret, newCommitInfo(c))
+	}
+	return ret, nil
+}
@@ -0,0 +1,28 @@
+package goget
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cresta/gitdb/internal/gitdb/tracing"
+	"github.com/cresta/gitdb/internal/testhelp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitCheckout_Log(t *testing.T) {
+	g := &GitOperator{Log: testhelp.ZapTestingLogger(t), Tracer: tracing.Noop{}}
+	repo, commit := newTestRepo(t)
+	co, err := g.newCheckout(repo, "", "git@example.com:org/repo.git", nil)
+	require.NoError(t, err)
+	commits, err := co.Log(context.Background(), "master", "a.txt", 10)
+	require.NoError(t, err)
+	require.Len(t, commits, 1)
+	require.Equal(t, commit.String(), commits[0].Hash)
+
+	commits, err = co.Log(context.Background(), "master", "missing.txt", 10)
+	require.NoError(t, err)
+	require.Empty(t, commits)
+
+	_, err = co.Log(context.Background(), "nope", "a.txt", 10)
+	require.ErrorIs(t, err, ErrUnknownBranch)
+}
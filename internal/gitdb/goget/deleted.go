@@ -0,0 +1,63 @@
+package goget
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"go.uber.org/zap"
+)
+
+type deletedBranch struct {
+	hash      plumbing.Hash
+	deletedAt time.Time
+}
+
+// SetDeletedBranchGrace makes refreshes prune branches deleted upstream, but keeps serving their last commit for grace
+// afterwards.  Without it deleted branches are never pruned.
+func (g *GitCheckout) SetDeletedBranchGrace(grace time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.deletedBranchGrace = grace
+}
+
+// BranchDeleted returns when branch was deleted upstream, if it is only still served because of the grace period
+func (g *GitCheckout) BranchDeleted(branch string) (time.Time, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	d, exists := g.deletedBranch(branch)
+	return d.deletedAt, exists
+}
+
+// deletedBranch returns branch if it was deleted within the grace period.  Must hold g.mu.
+func (g *GitCheckout) deletedBranch(branch string) (deletedBranch, bool) {
+	d, exists := g.deleted[branch]
+	if !exists {
+		return deletedBranch{}, false
+	}
+	if time.Since(d.deletedAt) > g.deletedBranchGrace {
+		delete(g.deleted, branch)
+		return deletedBranch{}, false
+	}
+	return d, true
+}
+
+// recordDeletedBranches remembers branches pruned by a refresh.  Must hold g.mu.
+func (g *GitCheckout) recordDeletedBranches(ctx context.Context, before map[string]plumbing.Hash, after map[string]plumbing.Hash, now time.Time) {
+	if g.deletedBranchGrace <= 0 {
+		return
+	}
+	for branch, hash := range before {
+		if _, exists := after[branch]; exists {
+			continue
+		}
+		if g.deleted == nil {
+			g.deleted = make(map[string]deletedBranch)
+		}
+		g.deleted[branch] = deletedBranch{hash: hash, deletedAt: now}
+		g.log.Info(ctx, "branch deleted upstream, serving last commit during grace period", zap.String("branch", branch), zap.String("hash", hash.String()), zap.Duration("grace", g.deletedBranchGrace))
+	}
+	for branch := range after {
+		delete(g.deleted, branch)
+	}
+}
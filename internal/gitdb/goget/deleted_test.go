@@ -0,0 +1,95 @@
+package goget
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cresta/gitdb/internal/gitdb/tracing"
+	"github.com/cresta/gitdb/internal/testhelp"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitCheckout_DeletedBranchGrace(t *testing.T) {
+	ctx := context.Background()
+	g := &GitOperator{Log: testhelp.ZapTestingLogger(t), Tracer: tracing.Noop{}}
+	upstreamDir := newBareUpstream(t)
+	upstream, err := git.PlainOpen(upstreamDir)
+	require.NoError(t, err)
+	head, err := upstream.Head()
+	require.NoError(t, err)
+	commit := head.Hash()
+	feature := plumbing.NewBranchReferenceName("feature")
+	require.NoError(t, upstream.Storer.SetReference(plumbing.NewHashReference(feature, commit)))
+
+	co, err := g.Clone(ctx, t.TempDir(), upstreamDir, nil)
+	require.NoError(t, err)
+	grace := time.Millisecond * 200
+	co.SetDeletedBranchGrace(grace)
+	served := func() bool {
+		c, err := co.Commit(ctx, "feature")
+		if err != nil {
+			require.ErrorIs(t, err, ErrUnknownBranch)
+			return false
+		}
+		require.Equal(t, commit.String(), c.Hash)
+		return true
+	}
+	require.True(t, served())
+
+	// Pruned, but still served during the grace period
+	require.NoError(t, upstream.Storer.RemoveReference(feature))
+	require.NoError(t, co.Refresh(ctx))
+	_, err = co.repo.Reference(plumbing.NewRemoteReferenceName("origin", "feature"), true)
+	require.ErrorIs(t, err, plumbing.ErrReferenceNotFound)
+	require.True(t, served())
+	deletedAt, deleted := co.BranchDeleted("feature")
+	require.True(t, deleted)
+	// Served, but a push re-creating it at the same commit must still refresh
+	require.False(t, co.HasHead("feature", commit.String()))
+	require.WithinDuration(t, time.Now(), deletedAt, time.Minute)
+
+	// Re-created upstream before the grace ran out
+	require.NoError(t, upstream.Storer.SetReference(plumbing.NewHashReference(feature, commit)))
+	require.NoError(t, co.Refresh(ctx))
+	_, deleted = co.BranchDeleted("feature")
+	require.False(t, deleted)
+	require.True(t, served())
+	require.True(t, co.HasHead("feature", commit.String()))
+	require.False(t, co.HasHead("feature", plumbing.ZeroHash.String()))
+
+	// Gone once the grace runs out
+	require.NoError(t, upstream.Storer.RemoveReference(feature))
+	require.NoError(t, co.Refresh(ctx))
+	require.True(t, served())
+	time.Sleep(grace + time.Millisecond*50)
+	require.False(t, served())
+	_, deleted = co.BranchDeleted("feature")
+	require.False(t, deleted)
+}
+
+func TestGitCheckout_HasHead(t *testing.T) {
+	ctx := context.Background()
+	g := &GitOperator{Log: testhelp.ZapTestingLogger(t), Tracer: tracing.Noop{}}
+	upstreamDir := newBareUpstream(t)
+	upstream, err := git.PlainOpen(upstreamDir)
+	require.NoError(t, err)
+	head, err := upstream.Head()
+	require.NoError(t, err)
+	commit := head.Hash()
+	_, err = upstream.CreateTag("v1", commit, nil)
+	require.NoError(t, err)
+
+	co, err := g.Clone(ctx, t.TempDir(), upstreamDir, nil)
+	require.NoError(t, err)
+	require.True(t, co.HasHead("master", commit.String()))
+	// Resolvable, but not branches a push could be for
+	_, err = co.Commit(ctx, "v1")
+	require.NoError(t, err)
+	require.False(t, co.HasHead("v1", commit.String()))
+	_, err = co.Commit(ctx, commit.String())
+	require.NoError(t, err)
+	require.False(t, co.HasHead(commit.String(), commit.String()))
+}
@@ -0,0 +1,130 @@
+package goget
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cresta/gitdb/internal/gitdb/tracing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/utils/merkletrie"
+)
+
+// Ways a file can differ between two commits
+const (
+	FileAdded    = "added"
+	FileModified = "modified"
+	FileDeleted  = "deleted"
+)
+
+// FileDiff is one file that differs between two commits
+type FileDiff struct {
+	Path string
+	// One of FileAdded, FileModified or FileDeleted
+	Action   string
+	FromHash string `json:",omitempty"`
+	ToHash   string `json:",omitempty"`
+	// Unified diff, only set if asked for
+	Patch string `json:",omitempty"`
+}
+
+// TreeDiff is every file that differs between two commits, sorted by path.  Renames show as a delete and an add.
+type TreeDiff struct {
+	From  string
+	To    string
+	Files []FileDiff
+}
+
+// Diff compares the files under dir (or everything if dir is empty) at from with those at to.  from and to may be
+// anything a branch may be: a branch, tag or commit SHA.  withPatch adds a unified diff to each file.
+func (g *GitCheckout) Diff(ctx context.Context, from string, to string, dir string, withPatch bool) (TreeDiff, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	dir = strings.Trim(dir, "/")
+	var ret TreeDiff
+	err := g.tracing.StartSpanFromContext(ctx, tracing.SpanConfig{OperationName: "diff"}, func(ctx context.Context) error {
+		fromTree, fromHash, err := g.branchTree(ctx, from)
+		if err != nil {
+			return err
+		}
+		toTree, toHash, err := g.branchTree(ctx, to)
+		if err != nil {
+			return err
+		}
+		ret.From = fromHash
+		ret.To = toHash
+		changes, err := object.DiffTreeWithOptions(ctx, fromTree, toTree, nil)
+		if err != nil {
+			return fmt.Errorf("unable to diff trees: %w", err)
+		}
+		ret.Files = make([]FileDiff, 0, len(changes))
+		for _, ch := range changes {
+			f, err := fileDiff(ch, dir, withPatch)
+			if err != nil {
+				return err
+			}
+			if f != nil {
+				ret.Files = append(ret.Files, *f)
+			}
+		}
+		sort.Slice(ret.Files, func(i, j int) bool {
+			return ret.Files[i].Path < ret.Files[j].Path
+		})
+		g.tracing.AttachTag(ctx, "git.files", len(ret.Files))
+		return nil
+	})
+	return ret, err
+}
+
+// branchTree returns the root tree of the commit branch resolves to.  Must hold g.mu.
+func (g *GitCheckout) branchTree(ctx context.Context, branch string) (*object.Tree, string, error) {
+	r, err := g.resolveRef(ctx, branch)
+	if err != nil {
+		return nil, "", err
+	}
+	c, err := g.repo.CommitObject(r.Hash())
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to find commit %s: %w", r.Hash(), err)
+	}
+	t, err := c.Tree()
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to find tree for %s: %w", r.Hash(), err)
+	}
+	return t, r.Hash().String(), nil
+}
+
+// fileDiff describes ch, or returns nil if it is outside dir
+func fileDiff(ch *object.Change, dir string, withPatch bool) (*FileDiff, error) {
+	action, err := ch.Action()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read change: %w", err)
+	}
+	ret := &FileDiff{Path: ch.To.Name}
+	switch action {
+	case merkletrie.Insert:
+		ret.Action = FileAdded
+	case merkletrie.Delete:
+		ret.Action = FileDeleted
+		ret.Path = ch.From.Name
+	default:
+		ret.Action = FileModified
+	}
+	if dir != "" && !strings.HasPrefix(ret.Path, dir+"/") {
+		return nil, nil
+	}
+	if !ch.From.TreeEntry.Hash.IsZero() {
+		ret.FromHash = ch.From.TreeEntry.Hash.String()
+	}
+	if !ch.To.TreeEntry.Hash.IsZero() {
+		ret.ToHash = ch.To.TreeEntry.Hash.String()
+	}
+	if withPatch {
+		p, err := ch.Patch()
+		if err != nil {
+			return nil, fmt.Errorf("unable to diff %s: %w", ret.Path, err)
+		}
+		ret.Patch = p.String()
+	}
+	return ret, nil
+}
@@ -0,0 +1,57 @@
+package goget
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cresta/gitdb/internal/gitdb/tracing"
+	"github.com/cresta/gitdb/internal/testhelp"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitCheckout_Diff(t *testing.T) {
+	g := &GitOperator{Log: testhelp.ZapTestingLogger(t), Tracer: tracing.Noop{}}
+	repo, commit := newTestRepo(t)
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+	for name, content := range map[string]string{"a.txt": "bye\n", "dir/b.txt": "b\n"} {
+		f, err := wt.Filesystem.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(content))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+		_, err = wt.Add(name)
+		require.NoError(t, err)
+	}
+	sig := &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()}
+	staging, err := wt.Commit("second", &git.CommitOptions{Author: sig})
+	require.NoError(t, err)
+	require.NoError(t, repo.Storer.SetReference(plumbing.NewHashReference(plumbing.NewRemoteReferenceName("origin", "staging"), staging)))
+	co, err := g.newCheckout(repo, "", "git@example.com:org/repo.git", nil)
+	require.NoError(t, err)
+
+	diff, err := co.Diff(context.Background(), "master", "staging", "", true)
+	require.NoError(t, err)
+	require.Equal(t, commit.String(), diff.From)
+	require.Equal(t, staging.String(), diff.To)
+	require.Len(t, diff.Files, 2)
+	require.Equal(t, "a.txt", diff.Files[0].Path)
+	require.Equal(t, FileModified, diff.Files[0].Action)
+	require.Contains(t, diff.Files[0].Patch, "+bye")
+	require.Equal(t, "dir/b.txt", diff.Files[1].Path)
+	require.Equal(t, FileAdded, diff.Files[1].Action)
+	require.Empty(t, diff.Files[1].FromHash)
+
+	diff, err = co.Diff(context.Background(), "staging", "master", "dir", false)
+	require.NoError(t, err)
+	require.Len(t, diff.Files, 1)
+	require.Equal(t, FileDeleted, diff.Files[0].Action)
+	require.Empty(t, diff.Files[0].Patch)
+
+	_, err = co.Diff(context.Background(), "master", "nope", "", false)
+	require.ErrorIs(t, err, ErrUnknownBranch)
+}
@@ -0,0 +1,250 @@
+package goget
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cresta/gitdb/internal/gitdb/metrics"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"go.uber.org/zap"
+)
+
+// fetchOnMissRetry is how long a branch still missing upstream is answered as unknown without fetching it again
+const fetchOnMissRetry = 30 * time.Second
+
+// maxMissedBranches bounds how many missing branches are remembered, since any name can be asked for
+const maxMissedBranches = 1000
+
+// SetFetchOnMiss makes reads of a branch not known locally fetch just that branch from upstream before failing, so
+// branches created since the last refresh are readable right away.  Only branches the checkout fetches anyway, per
+// its clone options, are fetched.
+func (g *GitCheckout) SetFetchOnMiss(enabled bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.fetchOnMiss = enabled
+}
+
+// resolveRef is branchRef for reads: with fetch on miss, an unknown branch is fetched from upstream before giving up.
+// Must hold g.mu, which is released while the branch is fetched.
+func (g *GitCheckout) resolveRef(ctx context.Context, branch string) (*plumbing.Reference, error) {
+	r, err := g.branchRef(branch)
+	if err == nil || !errors.Is(err, ErrUnknownBranch) || !g.fetchOnMiss || g.local || g.healing || branch == DefaultBranch {
+		return r, err
+	}
+	if missedAt, exists := g.missed[branch]; exists && time.Since(missedAt) < fetchOnMissRetry {
+		return r, err
+	}
+	g.mu.Unlock()
+	// Reads of the same missing branch wait for one fetch of it
+	found, _, _ := g.missFetches.Do(branch, func() (interface{}, error) {
+		return g.fetchMissingBranch(ctx, branch), nil
+	})
+	g.mu.Lock()
+	if !found.(bool) {
+		return r, err
+	}
+	return g.branchRef(branch)
+}
+
+// fetchMissingBranch fetches branch for resolveRef and records the result, returning whether it was found.  Must not
+// hold g.mu.
+func (g *GitCheckout) fetchMissingBranch(ctx context.Context, branch string) bool {
+	found, fetchErr := g.fetchBranch(ctx, branch)
+	result := "found"
+	switch {
+	case fetchErr != nil:
+		result = "error"
+		g.log.Warn(ctx, "unable to fetch missing branch", zap.String("branch", branch), zap.Error(fetchErr))
+	case !found:
+		result = "missing"
+	}
+	g.metrics.Count("gitdb_fetch_on_miss_total", 1, metrics.Tags{"repo": g.remoteURL, "result": result})
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if found {
+		delete(g.missed, branch)
+	} else {
+		g.noteMissNoLock(branch)
+	}
+	return found
+}
+
+// noteMissNoLock remembers branch went missing, forgetting expired misses, or the oldest once maxMissedBranches are
+// remembered.  Must hold g.mu.
+func (g *GitCheckout) noteMissNoLock(branch string) {
+	if g.missed == nil {
+		g.missed = make(map[string]time.Time)
+	}
+	if len(g.missed) >= maxMissedBranches {
+		var oldest string
+		for b, missedAt := range g.missed {
+			if time.Since(missedAt) >= fetchOnMissRetry {
+				delete(g.missed, b)
+			} else if oldest == "" || missedAt.Before(g.missed[oldest]) {
+				oldest = b
+			}
+		}
+		if len(g.missed) >= maxMissedBranches {
+			delete(g.missed, oldest)
+		}
+	}
+	g.missed[branch] = time.Now()
+}
+
+// fetchBranch fetches only branch from origin, returning false if origin has no such branch or the checkout's fetch
+// refspecs leave it out.  g.mu is only held while objects of the checkout are read and once the fetch is done, to
+// copy what it fetched into the checkout, never while waiting on upstream.  Must not hold g.mu.
+func (g *GitCheckout) fetchBranch(ctx context.Context, branch string) (bool, error) {
+	refName := plumbing.NewBranchReferenceName(branch)
+	refSpec := singleBranchRefSpec(refName)
+	if refSpec.Validate() != nil {
+		return false, nil
+	}
+	g.mu.Lock()
+	repo := g.repo
+	auth := g.auth
+	depth := g.depth
+	cfg, err := repo.Config()
+	g.mu.Unlock()
+	if err != nil {
+		return false, fmt.Errorf("unable to read config: %w", err)
+	}
+	origin, exists := cfg.Remotes["origin"]
+	if !exists {
+		return false, fmt.Errorf("unable to find origin remote")
+	}
+	if !matchesAny(origin.Fetch, refName) {
+		return false, nil
+	}
+	s := &fetchStorer{lockedStorer: lockedStorer{Storage: memory.NewStorage(), g: g}}
+	err = git.NewRemote(s, origin).FetchContext(ctx, &git.FetchOptions{
+		RefSpecs: []config.RefSpec{refSpec},
+		Auth:     attachContextToAuth(ctx, auth),
+		Depth:    depth,
+	})
+	if errors.Is(err, git.NoMatchingRefSpecError{}) {
+		return false, nil
+	}
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return false, err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.repo != repo {
+		return false, fmt.Errorf("checkout was re-cloned while fetching %s", branch)
+	}
+	before, err := g.remoteHeads()
+	if err != nil {
+		return false, err
+	}
+	if err := s.landNoLock(); err != nil {
+		return false, fmt.Errorf("unable to store fetched branch: %w", err)
+	}
+	g.log.Info(ctx, "fetched missing branch", zap.String("branch", branch))
+	return true, g.recordChanges(WithTrigger(ctx, TriggerFetchOnMiss), before)
+}
+
+// fetchStorer is what a fetch on miss runs against.  Like lockedStorer, it reads the checkout holding g.mu only while
+// each object or the references are read, so the fetch negotiates what it needs, but what the fetch stores goes to
+// memory until landNoLock copies it into the checkout.
+type fetchStorer struct {
+	lockedStorer
+}
+
+func (s *fetchStorer) EncodedObject(t plumbing.ObjectType, h plumbing.Hash) (plumbing.EncodedObject, error) {
+	if obj, err := s.Storage.EncodedObject(t, h); err == nil {
+		return obj, nil
+	}
+	return s.g.copyObject(t, h)
+}
+
+func (s *fetchStorer) HasEncodedObject(h plumbing.Hash) error {
+	if s.Storage.HasEncodedObject(h) == nil {
+		return nil
+	}
+	s.g.mu.Lock()
+	defer s.g.mu.Unlock()
+	return s.g.repo.Storer.HasEncodedObject(h)
+}
+
+func (s *fetchStorer) EncodedObjectSize(h plumbing.Hash) (int64, error) {
+	if size, err := s.Storage.EncodedObjectSize(h); err == nil {
+		return size, nil
+	}
+	s.g.mu.Lock()
+	defer s.g.mu.Unlock()
+	return s.g.repo.Storer.EncodedObjectSize(h)
+}
+
+// Reference is read to know where a fetched reference was before
+func (s *fetchStorer) Reference(name plumbing.ReferenceName) (*plumbing.Reference, error) {
+	if r, err := s.Storage.Reference(name); err == nil {
+		return r, nil
+	}
+	s.g.mu.Lock()
+	defer s.g.mu.Unlock()
+	return s.g.repo.Storer.Reference(name)
+}
+
+// IterReferences is read for the commits the checkout already has, so upstream only sends what is new
+func (s *fetchStorer) IterReferences() (storer.ReferenceIter, error) {
+	s.g.mu.Lock()
+	defer s.g.mu.Unlock()
+	refs, err := s.g.repo.Storer.IterReferences()
+	if err != nil {
+		return nil, err
+	}
+	defer refs.Close()
+	var ret []*plumbing.Reference
+	if err := refs.ForEach(func(r *plumbing.Reference) error {
+		ret = append(ret, r)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return storer.NewReferenceSliceIter(ret), nil
+}
+
+// landNoLock copies the fetched objects into the checkout, then the references that point at them.  Must hold g.mu.
+func (s *fetchStorer) landNoLock() error {
+	into := s.g.repo.Storer
+	objects, err := s.Storage.IterEncodedObjects(plumbing.AnyObject)
+	if err != nil {
+		return err
+	}
+	if err := objects.ForEach(func(obj plumbing.EncodedObject) error {
+		_, err := into.SetEncodedObject(obj)
+		return err
+	}); err != nil {
+		return err
+	}
+	shallow, err := s.Storage.Shallow()
+	if err != nil {
+		return err
+	}
+	if len(shallow) > 0 {
+		if err := into.SetShallow(shallow); err != nil {
+			return err
+		}
+	}
+	refs, err := s.Storage.IterReferences()
+	if err != nil {
+		return err
+	}
+	return refs.ForEach(into.SetReference)
+}
+
+func matchesAny(refSpecs []config.RefSpec, name plumbing.ReferenceName) bool {
+	for _, s := range refSpecs {
+		if s.Match(name) {
+			return true
+		}
+	}
+	return false
+}
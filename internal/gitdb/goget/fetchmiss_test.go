@@ -0,0 +1,107 @@
+package goget
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cresta/gitdb/internal/gitdb/tracing"
+	"github.com/cresta/gitdb/internal/testhelp"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitCheckout_FetchOnMiss(t *testing.T) {
+	ctx := context.Background()
+	g := &GitOperator{Log: testhelp.ZapTestingLogger(t), Tracer: tracing.Noop{}}
+	upstreamDir := t.TempDir()
+	upstream, err := git.PlainInit(upstreamDir, false)
+	require.NoError(t, err)
+	wt, err := upstream.Worktree()
+	require.NoError(t, err)
+	sig := &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()}
+	commit, err := wt.Commit("first", &git.CommitOptions{Author: sig, AllowEmptyCommits: true})
+	require.NoError(t, err)
+
+	co, err := g.Clone(ctx, t.TempDir(), upstreamDir, nil)
+	require.NoError(t, err)
+	require.NoError(t, upstream.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName("feature"), commit)))
+
+	_, err = co.Commit(ctx, "feature")
+	require.True(t, errors.Is(err, ErrUnknownBranch))
+
+	co.SetFetchOnMiss(true)
+	c, err := co.Commit(ctx, "feature")
+	require.NoError(t, err)
+	require.Equal(t, commit.String(), c.Hash)
+
+	_, err = co.Commit(ctx, "missing")
+	require.True(t, errors.Is(err, ErrUnknownBranch))
+	require.Contains(t, co.missed, "missing")
+	// Not fetched again until fetchOnMissRetry passes
+	require.NoError(t, upstream.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName("missing"), commit)))
+	_, err = co.Commit(ctx, "missing")
+	require.True(t, errors.Is(err, ErrUnknownBranch))
+}
+
+func TestGitCheckout_FetchOnMissConcurrent(t *testing.T) {
+	ctx := context.Background()
+	m := &countingMetrics{counts: make(map[string]float64)}
+	g := &GitOperator{Log: testhelp.ZapTestingLogger(t), Tracer: tracing.Noop{}, Metrics: m}
+	upstreamDir := newBareUpstream(t)
+	upstream, err := git.PlainOpen(upstreamDir)
+	require.NoError(t, err)
+	co, err := g.Clone(ctx, t.TempDir(), upstreamDir, nil)
+	require.NoError(t, err)
+	co.SetFetchOnMiss(true)
+	head, err := upstream.Head()
+	require.NoError(t, err)
+	require.NoError(t, upstream.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName("feature"), head.Hash())))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := co.GetFile(ctx, "feature", "a.txt")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	require.GreaterOrEqual(t, m.counts["gitdb_fetch_on_miss_total "+upstreamDir+" found"], 1.0)
+	// The checkout's own HEAD and branches are untouched
+	h, err := co.repo.Storer.Reference(plumbing.HEAD)
+	require.NoError(t, err)
+	require.Equal(t, plumbing.SymbolicReference, h.Type())
+}
+
+func TestGitCheckout_noteMissNoLock(t *testing.T) {
+	g := &GitCheckout{}
+	for i := 0; i < maxMissedBranches; i++ {
+		g.noteMissNoLock(strconv.Itoa(i))
+	}
+	require.Len(t, g.missed, maxMissedBranches)
+	// Full: the oldest is forgotten
+	g.missed["0"] = time.Now().Add(-time.Second)
+	g.noteMissNoLock("new")
+	require.Len(t, g.missed, maxMissedBranches)
+	require.NotContains(t, g.missed, "0")
+	require.Contains(t, g.missed, "new")
+	// Expired misses are all forgotten first
+	g.missed["1"] = time.Now().Add(-fetchOnMissRetry)
+	g.missed["2"] = time.Now().Add(-fetchOnMissRetry)
+	g.noteMissNoLock("newer")
+	require.Len(t, g.missed, maxMissedBranches-1)
+	require.NotContains(t, g.missed, "1")
+	require.NotContains(t, g.missed, "2")
+}
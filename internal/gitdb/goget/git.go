@@ -4,74 +4,180 @@ import (
 	"archive/zip"
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
 	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
 	lru "github.com/hashicorp/golang-lru"
 
+	"github.com/cresta/gitdb/internal/gitdb/metrics"
 	"github.com/cresta/gitdb/internal/gitdb/tracing"
 
 	"github.com/cresta/gitdb/internal/log"
 	"github.com/go-git/go-git/v5/plumbing/transport"
 
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
 	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/storage/memory"
 	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
 )
 
 type GitOperator struct {
-	Log    *log.Logger
-	Tracer tracing.Tracing
+	Log         *log.Logger
+	Tracer      tracing.Tracing
+	SharedCache SharedCache
+	// Optional: file contents shared by every checkout, keyed by commit and path
+	BlobCache *BlobCache
+	// Optional: gzip compressed text files shared by every checkout, keyed by blob
+	GzipCache *GzipCache
+	// Optional: records the progress of each clone
+	CloneTracker *CloneTracker
+	// Optional: where clones, refreshes and caches report metrics
+	Metrics metrics.Metrics
+	// Optional: runs every refresh, at a lower priority than reads
+	RefreshPool *RefreshPool
+	// Optional: records every branch update of every checkout
+	RefJournal *RefJournal
+}
+
+func (g *GitOperator) metrics() metrics.Metrics {
+	return metrics.OrNoop(g.Metrics)
 }
 
 func (g *GitOperator) Clone(ctx context.Context, into string, remoteURL string, auth transport.AuthMethod) (*GitCheckout, error) {
+	return g.clone(ctx, into, remoteURL, auth, func(ctx context.Context, opts *git.CloneOptions) (*git.Repository, error) {
+		return git.PlainCloneContext(ctx, into, true, opts)
+	})
+}
+
+// CloneStorage clones the repository into new storage created by s
+func (g *GitOperator) CloneStorage(ctx context.Context, s Storage, name string, remoteURL string, auth transport.AuthMethod) (*GitCheckout, error) {
+	storer, location, err := s.NewStorer(name)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create storage: %w", err)
+	}
+	return g.clone(ctx, location, remoteURL, auth, func(ctx context.Context, opts *git.CloneOptions) (*git.Repository, error) {
+		return git.CloneContext(ctx, storer, nil, opts)
+	})
+}
+
+func (g *GitOperator) clone(ctx context.Context, into string, remoteURL string, auth transport.AuthMethod, doClone func(ctx context.Context, opts *git.CloneOptions) (*git.Repository, error)) (*GitCheckout, error) {
 	var ret *GitCheckout
 	err := g.Tracer.StartSpanFromContext(ctx, tracing.SpanConfig{OperationName: "clone"}, func(ctx context.Context) error {
 		var progress bytes.Buffer
-		repo, err := git.PlainCloneContext(ctx, into, true, &git.CloneOptions{
+		var progressOut io.Writer = &progress
+		if pw := g.CloneTracker.start(remoteURL); pw != nil {
+			progressOut = io.MultiWriter(&progress, pw)
+		}
+		start := time.Now()
+		repo, err := doClone(ctx, &git.CloneOptions{
 			URL:      remoteURL,
 			Auth:     attachContextToAuth(ctx, auth),
-			Progress: &progress,
+			Progress: progressOut,
 		})
+		observeClone(g.metrics(), remoteURL, start, err)
+		g.CloneTracker.finish(remoteURL, err)
 		if err != nil {
 			g.Log.Warn(ctx, "unable to clone", zap.Stringer("progress", &progress))
 			return err
 		}
 		g.Log.Debug(ctx, "clone finished", zap.Stringer("progress", &progress))
-		c, err := lru.New(1000)
-		if err != nil {
-			return fmt.Errorf("unable to create cache: %w", err)
-		}
-		ret = &GitCheckout{
-			repo:      repo,
-			absPath:   into,
-			auth:      auth,
-			tracing:   g.Tracer,
-			cache:     c,
-			remoteURL: remoteURL,
-			log:       g.Log.With(zap.String("repo", remoteURL)),
-		}
-		return nil
+		ret, err = g.newCheckout(repo, into, remoteURL, auth)
+		return err
 	})
 	return ret, err
 }
 
+func (g *GitOperator) newCheckout(repo *git.Repository, into string, remoteURL string, auth transport.AuthMethod) (*GitCheckout, error) {
+	c, err := lru.New(1000)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create cache: %w", err)
+	}
+	ret := &GitCheckout{
+		repo:        repo,
+		absPath:     into,
+		auth:        auth,
+		tracing:     g.Tracer,
+		cache:       c,
+		sharedCache: g.SharedCache,
+		blobCache:   g.BlobCache,
+		gzipCache:   g.GzipCache,
+		metrics:     g.metrics(),
+		refreshPool: g.RefreshPool,
+		journal:     g.RefJournal,
+		remoteURL:   remoteURL,
+		log:         g.Log.With(zap.String("repo", remoteURL)),
+	}
+	ret.markRefreshed()
+	return ret, nil
+}
+
 type GitCheckout struct {
 	absPath   string
 	tracing   tracing.Tracing
+	metrics   metrics.Metrics
 	repo      *git.Repository
 	log       *log.Logger
 	remoteURL string
 	auth      transport.AuthMethod
 	cache     CheckoutCache
+	// Optional cache of content addressed objects, shared across replicas
+	sharedCache SharedCache
+	// Optional in memory cache of file contents by commit and path
+	blobCache *BlobCache
+	// Optional cache of compressed text files by blob
+	gzipCache *GzipCache
+	// Optional: where refreshes run
+	refreshPool *RefreshPool
+	// Optional: where branch updates are recorded
+	journal *RefJournal
+	// Branches and paths served compressed, compressed again after each refresh.  Guarded by hotMu, not g.mu, since
+	// they are recorded while serving.
+	hotMu    sync.Mutex
+	hotPaths map[hotPath]struct{}
+	// What changed on each branch during the last refresh that moved it
+	changes map[string]BranchChange
+	// Optional checked out copy of one branch
+	workTree *workTree
+	// Branches deleted upstream that are still served until deletedBranchGrace passes
+	deleted            map[string]deletedBranch
+	deletedBranchGrace time.Duration
+	// Re-clones the repository when its storage is corrupt
+	recloner Recloner
+	// Reads in a row that failed with corruption
+	corruptReads int
+	// Set while a fresh clone is being made to replace this one
+	healing bool
+	// Fetch branches that are not known locally when they are read, unless they went missing within fetchOnMissRetry
+	fetchOnMiss bool
+	missed      map[string]time.Time
+	missFetches singleflight.Group
+	// Positive fetches only this many commits of history.  Set for shallow clones.
+	depth int
+	// Set by OpenLocal: branches are the repository's own, and there is no remote to fetch
+	local bool
+	// Heads of an OpenLocal checkout at its last refresh
+	localHeads map[string]plumbing.Hash
+	// Unix nanoseconds of the last successful fetch.  Read without g.mu, which a running fetch holds.
+	lastRefresh atomic.Int64
+	// Set while Revalidate's background refresh runs
+	revalidating atomic.Bool
+	// When background refreshes are paused.  Read without g.mu, like lastRefresh.
+	maintenanceWindows atomic.Pointer[[]MaintenanceWindow]
 
 	mu sync.Mutex
 }
@@ -82,6 +188,7 @@ type CheckoutCache interface {
 	Get(key interface{}) (interface{}, bool)
 	Add(key interface{}, b interface{}) bool
 	Remove(key interface{}) (present bool)
+	Purge()
 }
 
 func (g *GitCheckout) RemoteURL() string {
@@ -89,25 +196,108 @@ func (g *GitCheckout) RemoteURL() string {
 }
 
 func (g *GitCheckout) Refresh(ctx context.Context) error {
+	// Waits for a worker without g.mu, so reads are served meanwhile
+	return g.refreshPool.Do(ctx, func() error {
+		return g.refresh(ctx)
+	})
+}
+
+func (g *GitCheckout) refresh(ctx context.Context) error {
 	g.mu.Lock()
 	defer g.mu.Unlock()
-	return g.tracing.StartSpanFromContext(ctx, tracing.SpanConfig{OperationName: "refresh"}, func(ctx context.Context) error {
+	start := time.Now()
+	err := g.tracing.StartSpanFromContext(ctx, tracing.SpanConfig{OperationName: "refresh"}, func(ctx context.Context) error {
 		var progress bytes.Buffer
 		g.tracing.AttachTag(ctx, "git.remote_url", g.remoteURL)
-		err := g.repo.FetchContext(ctx, &git.FetchOptions{
+		if g.healing {
+			g.log.Info(ctx, "skipping refresh of quarantined checkout")
+			return nil
+		}
+		if g.local {
+			return g.refreshLocal(ctx)
+		}
+		before, err := g.remoteHeads()
+		if err != nil {
+			return err
+		}
+		err = g.repo.FetchContext(ctx, &git.FetchOptions{
 			Auth:     attachContextToAuth(ctx, g.auth),
 			Progress: &progress,
+			Prune:    g.deletedBranchGrace > 0,
+			Depth:    g.depth,
 		})
 		if err == nil || errors.Is(err, git.NoErrAlreadyUpToDate) {
+			g.markRefreshed()
+		}
+		if errors.Is(err, git.NoErrAlreadyUpToDate) {
 			g.log.Debug(ctx, "fetch finished", zap.Stringer("progress", &progress))
-			return nil
+			// A prune alone can report up to date
+			return g.recordChanges(ctx, before)
+		}
+		if err == nil {
+			g.log.Debug(ctx, "fetch finished", zap.Stringer("progress", &progress))
+			if err := g.recordChanges(ctx, before); err != nil {
+				return err
+			}
+			g.prefetchNoLock(ctx)
+			g.precompressNoLock(ctx)
+			return g.syncWorkTree(ctx)
 		}
 		g.log.Warn(ctx, "unable to fetch", zap.Stringer("progress", &progress))
 		return fmt.Errorf("unable to refresh repository: %w", err)
 	})
+	observeRefresh(g.metrics, g.remoteURL, start, err)
+	return err
 }
 
+// Close waits for a running refresh or read, then releases the files held open by the checkout's storage.  The
+// checkout must not be used afterwards.
+func (g *GitCheckout) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if c, ok := g.repo.Storer.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// HasHead is true if the remote tracking branch of branch is already at commit hash, so a fetch for it would do
+// nothing.  Only the tracking branch counts: a deleted branch still served during its grace period, a tag or a commit
+// of the same name never match.
+func (g *GitCheckout) HasHead(branch string, hash string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	r, err := g.repo.Reference(plumbing.NewRemoteReferenceName("origin", branch), true)
+	if err != nil {
+		return false
+	}
+	return r.Hash().String() == hash
+}
+
+// RotateAuth replaces the credentials used to fetch, once an ls-remote with the new credentials succeeds.  The old
+// credentials stay in use if validation fails.
+func (g *GitCheckout) RotateAuth(ctx context.Context, auth transport.AuthMethod) error {
+	return g.tracing.StartSpanFromContext(ctx, tracing.SpanConfig{OperationName: "rotate_auth"}, func(ctx context.Context) error {
+		g.tracing.AttachTag(ctx, "git.remote_url", g.remoteURL)
+		remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+			Name: "origin",
+			URLs: []string{g.remoteURL},
+		})
+		if _, err := remote.ListContext(ctx, &git.ListOptions{Auth: attachContextToAuth(ctx, auth)}); err != nil {
+			return fmt.Errorf("unable to validate new credentials: %w", err)
+		}
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		g.auth = auth
+		g.log.Info(ctx, "rotated credentials")
+		return nil
+	})
+}
+
+// AbsPath is the location the repository was cloned into, or empty for storage without one (like memory)
 func (g *GitCheckout) AbsPath() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
 	return g.absPath
 }
 
@@ -126,14 +316,31 @@ type getFileCacheKey struct {
 
 type getFileCacheValue struct {
 	data         string
+	info         FileInfo
 	creationTime time.Time
 }
 
+// FileInfo identifies the revision a file was read from
+type FileInfo struct {
+	Commit     string
+	CommitTime time.Time
+	BlobHash   string
+	// Set by content_types in the repository's .gitdb.yaml
+	ContentType string
+}
+
 func (g *GitCheckout) GetFile(ctx context.Context, branch string, path string) (io.WriterTo, error) {
+	ret, _, err := g.GetFileWithInfo(ctx, branch, path)
+	return ret, err
+}
+
+// GetFileWithInfo is GetFile that also returns the commit and blob the content came from
+func (g *GitCheckout) GetFileWithInfo(ctx context.Context, branch string, path string) (io.WriterTo, FileInfo, error) {
 	cacheKey := getFileCacheKey{branch, path}
 	if item, exists := g.cache.Get(cacheKey); exists {
 		if v, ok := item.(getFileCacheValue); ok {
 			g.tracing.AttachTag(ctx, "cache.hit", true)
+			observeCache(g.metrics, "file", true)
 			if time.Since(v.creationTime) > time.Minute*2 {
 				g.log.Debug(ctx, "old cache hit")
 				g.cache.Remove(cacheKey)
@@ -141,41 +348,99 @@ func (g *GitCheckout) GetFile(ctx context.Context, branch string, path string) (
 				g.log.Debug(ctx, "cache hit")
 				var buf bytes.Buffer
 				if _, err := io.WriteString(&buf, v.data); err != nil {
-					return nil, fmt.Errorf("unable to write to buffer: %w", err)
+					return nil, FileInfo{}, fmt.Errorf("unable to write to buffer: %w", err)
 				}
-				return &buf, nil
+				return &buf, v.info, nil
 			}
 		}
 	}
 	g.tracing.AttachTag(ctx, "cache.hit", false)
+	observeCache(g.metrics, "file", false)
 	g.mu.Lock()
 	defer g.mu.Unlock()
-	branchAsRef := plumbing.NewRemoteReferenceName("origin", branch)
-	r, err := g.repo.Reference(plumbing.ReferenceName(branchAsRef.String()), true)
+	buf, info, err := g.getFileNoLock(ctx, branch, path)
 	if err != nil {
-		return nil, &unknownBranch{branch: branch, wraps: err}
-	}
-	if err != nil {
-		g.log.Warn(ctx, "invalid branch", zap.Error(err))
-		return nil, err
-	}
-	f, err := g.fileContent(ctx, path, r)
-	if err != nil {
-		return nil, err
+		return nil, FileInfo{}, err
 	}
+	return buf, info, nil
+}
+
+// getFileNoLock reads path at the current head of branch and caches it.  Must hold g.mu.
+func (g *GitCheckout) getFileNoLock(ctx context.Context, branch string, path string) (*bytes.Buffer, FileInfo, error) {
+	var info FileInfo
 	var buf bytes.Buffer
-	if _, err := f.WriteTo(&buf); err != nil {
-		return nil, fmt.Errorf("unable to read file contents: %w", err)
+	err := g.withReadRetries(ctx, "get_file", func() error {
+		buf.Reset()
+		r, err := g.resolveRef(ctx, branch)
+		if err != nil {
+			return err
+		}
+		data, cachedInfo, ok := g.blobCache.get(r.Hash(), path)
+		if g.blobCache != nil {
+			observeCache(g.metrics, "blob", ok)
+		}
+		if ok {
+			info = cachedInfo
+			buf.Write(data)
+			return nil
+		}
+		f, err := g.fileContent(ctx, path, r)
+		if err != nil {
+			return err
+		}
+		info = FileInfo{
+			Commit:     f.commit.Hash.String(),
+			CommitTime: f.commit.Committer.When,
+			BlobHash:   f.f.Hash.String(),
+		}
+		if meta, err := g.metadataNoLock(r.Hash()); err != nil {
+			g.log.Warn(ctx, "unable to read repository metadata", zap.Error(err))
+		} else {
+			info.ContentType = meta.contentType(path)
+		}
+		blobKey := "blob:" + f.f.Hash.String()
+		if data, ok := g.sharedCacheGet(ctx, blobKey); ok {
+			buf.Write(data)
+		} else {
+			if _, err := f.WriteTo(&buf); err != nil {
+				return fmt.Errorf("unable to read file contents: %w", err)
+			}
+			g.sharedCacheSet(ctx, blobKey, buf.Bytes())
+		}
+		g.blobCache.add(r.Hash(), path, bytes.Clone(buf.Bytes()), info)
+		return nil
+	})
+	if err != nil {
+		return nil, FileInfo{}, err
 	}
 	if buf.Len() > 100_000 {
-		return &buf, nil
+		return &buf, info, nil
 	}
 	var cacheBuf bytes.Buffer
 	if _, err := io.WriteString(&cacheBuf, buf.String()); err != nil {
-		return nil, fmt.Errorf("unable to copy file contents to cache: %w", err)
+		return nil, FileInfo{}, fmt.Errorf("unable to copy file contents to cache: %w", err)
 	}
-	g.cache.Add(getFileCacheKey{branch, path}, getFileCacheValue{data: cacheBuf.String(), creationTime: time.Now()})
-	return &buf, nil
+	g.cache.Add(getFileCacheKey{branch, path}, getFileCacheValue{data: cacheBuf.String(), info: info, creationTime: time.Now()})
+	return &buf, info, nil
+}
+
+// StatFile returns where path in branch comes from and its size, without reading its content
+func (g *GitCheckout) StatFile(ctx context.Context, branch string, path string) (FileInfo, int64, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	r, err := g.resolveRef(ctx, branch)
+	if err != nil {
+		return FileInfo{}, 0, err
+	}
+	f, err := g.fileContent(ctx, path, r)
+	if err != nil {
+		return FileInfo{}, 0, err
+	}
+	return FileInfo{
+		Commit:     f.commit.Hash.String(),
+		CommitTime: f.commit.Committer.When,
+		BlobHash:   f.f.Hash.String(),
+	}, f.f.Size, nil
 }
 
 func (g *GitCheckout) LsFiles(ctx context.Context, branch string) ([]string, error) {
@@ -186,10 +451,9 @@ func (g *GitCheckout) LsFiles(ctx context.Context, branch string) ([]string, err
 
 func (g *GitCheckout) lsFilesNoLock(ctx context.Context, branch string) ([]string, error) {
 	var ret []string
-	branchAsRef := plumbing.NewRemoteReferenceName("origin", branch)
-	r, err := g.repo.Reference(plumbing.ReferenceName(branchAsRef.String()), true)
+	r, err := g.resolveRef(ctx, branch)
 	if err != nil {
-		return nil, &unknownBranch{branch: branch, wraps: err}
+		return nil, err
 	}
 	err2 := g.tracing.StartSpanFromContext(ctx, tracing.SpanConfig{OperationName: "ls_files"}, func(ctx context.Context) error {
 		g.log.Debug(ctx, "asked to list files")
@@ -214,38 +478,100 @@ func (g *GitCheckout) lsFilesNoLock(ctx context.Context, branch string) ([]strin
 	return ret, err2
 }
 
-func (g *GitCheckout) ZipContent(ctx context.Context, into io.Writer, prefix string, branch string) (int, error) {
+// WalkFiles calls callback for every file at the tip of branch and returns the hash of the commit walked.  The commit
+// is resolved once, and g.mu is only held while each entry is read, never while callback runs.  The content of the
+// files is read, holding g.mu, when callback asks for it.
+func (g *GitCheckout) WalkFiles(ctx context.Context, branch string, callback func(f *object.File) error) (string, error) {
 	g.mu.Lock()
-	defer g.mu.Unlock()
-	w := zip.NewWriter(into)
-	files, err := g.lsFilesNoLock(ctx, branch)
-	prefix = strings.Trim(prefix, "/")
+	r, err := g.resolveRef(ctx, branch)
 	if err != nil {
-		return 0, fmt.Errorf("unable to list files: %w", err)
+		g.mu.Unlock()
+		return "", err
 	}
-	branchAsRef := plumbing.NewRemoteReferenceName("origin", branch)
-	r, err := g.repo.Reference(plumbing.ReferenceName(branchAsRef.String()), true)
+	t, err := g.dirTree(r, "")
+	g.mu.Unlock()
 	if err != nil {
-		return 0, &unknownBranch{branch: branch, wraps: err}
+		return "", err
 	}
-	numFiles := 0
-	for _, file := range files {
-		if !strings.HasPrefix(file, prefix) {
-			continue
-		}
-		filePath := file[len(prefix):]
-		wf, err := w.Create(strings.TrimPrefix(filePath, "/"))
-		if err != nil {
-			return numFiles, fmt.Errorf("unable to create file at path %s: %w", filePath, err)
+	err = g.tracing.StartSpanFromContext(ctx, tracing.SpanConfig{OperationName: "walk_files"}, func(_ context.Context) error {
+		walker := object.NewTreeWalker(t, true, nil)
+		defer walker.Close()
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			stat, err := g.nextEntry(walker)
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("unable to walk files of hash %s: %w", r.Hash(), err)
+			}
+			mode := filemode.FileMode(stat.Mode)
+			if !mode.IsFile() {
+				continue
+			}
+			blob, err := object.DecodeBlob(&lockedBlob{g: g, hash: plumbing.NewHash(stat.Hash), size: stat.Size})
+			if err != nil {
+				return fmt.Errorf("unable to make blob of %s: %w", stat.Name, err)
+			}
+			if err := callback(object.NewFile(stat.Name, mode, blob)); err != nil {
+				return err
+			}
 		}
-		wt, err := g.fileContent(ctx, file, r)
+	})
+	return r.Hash().String(), err
+}
+
+// lockedBlob is a blob of the checkout that is only read, holding g.mu, when a reader is asked for
+type lockedBlob struct {
+	g    *GitCheckout
+	hash plumbing.Hash
+	size int64
+}
+
+func (b *lockedBlob) Hash() plumbing.Hash             { return b.hash }
+func (b *lockedBlob) Type() plumbing.ObjectType       { return plumbing.BlobObject }
+func (b *lockedBlob) SetType(plumbing.ObjectType)     {}
+func (b *lockedBlob) Size() int64                     { return b.size }
+func (b *lockedBlob) SetSize(size int64)              { b.size = size }
+func (b *lockedBlob) Writer() (io.WriteCloser, error) { return nil, errors.New("blob is read only") }
+
+func (b *lockedBlob) Reader() (io.ReadCloser, error) {
+	obj, err := b.g.copyObject(plumbing.BlobObject, b.hash)
+	if err != nil {
+		return nil, err
+	}
+	return obj.Reader()
+}
+
+var _ plumbing.EncodedObject = &lockedBlob{}
+
+func (g *GitCheckout) ZipContent(ctx context.Context, into io.Writer, prefix string, branch string) (int, error) {
+	return g.ZipContents(ctx, into, []ZipPrefix{{Prefix: prefix}}, branch)
+}
+
+// ZipPrefix selects the files under Prefix and places them under Folder in the archive
+type ZipPrefix struct {
+	Prefix string
+	Folder string
+}
+
+// ZipContents writes every file under each prefix into a single zip
+func (g *GitCheckout) ZipContents(ctx context.Context, into io.Writer, prefixes []ZipPrefix, branch string) (int, error) {
+	w := zip.NewWriter(into)
+	numFiles, err := g.walkPrefixes(ctx, prefixes, branch, func(filePath string, f prefixFile) error {
+		wf, err := w.Create(filePath)
 		if err != nil {
-			return numFiles, fmt.Errorf("unable to get file content for %s: %w", file, err)
+			return fmt.Errorf("unable to create file at path %s: %w", filePath, err)
 		}
-		if _, err := wt.WriteTo(wf); err != nil {
-			return numFiles, fmt.Errorf("unable to write file named %s: %w", file, err)
+		if _, err := wf.Write(f.content); err != nil {
+			return fmt.Errorf("unable to write file named %s: %w", filePath, err)
 		}
-		numFiles++
+		return nil
+	})
+	if err != nil {
+		return numFiles, err
 	}
 	if err := w.Close(); err != nil {
 		return numFiles, fmt.Errorf("unable to close zip: %w", err)
@@ -253,10 +579,108 @@ func (g *GitCheckout) ZipContent(ctx context.Context, into io.Writer, prefix str
 	return numFiles, nil
 }
 
+// prefixFile is a file read by walkPrefixes
+type prefixFile struct {
+	content []byte
+	mode    filemode.FileMode
+	// When the commit the file was read at was made
+	commitTime time.Time
+}
+
+// walkPrefixes calls add with every file under each prefix, and the path it has under the prefix's folder.  Files
+// ignored by the repository's export_ignore are skipped.  It returns how many files were added.  The commit is resolved
+// once, and g.mu is only held while each file is read, never while add runs, so a slow consumer like a client stalls
+// neither reads nor refreshes.
+func (g *GitCheckout) walkPrefixes(ctx context.Context, prefixes []ZipPrefix, branch string, add func(filePath string, f prefixFile) error) (int, error) {
+	g.mu.Lock()
+	files, r, meta, err := g.prefixFilesNoLock(ctx, branch)
+	g.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	numFiles := 0
+	for _, p := range prefixes {
+		prefix := strings.Trim(p.Prefix, "/")
+		folder := strings.Trim(p.Folder, "/")
+		for _, file := range files {
+			if !strings.HasPrefix(file, prefix) || meta.exportIgnored(file) {
+				continue
+			}
+			if err := ctx.Err(); err != nil {
+				return numFiles, fmt.Errorf("stopped archiving: %w", err)
+			}
+			filePath := strings.TrimPrefix(file[len(prefix):], "/")
+			if folder != "" {
+				filePath = folder + "/" + filePath
+			}
+			f, err := g.readPrefixFile(ctx, file, r)
+			if err != nil {
+				return numFiles, fmt.Errorf("unable to get file content for %s: %w", file, err)
+			}
+			if err := add(filePath, f); err != nil {
+				return numFiles, err
+			}
+			numFiles++
+		}
+	}
+	return numFiles, nil
+}
+
+// prefixFilesNoLock is every file at the head of branch, the commit it resolved to and its metadata.  Must hold g.mu.
+func (g *GitCheckout) prefixFilesNoLock(ctx context.Context, branch string) ([]string, *plumbing.Reference, RepoMetadata, error) {
+	files, err := g.lsFilesNoLock(ctx, branch)
+	if err != nil {
+		return nil, nil, RepoMetadata{}, fmt.Errorf("unable to list files: %w", err)
+	}
+	r, err := g.resolveRef(ctx, branch)
+	if err != nil {
+		return nil, nil, RepoMetadata{}, err
+	}
+	// Pinned to the commit, which a refresh moving branch cannot change
+	r = plumbing.NewHashReference(r.Name(), r.Hash())
+	meta, err := g.metadataNoLock(r.Hash())
+	if err != nil {
+		return nil, nil, RepoMetadata{}, err
+	}
+	return files, r, meta, nil
+}
+
+// readPrefixFile reads file at the commit r points to into memory, holding g.mu only for the read
+func (g *GitCheckout) readPrefixFile(ctx context.Context, file string, r *plumbing.Reference) (prefixFile, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	f, err := g.fileContent(ctx, file, r)
+	if err != nil {
+		return prefixFile{}, err
+	}
+	var buf bytes.Buffer
+	buf.Grow(int(f.f.Size))
+	if _, err := f.WriteTo(&buf); err != nil {
+		return prefixFile{}, err
+	}
+	return prefixFile{content: buf.Bytes(), mode: f.f.Mode, commitTime: f.commit.Committer.When}, nil
+}
+
 type FileStat struct {
 	Name string
 	Mode uint32
 	Hash string
+	// Bytes of a file.  Zero for directories and submodules.
+	Size int64 `json:",omitempty"`
+	// When the last commit changing the entry was made.  Only set when asked for, since finding it walks history.
+	LastModified *time.Time `json:",omitempty"`
+}
+
+// entrySize is the size of the blob e points at, or zero for directories and submodules.  Must hold g.mu.
+func (g *GitCheckout) entrySize(e object.TreeEntry) (int64, error) {
+	if !e.Mode.IsFile() {
+		return 0, nil
+	}
+	size, err := g.repo.Storer.EncodedObjectSize(e.Hash)
+	if err != nil {
+		return 0, fmt.Errorf("unable to find size of %s: %w", e.Name, err)
+	}
+	return size, nil
 }
 
 type unknownBranch struct {
@@ -274,6 +698,66 @@ func (u *unknownBranch) Unwrap() error {
 
 var ErrUnknownBranch = errors.New("unknown branch")
 
+// branchRef resolves the branch of a request, trying in order a branch, a tag, then a full or abbreviated commit SHA.
+// Must hold g.mu.
+func (g *GitCheckout) branchRef(branch string) (*plumbing.Reference, error) {
+	if branch == DefaultBranch {
+		name, err := g.defaultBranchNoLock()
+		if err != nil {
+			return nil, &unknownBranch{branch: branch, wraps: err}
+		}
+		branch = name
+	}
+	branchAsRef := g.branchRefName(branch)
+	r, err := g.repo.Reference(branchAsRef, true)
+	if err == nil {
+		return r, nil
+	}
+	if d, exists := g.deletedBranch(branch); exists {
+		return plumbing.NewHashReference(branchAsRef, d.hash), nil
+	}
+	if r, tagErr := g.tagRef(branch); tagErr == nil {
+		return r, nil
+	}
+	if r, shaErr := g.commitRef(branch); shaErr == nil {
+		return r, nil
+	}
+	return nil, &unknownBranch{branch: branch, wraps: err}
+}
+
+// tagRef resolves a tag, annotated or not, to the commit it points at
+func (g *GitCheckout) tagRef(tag string) (*plumbing.Reference, error) {
+	r, err := g.repo.Tag(tag)
+	if err != nil {
+		return nil, err
+	}
+	hash := r.Hash()
+	if t, err := g.repo.TagObject(hash); err == nil {
+		c, err := t.Commit()
+		if err != nil {
+			return nil, fmt.Errorf("unable to find commit of tag %s: %w", tag, err)
+		}
+		hash = c.Hash
+	}
+	return plumbing.NewHashReference(r.Name(), hash), nil
+}
+
+// commitRef resolves a full or abbreviated commit SHA.  Like git, at least 4 hex digits are needed.
+func (g *GitCheckout) commitRef(sha string) (*plumbing.Reference, error) {
+	sha = strings.ToLower(sha)
+	if len(sha) < 4 || len(sha) > 40 || strings.Trim(sha, "0123456789abcdef") != "" {
+		return nil, fmt.Errorf("not a commit sha: %s", sha)
+	}
+	hash, err := g.repo.ResolveRevision(plumbing.Revision(sha))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := g.repo.CommitObject(*hash); err != nil {
+		return nil, err
+	}
+	return plumbing.NewHashReference(plumbing.ReferenceName(sha), *hash), nil
+}
+
 func (u *unknownBranch) Is(err error) bool {
 	return err == ErrUnknownBranch
 }
@@ -285,46 +769,70 @@ func (g *GitCheckout) LsDir(ctx context.Context, dir string, branch string) (ret
 	defer func() {
 		g.log.Debug(ctx, "list done", zap.Error(retErr))
 	}()
-	branchAsRef := plumbing.NewRemoteReferenceName("origin", branch)
-	r, err := g.repo.Reference(plumbing.ReferenceName(branchAsRef.String()), true)
+	retErr = g.tracing.StartSpanFromContext(ctx, tracing.SpanConfig{OperationName: "ls_dir"}, func(ctx context.Context) error {
+		return g.withReadRetries(ctx, "ls_dir", func() error {
+			var err error
+			retStat, err = g.lsDirNoLock(ctx, dir, branch)
+			return err
+		})
+	})
+	return retStat, retErr
+}
+
+// lsDirNoLock lists dir at the current head of branch.  Must hold g.mu.
+func (g *GitCheckout) lsDirNoLock(ctx context.Context, dir string, branch string) ([]FileStat, error) {
+	r, err := g.resolveRef(ctx, branch)
 	if err != nil {
-		return nil, &unknownBranch{branch: branch, wraps: err}
+		return nil, err
 	}
-	retErr = g.tracing.StartSpanFromContext(ctx, tracing.SpanConfig{OperationName: "ls_dir"}, func(_ context.Context) error {
-		co, err := g.repo.CommitObject(r.Hash())
-		if err != nil {
-			return fmt.Errorf("unable to make commit object for hash %s: %w", r.Hash(), err)
-		}
-		t, err := co.Tree()
+	co, err := g.repo.CommitObject(r.Hash())
+	if err != nil {
+		return nil, fmt.Errorf("unable to make commit object for hash %s: %w", r.Hash(), err)
+	}
+	t, err := co.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("unable to make tree object for hash %s: %w", co.Hash, err)
+	}
+	te := t
+	if dir != "" {
+		te, err = t.Tree(dir)
 		if err != nil {
-			return fmt.Errorf("unable to make tree object for hash %s: %w", co.Hash, err)
+			return nil, fmt.Errorf("unable to find entry named %s: %w", dir, err)
 		}
-		te := t
-		if dir != "" {
-			te, err = t.Tree(dir)
-			if err != nil {
-				return fmt.Errorf("unable to find entry named %s: %w", dir, err)
-			}
+	}
+	treeKey := "tree:" + te.Hash.String()
+	var retStat []FileStat
+	if data, ok := g.sharedCacheGet(ctx, treeKey); ok {
+		if err := json.Unmarshal(data, &retStat); err == nil {
+			return retStat, nil
 		}
-		retStat = make([]FileStat, 0)
-		for _, e := range te.Entries {
-			retStat = append(retStat, FileStat{
-				Name: e.Name,
-				Mode: uint32(e.Mode),
-				Hash: e.Hash.String(),
-			})
+	}
+	retStat = make([]FileStat, 0)
+	for _, e := range te.Entries {
+		size, err := g.entrySize(e)
+		if err != nil {
+			return nil, err
 		}
-		sort.Slice(retStat, func(i, j int) bool {
-			return retStat[i].Name < retStat[j].Name
+		retStat = append(retStat, FileStat{
+			Name: e.Name,
+			Mode: uint32(e.Mode),
+			Hash: e.Hash.String(),
+			Size: size,
 		})
-		return nil
+	}
+	sort.Slice(retStat, func(i, j int) bool {
+		return retStat[i].Name < retStat[j].Name
 	})
-	return retStat, retErr
+	if data, err := json.Marshal(retStat); err == nil {
+		g.sharedCacheSet(ctx, treeKey, data)
+	}
+	return retStat, nil
 }
 
-// Will eventually want to cache this
-func (g *GitCheckout) fileContent(ctx context.Context, fileName string, w *plumbing.Reference) (io.WriterTo, error) {
-	var ret io.WriterTo
+// fileContent finds fileName in the commit w points at.  getFileNoLock caches what it reads in the BlobCache.
+func (g *GitCheckout) fileContent(ctx context.Context, fileName string, w *plumbing.Reference) (*readerWriterTo, error) {
+	var ret *readerWriterTo
+	reqCtx := ctx
 	err := g.tracing.StartSpanFromContext(ctx, tracing.SpanConfig{OperationName: "file_content"}, func(ctx context.Context) error {
 		g.log.Debug(ctx, "asked to fetch file", zap.String("file_name", fileName))
 		defer g.log.Debug(ctx, "fetch done")
@@ -337,8 +845,10 @@ func (g *GitCheckout) fileContent(ctx context.Context, fileName string, w *plumb
 			return fmt.Errorf("unable to fetch file %s: %w", fileName, err)
 		}
 		ret = &readerWriterTo{
-			f: f,
-			z: g.log.With(zap.String("file_name", fileName)),
+			ctx:    reqCtx,
+			f:      f,
+			commit: t,
+			z:      g.log.With(zap.String("file_name", fileName)),
 		}
 		return nil
 	})
@@ -346,8 +856,11 @@ func (g *GitCheckout) fileContent(ctx context.Context, fileName string, w *plumb
 }
 
 type readerWriterTo struct {
-	f *object.File
-	z *log.Logger
+	// Reads stop once ctx ends, so a cancelled request does not finish copying a large blob
+	ctx    context.Context
+	f      *object.File
+	commit *object.Commit
+	z      *log.Logger
 }
 
 func (r *readerWriterTo) WriteTo(w io.Writer) (n int64, err error) {
@@ -356,18 +869,37 @@ func (r *readerWriterTo) WriteTo(w io.Writer) (n int64, err error) {
 		return 0, fmt.Errorf("unable to make reader : %w", err)
 	}
 	defer func() {
-		r.z.IfErr(rd.Close()).Warn(context.Background(), "unable to close file object")
+		r.z.IfErr(rd.Close()).Warn(r.ctx, "unable to close file object")
 	}()
-	return io.Copy(w, rd)
+	return io.Copy(w, &contextReader{ctx: r.ctx, r: rd})
+}
+
+// contextReader fails reads once ctx is done
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
 }
 
 var _ io.WriterTo = &readerWriterTo{}
 
+// WrapGitProtocols traces every git transport.  HTTP remotes also use a traced http.Client, so trace context reaches
+// the git server.
 func WrapGitProtocols(t tracing.Tracing) {
+	httpTransport := githttp.NewClient(tracing.NewHTTPClient(t, 0))
 	for key, protocol := range client.Protocols {
 		if _, ok := client.Protocols[key].(*LoggedClient); ok {
 			continue
 		}
+		if key == "http" || key == "https" {
+			protocol = httpTransport
+		}
 		client.Protocols[key] = &LoggedClient{
 			Wrapped: protocol,
 			Tracing: t,
@@ -382,10 +914,14 @@ type LoggedClient struct {
 
 var _ transport.Transport = &LoggedClient{}
 
+// NewUploadPackSession continues the trace of the context curried into authMethod, like a /refresh request's, and so
+// do the returned session's calls
 func (l *LoggedClient) NewUploadPackSession(endpoint *transport.Endpoint, authMethod transport.AuthMethod) (transport.UploadPackSession, error) {
+	sessionCtx := contextFromAuth(authMethod)
 	var ret transport.UploadPackSession
-	err := l.Tracing.StartSpanFromContext(contextFromAuth(authMethod), tracing.SpanConfig{OperationName: "NewUploadPackSession"}, func(ctx context.Context) error {
+	err := l.Tracing.StartSpanFromContext(sessionCtx, tracing.SpanConfig{OperationName: "NewUploadPackSession"}, func(ctx context.Context) error {
 		authMethod = unwrapAuth(authMethod)
+		l.tagRoute(ctx)
 		l.Tracing.AttachTag(ctx, "git.upload_pack.endpoint", endpoint.String())
 		if authMethod != nil {
 			l.Tracing.AttachTag(ctx, "git.auth", authMethod.Name())
@@ -394,13 +930,21 @@ func (l *LoggedClient) NewUploadPackSession(endpoint *transport.Endpoint, authMe
 		ret, retErr = l.Wrapped.NewUploadPackSession(endpoint, authMethod)
 		return retErr
 	})
-	return ret, err
+	if err != nil {
+		return nil, err
+	}
+	return &tracedUploadPackSession{
+		UploadPackSession: ret,
+		tracedSession:     tracedSession{client: l, ctx: sessionCtx, session: ret, endpoint: endpoint.String()},
+	}, nil
 }
 
 func (l *LoggedClient) NewReceivePackSession(endpoint *transport.Endpoint, authMethod transport.AuthMethod) (transport.ReceivePackSession, error) {
+	sessionCtx := contextFromAuth(authMethod)
 	var ret transport.ReceivePackSession
-	err := l.Tracing.StartSpanFromContext(contextFromAuth(authMethod), tracing.SpanConfig{OperationName: "NewReceivePackSession"}, func(ctx context.Context) error {
+	err := l.Tracing.StartSpanFromContext(sessionCtx, tracing.SpanConfig{OperationName: "NewReceivePackSession"}, func(ctx context.Context) error {
 		authMethod = unwrapAuth(authMethod)
+		l.tagRoute(ctx)
 		l.Tracing.AttachTag(ctx, "git.recv_pack.endpoint", endpoint.String())
 		if authMethod != nil {
 			l.Tracing.AttachTag(ctx, "git.auth", authMethod.Name())
@@ -409,6 +953,101 @@ func (l *LoggedClient) NewReceivePackSession(endpoint *transport.Endpoint, authM
 		ret, retErr = l.Wrapped.NewReceivePackSession(endpoint, authMethod)
 		return retErr
 	})
+	if err != nil {
+		return nil, err
+	}
+	return &tracedReceivePackSession{
+		ReceivePackSession: ret,
+		tracedSession:      tracedSession{client: l, ctx: sessionCtx, session: ret, endpoint: endpoint.String()},
+	}, nil
+}
+
+// tagRoute tags the span in ctx with the route of the request that caused it, if any
+func (l *LoggedClient) tagRoute(ctx context.Context) {
+	if route := tracing.Route(ctx); route != "" {
+		l.Tracing.AttachTag(ctx, "mux.name", route)
+	}
+}
+
+// span runs callback in a span tagged like the session's
+func (l *LoggedClient) span(ctx context.Context, operation string, endpoint string, callback func(ctx context.Context) error) error {
+	return l.Tracing.StartSpanFromContext(ctx, tracing.SpanConfig{OperationName: operation}, func(ctx context.Context) error {
+		l.tagRoute(ctx)
+		l.Tracing.AttachTag(ctx, "git.endpoint", endpoint)
+		return callback(ctx)
+	})
+}
+
+// tracedSession traces reference discovery.  Calls without a context continue the trace the session was created in.
+type tracedSession struct {
+	client   *LoggedClient
+	ctx      context.Context
+	session  transport.Session
+	endpoint string
+}
+
+func (t *tracedSession) AdvertisedReferences() (*packp.AdvRefs, error) {
+	return t.AdvertisedReferencesContext(t.ctx)
+}
+
+func (t *tracedSession) AdvertisedReferencesContext(ctx context.Context) (*packp.AdvRefs, error) {
+	var ret *packp.AdvRefs
+	err := t.client.span(ctx, "AdvertisedReferences", t.endpoint, func(ctx context.Context) error {
+		var err error
+		ret, err = t.session.AdvertisedReferencesContext(ctx)
+		return err
+	})
+	return ret, err
+}
+
+type tracedUploadPackSession struct {
+	transport.UploadPackSession
+	tracedSession
+}
+
+var _ transport.UploadPackSession = &tracedUploadPackSession{}
+
+func (t *tracedUploadPackSession) AdvertisedReferences() (*packp.AdvRefs, error) {
+	return t.tracedSession.AdvertisedReferences()
+}
+
+func (t *tracedUploadPackSession) AdvertisedReferencesContext(ctx context.Context) (*packp.AdvRefs, error) {
+	return t.tracedSession.AdvertisedReferencesContext(ctx)
+}
+
+// UploadPack is the fetch itself: the spans of a slow refresh are mostly this one
+func (t *tracedUploadPackSession) UploadPack(ctx context.Context, req *packp.UploadPackRequest) (*packp.UploadPackResponse, error) {
+	var ret *packp.UploadPackResponse
+	err := t.client.span(ctx, "UploadPack", t.endpoint, func(ctx context.Context) error {
+		var err error
+		ret, err = t.UploadPackSession.UploadPack(ctx, req)
+		return err
+	})
+	return ret, err
+}
+
+type tracedReceivePackSession struct {
+	transport.ReceivePackSession
+	tracedSession
+}
+
+var _ transport.ReceivePackSession = &tracedReceivePackSession{}
+
+func (t *tracedReceivePackSession) AdvertisedReferences() (*packp.AdvRefs, error) {
+	return t.tracedSession.AdvertisedReferences()
+}
+
+func (t *tracedReceivePackSession) AdvertisedReferencesContext(ctx context.Context) (*packp.AdvRefs, error) {
+	return t.tracedSession.AdvertisedReferencesContext(ctx)
+}
+
+func (t *tracedReceivePackSession) ReceivePack(ctx context.Context, req *packp.ReferenceUpdateRequest) (*packp.ReportStatus, error) {
+	var ret *packp.ReportStatus
+	err := t.client.span(ctx, "ReceivePack", t.endpoint, func(ctx context.Context) error {
+		var err error
+		ret, err = t.ReceivePackSession.ReceivePack(ctx, req)
+		return err
+	})
 	return ret, err
 }
 
@@ -441,6 +1080,18 @@ func (c *ContextCurriedSSHAuth) Unwrap() transport.AuthMethod {
 // https://github.com/go-git/go-git/issues/185
 var _ ssh.AuthMethod = &ContextCurriedSSHAuth{}
 
+// ContextCurriedHTTPAuth keeps basic and token auth usable by the http transport, which rejects other auth methods
+type ContextCurriedHTTPAuth struct {
+	ContextCurried
+	githttp.AuthMethod
+}
+
+func (c *ContextCurriedHTTPAuth) Unwrap() transport.AuthMethod {
+	return c.AuthMethod
+}
+
+var _ githttp.AuthMethod = &ContextCurriedHTTPAuth{}
+
 func attachContextToAuth(ctx context.Context, auth transport.AuthMethod) transport.AuthMethod {
 	if sshAuth, ok := auth.(ssh.AuthMethod); ok {
 		return &ContextCurriedSSHAuth{
@@ -450,6 +1101,14 @@ func attachContextToAuth(ctx context.Context, auth transport.AuthMethod) transpo
 			},
 		}
 	}
+	if httpAuth, ok := auth.(githttp.AuthMethod); ok {
+		return &ContextCurriedHTTPAuth{
+			AuthMethod: httpAuth,
+			ContextCurried: ContextCurried{
+				ctx: ctx,
+			},
+		}
+	}
 	return &ContextCurriedAuth{
 		ContextCurried: ContextCurried{
 			ctx: ctx,
@@ -0,0 +1,62 @@
+package goget
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/cresta/gitdb/internal/gitdb/tracing"
+	"github.com/cresta/gitdb/internal/testhelp"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/require"
+)
+
+// cancelAfterRead cancels a context once the first read of r returns
+type cancelAfterRead struct {
+	r      io.Reader
+	cancel context.CancelFunc
+}
+
+func (c *cancelAfterRead) Read(p []byte) (int, error) {
+	defer c.cancel()
+	return c.r.Read(p[:1])
+}
+
+func TestContextReader(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var out bytes.Buffer
+	n, err := io.Copy(&out, &contextReader{ctx: ctx, r: &cancelAfterRead{r: strings.NewReader("hello"), cancel: cancel}})
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, int64(1), n)
+	require.Equal(t, "h", out.String())
+}
+
+func TestGitCheckout_cancelledReads(t *testing.T) {
+	g := &GitOperator{Log: testhelp.ZapTestingLogger(t), Tracer: tracing.Noop{}}
+	repo, _ := newTestRepo(t)
+	co, err := g.newCheckout(repo, "", "git@example.com:org/repo.git", nil)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = co.GetFile(ctx, "master", "a.txt")
+	require.ErrorIs(t, err, context.Canceled)
+	_, err = co.WalkFiles(ctx, "master", func(f *object.File) error {
+		t.Errorf("walked %s after the context ended", f.Name)
+		return nil
+	})
+	require.ErrorIs(t, err, context.Canceled)
+	var zipped bytes.Buffer
+	_, err = co.ZipContents(ctx, &zipped, []ZipPrefix{{Prefix: ""}}, "master")
+	require.ErrorIs(t, err, context.Canceled)
+
+	// Still readable by requests that have not ended
+	f, err := co.GetFile(context.Background(), "master", "a.txt")
+	require.NoError(t, err)
+	var out bytes.Buffer
+	_, err = f.WriteTo(&out)
+	require.NoError(t, err)
+	require.Equal(t, "hello\n", out.String())
+}
@@ -0,0 +1,139 @@
+package goget
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"expvar"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"go.uber.org/zap"
+)
+
+// GzipCacheStats counts gzip cache hits, misses and evictions
+var GzipCacheStats = expvar.NewMap("gitdb_gzip_cache")
+
+// Text smaller than this is served uncompressed, since gzip's framing would save little or nothing
+const minGzipBytes = 512
+
+// How many paths a checkout remembers serving compressed, to compress again after each refresh
+const maxHotPaths = 256
+
+// GzipCache is an LRU of gzip compressed text files keyed by blob, bounded by the compressed bytes it holds.  One cache
+// is shared by every checkout.
+type GzipCache struct {
+	mu  sync.Mutex
+	lru *sizedLRU[plumbing.Hash, []byte]
+}
+
+// NewGzipCache returns a cache holding up to maxBytes of compressed content, or nil (which disables serving
+// compressed files) if maxBytes is not positive
+func NewGzipCache(maxBytes int64) *GzipCache {
+	if maxBytes <= 0 {
+		return nil
+	}
+	return &GzipCache{
+		lru: newSizedLRU[plumbing.Hash, []byte](maxBytes),
+	}
+}
+
+// Bytes is how much compressed content the cache holds
+func (c *GzipCache) Bytes() int64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.bytes
+}
+
+// compressed returns the cached compression of blob, compressing content if it is not cached.  hit is true if it was
+// cached.
+func (c *GzipCache) compressed(blob plumbing.Hash, content []byte) (data []byte, hit bool, err error) {
+	c.mu.Lock()
+	data, exists := c.lru.get(blob)
+	c.mu.Unlock()
+	if exists {
+		GzipCacheStats.Add("hits", 1)
+		return data, true, nil
+	}
+	GzipCacheStats.Add("misses", 1)
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(content); err != nil {
+		return nil, false, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, false, err
+	}
+	data = buf.Bytes()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if evicted := c.lru.add(blob, data, int64(len(data))); evicted > 0 {
+		GzipCacheStats.Add("evictions", int64(evicted))
+	}
+	return data, false, nil
+}
+
+// compressible is true for text large enough to be worth compressing
+func compressible(content []byte) bool {
+	return len(content) >= minGzipBytes && strings.HasPrefix(http.DetectContentType(content), "text/")
+}
+
+type hotPath struct {
+	branch string
+	path   string
+}
+
+// Gzipped returns content, read from path on branch with info, gzip compressed.  The compression is cached by blob, and
+// the path is compressed again after each refresh that changes it.  It is not ok if there is no GzipCache or content is
+// not worth compressing.
+func (g *GitCheckout) Gzipped(branch string, path string, info FileInfo, content []byte) ([]byte, bool) {
+	if g.gzipCache == nil || !compressible(content) {
+		return nil, false
+	}
+	g.hotMu.Lock()
+	if len(g.hotPaths) < maxHotPaths {
+		if g.hotPaths == nil {
+			g.hotPaths = make(map[hotPath]struct{})
+		}
+		g.hotPaths[hotPath{branch: branch, path: path}] = struct{}{}
+	}
+	g.hotMu.Unlock()
+	data, hit, err := g.gzipCache.compressed(plumbing.NewHash(info.BlobHash), content)
+	observeCache(g.metrics, "gzip", hit)
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// precompressNoLock compresses the current version of every path served compressed, so the first request after a
+// refresh does not pay for it.  Failures are only logged, since files are compressed on request too.  Must hold g.mu.
+func (g *GitCheckout) precompressNoLock(ctx context.Context) {
+	if g.gzipCache == nil {
+		return
+	}
+	g.hotMu.Lock()
+	hot := make([]hotPath, 0, len(g.hotPaths))
+	for p := range g.hotPaths {
+		hot = append(hot, p)
+	}
+	g.hotMu.Unlock()
+	for _, p := range hot {
+		buf, info, err := g.getFileNoLock(ctx, p.branch, p.path)
+		if err != nil {
+			g.log.Debug(ctx, "unable to read hot file", zap.String("branch", p.branch), zap.String("path", p.path), zap.Error(err))
+			continue
+		}
+		if !compressible(buf.Bytes()) {
+			continue
+		}
+		if _, _, err := g.gzipCache.compressed(plumbing.NewHash(info.BlobHash), buf.Bytes()); err != nil {
+			g.log.Warn(ctx, "unable to precompress file", zap.String("branch", p.branch), zap.String("path", p.path), zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package goget
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/cresta/gitdb/internal/gitdb/tracing"
+	"github.com/cresta/gitdb/internal/testhelp"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitCheckout_Gzipped(t *testing.T) {
+	g := &GitOperator{Log: testhelp.ZapTestingLogger(t), Tracer: tracing.Noop{}, GzipCache: NewGzipCache(1 << 20)}
+	repo, _ := newTestRepo(t)
+	co, err := g.newCheckout(repo, "", "git@example.com:org/repo.git", nil)
+	require.NoError(t, err)
+	info := FileInfo{BlobHash: plumbing.NewHash("2222222222222222222222222222222222222222").String()}
+
+	_, ok := co.Gzipped("master", "a.txt", info, []byte("hello\n"))
+	require.False(t, ok)
+	_, ok = co.Gzipped("master", "a.bin", info, bytes.Repeat([]byte{0, 1, 2}, 1000))
+	require.False(t, ok)
+
+	content := []byte(strings.Repeat("key: value\n", 100))
+	gz, ok := co.Gzipped("master", "a.yaml", info, content)
+	require.True(t, ok)
+	r, err := gzip.NewReader(bytes.NewReader(gz))
+	require.NoError(t, err)
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, content, got)
+	require.Equal(t, int64(len(gz)), g.GzipCache.Bytes())
+	// Served from the cache by blob, without compressing again
+	misses := GzipCacheStats.Get("misses").String()
+	again, ok := co.Gzipped("master", "a.yaml", info, content)
+	require.True(t, ok)
+	require.Equal(t, gz, again)
+	require.Equal(t, misses, GzipCacheStats.Get("misses").String())
+
+	disabled, err := (&GitOperator{Log: g.Log, Tracer: tracing.Noop{}}).newCheckout(repo, "", "git@example.com:org/repo.git", nil)
+	require.NoError(t, err)
+	_, ok = disabled.Gzipped("master", "a.yaml", info, content)
+	require.False(t, ok)
+	require.Nil(t, NewGzipCache(0))
+}
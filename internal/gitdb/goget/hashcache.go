@@ -0,0 +1,57 @@
+package goget
+
+import "sync"
+
+// HashCache is an LRU of content keyed by the hash of the git object it was read from, like a blob's content or a
+// tree's listing, bounded by the bytes of content it holds.  Objects never change, so entries never go stale.
+type HashCache struct {
+	mu  sync.Mutex
+	lru *sizedLRU[string, HashCacheEntry]
+}
+
+// HashCacheEntry is cached content and the Content-Type it is served as
+type HashCacheEntry struct {
+	Data        []byte
+	ContentType string
+}
+
+// NewHashCache returns a cache holding up to maxBytes of content, or nil (which caches nothing) if maxBytes is not
+// positive
+func NewHashCache(maxBytes int64) *HashCache {
+	if maxBytes <= 0 {
+		return nil
+	}
+	return &HashCache{
+		lru: newSizedLRU[string, HashCacheEntry](maxBytes),
+	}
+}
+
+// Bytes is how much content the cache holds
+func (c *HashCache) Bytes() int64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.bytes
+}
+
+func (c *HashCache) Get(hash string) (HashCacheEntry, bool) {
+	if c == nil {
+		return HashCacheEntry{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.get(hash)
+}
+
+// Add caches entry, whose Data must not be modified afterwards, and returns how many entries were evicted to make room.
+// Content larger than the whole cache is not cached.
+func (c *HashCache) Add(hash string, entry HashCacheEntry) int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.add(hash, entry, int64(len(entry.Data)))
+}
@@ -0,0 +1,28 @@
+package goget
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashCache(t *testing.T) {
+	c := NewHashCache(10)
+	require.Zero(t, c.Add("a", HashCacheEntry{Data: []byte("12345"), ContentType: "text/plain"}))
+	require.Zero(t, c.Add("b", HashCacheEntry{Data: []byte("12345")}))
+	entry, ok := c.Get("a")
+	require.True(t, ok)
+	require.Equal(t, "12345", string(entry.Data))
+	require.Equal(t, "text/plain", entry.ContentType)
+	// b is now least recently used
+	require.Equal(t, 1, c.Add("c", HashCacheEntry{Data: []byte("123")}))
+	_, ok = c.Get("b")
+	require.False(t, ok)
+	require.Equal(t, int64(8), c.Bytes())
+
+	var disabled *HashCache
+	require.Zero(t, disabled.Add("a", HashCacheEntry{Data: []byte("1")}))
+	_, ok = disabled.Get("a")
+	require.False(t, ok)
+	require.Nil(t, NewHashCache(0))
+}
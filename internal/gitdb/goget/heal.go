@@ -0,0 +1,115 @@
+package goget
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"os"
+
+	"github.com/cresta/gitdb/internal/gitdb/tracing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"go.uber.org/zap"
+)
+
+// Heals counts checkouts replaced by a fresh clone, keyed by remote URL
+var Heals = expvar.NewMap("gitdb_heals")
+
+// How many reads in a row must fail with corruption, even after retries, before a checkout heals itself
+const healAfterCorruptReads = 5
+
+// ErrHealing is returned by Heal while the checkout is already being re-cloned
+var ErrHealing = errors.New("checkout is already healing")
+
+// Recloner makes a fresh clone of a checkout's repository with the given credentials
+type Recloner func(ctx context.Context, auth transport.AuthMethod) (*GitCheckout, error)
+
+// SetRecloner enables healing.  Without one, corrupt checkouts keep failing reads until restarted.
+func (g *GitCheckout) SetRecloner(r Recloner) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.recloner = r
+}
+
+// Healing is true while the checkout is quarantined: it is being re-cloned and is not fetched into
+func (g *GitCheckout) Healing() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.healing
+}
+
+// Heal re-clones the repository and swaps the fresh clone in, once it is complete, in place of the current storage.
+// The old directory is kept with a .quarantine suffix for inspection, until stale directories are removed.
+func (g *GitCheckout) Heal(ctx context.Context) error {
+	g.mu.Lock()
+	if g.healing {
+		g.mu.Unlock()
+		return ErrHealing
+	}
+	g.healing = true
+	g.mu.Unlock()
+	return g.heal(ctx)
+}
+
+// noteRead tracks reads failing with corruption, and heals the checkout in the background once too many fail in a
+// row.  Must hold g.mu.
+func (g *GitCheckout) noteRead(ctx context.Context, err error) {
+	if err == nil || !retryableReadError(err) {
+		g.corruptReads = 0
+		return
+	}
+	g.corruptReads++
+	if g.corruptReads < healAfterCorruptReads || g.healing || g.recloner == nil {
+		return
+	}
+	g.log.Error(ctx, "checkout looks corrupt, quarantining and re-cloning", zap.Int("failed_reads", g.corruptReads), zap.Error(err))
+	g.healing = true
+	go func() {
+		ctx := context.Background()
+		g.log.IfErr(g.heal(ctx)).Error(ctx, "unable to heal checkout")
+	}()
+}
+
+// heal does the work of Heal.  g.healing must already be set.
+func (g *GitCheckout) heal(ctx context.Context) error {
+	defer func() {
+		g.mu.Lock()
+		g.healing = false
+		g.mu.Unlock()
+	}()
+	return g.tracing.StartSpanFromContext(ctx, tracing.SpanConfig{OperationName: "heal"}, func(ctx context.Context) error {
+		g.tracing.AttachTag(ctx, "git.remote_url", g.remoteURL)
+		g.mu.Lock()
+		recloner := g.recloner
+		auth := g.auth
+		g.mu.Unlock()
+		if recloner == nil {
+			return fmt.Errorf("unable to heal %s: no recloner set", g.remoteURL)
+		}
+		fresh, err := recloner(ctx, auth)
+		if err != nil {
+			return fmt.Errorf("unable to re-clone: %w", err)
+		}
+		g.mu.Lock()
+		oldPath := g.absPath
+		g.repo = fresh.repo
+		g.absPath = fresh.absPath
+		g.corruptReads = 0
+		// The work tree reads its objects from the checkout, so is checked out again from the fresh clone
+		var workTreeErr error
+		if g.workTree != nil {
+			g.workTree.commit = ""
+			workTreeErr = g.syncWorkTree(ctx)
+		}
+		g.mu.Unlock()
+		g.log.IfErr(workTreeErr).Warn(ctx, "unable to check out work tree from fresh clone")
+		// The cache is read without holding g.mu, so is emptied rather than replaced
+		g.cache.Purge()
+		Heals.Add(g.remoteURL, 1)
+		g.log.Info(ctx, "swapped in fresh clone", zap.String("into", fresh.absPath))
+		if oldPath != "" {
+			g.log.IfErr(os.Rename(oldPath, oldPath+".quarantine")).Warn(ctx, "unable to quarantine old clone", zap.String("path", oldPath))
+		}
+		return nil
+	})
+}
@@ -0,0 +1,75 @@
+package goget
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cresta/gitdb/internal/gitdb/tracing"
+	"github.com/cresta/gitdb/internal/testhelp"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitCheckout_HealsCorruptStorage(t *testing.T) {
+	ctx := context.Background()
+	g := &GitOperator{Log: testhelp.ZapTestingLogger(t), Tracer: tracing.Noop{}}
+	good, commit := newTestRepo(t)
+	// Refs without their objects, as if packs were lost
+	corrupt, err := git.Init(memory.NewStorage(), nil)
+	require.NoError(t, err)
+	require.NoError(t, corrupt.Storer.SetReference(plumbing.NewHashReference(plumbing.NewRemoteReferenceName("origin", "master"), commit)))
+	co, err := g.newCheckout(corrupt, "", "git@example.com:org/repo.git", nil)
+	require.NoError(t, err)
+	co.SetRecloner(func(ctx context.Context, auth transport.AuthMethod) (*GitCheckout, error) {
+		return g.newCheckout(good, "", "git@example.com:org/repo.git", auth)
+	})
+
+	for i := 0; i < healAfterCorruptReads; i++ {
+		_, err := co.GetFile(ctx, "master", "a.txt")
+		require.Error(t, err)
+	}
+	require.Eventually(t, func() bool {
+		content, err := co.GetFile(ctx, "master", "a.txt")
+		if err != nil {
+			return false
+		}
+		var b bytes.Buffer
+		_, err = content.WriteTo(&b)
+		return err == nil && b.String() == "hello\n"
+	}, time.Second*5, time.Millisecond*10)
+	require.False(t, co.Healing())
+	require.NoError(t, co.Heal(ctx))
+}
+
+func TestGitCheckout_HealWorkTree(t *testing.T) {
+	ctx := context.Background()
+	g := &GitOperator{Log: testhelp.ZapTestingLogger(t), Tracer: tracing.Noop{}}
+	upstream := newBareUpstream(t)
+	oldPath := filepath.Join(t.TempDir(), "old")
+	co, err := g.Clone(ctx, oldPath, upstream, nil)
+	require.NoError(t, err)
+	co.SetRecloner(func(ctx context.Context, auth transport.AuthMethod) (*GitCheckout, error) {
+		return g.Clone(ctx, filepath.Join(t.TempDir(), "fresh"), upstream, auth)
+	})
+	dir := t.TempDir()
+	require.NoError(t, co.EnableWorkTree(ctx, dir, "master"))
+	require.NoError(t, os.Remove(filepath.Join(dir, "a.txt")))
+
+	require.NoError(t, co.Heal(ctx))
+	_, err = os.Stat(oldPath + ".quarantine")
+	require.NoError(t, err)
+	// Checked out again from the fresh clone
+	content, err := os.ReadFile(filepath.Join(dir, "a.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "hello\n", string(content))
+	info, exists := co.WorkTree()
+	require.True(t, exists)
+	require.Len(t, info.Commit, 40)
+}
@@ -0,0 +1,115 @@
+package goget
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// What caused a refresh, recorded with the branches it moved
+const (
+	TriggerPoll        = "poll"
+	TriggerWebhook     = "webhook"
+	TriggerManual      = "manual"
+	TriggerBootstrap   = "bootstrap"
+	TriggerRevalidate  = "revalidate"
+	TriggerFetchOnMiss = "fetch_on_miss"
+	TriggerSimulate    = "simulate"
+	TriggerWrite       = "write"
+	TriggerUnknown     = "unknown"
+)
+
+type triggerVal string
+
+var triggerKey = triggerVal("trigger")
+
+// WithTrigger tags refreshes made with ctx as caused by trigger, like TriggerWebhook
+func WithTrigger(ctx context.Context, trigger string) context.Context {
+	return context.WithValue(ctx, triggerKey, trigger)
+}
+
+func triggerOf(ctx context.Context) string {
+	if ret, ok := ctx.Value(triggerKey).(string); ok {
+		return ret
+	}
+	return TriggerUnknown
+}
+
+// RefUpdate is a branch of a checkout moving to a new commit, which is served from Time on
+type RefUpdate struct {
+	// Remote URL of the checkout
+	Repo   string
+	Branch string
+	// Empty if the branch is new
+	From    string
+	To      string
+	Trigger string
+	Time    time.Time
+}
+
+// RefJournal remembers the most recent branch updates of every checkout, so an operator can tell when a commit started
+// being served.  It is kept in memory, so it starts empty on restart.
+type RefJournal struct {
+	mu      sync.Mutex
+	entries []RefUpdate
+	// Index the next update is written to, once entries is full
+	next int
+}
+
+// NewRefJournal keeps the last size updates.  It returns nil, which records nothing, if size is not positive.
+func NewRefJournal(size int) *RefJournal {
+	if size <= 0 {
+		return nil
+	}
+	return &RefJournal{
+		entries: make([]RefUpdate, 0, size),
+	}
+}
+
+func (j *RefJournal) record(u RefUpdate) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if len(j.entries) < cap(j.entries) {
+		j.entries = append(j.entries, u)
+		return
+	}
+	j.entries[j.next] = u
+	j.next = (j.next + 1) % len(j.entries)
+}
+
+// RefJournalFilter picks updates out of a RefJournal.  Empty fields match every update.
+type RefJournalFilter struct {
+	// Remote URL of the checkout
+	Repo   string
+	Branch string
+	// Prefix of the commit a branch moved to
+	Commit string
+}
+
+func (f RefJournalFilter) matches(u RefUpdate) bool {
+	return (f.Repo == "" || f.Repo == u.Repo) &&
+		(f.Branch == "" || f.Branch == u.Branch) &&
+		(f.Commit == "" || strings.HasPrefix(u.To, f.Commit))
+}
+
+// Entries returns the remembered updates that match f, newest first
+func (j *RefJournal) Entries(f RefJournalFilter) []RefUpdate {
+	ret := make([]RefUpdate, 0)
+	if j == nil {
+		return ret
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for i := range j.entries {
+		// Walks back from the newest update, which is just before next
+		u := j.entries[(j.next-1-i+len(j.entries))%len(j.entries)]
+		if f.matches(u) {
+			ret = append(ret, u)
+		}
+	}
+	return ret
+}
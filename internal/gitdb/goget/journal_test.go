@@ -0,0 +1,51 @@
+package goget
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cresta/gitdb/internal/gitdb/tracing"
+	"github.com/cresta/gitdb/internal/testhelp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefJournal(t *testing.T) {
+	var nilJournal *RefJournal
+	nilJournal.record(RefUpdate{Branch: "master"})
+	require.Empty(t, nilJournal.Entries(RefJournalFilter{}))
+	require.Nil(t, NewRefJournal(0))
+
+	j := NewRefJournal(3)
+	for _, to := range []string{"aaa", "bbb", "ccc", "ddd"} {
+		j.record(RefUpdate{Repo: "repo", Branch: "master", To: to})
+	}
+	j.record(RefUpdate{Repo: "other", Branch: "main", To: "eee"})
+	var got []string
+	for _, u := range j.Entries(RefJournalFilter{}) {
+		got = append(got, u.To)
+	}
+	require.Equal(t, []string{"eee", "ddd", "ccc"}, got)
+	require.Len(t, j.Entries(RefJournalFilter{Repo: "repo"}), 2)
+	require.Len(t, j.Entries(RefJournalFilter{Branch: "main"}), 1)
+	require.Len(t, j.Entries(RefJournalFilter{Commit: "dd"}), 1)
+	require.Empty(t, j.Entries(RefJournalFilter{Commit: "bbb"}))
+}
+
+func TestGitCheckout_journal(t *testing.T) {
+	ctx := context.Background()
+	j := NewRefJournal(10)
+	g := &GitOperator{Log: testhelp.ZapTestingLogger(t), Tracer: tracing.Noop{}, RefJournal: j}
+	repo, first := newTestRepo(t)
+	co, err := g.newCheckout(repo, "", "git@example.com:org/repo.git", nil)
+	require.NoError(t, err)
+	content := "bye\n"
+	change, err := co.Simulate(ctx, "master", "change a", map[string]*string{"a.txt": &content})
+	require.NoError(t, err)
+	entries := j.Entries(RefJournalFilter{Commit: change.To})
+	require.Len(t, entries, 1)
+	require.Equal(t, "git@example.com:org/repo.git", entries[0].Repo)
+	require.Equal(t, "master", entries[0].Branch)
+	require.Equal(t, first.String(), entries[0].From)
+	require.Equal(t, TriggerSimulate, entries[0].Trigger)
+	require.Equal(t, change.Time, entries[0].Time)
+}
@@ -0,0 +1,84 @@
+package goget
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// LastModified is when each entry of dir was last changed on branch, keyed by name.  It follows first parents back from
+// the head once for the whole directory, so merged branches count from their merge commit.  Entries unchanged since
+// the oldest commit reachable, like the root or the edge of a shallow clone, get its time.
+func (g *GitCheckout) LastModified(ctx context.Context, dir string, branch string) (map[string]time.Time, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	r, err := g.resolveRef(ctx, branch)
+	if err != nil {
+		return nil, err
+	}
+	c, err := g.repo.CommitObject(r.Hash())
+	if err != nil {
+		return nil, fmt.Errorf("unable to make commit object for hash %s: %w", r.Hash(), err)
+	}
+	t, err := g.dirTree(r, dir)
+	if err != nil {
+		return nil, err
+	}
+	// Entries not yet known to change, and their hash at the head
+	remaining := make(map[string]plumbing.Hash, len(t.Entries))
+	for _, e := range t.Entries {
+		remaining[e.Name] = e.Hash
+	}
+	ret := make(map[string]time.Time, len(t.Entries))
+	for len(remaining) > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		parent, err := c.Parent(0)
+		if errors.Is(err, object.ErrParentNotFound) || errors.Is(err, plumbing.ErrObjectNotFound) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to find parent of %s: %w", c.Hash, err)
+		}
+		before := make(map[string]plumbing.Hash)
+		if pt, err := commitDirTree(parent, dir); err == nil {
+			for _, e := range pt.Entries {
+				before[e.Name] = e.Hash
+			}
+		} else if !errors.Is(err, object.ErrDirectoryNotFound) {
+			return nil, err
+		}
+		for name, hash := range remaining {
+			if before[name] != hash {
+				ret[name] = c.Committer.When
+				delete(remaining, name)
+			}
+		}
+		c = parent
+	}
+	for name := range remaining {
+		ret[name] = c.Committer.When
+	}
+	return ret, nil
+}
+
+// commitDirTree is dirTree for a commit already read
+func commitDirTree(c *object.Commit, dir string) (*object.Tree, error) {
+	t, err := c.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("unable to make tree object for hash %s: %w", c.Hash, err)
+	}
+	if dir == "" {
+		return t, nil
+	}
+	te, err := t.Tree(dir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find entry named %s: %w", dir, err)
+	}
+	return te, nil
+}
@@ -0,0 +1,58 @@
+package goget
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cresta/gitdb/internal/gitdb/tracing"
+	"github.com/cresta/gitdb/internal/testhelp"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitCheckout_LastModified(t *testing.T) {
+	g := &GitOperator{Log: testhelp.ZapTestingLogger(t), Tracer: tracing.Noop{}}
+	repo, first := newTestRepo(t)
+	firstCommit, err := repo.CommitObject(first)
+	require.NoError(t, err)
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+	f, err := wt.Filesystem.Create("dir/b.txt")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("bb\n"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	_, err = wt.Add("dir/b.txt")
+	require.NoError(t, err)
+	when := time.Now().Add(time.Hour)
+	second, err := wt.Commit("second", &git.CommitOptions{Author: &object.Signature{Name: "test", Email: "test@example.com", When: when}})
+	require.NoError(t, err)
+	require.NoError(t, repo.Storer.SetReference(plumbing.NewHashReference(plumbing.NewRemoteReferenceName("origin", "master"), second)))
+	co, err := g.newCheckout(repo, "", "git@example.com:org/repo.git", nil)
+	require.NoError(t, err)
+
+	modified, err := co.LastModified(context.Background(), "", "master")
+	require.NoError(t, err)
+	require.Len(t, modified, 2)
+	require.Equal(t, firstCommit.Committer.When.Unix(), modified["a.txt"].Unix())
+	require.Equal(t, when.Unix(), modified["dir"].Unix())
+
+	modified, err = co.LastModified(context.Background(), "dir", "master")
+	require.NoError(t, err)
+	require.Equal(t, when.Unix(), modified["b.txt"].Unix())
+
+	stat, err := co.LsDir(context.Background(), "", "master")
+	require.NoError(t, err)
+	require.Len(t, stat, 2)
+	require.Equal(t, "a.txt", stat[0].Name)
+	require.Equal(t, int64(len("hello\n")), stat[0].Size)
+	require.Equal(t, uint32(filemode.Dir), stat[1].Mode)
+	require.Zero(t, stat[1].Size)
+
+	_, err = co.LastModified(context.Background(), "", "nope")
+	require.ErrorIs(t, err, ErrUnknownBranch)
+}
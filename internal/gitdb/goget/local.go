@@ -0,0 +1,58 @@
+package goget
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/cresta/gitdb/internal/gitdb/tracing"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// OpenLocal serves the git repository at dir, like a developer's working copy, in place rather than cloning it.  Its
+// local branches are the branches served, read from disk as they are committed, so refreshes have nothing to fetch.
+// Uncommitted changes are not served.
+func (g *GitOperator) OpenLocal(ctx context.Context, dir string) (*GitCheckout, error) {
+	var ret *GitCheckout
+	err := g.Tracer.StartSpanFromContext(ctx, tracing.SpanConfig{OperationName: "open_local"}, func(ctx context.Context) error {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return fmt.Errorf("unable to find absolute path of %s: %w", dir, err)
+		}
+		repo, err := git.PlainOpen(abs)
+		if err != nil {
+			return fmt.Errorf("unable to open git repository at %s: %w", abs, err)
+		}
+		ret, err = g.newCheckout(repo, abs, abs, nil)
+		if err != nil {
+			return err
+		}
+		ret.local = true
+		ret.localHeads, err = ret.remoteHeads()
+		return err
+	})
+	return ret, err
+}
+
+// branchRefName is where branch lives: a local branch for OpenLocal checkouts, otherwise origin's branch
+func (g *GitCheckout) branchRefName(branch string) plumbing.ReferenceName {
+	if g.local {
+		return plumbing.NewBranchReferenceName(branch)
+	}
+	return plumbing.NewRemoteReferenceName("origin", branch)
+}
+
+// refreshLocal picks up commits made to an OpenLocal checkout since the last refresh.  Must hold g.mu.
+func (g *GitCheckout) refreshLocal(ctx context.Context) error {
+	before := g.localHeads
+	heads, err := g.remoteHeads()
+	if err != nil {
+		return err
+	}
+	g.localHeads = heads
+	// Files are cached by branch, which moves without a fetch here
+	g.cache.Purge()
+	g.markRefreshed()
+	return g.recordChanges(ctx, before)
+}
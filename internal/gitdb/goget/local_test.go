@@ -0,0 +1,57 @@
+package goget
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cresta/gitdb/internal/gitdb/tracing"
+	"github.com/cresta/gitdb/internal/testhelp"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitOperator_OpenLocal(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	require.NoError(t, err)
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+	commit := func(content string) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte(content), 0o600))
+		_, err := wt.Add("a.txt")
+		require.NoError(t, err)
+		_, err = wt.Commit("update", &git.CommitOptions{Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()}})
+		require.NoError(t, err)
+	}
+	commit("hello\n")
+
+	g := &GitOperator{Log: testhelp.ZapTestingLogger(t), Tracer: tracing.Noop{}}
+	co, err := g.OpenLocal(ctx, dir)
+	require.NoError(t, err)
+	read := func(branch string) string {
+		f, err := co.GetFile(ctx, branch, "a.txt")
+		require.NoError(t, err)
+		var b bytes.Buffer
+		_, err = f.WriteTo(&b)
+		require.NoError(t, err)
+		return b.String()
+	}
+	require.Equal(t, "hello\n", read("master"))
+	require.Equal(t, "hello\n", read(DefaultBranch))
+
+	commit("bye\n")
+	require.NoError(t, co.Refresh(ctx))
+	require.Equal(t, "bye\n", read("master"))
+	change, exists := co.Changes("master")
+	require.True(t, exists)
+	require.Equal(t, []string{"a.txt"}, change.Paths)
+
+	_, err = g.OpenLocal(ctx, t.TempDir())
+	require.ErrorIs(t, err, git.ErrRepositoryNotExists)
+}
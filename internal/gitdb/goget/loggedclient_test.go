@@ -0,0 +1,87 @@
+package goget
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cresta/gitdb/internal/gitdb/tracing"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/file"
+	"github.com/stretchr/testify/require"
+)
+
+type spanParentKey struct{}
+
+type recordedSpan struct {
+	operation string
+	parent    string
+	tags      map[string]interface{}
+}
+
+// recordingTracer remembers every span, and the operation of the span it was started in
+type recordingTracer struct {
+	tracing.Noop
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+func (r *recordingTracer) StartSpanFromContext(ctx context.Context, cfg tracing.SpanConfig, callback func(ctx context.Context) error) error {
+	parent, _ := ctx.Value(spanParentKey{}).(*recordedSpan)
+	span := &recordedSpan{operation: cfg.OperationName, tags: make(map[string]interface{})}
+	if parent != nil {
+		span.parent = parent.operation
+	}
+	r.mu.Lock()
+	r.spans = append(r.spans, span)
+	r.mu.Unlock()
+	return callback(context.WithValue(ctx, spanParentKey{}, span))
+}
+
+func (r *recordingTracer) AttachTag(ctx context.Context, key string, value interface{}) {
+	if span, ok := ctx.Value(spanParentKey{}).(*recordedSpan); ok {
+		r.mu.Lock()
+		span.tags[key] = value
+		r.mu.Unlock()
+	}
+}
+
+func TestLoggedClient_continuesTrace(t *testing.T) {
+	dir := t.TempDir()
+	upstream, err := git.PlainInit(dir, false)
+	require.NoError(t, err)
+	wt, err := upstream.Worktree()
+	require.NoError(t, err)
+	_, err = wt.Commit("first", &git.CommitOptions{Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()}, AllowEmptyCommits: true})
+	require.NoError(t, err)
+
+	rec := &recordingTracer{}
+	l := &LoggedClient{Wrapped: file.DefaultClient, Tracing: rec}
+	endpoint, err := transport.NewEndpoint(dir)
+	require.NoError(t, err)
+	ctx := tracing.WithRoute(context.Background(), "refresh_handler")
+	err = rec.StartSpanFromContext(ctx, tracing.SpanConfig{OperationName: "http.request"}, func(ctx context.Context) error {
+		session, err := l.NewUploadPackSession(endpoint, attachContextToAuth(ctx, nil))
+		if err != nil {
+			return err
+		}
+		defer func() {
+			require.NoError(t, session.Close())
+		}()
+		// Without a context, the session continues the trace it was created in
+		_, err = session.AdvertisedReferences()
+		return err
+	})
+	require.NoError(t, err)
+
+	require.Len(t, rec.spans, 3)
+	for _, span := range rec.spans[1:] {
+		require.Equal(t, "http.request", span.parent)
+		require.Equal(t, "refresh_handler", span.tags["mux.name"])
+	}
+	require.Equal(t, "NewUploadPackSession", rec.spans[1].operation)
+	require.Equal(t, "AdvertisedReferences", rec.spans[2].operation)
+}
@@ -0,0 +1,26 @@
+package goget
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// LsRemote lists the refs of remoteURL, like git ls-remote, without a checkout.  It fails the way a clone would on a
+// name that does not resolve or credentials the remote rejects.
+func LsRemote(ctx context.Context, remoteURL string, auth transport.AuthMethod) ([]*plumbing.Reference, error) {
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: "origin",
+		URLs: []string{remoteURL},
+	})
+	refs, err := remote.ListContext(ctx, &git.ListOptions{Auth: attachContextToAuth(ctx, auth)})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list remote %s: %w", remoteURL, err)
+	}
+	return refs, nil
+}
@@ -0,0 +1,113 @@
+package goget
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MaintenanceWindow is a daily UTC time range, optionally only on some weekdays, during which background refreshes of
+// a checkout are paused
+type MaintenanceWindow struct {
+	// Empty means every day
+	Days []time.Weekday
+	// Offsets from midnight UTC.  End before Start crosses midnight.
+	Start time.Duration
+	End   time.Duration
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// ParseMaintenanceWindow parses a window like "01:00-03:00" or "Sat,Sun 22:00-02:00", in UTC.  A window crossing
+// midnight belongs to the day it starts on.
+func ParseMaintenanceWindow(s string) (MaintenanceWindow, error) {
+	var ret MaintenanceWindow
+	fields := strings.Fields(s)
+	if len(fields) == 0 || len(fields) > 2 {
+		return ret, fmt.Errorf("invalid maintenance window %q, expected like \"Sat,Sun 01:00-03:00\"", s)
+	}
+	if len(fields) == 2 {
+		for _, d := range strings.Split(fields[0], ",") {
+			day, exists := weekdays[strings.ToLower(d)]
+			if !exists {
+				return ret, fmt.Errorf("invalid day %q in maintenance window %q", d, s)
+			}
+			ret.Days = append(ret.Days, day)
+		}
+	}
+	start, end, found := strings.Cut(fields[len(fields)-1], "-")
+	if !found {
+		return ret, fmt.Errorf("invalid time range in maintenance window %q", s)
+	}
+	var err error
+	if ret.Start, err = parseTimeOfDay(start); err != nil {
+		return ret, fmt.Errorf("invalid start of maintenance window %q: %w", s, err)
+	}
+	if ret.End, err = parseTimeOfDay(end); err != nil {
+		return ret, fmt.Errorf("invalid end of maintenance window %q: %w", s, err)
+	}
+	return ret, nil
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Contains is true if now falls in the window
+func (m MaintenanceWindow) Contains(now time.Time) bool {
+	now = now.UTC()
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	offset := now.Sub(midnight)
+	if m.Start <= m.End {
+		return m.onDay(now.Weekday()) && offset >= m.Start && offset < m.End
+	}
+	// Crossing midnight: the evening part is on the window's day, the morning part on the day after
+	if offset >= m.Start {
+		return m.onDay(now.Weekday())
+	}
+	return offset < m.End && m.onDay((now.Weekday()+6)%7)
+}
+
+func (m MaintenanceWindow) onDay(d time.Weekday) bool {
+	if len(m.Days) == 0 {
+		return true
+	}
+	for _, day := range m.Days {
+		if day == d {
+			return true
+		}
+	}
+	return false
+}
+
+// SetMaintenanceWindows sets when background refreshes of the checkout are paused
+func (g *GitCheckout) SetMaintenanceWindows(windows []MaintenanceWindow) {
+	g.maintenanceWindows.Store(&windows)
+}
+
+// InMaintenance is true if now is in one of the checkout's maintenance windows.  Background refreshes skip the
+// checkout then; refreshes asked for by webhooks or the API still run.
+func (g *GitCheckout) InMaintenance(now time.Time) bool {
+	windows := g.maintenanceWindows.Load()
+	if windows == nil {
+		return false
+	}
+	for _, w := range *windows {
+		if w.Contains(now) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,33 @@
+package goget
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaintenanceWindow(t *testing.T) {
+	// A Saturday
+	at := func(day int, hour int, minute int) time.Time {
+		return time.Date(2021, 1, day, hour, minute, 0, 0, time.UTC)
+	}
+	daily, err := ParseMaintenanceWindow("01:00-03:00")
+	require.NoError(t, err)
+	require.True(t, daily.Contains(at(2, 1, 0)))
+	require.True(t, daily.Contains(at(5, 2, 59)))
+	require.False(t, daily.Contains(at(2, 3, 0)))
+	require.False(t, daily.Contains(at(2, 0, 59)))
+
+	weekend, err := ParseMaintenanceWindow("Sat,sun 22:00-02:00")
+	require.NoError(t, err)
+	require.True(t, weekend.Contains(at(2, 23, 0)))
+	require.True(t, weekend.Contains(at(4, 1, 0)), "monday morning is part of sunday's window")
+	require.False(t, weekend.Contains(at(2, 1, 0)), "saturday morning is part of friday's window")
+	require.False(t, weekend.Contains(at(4, 23, 0)))
+
+	for _, bad := range []string{"", "01:00", "Someday 01:00-02:00", "25:00-26:00", "Sat 01:00-02:00 extra"} {
+		_, err := ParseMaintenanceWindow(bad)
+		require.Error(t, err, bad)
+	}
+}
@@ -0,0 +1,162 @@
+package goget
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// MetadataFile is read from the root of a branch for options the repository sets for itself
+const MetadataFile = ".gitdb.yaml"
+
+// DefaultBranch can be requested as a branch name to read the repository's default branch: default_branch from the
+// .gitdb.yaml of the upstream HEAD branch, or that branch itself
+const DefaultBranch = "HEAD"
+
+// RepoMetadata are the options in a .gitdb.yaml
+type RepoMetadata struct {
+	// Branch served for DefaultBranch.  Only read from the upstream HEAD branch.
+	DefaultBranch string `yaml:"default_branch"`
+	// Files left out of zips, like export-ignore in .gitattributes.  Patterns without a slash, like "*.psd", match the
+	// file name in any directory.  Patterns ending in a slash match everything under a directory.
+	ExportIgnore []string `yaml:"export_ignore"`
+	// Content-Type of files matching a pattern, like "*.yaml": "application/yaml".  The longest matching pattern wins.
+	ContentTypes map[string]string `yaml:"content_types"`
+	// Files of the default branch read into the cache after each refresh
+	Prefetch []string `yaml:"prefetch"`
+}
+
+type metadataCacheKey struct {
+	commit plumbing.Hash
+}
+
+// Metadata returns the .gitdb.yaml at the head of branch, or empty metadata if there is none
+func (g *GitCheckout) Metadata(ctx context.Context, branch string) (RepoMetadata, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	r, err := g.resolveRef(ctx, branch)
+	if err != nil {
+		return RepoMetadata{}, err
+	}
+	return g.metadataNoLock(r.Hash())
+}
+
+// metadataNoLock reads the .gitdb.yaml of a commit, which never changes, so it is cached by commit.  Must hold g.mu.
+func (g *GitCheckout) metadataNoLock(commit plumbing.Hash) (RepoMetadata, error) {
+	key := metadataCacheKey{commit: commit}
+	if item, exists := g.cache.Get(key); exists {
+		if m, ok := item.(RepoMetadata); ok {
+			return m, nil
+		}
+	}
+	co, err := g.repo.CommitObject(commit)
+	if err != nil {
+		return RepoMetadata{}, fmt.Errorf("unable to make commit object for hash %s: %w", commit, err)
+	}
+	var ret RepoMetadata
+	f, err := co.File(MetadataFile)
+	if errors.Is(err, object.ErrFileNotFound) {
+		g.cache.Add(key, ret)
+		return ret, nil
+	}
+	if err != nil {
+		return RepoMetadata{}, fmt.Errorf("unable to fetch file %s: %w", MetadataFile, err)
+	}
+	content, err := f.Contents()
+	if err != nil {
+		return RepoMetadata{}, fmt.Errorf("unable to read file %s: %w", MetadataFile, err)
+	}
+	if err := yaml.Unmarshal([]byte(content), &ret); err != nil {
+		return RepoMetadata{}, fmt.Errorf("unable to parse %s at %s: %w", MetadataFile, commit, err)
+	}
+	g.cache.Add(key, ret)
+	return ret, nil
+}
+
+// defaultBranchNoLock resolves DefaultBranch.  HEAD of the clone points at the upstream HEAD branch.  Must hold g.mu.
+func (g *GitCheckout) defaultBranchNoLock() (string, error) {
+	head, err := g.repo.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return "", fmt.Errorf("unable to read HEAD: %w", err)
+	}
+	name := head.Target().Short()
+	if name == DefaultBranch || name == "" {
+		return "", fmt.Errorf("HEAD is not a branch")
+	}
+	r, err := g.branchRef(name)
+	if err != nil {
+		return "", err
+	}
+	meta, err := g.metadataNoLock(r.Hash())
+	if err != nil {
+		return "", err
+	}
+	if meta.DefaultBranch != "" && meta.DefaultBranch != DefaultBranch {
+		return meta.DefaultBranch, nil
+	}
+	return name, nil
+}
+
+// prefetchNoLock reads the default branch's prefetch files into the cache.  Failures are only logged, since the files
+// are read again on request.  Must hold g.mu.
+func (g *GitCheckout) prefetchNoLock(ctx context.Context) {
+	branch, err := g.defaultBranchNoLock()
+	if err != nil {
+		g.log.Debug(ctx, "no default branch to prefetch", zap.Error(err))
+		return
+	}
+	r, err := g.branchRef(branch)
+	if err != nil {
+		return
+	}
+	meta, err := g.metadataNoLock(r.Hash())
+	if err != nil {
+		g.log.Warn(ctx, "unable to read repository metadata", zap.Error(err))
+		return
+	}
+	for _, p := range meta.Prefetch {
+		if _, _, err := g.getFileNoLock(ctx, branch, strings.TrimPrefix(p, "/")); err != nil {
+			g.log.Warn(ctx, "unable to prefetch file", zap.String("branch", branch), zap.String("path", p), zap.Error(err))
+		}
+	}
+}
+
+func (m RepoMetadata) exportIgnored(file string) bool {
+	for _, p := range m.ExportIgnore {
+		if matchesPattern(p, file) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m RepoMetadata) contentType(file string) string {
+	var best string
+	var ret string
+	for p, ct := range m.ContentTypes {
+		if len(p) > len(best) && matchesPattern(p, file) {
+			best = p
+			ret = ct
+		}
+	}
+	return ret
+}
+
+func matchesPattern(pattern string, file string) bool {
+	pattern = strings.TrimPrefix(pattern, "/")
+	if strings.HasSuffix(pattern, "/") {
+		return strings.HasPrefix(file, pattern)
+	}
+	if !strings.Contains(pattern, "/") {
+		file = path.Base(file)
+	}
+	matched, _ := path.Match(pattern, file)
+	return matched
+}
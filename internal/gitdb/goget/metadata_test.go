@@ -0,0 +1,26 @@
+package goget
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepoMetadata_ExportIgnored(t *testing.T) {
+	m := RepoMetadata{ExportIgnore: []string{"*.psd", "docs/internal/", "/build/*.log"}}
+	require.True(t, m.exportIgnored("art/logo.psd"))
+	require.True(t, m.exportIgnored("docs/internal/a.md"))
+	require.True(t, m.exportIgnored("build/out.log"))
+	require.False(t, m.exportIgnored("build/sub/out.log"))
+	require.False(t, m.exportIgnored("docs/public/a.md"))
+}
+
+func TestRepoMetadata_ContentType(t *testing.T) {
+	m := RepoMetadata{ContentTypes: map[string]string{
+		"*.yaml":              "application/yaml",
+		"config/openapi.yaml": "application/openapi+yaml",
+	}}
+	require.Equal(t, "application/yaml", m.contentType("a/b.yaml"))
+	require.Equal(t, "application/openapi+yaml", m.contentType("config/openapi.yaml"))
+	require.Equal(t, "", m.contentType("a.txt"))
+}
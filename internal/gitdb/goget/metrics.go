@@ -0,0 +1,32 @@
+package goget
+
+import (
+	"time"
+
+	"github.com/cresta/gitdb/internal/gitdb/metrics"
+)
+
+func observeRefresh(m metrics.Metrics, remoteURL string, start time.Time, err error) {
+	tags := metrics.Tags{"repo": remoteURL}
+	m.Observe("gitdb_refresh_duration_seconds", time.Since(start).Seconds(), tags)
+	if err != nil {
+		m.Count("gitdb_refresh_failures_total", 1, tags)
+	}
+}
+
+func observeClone(m metrics.Metrics, remoteURL string, start time.Time, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	m.Observe("gitdb_clone_duration_seconds", time.Since(start).Seconds(), metrics.Tags{"repo": remoteURL, "result": result})
+}
+
+// observeCache counts a lookup in one of the in memory caches: file, blob or gzip
+func observeCache(m metrics.Metrics, cache string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	m.Count("gitdb_cache_requests_total", 1, metrics.Tags{"cache": cache, "result": result})
+}
@@ -0,0 +1,74 @@
+package goget
+
+import (
+	"fmt"
+
+	"github.com/cresta/gitdb/internal/gitdb/metrics"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/storage"
+)
+
+// DefaultObjectCacheBytes is the size of go-git's own object cache, used when a repository does not pick one
+const DefaultObjectCacheBytes = int64(cache.DefaultMaxSize)
+
+// ObjectCacheStorage is a Storage whose storers keep decoded git objects in an in memory cache picked by the caller,
+// instead of go-git's default
+type ObjectCacheStorage interface {
+	Storage
+	NewStorerWithCache(name string, objects cache.Object) (storer storage.Storer, location string, err error)
+}
+
+// ObjectCache is go-git's object LRU, counting hits and misses as gitdb_object_cache_requests_total
+type ObjectCache struct {
+	lru     *cache.ObjectLRU
+	metrics metrics.Metrics
+	repo    string
+}
+
+var _ cache.Object = &ObjectCache{}
+
+// NewObjectCache holds up to maxBytes of objects of repo, which tags its metrics
+func NewObjectCache(maxBytes int64, m metrics.Metrics, repo string) *ObjectCache {
+	return &ObjectCache{
+		lru:     cache.NewObjectLRU(cache.FileSize(maxBytes)),
+		metrics: metrics.OrNoop(m),
+		repo:    repo,
+	}
+}
+
+func (o *ObjectCache) Put(obj plumbing.EncodedObject) {
+	o.lru.Put(obj)
+}
+
+func (o *ObjectCache) Get(k plumbing.Hash) (plumbing.EncodedObject, bool) {
+	obj, hit := o.lru.Get(k)
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	o.metrics.Count("gitdb_object_cache_requests_total", 1, metrics.Tags{"repo": o.repo, "result": result})
+	return obj, hit
+}
+
+func (o *ObjectCache) Clear() {
+	o.lru.Clear()
+}
+
+// WithObjectCache is s with every storer it creates using objects
+func WithObjectCache(s ObjectCacheStorage, objects cache.Object) Storage {
+	return &objectCacheStorage{s: s, objects: objects}
+}
+
+type objectCacheStorage struct {
+	s       ObjectCacheStorage
+	objects cache.Object
+}
+
+func (o *objectCacheStorage) NewStorer(name string) (storage.Storer, string, error) {
+	st, location, err := o.s.NewStorerWithCache(name, o.objects)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to create storage with object cache: %w", err)
+	}
+	return st, location, nil
+}
@@ -0,0 +1,54 @@
+package goget
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/cresta/gitdb/internal/gitdb/metrics"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/stretchr/testify/require"
+)
+
+type countingMetrics struct {
+	metrics.Noop
+	mu     sync.Mutex
+	counts map[string]float64
+}
+
+func (c *countingMetrics) Count(name string, delta float64, tags metrics.Tags) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[name+" "+tags["repo"]+" "+tags["result"]] += delta
+}
+
+func TestObjectCache(t *testing.T) {
+	m := &countingMetrics{counts: make(map[string]float64)}
+	c := NewObjectCache(1<<20, m, "repo")
+	obj := &plumbing.MemoryObject{}
+	obj.SetType(plumbing.BlobObject)
+	_, err := obj.Write([]byte("hello\n"))
+	require.NoError(t, err)
+
+	_, hit := c.Get(obj.Hash())
+	require.False(t, hit)
+	c.Put(obj)
+	got, hit := c.Get(obj.Hash())
+	require.True(t, hit)
+	require.Equal(t, obj.Hash(), got.Hash())
+	c.Clear()
+	_, hit = c.Get(obj.Hash())
+	require.False(t, hit)
+	require.Equal(t, map[string]float64{
+		"gitdb_object_cache_requests_total repo hit":  1,
+		"gitdb_object_cache_requests_total repo miss": 2,
+	}, m.counts)
+}
+
+func TestWithObjectCache(t *testing.T) {
+	s := WithObjectCache(&DiskStorage{Directory: t.TempDir()}, NewObjectCache(1<<20, nil, "repo"))
+	st, location, err := s.NewStorer("repo")
+	require.NoError(t, err)
+	require.NotEmpty(t, location)
+	require.IsType(t, &filesystem.Storage{}, st)
+}
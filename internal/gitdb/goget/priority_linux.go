@@ -0,0 +1,11 @@
+package goget
+
+import "syscall"
+
+// lowerThreadPriority nices the calling thread.  Linux schedules each thread with its own nice value.
+func lowerThreadPriority(niceness int) error {
+	if niceness == 0 {
+		return nil
+	}
+	return syscall.Setpriority(syscall.PRIO_PROCESS, syscall.Gettid(), niceness)
+}
@@ -0,0 +1,23 @@
+package goget
+
+import (
+	"context"
+	"syscall"
+	"testing"
+
+	"github.com/cresta/gitdb/internal/testhelp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefreshPool_niceness(t *testing.T) {
+	p := NewRefreshPool(1, 5, testhelp.ZapTestingLogger(t))
+	defer p.Close()
+	var prio int
+	require.NoError(t, p.Do(context.Background(), func() error {
+		var err error
+		prio, err = syscall.Getpriority(syscall.PRIO_PROCESS, syscall.Gettid())
+		return err
+	}))
+	// The raw syscall returns 20 - nice
+	require.Equal(t, 15, prio)
+}
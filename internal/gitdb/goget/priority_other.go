@@ -0,0 +1,8 @@
+//go:build !linux
+
+package goget
+
+// lowerThreadPriority does nothing: other platforms cannot nice a single thread
+func lowerThreadPriority(int) error {
+	return nil
+}
@@ -0,0 +1,128 @@
+package goget
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CloneProgress is the state of one clone, parsed from git's progress output
+type CloneProgress struct {
+	Repo string
+	// Phase as reported by git, like "Receiving objects"
+	Phase        string
+	Percent      int
+	Objects      int
+	TotalObjects int
+	// Human readable amount received, like "1.20 MiB"
+	Received string
+	Done     bool
+	Error    string `json:",omitempty"`
+	Started  time.Time
+	Finished time.Time `json:",omitempty"`
+}
+
+// CloneTracker records the progress of running and finished clones
+type CloneTracker struct {
+	mu     sync.Mutex
+	clones map[string]*CloneProgress
+}
+
+// Snapshot returns the progress of every clone, sorted by repo
+func (t *CloneTracker) Snapshot() []CloneProgress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ret := make([]CloneProgress, 0, len(t.clones))
+	for _, c := range t.clones {
+		ret = append(ret, *c)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Repo < ret[j].Repo
+	})
+	return ret
+}
+
+func (t *CloneTracker) start(repo string) *progressWriter {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.clones == nil {
+		t.clones = make(map[string]*CloneProgress)
+	}
+	t.clones[repo] = &CloneProgress{
+		Repo:    repo,
+		Started: time.Now(),
+	}
+	return &progressWriter{tracker: t, repo: repo}
+}
+
+func (t *CloneTracker) finish(repo string, err error) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c, exists := t.clones[repo]
+	if !exists {
+		return
+	}
+	c.Done = true
+	c.Finished = time.Now()
+	if err != nil {
+		c.Error = err.Error()
+	}
+}
+
+// Matches progress lines like "Receiving objects:  45% (450/1000), 1.20 MiB | 2.00 MiB/s"
+var progressLine = regexp.MustCompile(`^(?:remote: )?([A-Za-z ]+):\s+(\d+)% \((\d+)/(\d+)\)(?:, ([^|]+))?`)
+
+// progressWriter parses git's sideband progress.  Lines end in \r while a phase is running and \n when it finishes.
+type progressWriter struct {
+	tracker *CloneTracker
+	repo    string
+	partial string
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	data := p.partial + string(b)
+	lines := strings.FieldsFunc(data, func(r rune) bool {
+		return r == '\r' || r == '\n'
+	})
+	p.partial = ""
+	if len(data) > 0 && data[len(data)-1] != '\r' && data[len(data)-1] != '\n' && len(lines) > 0 {
+		p.partial = lines[len(lines)-1]
+		lines = lines[:len(lines)-1]
+	}
+	for _, line := range lines {
+		p.parse(line)
+	}
+	return len(b), nil
+}
+
+func (p *progressWriter) parse(line string) {
+	m := progressLine.FindStringSubmatch(strings.TrimSpace(line))
+	if m == nil {
+		return
+	}
+	percent, _ := strconv.Atoi(m[2])
+	objects, _ := strconv.Atoi(m[3])
+	total, _ := strconv.Atoi(m[4])
+	p.tracker.mu.Lock()
+	defer p.tracker.mu.Unlock()
+	c, exists := p.tracker.clones[p.repo]
+	if !exists {
+		return
+	}
+	c.Phase = m[1]
+	c.Percent = percent
+	c.Objects = objects
+	c.TotalObjects = total
+	if m[5] != "" {
+		c.Received = strings.TrimSpace(m[5])
+	}
+}
@@ -0,0 +1,27 @@
+package goget
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgressWriter(t *testing.T) {
+	tracker := &CloneTracker{}
+	pw := tracker.start("git@github.com:cresta/gitdb-reference.git")
+	_, err := pw.Write([]byte("Counting objects: 100% (10/10), done.\nReceiving objects:  45% (450/1000), 1.20 Mi"))
+	require.NoError(t, err)
+	_, err = pw.Write([]byte("B | 2.00 MiB/s\r"))
+	require.NoError(t, err)
+	s := tracker.Snapshot()
+	require.Len(t, s, 1)
+	require.Equal(t, "Receiving objects", s[0].Phase)
+	require.Equal(t, 45, s[0].Percent)
+	require.Equal(t, 450, s[0].Objects)
+	require.Equal(t, 1000, s[0].TotalObjects)
+	require.Equal(t, "1.20 MiB", s[0].Received)
+	require.False(t, s[0].Done)
+
+	tracker.finish("git@github.com:cresta/gitdb-reference.git", nil)
+	require.True(t, tracker.Snapshot()[0].Done)
+}
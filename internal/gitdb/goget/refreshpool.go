@@ -0,0 +1,86 @@
+package goget
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+
+	"github.com/cresta/gitdb/internal/log"
+	"go.uber.org/zap"
+)
+
+// ErrRefreshPoolClosed is returned for refreshes queued after the pool was closed
+var ErrRefreshPoolClosed = errors.New("refresh pool closed")
+
+// RefreshPool runs refreshes on a few dedicated OS threads with a lower scheduling priority, so bulk fetches do not
+// compete for CPU with reads.  Work a fetch hands to other goroutines, like network reads, keeps normal priority.
+type RefreshPool struct {
+	jobs chan refreshJob
+	stop chan struct{}
+	once sync.Once
+	wg   sync.WaitGroup
+}
+
+type refreshJob struct {
+	fn   func() error
+	done chan error
+}
+
+// NewRefreshPool starts workers that each lower the priority of their thread by niceness, from 0 (unchanged) to 19.
+// Threads are only reniced on Linux.
+func NewRefreshPool(workers int, niceness int, logger *log.Logger) *RefreshPool {
+	ret := &RefreshPool{
+		jobs: make(chan refreshJob),
+		stop: make(chan struct{}),
+	}
+	for i := 0; i < workers; i++ {
+		ret.wg.Add(1)
+		go ret.work(niceness, logger)
+	}
+	return ret
+}
+
+func (p *RefreshPool) work(niceness int, logger *log.Logger) {
+	defer p.wg.Done()
+	// Never unlocked: the thread exits with this goroutine, so its lowered priority never reaches other goroutines
+	runtime.LockOSThread()
+	logger.IfErr(lowerThreadPriority(niceness)).Warn(context.Background(), "unable to lower refresh thread priority", zap.Int("niceness", niceness))
+	for {
+		select {
+		case <-p.stop:
+			return
+		case job := <-p.jobs:
+			job.done <- job.fn()
+		}
+	}
+}
+
+// Do runs fn on a worker once one is free, or gives up when ctx is done first.  A nil pool runs fn on the calling
+// goroutine.
+func (p *RefreshPool) Do(ctx context.Context, fn func() error) error {
+	if p == nil {
+		return fn()
+	}
+	job := refreshJob{fn: fn, done: make(chan error, 1)}
+	select {
+	case p.jobs <- job:
+		return <-job.done
+	case <-p.stop:
+		return ErrRefreshPoolClosed
+	case <-ctx.Done():
+		return fmt.Errorf("unable to find a free refresh worker: %w", ctx.Err())
+	}
+}
+
+// Close stops the workers once their current refreshes finish
+func (p *RefreshPool) Close() {
+	if p == nil {
+		return
+	}
+	p.once.Do(func() {
+		close(p.stop)
+	})
+	p.wg.Wait()
+}
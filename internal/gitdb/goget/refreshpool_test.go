@@ -0,0 +1,40 @@
+package goget
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cresta/gitdb/internal/testhelp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefreshPool(t *testing.T) {
+	ctx := context.Background()
+	var nilPool *RefreshPool
+	require.NoError(t, nilPool.Do(ctx, func() error { return nil }))
+
+	p := NewRefreshPool(1, 0, testhelp.ZapTestingLogger(t))
+	errFailed := errors.New("failed")
+	require.ErrorIs(t, p.Do(ctx, func() error { return errFailed }), errFailed)
+
+	// While the only worker is busy, others wait until their context is done
+	started := make(chan struct{})
+	release := make(chan struct{})
+	go func() {
+		_ = p.Do(ctx, func() error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+	<-started
+	waitCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, p.Do(waitCtx, func() error { return nil }), context.DeadlineExceeded)
+	close(release)
+
+	p.Close()
+	require.ErrorIs(t, p.Do(ctx, func() error { return nil }), ErrRefreshPoolClosed)
+}
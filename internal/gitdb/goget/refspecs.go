@@ -0,0 +1,182 @@
+package goget
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage"
+)
+
+// ParseFetchRefSpecs parses refspecs restricting what is fetched, like "+refs/heads/main" or "refs/tags/*".  A refspec
+// without a destination fetches branches into their origin branch and anything else into the same name.
+func ParseFetchRefSpecs(specs []string) ([]config.RefSpec, error) {
+	ret := make([]config.RefSpec, 0, len(specs))
+	for _, s := range specs {
+		s = strings.TrimSpace(s)
+		if !strings.Contains(s, ":") {
+			src := strings.TrimPrefix(s, "+")
+			dst := src
+			if strings.HasPrefix(src, "refs/heads/") {
+				dst = "refs/remotes/origin/" + strings.TrimPrefix(src, "refs/heads/")
+			}
+			s = s + ":" + dst
+		}
+		spec := config.RefSpec(s)
+		if err := spec.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid refspec %s: %w", s, err)
+		}
+		if spec.IsDelete() {
+			return nil, fmt.Errorf("invalid refspec %s: fetch refspecs need a source", s)
+		}
+		ret = append(ret, spec)
+	}
+	return ret, nil
+}
+
+// CloneOptions restrict what a clone fetches, when cloning and on every refresh
+type CloneOptions struct {
+	// Only fetch these refspecs instead of every branch and tag
+	RefSpecs []config.RefSpec
+	// Without RefSpecs, only fetch the remote's default branch
+	SingleBranch bool
+	// Positive only fetches this many commits of each branch's history.  History past it cannot be read.
+	Depth int
+}
+
+func (o CloneOptions) restricted() bool {
+	return len(o.RefSpecs) > 0 || o.SingleBranch || o.Depth > 0
+}
+
+// singleBranchRefSpec fetches only branch, into its origin branch
+func singleBranchRefSpec(branch plumbing.ReferenceName) config.RefSpec {
+	return config.RefSpec(fmt.Sprintf("+%s:%s", branch, plumbing.NewRemoteReferenceName("origin", branch.Short())))
+}
+
+// CloneStorageRefSpecs is CloneStorage that only fetches refSpecs instead of every branch and tag.  Later refreshes
+// fetch the same refSpecs.  Empty refSpecs clone everything.
+func (g *GitOperator) CloneStorageRefSpecs(ctx context.Context, s Storage, name string, remoteURL string, auth transport.AuthMethod, refSpecs []config.RefSpec) (*GitCheckout, error) {
+	return g.CloneStorageOptions(ctx, s, name, remoteURL, auth, CloneOptions{RefSpecs: refSpecs})
+}
+
+// CloneStorageOptions is CloneStorage that only fetches what opts allow, like a shallow clone of one branch
+func (g *GitOperator) CloneStorageOptions(ctx context.Context, s Storage, name string, remoteURL string, auth transport.AuthMethod, opts CloneOptions) (*GitCheckout, error) {
+	if !opts.restricted() {
+		return g.CloneStorage(ctx, s, name, remoteURL, auth)
+	}
+	storer, location, err := s.NewStorer(name)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create storage: %w", err)
+	}
+	co, err := g.clone(ctx, location, remoteURL, auth, func(ctx context.Context, cloneOpts *git.CloneOptions) (*git.Repository, error) {
+		return cloneRestricted(ctx, storer, cloneOpts, opts)
+	})
+	if err != nil {
+		return nil, err
+	}
+	co.depth = opts.Depth
+	return co, nil
+}
+
+// cloneRestricted clones like git.CloneContext, but with origin set up to fetch only what opts allow.  HEAD follows
+// the remote's HEAD even when the remote's default branch is not fetched.
+func cloneRestricted(ctx context.Context, st storage.Storer, cloneOpts *git.CloneOptions, opts CloneOptions) (*git.Repository, error) {
+	repo, err := git.Init(st, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to init repository: %w", err)
+	}
+	remote, err := repo.CreateRemote(&config.RemoteConfig{
+		Name:  "origin",
+		URLs:  []string{cloneOpts.URL},
+		Fetch: opts.RefSpecs,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create origin remote: %w", err)
+	}
+	refs, err := remote.ListContext(ctx, &git.ListOptions{Auth: cloneOpts.Auth})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list remote: %w", err)
+	}
+	var head *plumbing.Reference
+	for _, r := range refs {
+		if r.Name() == plumbing.HEAD && r.Type() == plumbing.SymbolicReference {
+			head = r
+			if err := st.SetReference(r); err != nil {
+				return nil, fmt.Errorf("unable to set HEAD: %w", err)
+			}
+		}
+	}
+	if opts.SingleBranch && len(opts.RefSpecs) == 0 {
+		if head == nil {
+			return nil, fmt.Errorf("unable to find the remote's default branch to fetch")
+		}
+		if err := setOriginFetch(repo, []config.RefSpec{singleBranchRefSpec(head.Target())}); err != nil {
+			return nil, err
+		}
+	}
+	err = repo.FetchContext(ctx, &git.FetchOptions{
+		Auth:     cloneOpts.Auth,
+		Progress: cloneOpts.Progress,
+		Depth:    opts.Depth,
+	})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return nil, err
+	}
+	return repo, nil
+}
+
+// SetCloneOptions restricts what later refreshes fetch to opts, for a checkout that was not cloned with them, like one
+// cloned from a bundle.  Refs already fetched are kept until pruned.
+func (g *GitCheckout) SetCloneOptions(opts CloneOptions) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.depth = opts.Depth
+	refSpecs := opts.RefSpecs
+	if opts.SingleBranch && len(refSpecs) == 0 {
+		head, err := g.repo.Storer.Reference(plumbing.HEAD)
+		if err != nil {
+			return fmt.Errorf("unable to read HEAD: %w", err)
+		}
+		refSpecs = []config.RefSpec{singleBranchRefSpec(head.Target())}
+	}
+	if len(refSpecs) == 0 {
+		return nil
+	}
+	return setOriginFetch(g.repo, refSpecs)
+}
+
+// setOriginFetch changes what fetching from origin fetches
+func setOriginFetch(repo *git.Repository, refSpecs []config.RefSpec) error {
+	cfg, err := repo.Config()
+	if err != nil {
+		return fmt.Errorf("unable to read config: %w", err)
+	}
+	origin, exists := cfg.Remotes["origin"]
+	if !exists {
+		return fmt.Errorf("unable to find origin remote")
+	}
+	origin.Fetch = refSpecs
+	if err := repo.SetConfig(cfg); err != nil {
+		return fmt.Errorf("unable to update config: %w", err)
+	}
+	return nil
+}
+
+// fetchedByOrigin is true if origin's fetch refspecs include the remote ref name.  Must hold g.mu.
+func (g *GitCheckout) fetchedByOrigin(name plumbing.ReferenceName) bool {
+	remote, err := g.repo.Remote("origin")
+	if err != nil {
+		return true
+	}
+	for _, spec := range remote.Config().Fetch {
+		if spec.Match(name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package goget
+
+import (
+	"testing"
+
+	"github.com/cresta/gitdb/internal/gitdb/tracing"
+	"github.com/cresta/gitdb/internal/testhelp"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFetchRefSpecs(t *testing.T) {
+	specs, err := ParseFetchRefSpecs([]string{"+refs/heads/main", "refs/tags/*", "+refs/heads/release/*:refs/remotes/origin/release/*"})
+	require.NoError(t, err)
+	require.Equal(t, []config.RefSpec{
+		"+refs/heads/main:refs/remotes/origin/main",
+		"refs/tags/*:refs/tags/*",
+		"+refs/heads/release/*:refs/remotes/origin/release/*",
+	}, specs)
+	_, err = ParseFetchRefSpecs([]string{"refs/heads/*:refs/remotes/origin/main"})
+	require.Error(t, err)
+	_, err = ParseFetchRefSpecs([]string{":refs/heads/main"})
+	require.Error(t, err)
+}
+
+func TestGitCheckout_SetCloneOptions(t *testing.T) {
+	g := &GitOperator{Log: testhelp.ZapTestingLogger(t), Tracer: tracing.Noop{}}
+	repo, _ := newTestRepo(t)
+	_, err := repo.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{"git@example.com:org/repo.git"}})
+	require.NoError(t, err)
+	co, err := g.newCheckout(repo, "", "git@example.com:org/repo.git", nil)
+	require.NoError(t, err)
+
+	require.NoError(t, co.SetCloneOptions(CloneOptions{SingleBranch: true, Depth: 1}))
+	cfg, err := repo.Config()
+	require.NoError(t, err)
+	require.Equal(t, []config.RefSpec{"+refs/heads/master:refs/remotes/origin/master"}, cfg.Remotes["origin"].Fetch)
+	require.Equal(t, 1, co.depth)
+}
@@ -0,0 +1,54 @@
+package goget
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"io"
+	"os"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"go.uber.org/zap"
+)
+
+// ReadRetries counts retried reads, keyed by operation
+var ReadRetries = expvar.NewMap("gitdb_read_retries")
+
+const maxReadAttempts = 3
+
+// retryableReadError is true for errors a concurrent repack or fetch can cause: a pack file or object disappears or is
+// read half written.  Missing paths and branches are not retried.
+func retryableReadError(err error) bool {
+	var packErr *packfile.Error
+	return errors.Is(err, plumbing.ErrObjectNotFound) ||
+		errors.Is(err, os.ErrNotExist) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.As(err, &packErr)
+}
+
+// withReadRetries runs read, which must resolve its branch again each call, retrying transient storage errors.
+// Storage that supports it is reindexed before each retry so newly written packs are found.  Errors that outlast the
+// retries count towards healing the checkout.  Must hold g.mu.
+func (g *GitCheckout) withReadRetries(ctx context.Context, op string, read func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := read()
+		if err == nil || attempt >= maxReadAttempts || !retryableReadError(err) || ctx.Err() != nil {
+			if ctx.Err() == nil {
+				g.noteRead(ctx, err)
+			}
+			return err
+		}
+		ReadRetries.Add(op, 1)
+		g.log.Warn(ctx, "retrying read", zap.String("op", op), zap.Int("attempt", attempt), zap.Error(err))
+		if r, ok := g.repo.Storer.(interface{ Reindex() }); ok {
+			r.Reindex()
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(time.Duration(attempt) * 10 * time.Millisecond):
+		}
+	}
+}
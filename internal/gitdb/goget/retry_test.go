@@ -0,0 +1,19 @@
+package goget
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryableReadError(t *testing.T) {
+	require.True(t, retryableReadError(fmt.Errorf("unable to read: %w", plumbing.ErrObjectNotFound)))
+	require.True(t, retryableReadError(packfile.ErrZLib.AddDetails("bad data")))
+	require.False(t, retryableReadError(object.ErrFileNotFound))
+	require.False(t, retryableReadError(&unknownBranch{branch: "nope", wraps: errors.New("missing")}))
+}
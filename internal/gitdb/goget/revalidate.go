@@ -0,0 +1,30 @@
+package goget
+
+import (
+	"context"
+	"time"
+)
+
+// RefreshedAt is when the checkout last fetched from its remote, or was cloned
+func (g *GitCheckout) RefreshedAt() time.Time {
+	return time.Unix(0, g.lastRefresh.Load())
+}
+
+func (g *GitCheckout) markRefreshed() {
+	g.lastRefresh.Store(time.Now().UnixNano())
+}
+
+// Revalidate starts a background refresh if the last refresh is older than maxAge, without waiting for it, so the
+// caller can serve what it has meanwhile.  However many requests ask, one refresh runs at a time, and none during a
+// maintenance window.  It reports whether a refresh was started.
+func (g *GitCheckout) Revalidate(maxAge time.Duration) bool {
+	if time.Since(g.RefreshedAt()) < maxAge || g.InMaintenance(time.Now()) || !g.revalidating.CompareAndSwap(false, true) {
+		return false
+	}
+	go func() {
+		defer g.revalidating.Store(false)
+		ctx := WithTrigger(context.Background(), TriggerRevalidate)
+		g.log.IfErr(g.Refresh(ctx)).Warn(ctx, "unable to revalidate checkout")
+	}()
+	return true
+}
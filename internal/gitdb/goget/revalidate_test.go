@@ -0,0 +1,28 @@
+package goget
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cresta/gitdb/internal/gitdb/tracing"
+	"github.com/cresta/gitdb/internal/testhelp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitCheckout_Revalidate(t *testing.T) {
+	g := &GitOperator{Log: testhelp.ZapTestingLogger(t), Tracer: tracing.Noop{}}
+	repo, _ := newTestRepo(t)
+	co, err := g.newCheckout(repo, "", "git@example.com:org/repo.git", nil)
+	require.NoError(t, err)
+	require.False(t, co.Revalidate(time.Minute))
+
+	co.lastRefresh.Store(time.Now().Add(-time.Hour).UnixNano())
+	// Holding the lock keeps the background refresh running
+	co.mu.Lock()
+	require.True(t, co.Revalidate(time.Minute))
+	require.False(t, co.Revalidate(time.Minute))
+	co.mu.Unlock()
+	require.Eventually(t, func() bool {
+		return !co.revalidating.Load()
+	}, time.Second*5, time.Millisecond*10)
+}
@@ -0,0 +1,37 @@
+package goget
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+// SharedCache stores content addressed values, such as blobs and tree listings.  Keys include the object hash, so
+// values never need invalidation and are safe to share between replicas.  Get and Set are called holding the lock of
+// the checkout, so they should give up quickly when the cache is slow.
+type SharedCache interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte) error
+}
+
+func (g *GitCheckout) sharedCacheGet(ctx context.Context, key string) ([]byte, bool) {
+	if g.sharedCache == nil {
+		return nil, false
+	}
+	data, exists, err := g.sharedCache.Get(ctx, key)
+	if err != nil {
+		g.log.Warn(ctx, "unable to read shared cache", zap.String("key", key), zap.Error(err))
+		return nil, false
+	}
+	g.tracing.AttachTag(ctx, "shared_cache.hit", exists)
+	return data, exists
+}
+
+func (g *GitCheckout) sharedCacheSet(ctx context.Context, key string, value []byte) {
+	if g.sharedCache == nil {
+		return
+	}
+	if err := g.sharedCache.Set(ctx, key, value); err != nil {
+		g.log.Warn(ctx, "unable to write shared cache", zap.String("key", key), zap.Error(err))
+	}
+}
@@ -0,0 +1,235 @@
+package goget
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"go.uber.org/zap"
+)
+
+// ErrInvalidChange is returned by Simulate and Write for files that cannot be committed, like an invalid path
+var ErrInvalidChange = errors.New("invalid simulated change")
+
+// Simulate commits files on top of branch and moves branch to the commit, as if a push had been fetched, so tests of
+// consumers can exercise Changes without a real push.  A nil content deletes the path.  A clone's branch goes back to
+// upstream on the next refresh; an OpenLocal checkout's branch is moved in the repository on disk.
+func (g *GitCheckout) Simulate(ctx context.Context, branch string, message string, files map[string]*string) (BranchChange, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	sig := object.Signature{Name: "gitdb simulation", Email: "simulation@gitdb", When: time.Now()}
+	c, err := g.commitNoLock(branch, CommitRequest{Message: message, Author: sig, Files: files})
+	if err != nil {
+		return BranchChange{}, err
+	}
+	before, err := g.remoteHeads()
+	if err != nil {
+		return BranchChange{}, err
+	}
+	g.log.Info(ctx, "simulated commit", zap.String("branch", c.branch), zap.String("commit", c.hash.String()), zap.Int("files", len(files)))
+	return g.moveBranchNoLock(WithTrigger(ctx, TriggerSimulate), c, before)
+}
+
+// newCommit is a commit stored but not yet on its branch
+type newCommit struct {
+	branch  string
+	refName plumbing.ReferenceName
+	parent  plumbing.Hash
+	hash    plumbing.Hash
+}
+
+// commitNoLock stores a commit of r on top of branch, without moving the branch.  Only the trees on the paths of
+// r.Files are read and rewritten, so the cost does not grow with the size of the repository.  Must hold g.mu.
+func (g *GitCheckout) commitNoLock(branch string, r CommitRequest) (newCommit, error) {
+	if branch == DefaultBranch {
+		name, err := g.defaultBranchNoLock()
+		if err != nil {
+			return newCommit{}, &unknownBranch{branch: branch, wraps: err}
+		}
+		branch = name
+	}
+	// Only branches move: tags and commits never change
+	refName := g.branchRefName(branch)
+	ref, err := g.repo.Reference(refName, true)
+	if err != nil {
+		return newCommit{}, &unknownBranch{branch: branch, wraps: err}
+	}
+	parent, err := g.repo.CommitObject(ref.Hash())
+	if err != nil {
+		return newCommit{}, fmt.Errorf("unable to find commit %s: %w", ref.Hash(), err)
+	}
+	tree, err := parent.Tree()
+	if err != nil {
+		return newCommit{}, fmt.Errorf("unable to find tree for %s: %w", parent.Hash, err)
+	}
+	for p, want := range r.Expect {
+		e, err := tree.FindEntry(p)
+		exists := err == nil && e.Mode != filemode.Dir
+		if (want == "" && exists) || (want != "" && (!exists || e.Hash.String() != want)) {
+			return newCommit{}, fmt.Errorf("file %s is not at %q on %s: %w", p, want, branch, ErrPreconditionFailed)
+		}
+	}
+	for p := range r.Files {
+		if !fs.ValidPath(p) || p == "." {
+			return newCommit{}, fmt.Errorf("invalid path %s: %w", p, ErrInvalidChange)
+		}
+	}
+	treeHash, err := g.rewriteTree(tree, "", r.Files)
+	if err != nil {
+		return newCommit{}, err
+	}
+	commit := &object.Commit{
+		Author:       r.Author,
+		Committer:    r.Author,
+		Message:      r.Message,
+		TreeHash:     treeHash,
+		ParentHashes: []plumbing.Hash{parent.Hash},
+	}
+	obj := g.repo.Storer.NewEncodedObject()
+	if err := commit.Encode(obj); err != nil {
+		return newCommit{}, fmt.Errorf("unable to encode commit: %w", err)
+	}
+	commitHash, err := g.repo.Storer.SetEncodedObject(obj)
+	if err != nil {
+		return newCommit{}, fmt.Errorf("unable to store commit: %w", err)
+	}
+	return newCommit{branch: branch, refName: refName, parent: parent.Hash, hash: commitHash}, nil
+}
+
+// moveBranchNoLock points the branch of c at it and records the change from the heads before, tagged with the
+// trigger of ctx.  Must hold g.mu.
+func (g *GitCheckout) moveBranchNoLock(ctx context.Context, c newCommit, before map[string]plumbing.Hash) (BranchChange, error) {
+	if err := g.repo.Storer.SetReference(plumbing.NewHashReference(c.refName, c.hash)); err != nil {
+		return BranchChange{}, fmt.Errorf("unable to move %s: %w", c.refName, err)
+	}
+	// Files are cached by branch, which moved without a fetch
+	g.cache.Purge()
+	if err := g.recordChanges(ctx, before); err != nil {
+		return BranchChange{}, err
+	}
+	if g.local {
+		var err error
+		g.localHeads, err = g.remoteHeads()
+		if err != nil {
+			return BranchChange{}, err
+		}
+	}
+	return g.changes[c.branch], nil
+}
+
+// storeObject writes content as an object of type t.  Must hold g.mu.
+func (g *GitCheckout) storeObject(t plumbing.ObjectType, content []byte) (plumbing.Hash, error) {
+	obj := g.repo.Storer.NewEncodedObject()
+	obj.SetType(t)
+	w, err := obj.Writer()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if _, err := w.Write(content); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if err := w.Close(); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return g.repo.Storer.SetEncodedObject(obj)
+}
+
+// rewriteTree stores tree, the directory dir or nil if it is new, with files applied, keyed by path relative to dir.
+// It returns the zero hash for a directory left empty, which git does not keep.  Must hold g.mu.
+func (g *GitCheckout) rewriteTree(tree *object.Tree, dir string, files map[string]*string) (plumbing.Hash, error) {
+	children := make(map[string]object.TreeEntry)
+	if tree != nil {
+		for _, e := range tree.Entries {
+			children[e.Name] = e
+		}
+	}
+	subdirs := make(map[string]map[string]*string)
+	for p, content := range files {
+		name, rest, nested := strings.Cut(p, "/")
+		if nested {
+			if subdirs[name] == nil {
+				subdirs[name] = make(map[string]*string)
+			}
+			subdirs[name][rest] = content
+			continue
+		}
+		full := path.Join(dir, name)
+		e, exists := children[name]
+		if exists && e.Mode == filemode.Dir {
+			return plumbing.ZeroHash, fmt.Errorf("path %s is a directory: %w", full, ErrInvalidChange)
+		}
+		if content == nil {
+			if !exists {
+				return plumbing.ZeroHash, fmt.Errorf("unable to delete missing file %s: %w", full, ErrInvalidChange)
+			}
+			delete(children, name)
+			continue
+		}
+		hash, err := g.storeObject(plumbing.BlobObject, []byte(*content))
+		if err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("unable to store %s: %w", full, err)
+		}
+		if !exists {
+			e = object.TreeEntry{Name: name, Mode: filemode.Regular}
+		}
+		e.Hash = hash
+		children[name] = e
+	}
+	for name, sub := range subdirs {
+		full := path.Join(dir, name)
+		var subtree *object.Tree
+		if e, exists := children[name]; exists {
+			if e.Mode != filemode.Dir {
+				return plumbing.ZeroHash, fmt.Errorf("path %s is both a file and a directory: %w", full, ErrInvalidChange)
+			}
+			var err error
+			if subtree, err = g.repo.TreeObject(e.Hash); err != nil {
+				return plumbing.ZeroHash, fmt.Errorf("unable to find tree of %s: %w", full, err)
+			}
+		}
+		hash, err := g.rewriteTree(subtree, full, sub)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		if hash.IsZero() {
+			delete(children, name)
+			continue
+		}
+		children[name] = object.TreeEntry{Name: name, Mode: filemode.Dir, Hash: hash}
+	}
+	if len(children) == 0 && dir != "" {
+		return plumbing.ZeroHash, nil
+	}
+	return g.storeTree(children)
+}
+
+// storeTree stores a tree of children, keyed by name.  Must hold g.mu.
+func (g *GitCheckout) storeTree(children map[string]object.TreeEntry) (plumbing.Hash, error) {
+	t := &object.Tree{Entries: make([]object.TreeEntry, 0, len(children))}
+	for _, e := range children {
+		t.Entries = append(t.Entries, e)
+	}
+	// Git orders directories as if their name ended in a slash
+	sortName := func(e object.TreeEntry) string {
+		if e.Mode == filemode.Dir {
+			return e.Name + "/"
+		}
+		return e.Name
+	}
+	sort.Slice(t.Entries, func(i, j int) bool {
+		return sortName(t.Entries[i]) < sortName(t.Entries[j])
+	})
+	obj := g.repo.Storer.NewEncodedObject()
+	if err := t.Encode(obj); err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("unable to encode tree: %w", err)
+	}
+	return g.repo.Storer.SetEncodedObject(obj)
+}
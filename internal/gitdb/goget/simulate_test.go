@@ -0,0 +1,61 @@
+package goget
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/cresta/gitdb/internal/gitdb/tracing"
+	"github.com/cresta/gitdb/internal/testhelp"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitCheckout_Simulate(t *testing.T) {
+	ctx := context.Background()
+	g := &GitOperator{Log: testhelp.ZapTestingLogger(t), Tracer: tracing.Noop{}}
+	repo, first := newTestRepo(t)
+	co, err := g.newCheckout(repo, "", "git@example.com:org/repo.git", nil)
+	require.NoError(t, err)
+	read := func(path string) string {
+		f, err := co.GetFile(ctx, "master", path)
+		require.NoError(t, err)
+		var b bytes.Buffer
+		_, err = f.WriteTo(&b)
+		require.NoError(t, err)
+		return b.String()
+	}
+	require.Equal(t, "hello\n", read("a.txt"))
+
+	content := "nested\n"
+	change, err := co.Simulate(ctx, "master", "add nested", map[string]*string{"dir/sub/b.txt": &content})
+	require.NoError(t, err)
+	require.Equal(t, first.String(), change.From)
+	require.Equal(t, []string{"dir/sub/b.txt"}, change.Paths)
+	require.Equal(t, "nested\n", read("dir/sub/b.txt"))
+	require.Equal(t, "hello\n", read("a.txt"))
+	recorded, exists := co.Changes("master")
+	require.True(t, exists)
+	require.Equal(t, change, recorded)
+
+	change, err = co.Simulate(ctx, "master", "remove a", map[string]*string{"a.txt": nil})
+	require.NoError(t, err)
+	require.Equal(t, []string{"a.txt"}, change.Paths)
+	_, err = co.GetFile(ctx, "master", "a.txt")
+	require.ErrorIs(t, err, object.ErrFileNotFound)
+
+	other := "other\n"
+	_, err = co.Simulate(ctx, "master", "move b", map[string]*string{"dir/sub/b.txt": nil, "dir/other/c.txt": &other})
+	require.NoError(t, err)
+	// dir/sub is gone once empty
+	files, err := co.LsFiles(ctx, "master")
+	require.NoError(t, err)
+	require.Equal(t, []string{"dir/other/c.txt"}, files)
+
+	_, err = co.Simulate(ctx, "master", "bad", map[string]*string{"../x": &content})
+	require.ErrorIs(t, err, ErrInvalidChange)
+	_, err = co.Simulate(ctx, "master", "bad", map[string]*string{"dir/other/c.txt/d": &content})
+	require.ErrorIs(t, err, ErrInvalidChange)
+	_, err = co.Simulate(ctx, "v1", "tags never move", map[string]*string{"b.txt": &content})
+	require.ErrorIs(t, err, ErrUnknownBranch)
+}
@@ -0,0 +1,56 @@
+package goget
+
+import "container/list"
+
+// sizedLRU evicts the least recently used entries once their sizes add up to more than maxBytes.  Callers lock.
+type sizedLRU[K comparable, V any] struct {
+	maxBytes int64
+	bytes    int64
+	order    *list.List
+	items    map[K]*list.Element
+}
+
+type sizedEntry[K comparable, V any] struct {
+	key   K
+	value V
+	size  int64
+}
+
+func newSizedLRU[K comparable, V any](maxBytes int64) *sizedLRU[K, V] {
+	return &sizedLRU[K, V]{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		items:    make(map[K]*list.Element),
+	}
+}
+
+func (l *sizedLRU[K, V]) get(key K) (V, bool) {
+	e, exists := l.items[key]
+	if !exists {
+		var zero V
+		return zero, false
+	}
+	l.order.MoveToFront(e)
+	return e.Value.(*sizedEntry[K, V]).value, true
+}
+
+// add keeps value unless key is already kept or size is more than the whole LRU holds, and returns how many entries
+// were evicted to make room
+func (l *sizedLRU[K, V]) add(key K, value V, size int64) int {
+	if size > l.maxBytes {
+		return 0
+	}
+	if _, exists := l.items[key]; exists {
+		return 0
+	}
+	l.items[key] = l.order.PushFront(&sizedEntry[K, V]{key: key, value: value, size: size})
+	l.bytes += size
+	evicted := 0
+	for l.bytes > l.maxBytes {
+		entry := l.order.Remove(l.order.Back()).(*sizedEntry[K, V])
+		delete(l.items, entry.key)
+		l.bytes -= entry.size
+		evicted++
+	}
+	return evicted
+}
@@ -0,0 +1,53 @@
+package goget
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/storage"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// Storage decides where a checkout keeps its git objects and references.  Implement this to add backends (for
+// example S3 backed or encrypted) without changing the checkout or HTTP layers.
+type Storage interface {
+	// NewStorer creates empty storage for a new clone.  name is a filesystem safe name for the repository.  The
+	// returned location is used for logging and AbsPath, and may be empty.
+	NewStorer(name string) (storer storage.Storer, location string, err error)
+}
+
+// DiskStorage keeps each repository in a bare git directory under Directory
+type DiskStorage struct {
+	Directory string
+}
+
+var _ ObjectCacheStorage = &DiskStorage{}
+
+func (d *DiskStorage) NewStorer(name string) (storage.Storer, string, error) {
+	return d.NewStorerWithCache(name, cache.NewObjectLRUDefault())
+}
+
+func (d *DiskStorage) NewStorerWithCache(name string, objects cache.Object) (storage.Storer, string, error) {
+	dir := d.Directory
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	into, err := os.MkdirTemp(dir, name)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to make temp dir for %s,%s: %w", dir, name, err)
+	}
+	return filesystem.NewStorage(osfs.New(into), objects), into, nil
+}
+
+// MemoryStorage keeps each repository in memory.  Useful for small, hot repositories where disk I/O is wasteful or the
+// root filesystem is read only.
+type MemoryStorage struct{}
+
+var _ Storage = MemoryStorage{}
+
+func (m MemoryStorage) NewStorer(_ string) (storage.Storer, string, error) {
+	return memory.NewStorage(), "", nil
+}
@@ -0,0 +1,94 @@
+package goget
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// ErrNotSubmodule is returned by Submodule for paths that are not a submodule
+var ErrNotSubmodule = errors.New("not a submodule")
+
+// Submodule is a submodule as a commit of its superproject records it
+type Submodule struct {
+	Path string
+	// Where the submodule is cloned from, with relative URLs resolved against the superproject's remote
+	URL string
+	// The commit the superproject pins the submodule at
+	Commit string
+}
+
+// Submodule returns the submodule at p, pinned at the commit branch records for it rather than any branch of the
+// submodule
+func (g *GitCheckout) Submodule(ctx context.Context, branch string, p string) (Submodule, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	tree, _, err := g.branchTree(ctx, branch)
+	if err != nil {
+		return Submodule{}, err
+	}
+	p = strings.Trim(p, "/")
+	entry, err := tree.FindEntry(p)
+	if errors.Is(err, object.ErrEntryNotFound) || errors.Is(err, object.ErrDirectoryNotFound) {
+		return Submodule{}, fmt.Errorf("unable to find %s: %w", p, ErrNotSubmodule)
+	}
+	if err != nil {
+		return Submodule{}, fmt.Errorf("unable to find %s: %w", p, err)
+	}
+	if entry.Mode != filemode.Submodule {
+		return Submodule{}, fmt.Errorf("path %s: %w", p, ErrNotSubmodule)
+	}
+	f, err := tree.File(".gitmodules")
+	if err != nil {
+		return Submodule{}, fmt.Errorf("unable to find .gitmodules for %s: %w", p, err)
+	}
+	r, err := f.Reader()
+	if err != nil {
+		return Submodule{}, fmt.Errorf("unable to read .gitmodules: %w", err)
+	}
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return Submodule{}, fmt.Errorf("unable to read .gitmodules: %w", err)
+	}
+	modules := config.NewModules()
+	if err := modules.Unmarshal(b); err != nil {
+		return Submodule{}, fmt.Errorf("unable to parse .gitmodules: %w", err)
+	}
+	for _, m := range modules.Submodules {
+		if strings.Trim(m.Path, "/") == p {
+			return Submodule{
+				Path:   p,
+				URL:    resolveSubmoduleURL(g.remoteURL, m.URL),
+				Commit: entry.Hash.String(),
+			}, nil
+		}
+	}
+	return Submodule{}, fmt.Errorf("unable to find %s in .gitmodules: %w", p, ErrNotSubmodule)
+}
+
+// resolveSubmoduleURL resolves a relative submodule URL, like "../lib.git", against the superproject's remote the way
+// git does: as if the remote were a directory
+func resolveSubmoduleURL(superURL string, subURL string) string {
+	if !strings.HasPrefix(subURL, "./") && !strings.HasPrefix(subURL, "../") {
+		return subURL
+	}
+	if u, err := url.Parse(superURL); err == nil && u.Scheme != "" && u.Host != "" {
+		u.Path = path.Join(u.Path, subURL)
+		return u.String()
+	}
+	// scp-like syntax, like git@github.com:org/repo.git
+	if host, repoPath, found := strings.Cut(superURL, ":"); found && !strings.Contains(host, "/") {
+		return host + ":" + path.Join(repoPath, subURL)
+	}
+	return filepath.Join(superURL, subURL)
+}
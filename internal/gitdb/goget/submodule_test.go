@@ -0,0 +1,61 @@
+package goget
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cresta/gitdb/internal/gitdb/tracing"
+	"github.com/cresta/gitdb/internal/testhelp"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitCheckout_Submodule(t *testing.T) {
+	ctx := context.Background()
+	g := &GitOperator{Log: testhelp.ZapTestingLogger(t), Tracer: tracing.Noop{}}
+	repo, first := newTestRepo(t)
+	co, err := g.newCheckout(repo, "", "git@example.com:org/app.git", nil)
+	require.NoError(t, err)
+	pinned := plumbing.NewHash("1111111111111111111111111111111111111111")
+	gitmodules, err := co.storeObject(plumbing.BlobObject, []byte(`[submodule "lib"]
+	path = vendor/lib
+	url = ../lib.git
+`))
+	require.NoError(t, err)
+	vendor, err := co.storeTree(map[string]object.TreeEntry{
+		"lib": {Name: "lib", Mode: filemode.Submodule, Hash: pinned},
+	})
+	require.NoError(t, err)
+	tree, err := co.storeTree(map[string]object.TreeEntry{
+		".gitmodules": {Name: ".gitmodules", Mode: filemode.Regular, Hash: gitmodules},
+		"vendor":      {Name: "vendor", Mode: filemode.Dir, Hash: vendor},
+	})
+	require.NoError(t, err)
+	sig := object.Signature{Name: "test", Email: "test@example.com", When: time.Now()}
+	obj := repo.Storer.NewEncodedObject()
+	require.NoError(t, (&object.Commit{Author: sig, Committer: sig, Message: "add lib", TreeHash: tree, ParentHashes: []plumbing.Hash{first}}).Encode(obj))
+	commit, err := repo.Storer.SetEncodedObject(obj)
+	require.NoError(t, err)
+	require.NoError(t, repo.Storer.SetReference(plumbing.NewHashReference(plumbing.NewRemoteReferenceName("origin", "master"), commit)))
+
+	sub, err := co.Submodule(ctx, "master", "vendor/lib/")
+	require.NoError(t, err)
+	require.Equal(t, Submodule{Path: "vendor/lib", URL: "git@example.com:org/lib.git", Commit: pinned.String()}, sub)
+
+	_, err = co.Submodule(ctx, "master", ".gitmodules")
+	require.ErrorIs(t, err, ErrNotSubmodule)
+	_, err = co.Submodule(ctx, "master", "vendor/missing")
+	require.ErrorIs(t, err, ErrNotSubmodule)
+	_, err = co.Submodule(ctx, "nobranch", "vendor/lib")
+	require.ErrorIs(t, err, ErrUnknownBranch)
+}
+
+func TestResolveSubmoduleURL(t *testing.T) {
+	require.Equal(t, "https://example.com/other/lib.git", resolveSubmoduleURL("git@example.com:org/app.git", "https://example.com/other/lib.git"))
+	require.Equal(t, "git@example.com:org/lib.git", resolveSubmoduleURL("git@example.com:org/app.git", "../lib.git"))
+	require.Equal(t, "https://example.com/org/lib", resolveSubmoduleURL("https://example.com/org/app", "../lib"))
+	require.Equal(t, "/srv/git/lib", resolveSubmoduleURL("/srv/git/app", "../lib"))
+}
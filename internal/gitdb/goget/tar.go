@@ -0,0 +1,55 @@
+package goget
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+)
+
+// TarContents is Tar, gzipped
+func (g *GitCheckout) TarContents(ctx context.Context, into io.Writer, prefixes []ZipPrefix, branch string) (int, error) {
+	gz := gzip.NewWriter(into)
+	numFiles, err := g.Tar(ctx, gz, prefixes, branch)
+	if err != nil {
+		return numFiles, err
+	}
+	if err := gz.Close(); err != nil {
+		return numFiles, fmt.Errorf("unable to close gzip: %w", err)
+	}
+	return numFiles, nil
+}
+
+// Tar writes every file under each prefix into a single uncompressed tarball, for the caller to compress.  Files keep
+// their git mode, so executables stay executable, and are dated at the commit time.
+func (g *GitCheckout) Tar(ctx context.Context, into io.Writer, prefixes []ZipPrefix, branch string) (int, error) {
+	tw := tar.NewWriter(into)
+	numFiles, err := g.walkPrefixes(ctx, prefixes, branch, func(filePath string, f prefixFile) error {
+		mode, err := f.mode.ToOSFileMode()
+		if err != nil {
+			return fmt.Errorf("unable to convert mode of %s: %w", filePath, err)
+		}
+		err = tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     filePath,
+			Mode:     int64(mode.Perm()),
+			Size:     int64(len(f.content)),
+			ModTime:  f.commitTime,
+		})
+		if err != nil {
+			return fmt.Errorf("unable to write header for %s: %w", filePath, err)
+		}
+		if _, err := tw.Write(f.content); err != nil {
+			return fmt.Errorf("unable to write file named %s: %w", filePath, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return numFiles, err
+	}
+	if err := tw.Close(); err != nil {
+		return numFiles, fmt.Errorf("unable to close tar: %w", err)
+	}
+	return numFiles, nil
+}
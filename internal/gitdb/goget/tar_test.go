@@ -0,0 +1,37 @@
+package goget
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/cresta/gitdb/internal/gitdb/tracing"
+	"github.com/cresta/gitdb/internal/testhelp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitCheckout_TarContents(t *testing.T) {
+	g := &GitOperator{Log: testhelp.ZapTestingLogger(t), Tracer: tracing.Noop{}}
+	repo, _ := newTestRepo(t)
+	co, err := g.newCheckout(repo, "", "git@example.com:org/repo.git", nil)
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	numFiles, err := co.TarContents(context.Background(), &buf, []ZipPrefix{{Prefix: "", Folder: "out"}}, "master")
+	require.NoError(t, err)
+	require.Equal(t, 1, numFiles)
+	gz, err := gzip.NewReader(&buf)
+	require.NoError(t, err)
+	tr := tar.NewReader(gz)
+	hdr, err := tr.Next()
+	require.NoError(t, err)
+	require.Equal(t, "out/a.txt", hdr.Name)
+	require.Equal(t, int64(0o644), hdr.Mode)
+	content, err := io.ReadAll(tr)
+	require.NoError(t, err)
+	require.Equal(t, "hello\n", string(content))
+	_, err = tr.Next()
+	require.Equal(t, io.EOF, err)
+}
@@ -0,0 +1,139 @@
+package goget
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	"github.com/cresta/gitdb/internal/gitdb/tracing"
+)
+
+// VerifyReport is how a checkout differs from its remote
+type VerifyReport struct {
+	// Branches whose local head is not the remote head
+	Stale []StaleBranch `json:",omitempty"`
+	// Branches on the remote that were never fetched
+	Missing []string `json:",omitempty"`
+	// Branches fetched earlier that no longer exist on the remote
+	Deleted []string `json:",omitempty"`
+	// Local heads whose commit or tree can not be read
+	BrokenObjects []BrokenObject `json:",omitempty"`
+}
+
+type StaleBranch struct {
+	Branch string
+	Local  string
+	Remote string
+}
+
+type BrokenObject struct {
+	Branch string
+	Hash   string
+	Error  string
+}
+
+// Drifted is true if the checkout does not match the remote
+func (v VerifyReport) Drifted() bool {
+	return len(v.Stale) > 0 || len(v.Missing) > 0 || len(v.Deleted) > 0 || len(v.BrokenObjects) > 0
+}
+
+// Verify compares the local branches against an ls-remote and checks every local head can be read
+func (g *GitCheckout) Verify(ctx context.Context) (VerifyReport, error) {
+	var ret VerifyReport
+	err := g.tracing.StartSpanFromContext(ctx, tracing.SpanConfig{OperationName: "verify"}, func(ctx context.Context) error {
+		g.tracing.AttachTag(ctx, "git.remote_url", g.remoteURL)
+		g.mu.Lock()
+		auth := g.auth
+		g.mu.Unlock()
+		remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+			Name: "origin",
+			URLs: []string{g.remoteURL},
+		})
+		refs, err := remote.ListContext(ctx, &git.ListOptions{Auth: attachContextToAuth(ctx, auth)})
+		if err != nil {
+			return fmt.Errorf("unable to list remote: %w", err)
+		}
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		remoteHeads := make(map[string]plumbing.Hash)
+		for _, r := range refs {
+			// Branches left out of the fetch refspecs are never fetched, so are not missing
+			if r.Type() == plumbing.HashReference && r.Name().IsBranch() && g.fetchedByOrigin(r.Name()) {
+				remoteHeads[r.Name().Short()] = r.Hash()
+			}
+		}
+		localHeads, err := g.remoteHeads()
+		if err != nil {
+			return err
+		}
+		ret = compareHeads(localHeads, remoteHeads)
+		for branch, hash := range localHeads {
+			if err := g.readHead(hash); err != nil {
+				ret.BrokenObjects = append(ret.BrokenObjects, BrokenObject{Branch: branch, Hash: hash.String(), Error: err.Error()})
+			}
+		}
+		sort.Slice(ret.BrokenObjects, func(i, j int) bool {
+			return ret.BrokenObjects[i].Branch < ret.BrokenObjects[j].Branch
+		})
+		return nil
+	})
+	return ret, err
+}
+
+func compareHeads(local map[string]plumbing.Hash, remote map[string]plumbing.Hash) VerifyReport {
+	var ret VerifyReport
+	for branch, remoteHash := range remote {
+		localHash, exists := local[branch]
+		if !exists {
+			ret.Missing = append(ret.Missing, branch)
+			continue
+		}
+		if localHash != remoteHash {
+			ret.Stale = append(ret.Stale, StaleBranch{Branch: branch, Local: localHash.String(), Remote: remoteHash.String()})
+		}
+	}
+	for branch := range local {
+		if _, exists := remote[branch]; !exists {
+			ret.Deleted = append(ret.Deleted, branch)
+		}
+	}
+	sort.Strings(ret.Missing)
+	sort.Strings(ret.Deleted)
+	sort.Slice(ret.Stale, func(i, j int) bool {
+		return ret.Stale[i].Branch < ret.Stale[j].Branch
+	})
+	return ret
+}
+
+// readHead walks the tree of a commit so missing or corrupt objects show up.  Must hold g.mu.
+func (g *GitCheckout) readHead(hash plumbing.Hash) error {
+	c, err := g.repo.CommitObject(hash)
+	if err != nil {
+		return fmt.Errorf("unable to read commit: %w", err)
+	}
+	tree, err := c.Tree()
+	if err != nil {
+		return fmt.Errorf("unable to read tree: %w", err)
+	}
+	files := tree.Files()
+	defer files.Close()
+	for {
+		f, err := files.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("unable to walk tree: %w", err)
+		}
+		if _, err := g.repo.Storer.EncodedObject(plumbing.BlobObject, f.Hash); err != nil {
+			return fmt.Errorf("unable to read blob %s: %w", f.Name, err)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package goget
+
+import (
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareHeads(t *testing.T) {
+	a := plumbing.NewHash("1111111111111111111111111111111111111111")
+	b := plumbing.NewHash("2222222222222222222222222222222222222222")
+	report := compareHeads(map[string]plumbing.Hash{
+		"master": a,
+		"old":    a,
+		"same":   b,
+	}, map[string]plumbing.Hash{
+		"master": b,
+		"new":    a,
+		"same":   b,
+	})
+	require.True(t, report.Drifted())
+	require.Equal(t, []StaleBranch{{Branch: "master", Local: a.String(), Remote: b.String()}}, report.Stale)
+	require.Equal(t, []string{"new"}, report.Missing)
+	require.Equal(t, []string{"old"}, report.Deleted)
+	require.False(t, compareHeads(map[string]plumbing.Hash{"same": b}, map[string]plumbing.Hash{"same": b}).Drifted())
+}
@@ -0,0 +1,81 @@
+package goget
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/cresta/gitdb/internal/gitdb/tracing"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+func (g *GitCheckout) dirTree(r *plumbing.Reference, dir string) (*object.Tree, error) {
+	co, err := g.repo.CommitObject(r.Hash())
+	if err != nil {
+		return nil, fmt.Errorf("unable to make commit object for hash %s: %w", r.Hash(), err)
+	}
+	return commitDirTree(co, dir)
+}
+
+// WalkDir calls callback for each entry of dir as it is read from the object store, without collecting the listing
+// in memory.  When recursive is set, entries of subdirectories are included with names relative to dir.  g.mu is only
+// held while each entry is read, never while callback runs, so a slow consumer like a client stalls no one else.
+func (g *GitCheckout) WalkDir(ctx context.Context, dir string, branch string, recursive bool, callback func(FileStat) error) error {
+	g.mu.Lock()
+	r, err := g.resolveRef(ctx, branch)
+	if err != nil {
+		g.mu.Unlock()
+		return err
+	}
+	t, err := g.dirTree(r, dir)
+	g.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return g.tracing.StartSpanFromContext(ctx, tracing.SpanConfig{OperationName: "walk_dir"}, func(ctx context.Context) error {
+		walker := object.NewTreeWalker(t, recursive, nil)
+		defer walker.Close()
+		numEntries := 0
+		defer func() {
+			g.tracing.AttachTag(ctx, "git.walk_dir.entries", numEntries)
+		}()
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			stat, err := g.nextEntry(walker)
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("unable to walk tree %s: %w", t.Hash, err)
+			}
+			numEntries++
+			if err := callback(stat); err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// nextEntry reads the next entry of walker, holding g.mu only for the read
+func (g *GitCheckout) nextEntry(walker *object.TreeWalker) (FileStat, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	name, e, err := walker.Next()
+	if err != nil {
+		return FileStat{}, err
+	}
+	size, err := g.entrySize(e)
+	if err != nil {
+		return FileStat{}, err
+	}
+	return FileStat{
+		Name: name,
+		Mode: uint32(e.Mode),
+		Hash: e.Hash.String(),
+		Size: size,
+	}, nil
+}
@@ -0,0 +1,113 @@
+package goget
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"go.uber.org/zap"
+)
+
+// WorkTreeInfo is where a checked out working tree lives and what it has checked out
+type WorkTreeInfo struct {
+	Branch string
+	Path   string
+	Commit string
+}
+
+// workTreeStorer is the repository of a work tree: its HEAD and index are its own, so checking out never moves the
+// HEAD of the checkout, but its objects are read from the checkout.  Only used while holding g.mu.
+type workTreeStorer struct {
+	*filesystem.Storage
+	g *GitCheckout
+}
+
+func (s *workTreeStorer) EncodedObject(t plumbing.ObjectType, h plumbing.Hash) (plumbing.EncodedObject, error) {
+	return s.g.repo.Storer.EncodedObject(t, h)
+}
+
+func (s *workTreeStorer) HasEncodedObject(h plumbing.Hash) error {
+	return s.g.repo.Storer.HasEncodedObject(h)
+}
+
+func (s *workTreeStorer) EncodedObjectSize(h plumbing.Hash) (int64, error) {
+	return s.g.repo.Storer.EncodedObjectSize(h)
+}
+
+type workTree struct {
+	tree   *git.Worktree
+	branch string
+	path   string
+	commit string
+}
+
+// EnableWorkTree keeps a checked out copy of branch at dir, updated on every refresh.  It is for tools that need a
+// real directory; gitdb itself never reads from it.  dir gets a .git of its own, so the checkout's HEAD never moves.
+func (g *GitCheckout) EnableWorkTree(ctx context.Context, dir string, branch string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	s := &workTreeStorer{
+		Storage: filesystem.NewStorage(osfs.New(filepath.Join(dir, git.GitDirName)), cache.NewObjectLRUDefault()),
+		g:       g,
+	}
+	repo, err := git.Init(s, osfs.New(dir))
+	if errors.Is(err, git.ErrRepositoryAlreadyExists) {
+		repo, err = git.Open(s, osfs.New(dir))
+	}
+	if err != nil {
+		return fmt.Errorf("unable to open work tree at %s: %w", dir, err)
+	}
+	tree, err := repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("unable to open work tree at %s: %w", dir, err)
+	}
+	g.workTree = &workTree{
+		tree:   tree,
+		branch: branch,
+		path:   dir,
+	}
+	return g.syncWorkTree(ctx)
+}
+
+// WorkTree returns the working tree set up by EnableWorkTree
+func (g *GitCheckout) WorkTree() (WorkTreeInfo, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.workTree == nil {
+		return WorkTreeInfo{}, false
+	}
+	return WorkTreeInfo{
+		Branch: g.workTree.branch,
+		Path:   g.workTree.path,
+		Commit: g.workTree.commit,
+	}, true
+}
+
+// syncWorkTree checks out the current head of the work tree's branch, discarding local edits.  Must hold g.mu.
+func (g *GitCheckout) syncWorkTree(ctx context.Context) error {
+	if g.workTree == nil {
+		return nil
+	}
+	r, err := g.branchRef(g.workTree.branch)
+	if err != nil {
+		return err
+	}
+	if r.Hash().String() == g.workTree.commit {
+		return nil
+	}
+	if err := g.workTree.tree.Checkout(&git.CheckoutOptions{Hash: r.Hash(), Force: true}); err != nil {
+		return fmt.Errorf("unable to check out %s: %w", g.workTree.branch, err)
+	}
+	if err := g.workTree.tree.Clean(&git.CleanOptions{Dir: true}); err != nil {
+		return fmt.Errorf("unable to clean work tree: %w", err)
+	}
+	g.workTree.commit = r.Hash().String()
+	g.log.Info(ctx, "updated work tree", zap.String("branch", g.workTree.branch), zap.String("commit", g.workTree.commit))
+	return nil
+}
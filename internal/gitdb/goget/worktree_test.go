@@ -0,0 +1,48 @@
+package goget
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cresta/gitdb/internal/gitdb/tracing"
+	"github.com/cresta/gitdb/internal/testhelp"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitCheckout_EnableWorkTree(t *testing.T) {
+	ctx := context.Background()
+	g := &GitOperator{Log: testhelp.ZapTestingLogger(t), Tracer: tracing.Noop{}}
+	upstream := newBareUpstream(t)
+	co, err := g.Clone(ctx, t.TempDir(), upstream, nil)
+	require.NoError(t, err)
+	dir := t.TempDir()
+	require.NoError(t, co.EnableWorkTree(ctx, dir, "master"))
+	content, err := os.ReadFile(filepath.Join(dir, "a.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "hello\n", string(content))
+	info, exists := co.WorkTree()
+	require.True(t, exists)
+	require.Len(t, info.Commit, 40)
+
+	// Checking out did not detach the HEAD of the checkout
+	head, err := co.repo.Storer.Reference(plumbing.HEAD)
+	require.NoError(t, err)
+	require.Equal(t, plumbing.SymbolicReference, head.Type())
+	file, err := co.GetFile(ctx, DefaultBranch, "a.txt")
+	require.NoError(t, err)
+	var b bytes.Buffer
+	_, err = file.WriteTo(&b)
+	require.NoError(t, err)
+	require.Equal(t, "hello\n", b.String())
+
+	// A restart reuses the work tree's repository
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("edited\n"), 0o600))
+	require.NoError(t, co.EnableWorkTree(ctx, dir, "master"))
+	content, err = os.ReadFile(filepath.Join(dir, "a.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "hello\n", string(content))
+}
@@ -0,0 +1,140 @@
+package goget
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrPreconditionFailed is returned by Write when a file is not at the hash CommitRequest.Expect asks for
+	ErrPreconditionFailed = errors.New("precondition failed")
+	// ErrPushRejected is returned by Write when upstream refuses the commit, usually because the branch moved upstream
+	// since the last fetch
+	ErrPushRejected = errors.New("push rejected")
+)
+
+// CommitRequest is a commit of files on top of a branch
+type CommitRequest struct {
+	Message string
+	// Also the committer
+	Author object.Signature
+	// Content keyed by path.  A nil content deletes the path.
+	Files map[string]*string
+	// Optional: the blob hash each path must have on the branch for the commit to be made, keyed by path.  An empty
+	// hash requires the path to not exist.
+	Expect map[string]string
+}
+
+// Write commits r on top of branch and pushes it upstream with the checkout's auth, moving branch only once upstream
+// accepted it.  g.mu is released during the push, so reads and refreshes are not held up by upstream.  An OpenLocal
+// checkout has no upstream: its branch is moved in the repository on disk.
+func (g *GitCheckout) Write(ctx context.Context, branch string, r CommitRequest) (BranchChange, error) {
+	g.mu.Lock()
+	c, err := g.commitNoLock(branch, r)
+	if err != nil {
+		g.mu.Unlock()
+		return BranchChange{}, err
+	}
+	logger := g.log.With(zap.String("branch", c.branch), zap.String("commit", c.hash.String()))
+	if g.local {
+		defer g.mu.Unlock()
+		logger.Info(ctx, "wrote commit", zap.Int("files", len(r.Files)))
+		return g.landCommitNoLock(ctx, c)
+	}
+	auth := g.auth
+	g.mu.Unlock()
+
+	// Pushed from storage without references, so the push does not move the remote tracking branch behind g.mu
+	remote := git.NewRemote(&lockedStorer{Storage: memory.NewStorage(), g: g}, &config.RemoteConfig{
+		Name: "origin",
+		URLs: []string{g.remoteURL},
+	})
+	err = remote.PushContext(ctx, &git.PushOptions{
+		RemoteName: "origin",
+		RefSpecs:   []config.RefSpec{config.RefSpec(c.hash.String() + ":refs/heads/" + c.branch)},
+		Auth:       attachContextToAuth(ctx, auth),
+	})
+	if err != nil {
+		logger.Warn(ctx, "unable to push commit", zap.Error(err))
+		// go-git does not export the error for a branch that moved
+		if strings.Contains(err.Error(), "non-fast-forward") {
+			return BranchChange{}, fmt.Errorf("%w: %s moved upstream: %v", ErrPushRejected, c.branch, err)
+		}
+		return BranchChange{}, fmt.Errorf("unable to push to %s: %w", c.branch, err)
+	}
+	logger.Info(ctx, "wrote commit", zap.Int("files", len(r.Files)))
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.landCommitNoLock(ctx, c)
+}
+
+// landCommitNoLock moves the branch of c to it, unless a refresh while the push ran already moved the branch past its
+// parent.  Must hold g.mu.
+func (g *GitCheckout) landCommitNoLock(ctx context.Context, c newCommit) (BranchChange, error) {
+	ref, err := g.repo.Reference(c.refName, true)
+	if err != nil {
+		return BranchChange{}, &unknownBranch{branch: c.branch, wraps: err}
+	}
+	if ref.Hash() == c.parent {
+		before, err := g.remoteHeads()
+		if err != nil {
+			return BranchChange{}, err
+		}
+		return g.moveBranchNoLock(WithTrigger(ctx, TriggerWrite), c, before)
+	}
+	paths, err := g.changedPaths(c.parent, c.hash)
+	if err != nil {
+		return BranchChange{}, fmt.Errorf("unable to diff commit %s: %w", c.hash, err)
+	}
+	return BranchChange{Branch: c.branch, From: c.parent.String(), To: c.hash.String(), Paths: paths, Time: time.Now()}, nil
+}
+
+// lockedStorer is what a push reads from: objects of the checkout, copied out holding g.mu only while each is read.
+// It has no references of its own, so the push cannot move any.
+type lockedStorer struct {
+	*memory.Storage
+	g *GitCheckout
+}
+
+func (s *lockedStorer) EncodedObject(t plumbing.ObjectType, h plumbing.Hash) (plumbing.EncodedObject, error) {
+	return s.g.copyObject(t, h)
+}
+
+// copyObject copies an object of the checkout into memory, holding g.mu only while it is read
+func (g *GitCheckout) copyObject(t plumbing.ObjectType, h plumbing.Hash) (plumbing.EncodedObject, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	obj, err := g.repo.Storer.EncodedObject(t, h)
+	if err != nil {
+		return nil, err
+	}
+	r, err := obj.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	ret := &plumbing.MemoryObject{}
+	ret.SetType(obj.Type())
+	if _, err := io.Copy(ret, r); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Shallow is read to know where the history of a shallow clone stops
+func (s *lockedStorer) Shallow() ([]plumbing.Hash, error) {
+	s.g.mu.Lock()
+	defer s.g.mu.Unlock()
+	return s.g.repo.Storer.Shallow()
+}
@@ -0,0 +1,96 @@
+package goget
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cresta/gitdb/internal/gitdb/tracing"
+	"github.com/cresta/gitdb/internal/testhelp"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/stretchr/testify/require"
+)
+
+// newBareUpstream is a bare repository on disk, with a.txt on master, that checkouts can push to
+func newBareUpstream(t *testing.T) string {
+	src := filepath.Join(t.TempDir(), "src")
+	repo, err := git.PlainInit(src, false)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(src, "a.txt"), []byte("hello\n"), 0o600))
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+	require.NoError(t, wt.AddGlob("."))
+	_, err = wt.Commit("first", &git.CommitOptions{Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()}})
+	require.NoError(t, err)
+	upstream := filepath.Join(t.TempDir(), "upstream.git")
+	_, err = git.PlainClone(upstream, true, &git.CloneOptions{URL: src})
+	require.NoError(t, err)
+	return upstream
+}
+
+func TestGitCheckout_Write(t *testing.T) {
+	ctx := context.Background()
+	g := &GitOperator{Log: testhelp.ZapTestingLogger(t), Tracer: tracing.Noop{}}
+	upstream := newBareUpstream(t)
+	clone := func() *GitCheckout {
+		repo, err := git.CloneContext(ctx, memory.NewStorage(), nil, &git.CloneOptions{URL: upstream})
+		require.NoError(t, err)
+		co, err := g.newCheckout(repo, "", upstream, nil)
+		require.NoError(t, err)
+		return co
+	}
+	co := clone()
+	stale := clone()
+	before := plumbing.ComputeHash(plumbing.BlobObject, []byte("hello\n")).String()
+	author := object.Signature{Name: "writer", Email: "writer@example.com", When: time.Now()}
+
+	content := "changed\n"
+	_, err := co.Write(ctx, "master", CommitRequest{
+		Message: "conflicting",
+		Author:  author,
+		Files:   map[string]*string{"a.txt": &content},
+		Expect:  map[string]string{"a.txt": plumbing.ZeroHash.String()},
+	})
+	require.ErrorIs(t, err, ErrPreconditionFailed)
+
+	change, err := co.Write(ctx, "master", CommitRequest{
+		Message: "change a",
+		Author:  author,
+		Files:   map[string]*string{"a.txt": &content},
+		Expect:  map[string]string{"a.txt": before, "b.txt": ""},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"a.txt"}, change.Paths)
+	f, err := co.GetFile(ctx, "master", "a.txt")
+	require.NoError(t, err)
+	var b bytes.Buffer
+	_, err = f.WriteTo(&b)
+	require.NoError(t, err)
+	require.Equal(t, "changed\n", b.String())
+
+	up, err := git.PlainOpen(upstream)
+	require.NoError(t, err)
+	ref, err := up.Reference(plumbing.NewBranchReferenceName("master"), true)
+	require.NoError(t, err)
+	require.Equal(t, change.To, ref.Hash().String())
+	commit, err := up.CommitObject(ref.Hash())
+	require.NoError(t, err)
+	require.Equal(t, "writer", commit.Author.Name)
+	require.Equal(t, "change a", commit.Message)
+
+	// stale has not fetched the commit above
+	other := "other\n"
+	_, err = stale.Write(ctx, "master", CommitRequest{Message: "late", Author: author, Files: map[string]*string{"c.txt": &other}})
+	require.ErrorIs(t, err, ErrPushRejected)
+	_, err = stale.GetFile(ctx, "master", "c.txt")
+	require.ErrorIs(t, err, object.ErrFileNotFound)
+	ref, err = up.Reference(plumbing.NewBranchReferenceName("master"), true)
+	require.NoError(t, err)
+	require.Equal(t, change.To, ref.Hash().String())
+}
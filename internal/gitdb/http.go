@@ -2,6 +2,7 @@ package gitdb
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"errors"
@@ -9,24 +10,54 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
-	jwtmiddleware "github.com/auth0/go-jwt-middleware"
 	"github.com/cresta/gitdb/internal/gitdb/goget"
+	"github.com/cresta/gitdb/internal/gitdb/metrics"
 	"github.com/cresta/gitdb/internal/gitdb/tracing"
 	"github.com/cresta/gitdb/internal/httpserver"
 	"github.com/cresta/gitdb/internal/log"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/go-git/go-git/v5/plumbing/object"
-	"github.com/go-git/go-git/v5/plumbing/transport"
-	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
 	"github.com/gorilla/mux"
+	"github.com/klauspost/compress/zstd"
 	"go.uber.org/zap"
 )
 
 type Config struct {
 	DataDirectory string
 	Repos         []Repository
+	SharedCache   goget.SharedCache
+	// Bytes of file content to keep in memory, keyed by commit and path.  Zero caches nothing.
+	BlobCacheBytes int64
+	// Bytes of gzip compressed text files to keep in memory, served to clients accepting gzip.  Zero serves files
+	// uncompressed.
+	GzipCacheBytes int64
+	// Branch updates to remember for GET /admin/refs.  Zero remembers none.
+	RefJournalSize int
+	// Extra storage backends repositories can select by name, in addition to "disk" and "memory"
+	Storages map[string]goget.Storage
+	// Optional: where handlers and git operations report metrics
+	Metrics metrics.Metrics
+	// Optional: runs refreshes on low priority threads.  Unset refreshes on the caller's goroutine.
+	RefreshPool *goget.RefreshPool
+	// Optional: consulted before serving file, ls, tree, zip, tar, bundle and sqlite requests
+	Authorizer httpserver.Authorizer
+	// Optional: download a bundle of each repository from here instead of cloning it upstream, like a peer's
+	// "http://gitdb-0.gitdb:8080/bundle/{repo}" or a backup location.  {repo} is replaced by the repository key.
+	BootstrapURL string
+	// Defaults to http.DefaultClient
+	BootstrapClient *http.Client
+	// Optional: persists the repositories once the admin API adds or removes one.  Unset, changes last until restart.
+	SaveRepos func(ctx context.Context, repos []Repository) error
+	// Optional: most bytes a zip or tarball may have.  Archives are streamed, so a larger one is cut off.  Zero is
+	// unlimited.
+	MaxArchiveBytes int64
+	// Optional: how repositories without an Alias are keyed from their URL.  Defaults to RepoKeyName.
+	RepoKeyNaming RepoKeyNaming
 }
 
 type Repository struct {
@@ -34,93 +65,354 @@ type Repository struct {
 	PrivateKey             string
 	PrivateKeyPassword     string
 	PrivateKeyPasswordFile string
-	Alias                  string
-	Public                 bool
+	// Optional, for https:// URLs: the basic auth username.  Defaults to "git" when only a password or token is set,
+	// which GitHub and GitLab accept with personal access tokens.
+	Username string
+	// Optional, for https:// URLs: the basic auth password or personal access token.  At most one of Password,
+	// PasswordFile and TokenEnvVar may be set.
+	Password string
+	// Optional: read the password or token from this file, like a mounted secret.  It is read again on key rotation.
+	PasswordFile string
+	// Optional: read the password or token from this environment variable, like "GITHUB_TOKEN"
+	TokenEnvVar string
+	// Optional, for https:// URLs: authenticate as an installation of this GitHub App instead, minting installation
+	// tokens as they expire.  Needs GitHubAppInstallationID and GitHubAppPrivateKey.
+	GitHubAppID             int64
+	GitHubAppInstallationID int64
+	// Path to the GitHub App's PEM private key
+	GitHubAppPrivateKey string
+	// Optional: the API of a GitHub Enterprise server minting GitHub App tokens, like
+	// "https://github.example.com/api/v3/"
+	GitHubAPIURL string
+	Alias        string
+	Public       bool
+	// Optional: with Public, serve the /public routes for this repository without any token
+	Anonymous bool
+	// Optional: static headers, like X-Robots-Tag or Cache-Control, added to every response for this repository
+	ResponseHeaders map[string]string
+	// Optional: how long to keep serving a branch after it is deleted upstream, like "24h".  Responses for it carry
+	// X-Gitdb-Branch-Deleted.  Unset never prunes deleted branches.
+	DeletedBranchGracePeriod string
+	// Optional: redirect large files and zips to a mirror of the repository in S3
+	S3Redirect *S3RedirectConfig
+	// Optional: keep a checked out working tree of this branch under DataDirectory, updated on refresh
+	WorkTreeBranch string
+	// Optional: how many expensive reads (zip, tar, tree, bundle, sqlite) may run at once for this repository
+	MaxConcurrentReads int
+	// Endpoints, like "zip", that are turned off for this repository
+	DisabledEndpoints []string
+	// Where the clone lives: "disk" (the default), "memory", or a name from Config.Storages
+	Storage string
+	// Optional: bytes of decoded git objects go-git keeps in memory for this clone.  Defaults to go-git's 96MiB.  Only
+	// storages implementing goget.ObjectCacheStorage, like "disk", support it.
+	ObjectCacheBytes int64
+	// Optional: only fetch these refspecs, like "+refs/heads/main" and "refs/tags/*", instead of every branch and tag
+	FetchRefSpecs []string
+	// Optional: only fetch these branches, like "main", in addition to any FetchRefSpecs
+	Branches []string
+	// Optional: only fetch the remote's default branch.  Cannot be combined with FetchRefSpecs or Branches.
+	SingleBranch bool
+	// Optional: only fetch this many commits of each branch's history, so large repositories clone quickly.  Commits,
+	// logs and diffs cannot reach past it.
+	Depth int
+	// Optional: fetch a branch that is not known yet when it is read, instead of answering 404 until the next refresh.
+	// A branch still missing upstream is not fetched again for 30 seconds.
+	FetchOnMiss bool
+	// Optional: once the last refresh is older than this, like "30s", content requests start a background refresh and
+	// are served the current content meanwhile.  Responses carry a matching Cache-Control max-age and
+	// stale-while-revalidate, so CDNs also serve stale content while revalidating.
+	RevalidateAfter string
+	// Optional: UTC windows, like "01:00-03:00" or "Sat,Sun 22:00-02:00", when background refreshes are paused.
+	// Webhook and API refreshes still run.
+	MaintenanceWindows []string
+	// Optional: other keys that also serve this repository, like a key it was renamed from.  Responses through them
+	// carry Deprecation and Sunset headers.
+	DeprecatedAliases []DeprecatedAlias
+	// Optional, for development: serve this local directory instead of cloning URL, which may then be empty.  A git
+	// repository is served in place, with its local branches as branches.  Any other directory is served as it is on
+	// disk under every branch name, without history.  The key defaults to the directory's name.
+	LocalPath string
 }
 
+const (
+	StorageDisk   = "disk"
+	StorageMemory = "memory"
+)
+
 func NewHandler(logger *log.Logger, cfg Config, tracer tracing.Tracing) (*CheckoutHandler, error) {
 	logger.Info(context.Background(), "setting up git server")
-	g := goget.GitOperator{
-		Log:    logger,
-		Tracer: tracer,
+	if err := cfg.RepoKeyNaming.validate(); err != nil {
+		return nil, err
+	}
+	cloneTracker := &goget.CloneTracker{}
+	refJournal := goget.NewRefJournal(cfg.RefJournalSize)
+	g := &goget.GitOperator{
+		Log:          logger,
+		Tracer:       tracer,
+		SharedCache:  cfg.SharedCache,
+		BlobCache:    goget.NewBlobCache(cfg.BlobCacheBytes),
+		GzipCache:    goget.NewGzipCache(cfg.GzipCacheBytes),
+		CloneTracker: cloneTracker,
+		RefJournal:   refJournal,
+		Metrics:      cfg.Metrics,
+		RefreshPool:  cfg.RefreshPool,
 	}
 	dataDir := cfg.DataDirectory
 	if dataDir == "" {
 		dataDir = os.TempDir()
 	}
-	gitCheckouts := make(map[string]*goget.GitCheckout)
-	checkoutConfigs := make(map[string]Repository)
-	ctx := context.Background()
-	for idx, repo := range cfg.Repos {
+	storages := map[string]goget.Storage{
+		StorageDisk:   &goget.DiskStorage{Directory: dataDir},
+		StorageMemory: goget.MemoryStorage{},
+	}
+	for name, s := range cfg.Storages {
+		storages[name] = s
+	}
+	setup := &repoSetup{
+		cfg:      cfg,
+		g:        g,
+		storages: storages,
+		dataDir:  dataDir,
+		tracer:   tracer,
+		log:      logger,
+	}
+	state, err := setup.build(context.Background(), cfg.Repos, nil)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info(context.Background(), "repos loaded", zap.Int("num_keys", len(cfg.Repos)))
+	ret := &CheckoutHandler{
+		dataDirectory:   dataDir,
+		cloneTracker:    cloneTracker,
+		refJournal:      refJournal,
+		authorizer:      cfg.Authorizer,
+		metrics:         metrics.OrNoop(cfg.Metrics),
+		Log:             logger.With(zap.String("class", "checkout_handler")),
+		setup:           setup,
+		maxArchiveBytes: cfg.MaxArchiveBytes,
+		repoKeyNaming:   cfg.RepoKeyNaming,
+	}
+	ret.install(state)
+	return ret, nil
+}
+
+// repoState is what the handler serves for each repository key.  Reload replaces it whole rather than modifying it.
+type repoState struct {
+	// As configured, in order
+	repos             []Repository
+	checkouts         map[string]Checkout
+	configs           map[string]Repository
+	readSemaphores    map[string]chan struct{}
+	s3Redirectors     map[string]*s3Redirector
+	revalidateAfter   map[string]time.Duration
+	deprecatedAliases map[string]deprecatedAlias
+}
+
+// repoSetup opens the repositories of a config, at startup and on every Reload
+type repoSetup struct {
+	cfg      Config
+	g        *goget.GitOperator
+	storages map[string]goget.Storage
+	dataDir  string
+	tracer   tracing.Tracing
+	log      *log.Logger
+}
+
+// withObjectCache returns st with an object cache sized for repo, counting hits and misses.  st is returned as is if it
+// has no object cache.
+func (s *repoSetup) withObjectCache(repo Repository, st goget.Storage, remoteURL string) (goget.Storage, error) {
+	if repo.ObjectCacheBytes < 0 {
+		return nil, fmt.Errorf("ObjectCacheBytes must not be negative")
+	}
+	cacheStorage, ok := st.(goget.ObjectCacheStorage)
+	if strings.TrimSpace(repo.LocalPath) != "" || !ok {
+		if repo.ObjectCacheBytes != 0 {
+			return nil, fmt.Errorf("ObjectCacheBytes needs a clone in a storage with an object cache, like disk")
+		}
+		return st, nil
+	}
+	size := repo.ObjectCacheBytes
+	if size == 0 {
+		size = goget.DefaultObjectCacheBytes
+	}
+	return goget.WithObjectCache(cacheStorage, goget.NewObjectCache(size, s.cfg.Metrics, remoteURL)), nil
+}
+
+// build sets up every repository in repos.  Checkouts in current are kept for repositories whose clone settings did not
+// change, rotating their credentials if those changed.  Everything else is cloned or opened.
+func (s *repoSetup) build(ctx context.Context, repos []Repository, current *repoState) (*repoState, error) {
+	logger := s.log
+	ret := &repoState{
+		repos:             repos,
+		checkouts:         make(map[string]Checkout),
+		configs:           make(map[string]Repository),
+		readSemaphores:    make(map[string]chan struct{}),
+		s3Redirectors:     make(map[string]*s3Redirector),
+		revalidateAfter:   make(map[string]time.Duration),
+		deprecatedAliases: make(map[string]deprecatedAlias),
+	}
+	keys, err := repoKeys(repos, s.cfg.RepoKeyNaming)
+	if err != nil {
+		return nil, err
+	}
+	for idx, repo := range repos {
 		trimmedRepoURL := strings.TrimSpace(repo.URL)
+		localPath := strings.TrimSpace(repo.LocalPath)
 		if trimmedRepoURL == "" {
-			return nil, fmt.Errorf("unable to find URL for repo index %d", idx)
+			trimmedRepoURL = localPath
 		}
-		cloneInto, err := os.MkdirTemp(dataDir, "gitdb_repo_"+sanitizeDir(trimmedRepoURL))
+		if trimmedRepoURL == "" {
+			return nil, fmt.Errorf("unable to find URL or LocalPath for repo index %d", idx)
+		}
+		if err := validateDisabledEndpoints(repo); err != nil {
+			return nil, fmt.Errorf("invalid config for repo %s: %w", trimmedRepoURL, err)
+		}
+		cloneOpts, err := repo.cloneOptions()
 		if err != nil {
-			return nil, fmt.Errorf("unable to make temp dir for %s,%s: %w", dataDir, "gitdb_repo_"+sanitizeDir(trimmedRepoURL), err)
+			return nil, fmt.Errorf("invalid config for repo %s: %w", trimmedRepoURL, err)
 		}
 		authMethod, err := getAuthMethod(repo)
 		if err != nil {
-			return nil, fmt.Errorf("unable to load private key: %w", err)
+			return nil, fmt.Errorf("unable to load credentials for repo %s: %w", trimmedRepoURL, err)
+		}
+		storageName := repo.Storage
+		if storageName == "" {
+			storageName = StorageDisk
+		}
+		st, exists := s.storages[storageName]
+		if !exists {
+			return nil, fmt.Errorf("unknown storage %s for repo %s", repo.Storage, trimmedRepoURL)
 		}
-		co, err := g.Clone(ctx, cloneInto, trimmedRepoURL, authMethod)
+		st, err = s.withObjectCache(repo, st, trimmedRepoURL)
 		if err != nil {
-			return nil, fmt.Errorf("unable to clone repo %s: %w", trimmedRepoURL, err)
+			return nil, fmt.Errorf("invalid config for repo %s: %w", trimmedRepoURL, err)
 		}
-		repoKey := repo.Alias
+		repoKey := keys[idx]
 		if repoKey == "" {
-			repoKey = getRepoKey(trimmedRepoURL)
+			return nil, fmt.Errorf("unable to key repo %s from its URL: set an Alias", trimmedRepoURL)
+		}
+		co, reused := current.reusable(repoKey, repo)
+		switch {
+		case reused:
+			if localPath == "" && current.authChanged(repoKey, repo) {
+				if err := co.RotateAuth(ctx, authMethod); err != nil {
+					return nil, fmt.Errorf("unable to rotate credentials for repo %s: %w", trimmedRepoURL, err)
+				}
+			}
+		case localPath != "":
+			co, err = openLocal(ctx, s.g, localPath)
+			if err != nil {
+				return nil, fmt.Errorf("unable to open local repo %s: %w", localPath, err)
+			}
+		default:
+			co, err = s.cfg.setupClone(ctx, s.g, st, repo, repoKey, trimmedRepoURL, authMethod, cloneOpts, s.dataDir, logger)
+			if err != nil {
+				return nil, err
+			}
+		}
+		ret.checkouts[repoKey] = co
+		ret.configs[repoKey] = repo
+		if repo.MaxConcurrentReads > 0 {
+			// Reads in flight keep counting against an unchanged limit
+			if sem, exists := current.readSemaphore(repoKey); exists && cap(sem) == repo.MaxConcurrentReads {
+				ret.readSemaphores[repoKey] = sem
+			} else {
+				ret.readSemaphores[repoKey] = make(chan struct{}, repo.MaxConcurrentReads)
+			}
+		}
+		if repo.S3Redirect != nil {
+			redirector, err := newS3Redirector(*repo.S3Redirect, s.tracer, logger)
+			if err != nil {
+				return nil, fmt.Errorf("invalid S3Redirect for repo %s: %w", trimmedRepoURL, err)
+			}
+			ret.s3Redirectors[repoKey] = redirector
+		}
+		if repo.RevalidateAfter != "" {
+			age, err := time.ParseDuration(repo.RevalidateAfter)
+			if err != nil {
+				return nil, fmt.Errorf("invalid RevalidateAfter for repo %s: %w", trimmedRepoURL, err)
+			}
+			ret.revalidateAfter[repoKey] = age
+		}
+		for _, a := range repo.DeprecatedAliases {
+			alias, err := parseDeprecatedAlias(a, repoKey)
+			if err != nil {
+				return nil, fmt.Errorf("invalid DeprecatedAliases for repo %s: %w", trimmedRepoURL, err)
+			}
+			if _, exists := ret.deprecatedAliases[a.Name]; exists {
+				return nil, fmt.Errorf("invalid DeprecatedAliases for repo %s: alias %s used twice", trimmedRepoURL, a.Name)
+			}
+			ret.deprecatedAliases[a.Name] = alias
+		}
+		if !reused {
+			logger.Info(context.Background(), "setup checkout", zap.String("repo", trimmedRepoURL), zap.String("key", repoKey), zap.String("into", co.AbsPath()), zap.String("storage", storageName))
 		}
-		gitCheckouts[repoKey] = co
-		checkoutConfigs[repoKey] = repo
-		logger.Info(context.Background(), "setup checkout", zap.String("repo", trimmedRepoURL), zap.String("key", repoKey), zap.String("into", cloneInto))
 	}
-	logger.Info(context.Background(), "repos loaded", zap.Int("num_keys", len(cfg.Repos)))
-	ret := &CheckoutHandler{
-		Checkouts:       gitCheckouts,
-		checkoutConfigs: checkoutConfigs,
-		Log:             logger.With(zap.String("class", "checkout_handler")),
+	for name, alias := range ret.deprecatedAliases {
+		if _, exists := ret.checkouts[name]; exists {
+			return nil, fmt.Errorf("deprecated alias %s of %s is already a repo key", name, alias.repoKey)
+		}
 	}
 	return ret, nil
 }
 
 type CheckoutHandler struct {
-	Checkouts       map[string]*goget.GitCheckout
+	// Keyed by repository key.  Read it with checkout or checkouts, since Reload replaces it.
+	Checkouts       map[string]Checkout
 	Log             *log.Logger
+	repos           []Repository
 	checkoutConfigs map[string]Repository
+	dataDirectory   string
+	cloneTracker    *goget.CloneTracker
+	refJournal      *goget.RefJournal
+	readSemaphores  map[string]chan struct{}
+	s3Redirectors   map[string]*s3Redirector
+	revalidateAfter map[string]time.Duration
+	// Keyed by alias
+	deprecatedAliases map[string]deprecatedAlias
+	// Guards the maps above, which are never modified once set: Reload replaces them whole
+	mu sync.RWMutex
+	// Set by NewHandler, for Reload
+	setup    *repoSetup
+	reloadMu sync.Mutex
+	// Called after each reload.  Guarded by reloadMu.
+	onReload   []func(checkoutsByRepo map[string]Checkout)
+	authorizer httpserver.Authorizer
+	metrics    metrics.Metrics
+	// Set once the /public routes are served with JWT auth
+	publicJWT bool
+	// Set once the /public routes are served at all
+	publicRoutes bool
+	// Zero is unlimited
+	maxArchiveBytes int64
+	repoKeyNaming   RepoKeyNaming
 }
 
-func (h *CheckoutHandler) CheckoutsByRepo() map[string]*goget.GitCheckout {
-	ret := make(map[string]*goget.GitCheckout)
-	for _, c := range h.Checkouts {
+func (h *CheckoutHandler) CheckoutsByRepo() map[string]Checkout {
+	ret := make(map[string]Checkout)
+	for _, c := range h.checkouts() {
 		ret[c.RemoteURL()] = c
 	}
 	return ret
 }
 
+// SetupPublicJWTHandler serves Public repositories under /public, with a JWT checked by keyFunc unless the repository
+// is Anonymous.  With a nil keyFunc only Anonymous repositories are served.
 func (h *CheckoutHandler) SetupPublicJWTHandler(muxRouter *mux.Router, keyFunc jwt.Keyfunc, repos []Repository) {
 	if noPublicRepos(repos) {
 		return
 	}
-	middleware := jwtmiddleware.New(jwtmiddleware.Options{
-		ValidationKeyGetter: keyFunc,
-		SigningMethod:       jwt.SigningMethodRS256,
-		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err string) {
-			resp := httpserver.BasicResponse{
-				Code:    http.StatusUnauthorized,
-				Msg:     strings.NewReader(err),
-				Headers: nil,
-			}
-			h.Log.Warn(r.Context(), "error during JWT", zap.String("err_string", err))
-			resp.HTTPWrite(r.Context(), w, h.Log)
-		},
-	})
+	if keyFunc == nil && noAnonymousRepos(repos) {
+		return
+	}
+	h.publicJWT = keyFunc != nil
+	h.publicRoutes = true
+	middleware := httpserver.JWTMiddleware(keyFunc, h.Log)
 	publicRepoMiddleware := func(root http.Handler) http.Handler {
 		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
 			vars := mux.Vars(request)
 			repo := vars["repo"]
-			if repoCfg, exists := h.checkoutConfigs[repo]; !exists {
+			if repoCfg, exists := h.repoConfig(repo); !exists {
 				writer.WriteHeader(http.StatusNotFound)
 				return
 			} else if !repoCfg.Public {
@@ -131,10 +423,65 @@ func (h *CheckoutHandler) SetupPublicJWTHandler(muxRouter *mux.Router, keyFunc j
 			root.ServeHTTP(writer, request)
 		})
 	}
+	jwtMiddleware := func(root http.Handler) http.Handler {
+		withJWT := middleware(root)
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			repo := mux.Vars(request)["repo"]
+			if repoCfg, _ := h.repoConfig(repo); repoCfg.Anonymous {
+				root.ServeHTTP(writer, request)
+				return
+			}
+			if keyFunc == nil {
+				h.Log.Warn(request.Context(), "no JWT public key for non anonymous repo", zap.String("repo", repo))
+				resp := httpserver.BasicResponse{
+					Code: http.StatusNotFound,
+					Msg:  strings.NewReader(fmt.Sprintf("unable to find repo %s", repo)),
+				}
+				resp.HTTPWrite(request.Context(), writer, h.Log)
+				return
+			}
+			withJWT.ServeHTTP(writer, request)
+		})
+	}
 
-	muxRouter.Methods(http.MethodGet).Path("/public/file/{repo}/{branch}/{path:.*}").Handler(publicRepoMiddleware(middleware.Handler(httpserver.BasicHandler(h.getFileHandler, h.Log)))).Name("public_get_file_handler")
-	muxRouter.Methods(http.MethodGet).Path("/public/ls/{repo}/{branch}/{dir:.*}").Handler(publicRepoMiddleware(middleware.Handler(httpserver.BasicHandler(h.lsDirHandler, h.Log)))).Name("public_ls_dir_handler")
-	muxRouter.Methods(http.MethodGet).Path("/public/zip/{repo}/{branch}/{dir:.*}").Handler(publicRepoMiddleware(middleware.Handler(httpserver.BasicHandler(h.zipDirHandler, h.Log)))).Name("public_zip_dir_handler")
+	muxRouter.Methods(http.MethodGet).Path("/public/file/{repo}/{branch}/{path:.*}").Handler(publicRepoMiddleware(jwtMiddleware(h.jwtScope("path", httpserver.BasicHandler(h.endpointGate(EndpointFile, h.getFileHandler), h.Log))))).Name("public_get_file_handler")
+	muxRouter.Methods(http.MethodGet).Path("/public/ls/{repo}/{branch}/{dir:.*}").Handler(publicRepoMiddleware(jwtMiddleware(h.jwtScope("dir", httpserver.BasicHandler(h.endpointGate(EndpointLs, h.lsDirHandler), h.Log))))).Name("public_ls_dir_handler")
+	// Limited inside the token check rather than by a route chain, so requests without a valid token take no read slot
+	muxRouter.Methods(http.MethodGet).Path("/public/zip/{repo}/{branch}/{dir:.*}").Handler(publicRepoMiddleware(jwtMiddleware(h.jwtScope("dir", h.limitReads(httpserver.BasicHandler(h.endpointGate(EndpointZip, h.zipDirHandler), h.Log)))))).Name("public_zip_dir_handler")
+	muxRouter.Methods(http.MethodGet).Path("/public/tar/{repo}/{branch}/{dir:.*}").Handler(publicRepoMiddleware(jwtMiddleware(h.jwtScope("dir", h.limitReads(httpserver.BasicHandler(h.endpointGate(EndpointTar, h.tarDirHandler), h.Log)))))).Name("public_tar_dir_handler")
+}
+
+// jwtScope rejects requests outside the repository or path prefix of a scoped token.  pathVar is the mux variable
+// holding the requested path.  Zips of several dirs are checked against the parent dir, which contains them all.
+func (h *CheckoutHandler) jwtScope(pathVar string, root http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		token, ok := request.Context().Value("user").(*jwt.Token)
+		if !ok {
+			root.ServeHTTP(writer, request)
+			return
+		}
+		claims, err := httpserver.ScopedClaimsFromToken(token)
+		vars := mux.Vars(request)
+		if err != nil || !claims.Allows(vars["repo"], vars[pathVar]) {
+			h.Log.Warn(request.Context(), "token scope does not allow path", zap.String("repo", vars["repo"]), zap.String("path", vars[pathVar]), zap.Error(err))
+			resp := httpserver.BasicResponse{
+				Code: http.StatusForbidden,
+				Msg:  strings.NewReader("token does not allow this path"),
+			}
+			resp.HTTPWrite(request.Context(), writer, h.Log)
+			return
+		}
+		root.ServeHTTP(writer, request)
+	})
+}
+
+func noAnonymousRepos(repos []Repository) bool {
+	for _, repo := range repos {
+		if repo.Public && repo.Anonymous {
+			return false
+		}
+	}
+	return true
 }
 
 func noPublicRepos(repos []Repository) bool {
@@ -147,16 +494,30 @@ func noPublicRepos(repos []Repository) bool {
 }
 
 func (h *CheckoutHandler) SetupMux(mux *mux.Router) {
-	mux.Methods(http.MethodGet).Path("/file/{repo}/{branch}/{path:.*}").Handler(httpserver.BasicHandler(h.getFileHandler, h.Log)).Name("get_file_handler")
-	mux.Methods(http.MethodGet).Path("/ls/{repo}/{branch}/{dir:.*}").Handler(httpserver.BasicHandler(h.lsDirHandler, h.Log)).Name("ls_dir_handler")
-	mux.Methods(http.MethodGet).Path("/zip/{repo}/{branch}/{dir:.*}").Handler(httpserver.BasicHandler(h.zipDirHandler, h.Log)).Name("zip_dir_handler")
+	mux.Methods(http.MethodGet).Path("/file/{repo}/{branch}/{path:.*}").Handler(httpserver.BasicHandler(h.endpointGate(EndpointFile, h.getFileHandler), h.Log)).Name("get_file_handler")
+	mux.Methods(http.MethodGet).Path("/ls/{repo}/{branch}/{dir:.*}").Handler(httpserver.BasicHandler(h.endpointGate(EndpointLs, h.lsDirHandler), h.Log)).Name("ls_dir_handler")
+	mux.Methods(http.MethodGet).Path("/tree/{repo}/{branch}/{dir:.*}").Handler(httpserver.BasicHandler(h.endpointGate(EndpointTree, h.treeHandler), h.Log)).Name("tree_handler")
+	mux.Methods(http.MethodGet).Path("/zip/{repo}/{branch}/{dir:.*}").Handler(httpserver.BasicHandler(h.endpointGate(EndpointZip, h.zipDirHandler), h.Log)).Name("zip_dir_handler")
+	mux.Methods(http.MethodPost).Path("/batch/{repo}/{branch}").Handler(httpserver.BasicHandler(h.endpointGate(EndpointFile, h.batchHandler), h.Log)).Name("batch_handler")
+	mux.Methods(http.MethodGet).Path("/tar/{repo}/{branch}/{dir:.*}").Handler(httpserver.BasicHandler(h.endpointGate(EndpointTar, h.tarDirHandler), h.Log)).Name("tar_dir_handler")
+	mux.Methods(http.MethodGet).Path("/bundle/{repo}").Handler(httpserver.BasicHandler(h.endpointGate(EndpointBundle, h.bundleAllHandler), h.Log)).Name("bundle_all_handler")
+	mux.Methods(http.MethodGet).Path("/bundle/{repo}/{branch}").Handler(httpserver.BasicHandler(h.endpointGate(EndpointBundle, h.bundleHandler), h.Log)).Name("bundle_handler")
+	mux.Methods(http.MethodGet).Path("/sqlite/{repo}/{branch}").Handler(httpserver.BasicHandler(h.endpointGate(EndpointSqlite, h.sqliteHandler), h.Log)).Name("sqlite_handler")
+	mux.Methods(http.MethodGet).Path("/commit/{repo}/{branch}").Handler(httpserver.BasicHandler(h.endpointGate(EndpointCommits, h.commitHandler), h.Log)).Name("commit_handler")
+	mux.Methods(http.MethodGet).Path("/commits/{repo}/{branch}").Handler(httpserver.BasicHandler(h.endpointGate(EndpointCommits, h.commitsHandler), h.Log)).Name("commits_handler")
+	mux.Methods(http.MethodGet).Path("/log/{repo}/{branch}/{path:.*}").Handler(httpserver.BasicHandler(h.endpointGate(EndpointLog, h.logHandler), h.Log)).Name("log_handler")
+	mux.Methods(http.MethodGet).Path("/diff/{repo}/{from}/{to}").Handler(httpserver.BasicHandler(h.endpointGate(EndpointDiff, h.diffHandler), h.Log)).Name("diff_handler")
+	mux.Methods(http.MethodGet).Path("/snapshot/{repo}/{branch}").Handler(httpserver.BasicHandler(h.endpointGate(EndpointSnapshot, h.snapshotHandler), h.Log)).Name("snapshot_handler")
+	mux.Methods(http.MethodGet).Path("/changes/{repo}/{branch}").Handler(httpserver.BasicHandler(h.endpointGate(EndpointChanges, h.changesHandler), h.Log)).Name("changes_handler")
+	mux.Methods(http.MethodGet).Path("/repos").Handler(httpserver.BasicHandler(h.endpointGate(EndpointRepos, h.reposHandler), h.Log)).Name("repos_handler")
 	mux.Methods(http.MethodPost).Path("/refresh/{repo}").Handler(httpserver.BasicHandler(h.refreshRepoHandler, h.Log)).Name("refresh_repo")
+	mux.Methods(http.MethodPost).Path("/refresh").Handler(httpserver.BasicHandler(h.refreshReposHandler, h.Log)).Name("refresh_repos")
 	mux.Methods(http.MethodPost).Path("/refreshall").Handler(httpserver.BasicHandler(h.refreshAllRepoHandler, h.Log)).Name("refresh_all")
 }
 
 func (h *CheckoutHandler) refreshAllRepoHandler(req *http.Request) httpserver.CanHTTPWrite {
-	for repoName, repo := range h.Checkouts {
-		if err := repo.Refresh(req.Context()); err != nil {
+	for repoName, repo := range h.checkouts() {
+		if err := repo.Refresh(goget.WithTrigger(req.Context(), goget.TriggerManual)); err != nil {
 			return &httpserver.BasicResponse{
 				Code: http.StatusInternalServerError,
 				Msg:  strings.NewReader(fmt.Sprintf("unable to refresh %s: %v", repoName, err)),
@@ -172,14 +533,14 @@ func (h *CheckoutHandler) refreshAllRepoHandler(req *http.Request) httpserver.Ca
 func (h *CheckoutHandler) refreshRepoHandler(req *http.Request) httpserver.CanHTTPWrite {
 	vars := mux.Vars(req)
 	repo := vars["repo"]
-	r, exists := h.Checkouts[repo]
+	r, exists := h.checkout(repo)
 	if !exists {
 		return &httpserver.BasicResponse{
 			Code: http.StatusNotFound,
 			Msg:  strings.NewReader(fmt.Sprintf("unknown repo %s", repo)),
 		}
 	}
-	err := r.Refresh(req.Context())
+	err := r.Refresh(goget.WithTrigger(req.Context(), goget.TriggerManual))
 	if err != nil {
 		return &httpserver.BasicResponse{
 			Code: http.StatusInternalServerError,
@@ -206,7 +567,7 @@ func (h *CheckoutHandler) getFileHandler(req *http.Request) httpserver.CanHTTPWr
 			Msg:  strings.NewReader(fmt.Sprintf("One unset{repo: %s, branch: %s, path: %s}", repo, branch, path)),
 		}
 	}
-	return h.getFile(req.Context(), repo, branch, path, logger)
+	return h.getFile(req, repo, branch, path, logger)
 }
 
 func (h *CheckoutHandler) lsDirHandler(req *http.Request) httpserver.CanHTTPWrite {
@@ -223,38 +584,67 @@ func (h *CheckoutHandler) lsDirHandler(req *http.Request) httpserver.CanHTTPWrit
 			Msg:  strings.NewReader(fmt.Sprintf("One unset{repo: %s, branch: %s}", repo, branch)),
 		}
 	}
-	r, exists := h.Checkouts[repo]
+	r, exists := h.checkout(repo)
 	if !exists {
 		buf := strings.NewReader(fmt.Sprintf("unable to find repo %s", repo))
 		logger.Warn(req.Context(), "invalid repo")
 		return &httpserver.BasicResponse{Code: http.StatusNotFound, Msg: buf}
 	}
-	stat, err := r.LsDir(req.Context(), dir, branch)
-	if err != nil {
-		if errors.Is(err, goget.ErrUnknownBranch) {
+	var modified map[string]time.Time
+	if m := req.URL.Query().Get("modified"); m != "" {
+		withModified, err := strconv.ParseBool(m)
+		if err != nil {
 			return &httpserver.BasicResponse{
-				Code: http.StatusNotFound,
-				Msg:  strings.NewReader(fmt.Sprintf("branch not found %s", branch)),
+				Code: http.StatusBadRequest,
+				Msg:  strings.NewReader(fmt.Sprintf("invalid modified %s: %v", m, err)),
 			}
 		}
-		if errors.Is(err, object.ErrDirectoryNotFound) {
-			return &httpserver.BasicResponse{
-				Code: http.StatusNotFound,
-				Msg:  strings.NewReader(fmt.Sprintf("directory not found %s", dir)),
+		if withModified {
+			if modified, err = r.LastModified(req.Context(), dir, branch); err != nil {
+				return listingError(req.Context(), err, branch, dir, logger)
 			}
 		}
-		logger.Warn(req.Context(), "unable to list path", zap.Error(err))
-		return &httpserver.BasicResponse{
-			Code: http.StatusInternalServerError,
-			Msg:  strings.NewReader(fmt.Sprintf("unable to list path %s: %v", dir, err)),
+	}
+	contentType := httpserver.NegotiateContentType(req, "application/json", "text/plain", "application/x-ndjson")
+	if contentType == "application/x-ndjson" {
+		s := streamListing(r, dir, branch, false, logger)
+		s.modified = modified
+		return s
+	}
+	stat, err := r.LsDir(req.Context(), dir, branch)
+	if err != nil {
+		return listingError(req.Context(), err, branch, dir, logger)
+	}
+	setLastModified(stat, modified)
+	headers := map[string]string{
+		"Content-Type": contentType,
+		"Vary":         "Accept",
+	}
+	// Only the plain JSON listing is exactly described by the tree, so only it is cached by hash, like by a proxy
+	if contentType == "application/json" && modified == nil {
+		hash, err := listingHash(stat)
+		if err != nil {
+			logger.Warn(req.Context(), "unable to hash listing", zap.Error(err))
+		} else {
+			headers["ETag"] = `"` + hash + `"`
+			if httpserver.ETagMatches(req, headers["ETag"]) {
+				delete(headers, "Content-Type")
+				return &httpserver.BasicResponse{
+					Code:    http.StatusNotModified,
+					Msg:     strings.NewReader(""),
+					Headers: headers,
+				}
+			}
 		}
 	}
+	var body io.WriterTo = FileStatArr(stat)
+	if contentType == "text/plain" {
+		body = FileStatNames(stat)
+	}
 	return &httpserver.BasicResponse{
-		Code: http.StatusOK,
-		Msg:  FileStatArr(stat),
-		Headers: map[string]string{
-			"Content-Type": "application/json",
-		},
+		Code:    http.StatusOK,
+		Msg:     body,
+		Headers: headers,
 	}
 }
 
@@ -272,39 +662,132 @@ func (h *CheckoutHandler) zipDirHandler(req *http.Request) httpserver.CanHTTPWri
 			Msg:  strings.NewReader(fmt.Sprintf("One unset{repo: %s, branch: %s}", repo, branch)),
 		}
 	}
-	r, exists := h.Checkouts[repo]
+	r, exists := h.checkout(repo)
+	if !exists {
+		buf := strings.NewReader(fmt.Sprintf("unable to find repo %s", repo))
+		logger.Warn(req.Context(), "invalid repo")
+		return &httpserver.BasicResponse{Code: http.StatusNotFound, Msg: buf}
+	}
+	prefixes, err := zipPrefixes(req, dir)
+	if err != nil {
+		return &httpserver.BasicResponse{
+			Code: http.StatusBadRequest,
+			Msg:  strings.NewReader(err.Error()),
+		}
+	}
+	if redirect := h.zipRedirect(req, repo, branch, dir); redirect != nil {
+		logger.Debug(req.Context(), "redirecting to S3")
+		return redirect
+	}
+	return &streamedArchive{
+		write: func(ctx context.Context, w io.Writer) (int, error) {
+			return r.ZipContents(ctx, w, prefixes, branch)
+		},
+		contentType: "application/zip",
+		branch:      branch,
+		dir:         dir,
+		maxBytes:    h.maxArchiveBytes,
+		metrics:     h.metrics,
+		tags:        metrics.Tags{"repo": repo, "format": "zip"},
+		log:         logger,
+	}
+}
+
+// tarDirHandler is zipDirHandler for consumers that want a .tar.gz, or a .tar.zst when they Accept application/zstd.
+// It takes the same ?dirs and ?naming.
+func (h *CheckoutHandler) tarDirHandler(req *http.Request) httpserver.CanHTTPWrite {
+	vars := mux.Vars(req)
+	repo := vars["repo"]
+	branch := vars["branch"]
+	dir := vars["dir"]
+	logger := h.Log.With(zap.String("repo", repo), zap.String("branch", branch), zap.String("dir", dir))
+	logger.Debug(req.Context(), "tar dir handler")
+	r, exists := h.checkout(repo)
+	if !exists {
+		buf := strings.NewReader(fmt.Sprintf("unable to find repo %s", repo))
+		logger.Warn(req.Context(), "invalid repo")
+		return &httpserver.BasicResponse{Code: http.StatusNotFound, Msg: buf}
+	}
+	prefixes, err := zipPrefixes(req, dir)
+	if err != nil {
+		return &httpserver.BasicResponse{
+			Code: http.StatusBadRequest,
+			Msg:  strings.NewReader(err.Error()),
+		}
+	}
+	contentType := httpserver.NegotiateContentType(req, "application/gzip", "application/zstd")
+	format := "tar"
+	if contentType == "application/zstd" {
+		format = "tar.zst"
+	}
+	return &streamedArchive{
+		write: func(ctx context.Context, w io.Writer) (int, error) {
+			compressed, err := tarCompressor(w, contentType)
+			if err != nil {
+				return 0, fmt.Errorf("unable to compress tar: %w", err)
+			}
+			numFiles, err := r.Tar(ctx, compressed, prefixes, branch)
+			if err != nil || numFiles == 0 {
+				return numFiles, err
+			}
+			if err := compressed.Close(); err != nil {
+				return numFiles, fmt.Errorf("unable to compress tar: %w", err)
+			}
+			return numFiles, nil
+		},
+		contentType: contentType,
+		headers:     map[string]string{"Vary": "Accept"},
+		branch:      branch,
+		dir:         dir,
+		maxBytes:    h.maxArchiveBytes,
+		metrics:     h.metrics,
+		tags:        metrics.Tags{"repo": repo, "format": format},
+		log:         logger,
+	}
+}
+
+// tarCompressor compresses a tarball into contentType, which tarDirHandler negotiated
+func tarCompressor(into io.Writer, contentType string) (io.WriteCloser, error) {
+	if contentType == "application/zstd" {
+		return zstd.NewWriter(into)
+	}
+	return gzip.NewWriter(into), nil
+}
+
+func (h *CheckoutHandler) bundleHandler(req *http.Request) httpserver.CanHTTPWrite {
+	vars := mux.Vars(req)
+	repo := vars["repo"]
+	branch := vars["branch"]
+	logger := h.Log.With(zap.String("repo", repo), zap.String("branch", branch))
+	logger.Debug(req.Context(), "bundle handler")
+	r, exists := h.checkout(repo)
 	if !exists {
 		buf := strings.NewReader(fmt.Sprintf("unable to find repo %s", repo))
 		logger.Warn(req.Context(), "invalid repo")
 		return &httpserver.BasicResponse{Code: http.StatusNotFound, Msg: buf}
 	}
 	var buf bytes.Buffer
-	if numFiles, err := r.ZipContent(req.Context(), &buf, dir, branch); err != nil {
+	if err := r.Bundle(req.Context(), &buf, branch); err != nil {
 		if errors.Is(err, goget.ErrUnknownBranch) {
 			return &httpserver.BasicResponse{
 				Code: http.StatusNotFound,
 				Msg:  strings.NewReader(fmt.Sprintf("branch not found %s", branch)),
 			}
 		}
-		logger.Warn(req.Context(), "unable to zip content", zap.Error(err))
+		logger.Warn(req.Context(), "unable to bundle content", zap.Error(err))
 		return &httpserver.BasicResponse{
 			Code: http.StatusInternalServerError,
-			Msg:  strings.NewReader(fmt.Sprintf("unable to zip content for %s: %v", dir, err)),
-		}
-	} else if numFiles == 0 {
-		logger.Warn(req.Context(), "no files in path")
-		return &httpserver.BasicResponse{
-			Code: http.StatusNotFound,
-			Msg:  strings.NewReader(fmt.Sprintf("no files in path %s", dir)),
+			Msg:  strings.NewReader(fmt.Sprintf("unable to bundle branch %s: %v", branch, err)),
 		}
 	}
-	return &httpserver.BasicResponse{
+	return &httpserver.DigestResponse{BasicResponse: httpserver.BasicResponse{
 		Code: http.StatusOK,
 		Msg:  &buf,
 		Headers: map[string]string{
-			"Content-Type": "application/zip",
+			"Content-Type":        "application/x-git-bundle",
+			"Content-Disposition": fmt.Sprintf("attachment; filename=%q", sanitizeDir(repo+"-"+branch)+".bundle"),
 		},
-	}
+	}}
 }
 
 type FileStatArr []goget.FileStat
@@ -318,14 +801,44 @@ func (f FileStatArr) WriteTo(w io.Writer) (int64, error) {
 	return io.Copy(w, &b)
 }
 
-func (h *CheckoutHandler) getFile(ctx context.Context, repo string, branch string, path string, logger *log.Logger) httpserver.CanHTTPWrite {
-	r, exists := h.Checkouts[repo]
+// FileStatNames writes one entry name per line
+type FileStatNames []goget.FileStat
+
+func (f FileStatNames) WriteTo(w io.Writer) (int64, error) {
+	var b bytes.Buffer
+	for _, s := range f {
+		b.WriteString(s.Name)
+		b.WriteByte('\n')
+	}
+	return io.Copy(w, &b)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// getFile serves a file with its blob hash as the ETag, answering 304 Not Modified when the request's If-None-Match
+// already has it
+func (h *CheckoutHandler) getFile(req *http.Request, repo string, branch string, path string, logger *log.Logger) httpserver.CanHTTPWrite {
+	ctx := req.Context()
+	r, exists := h.checkout(repo)
 	if !exists {
 		buf := strings.NewReader(fmt.Sprintf("unable to find repo %s", repo))
 		logger.Warn(ctx, "invalid repo")
 		return &httpserver.BasicResponse{Code: http.StatusNotFound, Msg: buf}
 	}
-	f, err := r.GetFile(ctx, branch, path)
+	if redirect := h.fileRedirect(ctx, repo, branch, path); redirect != nil {
+		logger.Debug(ctx, "redirecting to S3")
+		return redirect
+	}
+	f, info, err := r.GetFileWithInfo(ctx, branch, path)
 	if err != nil {
 		if errors.Is(err, goget.ErrUnknownBranch) {
 			return &httpserver.BasicResponse{
@@ -346,11 +859,38 @@ func (h *CheckoutHandler) getFile(ctx context.Context, repo string, branch strin
 			Msg:  strings.NewReader(fmt.Sprintf("Unable to fetch file %s: %s", path, err)),
 		}
 	}
+	headers := fileInfoHeaders(info)
+	etag := `"` + info.BlobHash + `"`
+	headers["ETag"] = etag
+	if httpserver.ETagMatches(req, etag) {
+		logger.Debug(ctx, "file not modified")
+		delete(headers, "Content-Type")
+		return &httpserver.BasicResponse{
+			Code:    http.StatusNotModified,
+			Msg:     strings.NewReader(""),
+			Headers: headers,
+		}
+	}
 	logger.Debug(ctx, "fetch ok")
-	return &httpserver.BasicResponse{
-		Code: http.StatusOK,
-		Msg:  f,
+	h.observeSize("gitdb_file_size_bytes", metrics.Tags{"repo": repo}, f)
+	if b, ok := f.(interface{ Bytes() []byte }); ok && httpserver.AcceptsEncoding(req, "gzip") {
+		if gz, ok := r.Gzipped(branch, path, info, b.Bytes()); ok {
+			// Like other servers compressing on the fly, the compressed variant gets a weak ETag
+			headers["ETag"] = "W/" + etag
+			headers["Content-Encoding"] = "gzip"
+			headers["Vary"] = "Accept-Encoding"
+			return &httpserver.DigestResponse{BasicResponse: httpserver.BasicResponse{
+				Code:    http.StatusOK,
+				Msg:     bytes.NewBuffer(gz),
+				Headers: headers,
+			}}
+		}
 	}
+	return &httpserver.DigestResponse{BasicResponse: httpserver.BasicResponse{
+		Code:    http.StatusOK,
+		Msg:     f,
+		Headers: headers,
+	}}
 }
 
 func sanitizeDir(s string) string {
@@ -363,30 +903,56 @@ func sanitizeDir(s string) string {
 	}, s)
 }
 
-func getAuthMethod(repo Repository) (transport.AuthMethod, error) {
-	pKey := strings.TrimSpace(repo.PrivateKey)
-	if pKey == "" {
-		return nil, nil
+// zipPrefixes reads ?dirs=a,b,c, which zips several directories (relative to dir) at once.  Each directory goes in a
+// top level folder named by ?naming: "path" (the default) uses the directory's path, "base" its last element, and
+// "flat" merges everything at the root.
+func zipPrefixes(req *http.Request, dir string) ([]goget.ZipPrefix, error) {
+	dirsParam := req.URL.Query().Get("dirs")
+	if dirsParam == "" {
+		return []goget.ZipPrefix{{Prefix: dir}}, nil
 	}
-	sshKey, err := os.ReadFile(pKey)
-	if err != nil {
-		return nil, fmt.Errorf("unable to read file %s: %w", pKey, err)
+	naming := req.URL.Query().Get("naming")
+	ret := make([]goget.ZipPrefix, 0)
+	seenFolders := make(map[string]struct{})
+	for _, d := range strings.Split(dirsParam, ",") {
+		d = strings.Trim(d, "/")
+		if d == "" {
+			continue
+		}
+		prefix := d
+		if trimmedDir := strings.Trim(dir, "/"); trimmedDir != "" {
+			prefix = trimmedDir + "/" + d
+		}
+		var folder string
+		switch naming {
+		case "", "path":
+			folder = d
+		case "base":
+			folder = d[strings.LastIndex(d, "/")+1:]
+		case "flat":
+		default:
+			return nil, fmt.Errorf("unknown naming %s: expected path, base or flat", naming)
+		}
+		if _, exists := seenFolders[folder]; exists && folder != "" {
+			return nil, fmt.Errorf("two directories would share the folder %s", folder)
+		}
+		seenFolders[folder] = struct{}{}
+		ret = append(ret, goget.ZipPrefix{Prefix: prefix, Folder: folder})
 	}
-	publicKey, err := ssh.NewPublicKeys("git", sshKey, repo.PrivateKeyPassword)
-	if err != nil {
-		return nil, fmt.Errorf("unable to load public keys: %w", err)
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("no directories in dirs")
 	}
-	return publicKey, nil
+	return ret, nil
 }
 
-func getRepoKey(repo string) string {
-	parts := strings.Split(repo, "/")
-	if len(parts) != 2 {
-		return repo
+func fileInfoHeaders(info goget.FileInfo) map[string]string {
+	ret := map[string]string{
+		"X-Gitdb-Commit":      info.Commit,
+		"X-Gitdb-Commit-Time": info.CommitTime.UTC().Format(time.RFC3339),
+		"X-Gitdb-Blob-Hash":   info.BlobHash,
 	}
-	parts2 := strings.Split(parts[1], ".")
-	if len(parts2) != 2 {
-		return repo
+	if info.ContentType != "" {
+		ret["Content-Type"] = info.ContentType
 	}
-	return parts2[0]
+	return ret
 }
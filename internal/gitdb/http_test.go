@@ -0,0 +1,1138 @@
+package gitdb
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cresta/gitdb/internal/gitdb/goget"
+	"github.com/cresta/gitdb/internal/gitdb/metrics"
+	"github.com/cresta/gitdb/internal/gitdb/tracing"
+	"github.com/cresta/gitdb/internal/httpserver"
+	"github.com/cresta/gitdb/internal/testhelp"
+	"github.com/cresta/gitdb/internal/testhelp/fakecheckout"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/gorilla/mux"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+)
+
+var _ Checkout = &fakecheckout.Checkout{}
+
+func newFakeHandler(t *testing.T, co Checkout) *mux.Router {
+	h := &CheckoutHandler{
+		Checkouts: map[string]Checkout{"repo": co},
+		Log:       testhelp.ZapTestingLogger(t),
+		metrics:   metrics.Noop{},
+	}
+	m := mux.NewRouter()
+	h.SetupMux(m)
+	return m
+}
+
+func serve(t *testing.T, m *mux.Router, method string, url string, headers map[string]string) *httptest.ResponseRecorder {
+	req, err := http.NewRequestWithContext(context.Background(), method, url, nil)
+	require.NoError(t, err)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, req)
+	return rec
+}
+
+// commitFiles writes files, keyed by slash separated path, into the repository at dir and commits them, creating the
+// repository first if dir has none
+func commitFiles(t *testing.T, dir string, files map[string]string) (*git.Repository, plumbing.Hash) {
+	repo, err := git.PlainOpen(dir)
+	if errors.Is(err, git.ErrRepositoryNotExists) {
+		repo, err = git.PlainInit(dir, false)
+	}
+	require.NoError(t, err)
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0o700))
+		require.NoError(t, os.WriteFile(p, []byte(content), 0o600))
+	}
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+	require.NoError(t, wt.AddGlob("."))
+	commit, err := wt.Commit("commit", &git.CommitOptions{Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()}})
+	require.NoError(t, err)
+	return repo, commit
+}
+
+func TestCheckoutHandler_getFile(t *testing.T) {
+	m := newFakeHandler(t, &fakecheckout.Checkout{
+		Files: map[string]map[string]string{
+			"master": {"a.txt": "hello\n"},
+		},
+	})
+	rec := serve(t, m, http.MethodGet, "/file/repo/master/a.txt", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "hello\n", rec.Body.String())
+	etag := rec.Header().Get("ETag")
+	require.Equal(t, `"ce013625030ba8dba906f756967f9e9ca394464a"`, etag)
+
+	rec = serve(t, m, http.MethodGet, "/file/repo/master/a.txt", map[string]string{"If-None-Match": etag})
+	require.Equal(t, http.StatusNotModified, rec.Code)
+
+	rec = serve(t, m, http.MethodGet, "/file/repo/master/missing.txt", nil)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+	rec = serve(t, m, http.MethodGet, "/file/repo/nobranch/a.txt", nil)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+	require.Contains(t, rec.Body.String(), "branch not found")
+	rec = serve(t, m, http.MethodGet, "/file/norepo/master/a.txt", nil)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestCheckoutHandler_lsDir(t *testing.T) {
+	m := newFakeHandler(t, &fakecheckout.Checkout{
+		Files: map[string]map[string]string{
+			"master": {"a.txt": "a", "adir/b.txt": "b", "adir/subdir/c.txt": "c"},
+		},
+	})
+	rec := serve(t, m, http.MethodGet, "/ls/repo/master/adir", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var stat []goget.FileStat
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &stat))
+	require.Len(t, stat, 2)
+	require.Equal(t, "b.txt", stat[0].Name)
+	require.Equal(t, int64(1), stat[0].Size)
+	require.Nil(t, stat[0].LastModified)
+	require.Equal(t, "subdir", stat[1].Name)
+
+	rec = serve(t, m, http.MethodGet, "/ls/repo/master/nodir", nil)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = serve(t, m, http.MethodGet, "/ls/repo/master/adir?modified=nope", nil)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestCheckoutHandler_lsDirModified(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "testrepo")
+	repo, commit := commitFiles(t, dir, map[string]string{"a.txt": "abc"})
+	c, err := repo.CommitObject(commit)
+	require.NoError(t, err)
+	when := c.Author.When
+	h, err := NewHandler(testhelp.ZapTestingLogger(t), Config{
+		Repos: []Repository{{LocalPath: dir}},
+	}, tracing.Noop{})
+	require.NoError(t, err)
+	m := mux.NewRouter()
+	h.SetupMux(m)
+
+	for _, accept := range []string{"application/json", "application/x-ndjson"} {
+		rec := serve(t, m, http.MethodGet, "/ls/testrepo/master/?modified=true", map[string]string{"Accept": accept})
+		require.Equal(t, http.StatusOK, rec.Code, accept)
+		var stat []goget.FileStat
+		if accept == "application/json" {
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &stat))
+		} else {
+			dec := json.NewDecoder(rec.Body)
+			for dec.More() {
+				var s goget.FileStat
+				require.NoError(t, dec.Decode(&s))
+				stat = append(stat, s)
+			}
+		}
+		require.Len(t, stat, 1, accept)
+		require.Equal(t, int64(3), stat[0].Size)
+		require.NotNil(t, stat[0].LastModified)
+		require.True(t, when.Equal(*stat[0].LastModified), accept)
+		require.Empty(t, rec.Header().Get("ETag"), accept)
+	}
+}
+
+func TestCheckoutHandler_lsDirETag(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "testrepo")
+	repo, commit := commitFiles(t, dir, map[string]string{"a.txt": "abc", "sub/b.txt": "b"})
+	co, err := repo.CommitObject(commit)
+	require.NoError(t, err)
+	h, err := NewHandler(testhelp.ZapTestingLogger(t), Config{
+		Repos: []Repository{{LocalPath: dir}},
+	}, tracing.Noop{})
+	require.NoError(t, err)
+	m := mux.NewRouter()
+	h.SetupMux(m)
+
+	rec := serve(t, m, http.MethodGet, "/ls/testrepo/master/", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, `"`+co.TreeHash.String()+`"`, rec.Header().Get("ETag"))
+	rec = serve(t, m, http.MethodGet, "/ls/testrepo/master/", map[string]string{"If-None-Match": rec.Header().Get("ETag")})
+	require.Equal(t, http.StatusNotModified, rec.Code)
+	require.Empty(t, rec.Body.String())
+	rec = serve(t, m, http.MethodGet, "/ls/testrepo/master/", map[string]string{"Accept": "text/plain"})
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Empty(t, rec.Header().Get("ETag"))
+}
+
+func TestCheckoutHandler_batchStream(t *testing.T) {
+	co := &fakecheckout.Checkout{
+		Files: map[string]map[string]string{
+			"master": {"a.txt": "a", "b.txt": "b"},
+		},
+		Heads: map[string]string{"master": "abc"},
+	}
+	m := newFakeHandler(t, co)
+	batch := func(branch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/batch/repo/"+branch, strings.NewReader(`{"Paths": ["a.txt", "missing.txt", "b.txt"]}`))
+		req.Header.Set("Accept", "application/x-ndjson")
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, req)
+		return rec
+	}
+	rec := batch("master")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "application/x-ndjson", rec.Header().Get("Content-Type"))
+	require.Equal(t, "abc", rec.Header().Get("X-Gitdb-Commit"))
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	require.Len(t, lines, 3)
+	var f goget.BatchFile
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &f))
+	require.Equal(t, "a", string(f.Content))
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &f))
+	require.True(t, f.NotFound)
+
+	rec = batch("nobranch")
+	require.Equal(t, http.StatusNotFound, rec.Code)
+	require.Contains(t, rec.Body.String(), "branch not found")
+
+	// Errors after the first file drop the connection
+	m = newFakeHandler(t, failingCheckout{Checkout: co})
+	require.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		batch("master")
+	})
+}
+
+// failingCheckout fails walks and batches after their first entry, like a read error midway through a response
+type failingCheckout struct {
+	*fakecheckout.Checkout
+}
+
+func (c failingCheckout) StreamFiles(ctx context.Context, branch string, paths []string, callback func(commit string, f goget.BatchFile) error) error {
+	return c.Checkout.StreamFiles(ctx, branch, paths, func(commit string, f goget.BatchFile) error {
+		if err := callback(commit, f); err != nil {
+			return err
+		}
+		return errors.New("read failed")
+	})
+}
+
+func (c failingCheckout) WalkDir(ctx context.Context, dir string, branch string, recursive bool, callback func(goget.FileStat) error) error {
+	return c.Checkout.WalkDir(ctx, dir, branch, recursive, func(stat goget.FileStat) error {
+		if err := callback(stat); err != nil {
+			return err
+		}
+		return errors.New("read failed")
+	})
+}
+
+func TestCheckoutHandler_lsStream(t *testing.T) {
+	co := &fakecheckout.Checkout{
+		Files: map[string]map[string]string{
+			"master": {"a.txt": "a", "sub/b.txt": "b"},
+		},
+	}
+	m := newFakeHandler(t, co)
+	accept := map[string]string{"Accept": "application/x-ndjson"}
+	rec := serve(t, m, http.MethodGet, "/ls/repo/master/", accept)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "application/x-ndjson", rec.Header().Get("Content-Type"))
+	require.Len(t, strings.Split(strings.TrimSpace(rec.Body.String()), "\n"), 2)
+
+	// Errors before the first entry keep their status
+	require.Equal(t, http.StatusNotFound, serve(t, m, http.MethodGet, "/ls/repo/master/missing", accept).Code)
+	require.Equal(t, http.StatusNotFound, serve(t, m, http.MethodGet, "/ls/repo/nobranch/", accept).Code)
+	// Errors after it drop the connection
+	m = newFakeHandler(t, failingCheckout{Checkout: co})
+	require.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		serve(t, m, http.MethodGet, "/ls/repo/master/", accept)
+	})
+}
+
+// policy is an Authorizer allowing the requests it returns true for
+type policy func(input httpserver.AuthzInput) bool
+
+func (p policy) Authorize(_ context.Context, input httpserver.AuthzInput) (bool, error) {
+	return p(input), nil
+}
+
+func TestCheckoutHandler_batchAuthorize(t *testing.T) {
+	h := &CheckoutHandler{
+		Checkouts: map[string]Checkout{"repo": &fakecheckout.Checkout{
+			Files: map[string]map[string]string{
+				"master": {"a.txt": "a", "secret/b.txt": "b"},
+			},
+		}},
+		Log:     testhelp.ZapTestingLogger(t),
+		metrics: metrics.Noop{},
+		authorizer: policy(func(input httpserver.AuthzInput) bool {
+			return !strings.HasPrefix(input.Vars["path"], "secret/")
+		}),
+	}
+	m := mux.NewRouter()
+	h.SetupMux(m)
+	batch := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/batch/repo/master", strings.NewReader(body)))
+		return rec
+	}
+	require.Equal(t, http.StatusOK, batch(`{"Paths": ["a.txt"]}`).Code)
+	rec := batch(`{"Paths": ["a.txt", "secret/b.txt"]}`)
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	require.Contains(t, rec.Body.String(), "secret/b.txt")
+	require.Equal(t, http.StatusForbidden, serve(t, m, http.MethodGet, "/file/repo/master/secret/b.txt", nil).Code)
+}
+
+func TestCheckoutHandler_authorizeEndpoints(t *testing.T) {
+	h := &CheckoutHandler{
+		Checkouts: map[string]Checkout{"repo": &fakecheckout.Checkout{
+			Files: map[string]map[string]string{"master": {"a.txt": "a"}},
+			Heads: map[string]string{"master": "abc"},
+		}},
+		Log:     testhelp.ZapTestingLogger(t),
+		metrics: metrics.Noop{},
+		authorizer: policy(func(input httpserver.AuthzInput) bool {
+			return input.Endpoint == EndpointFile
+		}),
+	}
+	m := mux.NewRouter()
+	h.SetupMux(m)
+	require.Equal(t, http.StatusOK, serve(t, m, http.MethodGet, "/file/repo/master/a.txt", nil).Code)
+	for _, url := range []string{"/commit/repo/master", "/commits/repo/master", "/changes/repo/master", "/repos"} {
+		require.Equal(t, http.StatusForbidden, serve(t, m, http.MethodGet, url, nil).Code, url)
+	}
+}
+
+func TestCheckoutHandler_ReadLimitMiddleware(t *testing.T) {
+	h := &CheckoutHandler{
+		Checkouts: map[string]Checkout{"repo": &fakecheckout.Checkout{
+			Files: map[string]map[string]string{"master": {"a.txt": "a"}},
+		}},
+		Log:     testhelp.ZapTestingLogger(t),
+		metrics: metrics.Noop{},
+		// Every read slot is taken
+		readSemaphores: map[string]chan struct{}{"repo": make(chan struct{})},
+	}
+	m := mux.NewRouter()
+	httpserver.NewChain(h.ReadLimitMiddleware()).Group(m, h.SetupMux)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/zip/repo/master/", nil).WithContext(ctx))
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	require.Equal(t, "1", rec.Header().Get("Retry-After"))
+	// Cheap reads are not limited
+	require.Equal(t, http.StatusOK, serve(t, m, http.MethodGet, "/file/repo/master/a.txt", nil).Code)
+}
+
+func TestCheckoutHandler_SetupPublicJWTHandler(t *testing.T) {
+	parent := t.TempDir()
+	var repos []Repository
+	for _, name := range []string{"anon", "locked"} {
+		dir := filepath.Join(parent, name)
+		commitFiles(t, dir, map[string]string{"a.txt": "hello\n"})
+		repos = append(repos, Repository{LocalPath: dir, Public: true, Anonymous: name == "anon"})
+	}
+	h, err := NewHandler(testhelp.ZapTestingLogger(t), Config{Repos: repos}, tracing.Noop{})
+	require.NoError(t, err)
+
+	// Without a key only anonymous repositories are served
+	m := mux.NewRouter()
+	h.SetupPublicJWTHandler(m, nil, repos)
+	require.Equal(t, http.StatusOK, serve(t, m, http.MethodGet, "/public/file/anon/master/a.txt", nil).Code)
+	rec := serve(t, m, http.MethodGet, "/public/file/locked/master/a.txt", nil)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+	require.Equal(t, "unable to find repo locked", rec.Body.String())
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	m = mux.NewRouter()
+	h.SetupPublicJWTHandler(m, func(*jwt.Token) (interface{}, error) { return &key.PublicKey, nil }, repos)
+	require.Equal(t, http.StatusOK, serve(t, m, http.MethodGet, "/public/file/anon/master/a.txt", nil).Code)
+	require.Equal(t, http.StatusUnauthorized, serve(t, m, http.MethodGet, "/public/file/locked/master/a.txt", nil).Code)
+	token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.StandardClaims{Subject: "reader"}).SignedString(key)
+	require.NoError(t, err)
+	rec = serve(t, m, http.MethodGet, "/public/file/locked/master/a.txt", map[string]string{"Authorization": "Bearer " + token})
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Equal(t, "hello\n", rec.Body.String())
+}
+
+// observedMetrics records what is observed, by metric name
+type observedMetrics struct {
+	metrics.Noop
+	mu       sync.Mutex
+	observed map[string][]observation
+}
+
+type observation struct {
+	value float64
+	tags  metrics.Tags
+}
+
+func (o *observedMetrics) Observe(name string, value float64, tags metrics.Tags) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.observed == nil {
+		o.observed = make(map[string][]observation)
+	}
+	o.observed[name] = append(o.observed[name], observation{value: value, tags: tags})
+}
+
+func (o *observedMetrics) get(name string) []observation {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.observed[name]
+}
+
+func TestCheckoutHandler_sizeMetrics(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "workdir")
+	require.NoError(t, os.Mkdir(dir, 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello\n"), 0o600))
+	m := &observedMetrics{}
+	h, err := NewHandler(testhelp.ZapTestingLogger(t), Config{
+		Repos:   []Repository{{LocalPath: dir}},
+		Metrics: m,
+	}, tracing.Noop{})
+	require.NoError(t, err)
+	router := mux.NewRouter()
+	h.SetupMux(router)
+
+	require.Equal(t, http.StatusOK, serve(t, router, http.MethodGet, "/file/workdir/main/a.txt", nil).Code)
+	require.Equal(t, []observation{{value: 6, tags: metrics.Tags{"repo": "workdir"}}}, m.get("gitdb_file_size_bytes"))
+	rec := serve(t, router, http.MethodGet, "/zip/workdir/main/", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, []observation{{value: float64(rec.Body.Len()), tags: metrics.Tags{"repo": "workdir", "format": "zip"}}}, m.get("gitdb_archive_size_bytes"))
+}
+
+func TestCheckoutHandler_disabledEndpoints(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "workdir")
+	require.NoError(t, os.Mkdir(dir, 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello\n"), 0o600))
+	_, err := NewHandler(testhelp.ZapTestingLogger(t), Config{
+		Repos: []Repository{{LocalPath: dir, DisabledEndpoints: []string{"nope"}}},
+	}, tracing.Noop{})
+	require.ErrorContains(t, err, "unknown endpoint nope")
+
+	h, err := NewHandler(testhelp.ZapTestingLogger(t), Config{
+		Repos: []Repository{{LocalPath: dir, DisabledEndpoints: []string{EndpointZip, EndpointSqlite}}},
+	}, tracing.Noop{})
+	require.NoError(t, err)
+	m := mux.NewRouter()
+	h.SetupMux(m)
+	rec := serve(t, m, http.MethodGet, "/zip/workdir/main/", nil)
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	require.Equal(t, "zip is disabled for repo workdir", rec.Body.String())
+	require.Equal(t, http.StatusForbidden, serve(t, m, http.MethodGet, "/sqlite/workdir/main", nil).Code)
+	require.Equal(t, http.StatusOK, serve(t, m, http.MethodGet, "/tar/workdir/main/", nil).Code)
+	require.Equal(t, http.StatusOK, serve(t, m, http.MethodGet, "/file/workdir/main/a.txt", nil).Code)
+}
+
+func TestCheckoutHandler_limitReads(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "workdir")
+	require.NoError(t, os.Mkdir(dir, 0o700))
+	h, err := NewHandler(testhelp.ZapTestingLogger(t), Config{
+		Repos: []Repository{{LocalPath: dir, MaxConcurrentReads: 1}},
+	}, tracing.Noop{})
+	require.NoError(t, err)
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	m := mux.NewRouter()
+	m.Path("/read/{repo}").Handler(h.limitReads(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		started <- struct{}{}
+		if request.URL.Query().Get("block") != "" {
+			<-release
+		}
+		writer.WriteHeader(http.StatusOK)
+	})))
+	read := func(url string, timeout time.Duration) int {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil).WithContext(ctx))
+		return rec.Code
+	}
+
+	blocked := make(chan int)
+	go func() {
+		blocked <- read("/read/workdir?block=true", time.Minute)
+	}()
+	<-started
+	// The only slot is taken until the first read is written
+	rec := httptest.NewRecorder()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/read/workdir", nil).WithContext(ctx))
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	require.Equal(t, "1", rec.Header().Get("Retry-After"))
+	// Repositories without MaxConcurrentReads are not limited
+	require.Equal(t, http.StatusOK, read("/read/other", time.Minute))
+	<-started
+
+	close(release)
+	require.Equal(t, http.StatusOK, <-blocked)
+	require.Equal(t, http.StatusOK, read("/read/workdir", time.Minute))
+	<-started
+}
+
+func TestCheckoutHandler_refresh(t *testing.T) {
+	co := &fakecheckout.Checkout{}
+	m := newFakeHandler(t, co)
+	rec := serve(t, m, http.MethodPost, "/refresh/repo", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, 1, co.Refreshes())
+
+	co.RefreshErr = errors.New("remote unavailable")
+	rec = serve(t, m, http.MethodPost, "/refresh/repo", nil)
+	require.Equal(t, http.StatusInternalServerError, rec.Code)
+	require.Equal(t, 2, co.Refreshes())
+}
+
+func TestNewHandler_LocalPath(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "workdir")
+	require.NoError(t, os.Mkdir(dir, 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello\n"), 0o600))
+	h, err := NewHandler(testhelp.ZapTestingLogger(t), Config{
+		Repos: []Repository{{LocalPath: dir}},
+	}, tracing.Noop{})
+	require.NoError(t, err)
+	m := mux.NewRouter()
+	h.SetupMux(m)
+	rec := serve(t, m, http.MethodGet, "/file/workdir/main/a.txt", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "hello\n", rec.Body.String())
+}
+
+func TestCheckoutHandler_simulate(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "testrepo")
+	commitFiles(t, dir, map[string]string{"a.txt": "hello\n"})
+
+	h, err := NewHandler(testhelp.ZapTestingLogger(t), Config{
+		Repos: []Repository{{LocalPath: dir}},
+	}, tracing.Noop{})
+	require.NoError(t, err)
+	m := mux.NewRouter()
+	h.SetupMux(m)
+	h.SetupSimulationMux(m)
+
+	simulate := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/simulate/testrepo/master", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, req)
+		return rec
+	}
+	rec := simulate(`{"Files": {"a.txt": "bye\n"}}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var change goget.BranchChange
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &change))
+	require.Equal(t, []string{"a.txt"}, change.Paths)
+
+	rec = serve(t, m, http.MethodGet, "/file/testrepo/master/a.txt", nil)
+	require.Equal(t, "bye\n", rec.Body.String())
+	rec = serve(t, m, http.MethodGet, "/changes/testrepo/master", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	require.Equal(t, http.StatusBadRequest, simulate(`{"Files": {"../a.txt": "x"}}`).Code)
+	require.Equal(t, http.StatusBadRequest, simulate(`{}`).Code)
+}
+
+func TestCheckoutHandler_write(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "testrepo")
+	repo, _ := commitFiles(t, dir, map[string]string{"a.txt": "hello\n"})
+
+	h, err := NewHandler(testhelp.ZapTestingLogger(t), Config{
+		Repos: []Repository{{LocalPath: dir}},
+	}, tracing.Noop{})
+	require.NoError(t, err)
+	m := mux.NewRouter()
+	h.SetupMux(m)
+	h.SetupWriteMux(m, func(handler http.Handler) http.Handler { return handler })
+
+	write := func(method string, path string, body string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, req)
+		return rec
+	}
+	etag := serve(t, m, http.MethodGet, "/file/testrepo/master/a.txt", nil).Header().Get("ETag")
+	author := map[string]string{"X-Gitdb-Author-Name": "writer", "X-Gitdb-Author-Email": "writer@example.com", "If-Match": etag}
+	rec := write(http.MethodPut, "/file/testrepo/master/a.txt", "bye\n", author)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var change goget.BranchChange
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &change))
+	require.Equal(t, []string{"a.txt"}, change.Paths)
+	require.Equal(t, change.To, rec.Header().Get("X-Gitdb-Commit"))
+	written := rec.Header().Get("ETag")
+	rec = serve(t, m, http.MethodGet, "/file/testrepo/master/a.txt", nil)
+	require.Equal(t, "bye\n", rec.Body.String())
+	require.Equal(t, written, rec.Header().Get("ETag"))
+	commit, err := repo.CommitObject(plumbing.NewHash(change.To))
+	require.NoError(t, err)
+	require.Equal(t, "writer", commit.Author.Name)
+	require.Equal(t, "Update a.txt", commit.Message)
+
+	// a.txt is no longer at etag
+	require.Equal(t, http.StatusPreconditionFailed, write(http.MethodPut, "/file/testrepo/master/a.txt", "again\n", author).Code)
+	require.Equal(t, http.StatusBadRequest, write(http.MethodPut, "/file/testrepo/master/a.txt", "again\n", nil).Code)
+	rec = write(http.MethodDelete, "/file/testrepo/master/a.txt", "", map[string]string{"X-Gitdb-Author-Name": "writer", "X-Gitdb-Commit-Message": "remove a"})
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Equal(t, http.StatusNotFound, serve(t, m, http.MethodGet, "/file/testrepo/master/a.txt", nil).Code)
+	require.Equal(t, http.StatusNotFound, write(http.MethodPut, "/file/testrepo/nope/a.txt", "x", map[string]string{"X-Gitdb-Author-Name": "writer"}).Code)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	jm := mux.NewRouter()
+	h.SetupWriteMux(jm, httpserver.JWTMiddleware(func(*jwt.Token) (interface{}, error) { return &key.PublicKey, nil }, h.Log))
+	writeAs := func(claims httpserver.ScopedClaims, p string) int {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(key)
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPut, p, strings.NewReader("x"))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		jm.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	sub := jwt.StandardClaims{Subject: "writer"}
+	require.Equal(t, http.StatusForbidden, writeAs(httpserver.ScopedClaims{StandardClaims: sub}, "/file/testrepo/master/c.txt"))
+	require.Equal(t, http.StatusForbidden, writeAs(httpserver.ScopedClaims{StandardClaims: sub, Write: true, PathPrefix: "dir"}, "/file/testrepo/master/c.txt"))
+	require.Equal(t, http.StatusForbidden, writeAs(httpserver.ScopedClaims{StandardClaims: sub, Write: true, Repo: "other"}, "/file/testrepo/master/c.txt"))
+	require.Equal(t, http.StatusOK, writeAs(httpserver.ScopedClaims{StandardClaims: sub, Write: true, PathPrefix: "dir"}, "/file/testrepo/master/dir/c.txt"))
+}
+
+func TestCheckoutHandler_snapshot(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "testrepo")
+	_, commit := commitFiles(t, dir, map[string]string{"a.txt": "hello\n", "sub dir/b.txt": "bye\n"})
+
+	h, err := NewHandler(testhelp.ZapTestingLogger(t), Config{
+		Repos: []Repository{{LocalPath: dir}},
+	}, tracing.Noop{})
+	require.NoError(t, err)
+	m := mux.NewRouter()
+	h.SetupMux(m)
+
+	rec := serve(t, m, http.MethodGet, "/snapshot/testrepo/master", nil)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Equal(t, `"`+commit.String()+`"`, rec.Header().Get("ETag"))
+	var snap Snapshot
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &snap))
+	require.Equal(t, commit.String(), snap.Commit)
+	require.Len(t, snap.Files, 2)
+	require.Equal(t, "sub dir/b.txt", snap.Files[1].Path)
+	require.Equal(t, int64(4), snap.Files[1].Size)
+	require.Equal(t, "/file/testrepo/"+commit.String()+"/sub%20dir/b.txt", snap.Files[1].URL)
+	for _, f := range snap.Files {
+		rec := serve(t, m, http.MethodGet, f.URL, nil)
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, `"`+f.Hash+`"`, rec.Header().Get("ETag"))
+	}
+
+	rec = serve(t, m, http.MethodGet, "/snapshot/testrepo/master", map[string]string{"If-None-Match": `"` + commit.String() + `"`})
+	require.Equal(t, http.StatusNotModified, rec.Code)
+	require.Equal(t, http.StatusNotFound, serve(t, m, http.MethodGet, "/snapshot/testrepo/missing", nil).Code)
+
+	// Reads pinned to the snapshot's commit do not see later commits
+	m.Use(h.PinnedReadMiddleware())
+	commitFiles(t, dir, map[string]string{"a.txt": "changed\n"})
+	require.NoError(t, h.checkouts()["testrepo"].Refresh(context.Background()))
+	require.Equal(t, "changed\n", serve(t, m, http.MethodGet, "/file/testrepo/master/a.txt", nil).Body.String())
+	rec = serve(t, m, http.MethodGet, "/file/testrepo/master/a.txt?at="+commit.String(), nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "hello\n", rec.Body.String())
+	require.Equal(t, http.StatusBadRequest, serve(t, m, http.MethodGet, "/file/testrepo/master/a.txt?at=master", nil).Code)
+	require.Equal(t, http.StatusBadRequest, serve(t, m, http.MethodGet, "/changes/testrepo/master?at="+commit.String(), nil).Code)
+}
+
+func TestCheckoutHandler_changes(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "testrepo")
+	_, first := commitFiles(t, dir, map[string]string{"a.txt": "hello\n", "b.txt": "same\n"})
+
+	h, err := NewHandler(testhelp.ZapTestingLogger(t), Config{
+		Repos: []Repository{{LocalPath: dir}},
+	}, tracing.Noop{})
+	require.NoError(t, err)
+	m := mux.NewRouter()
+	h.SetupMux(m)
+	require.Equal(t, http.StatusNotFound, serve(t, m, http.MethodGet, "/changes/testrepo/master", nil).Code)
+
+	_, second := commitFiles(t, dir, map[string]string{"a.txt": "changed\n", "c.txt": "new\n"})
+	require.NoError(t, h.checkouts()["testrepo"].Refresh(context.Background()))
+
+	rec := serve(t, m, http.MethodGet, "/changes/testrepo/master", nil)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	var change goget.BranchChange
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &change))
+	require.Equal(t, "master", change.Branch)
+	require.Equal(t, first.String(), change.From)
+	require.Equal(t, second.String(), change.To)
+	require.Equal(t, []string{"a.txt", "c.txt"}, change.Paths)
+	require.Equal(t, http.StatusNotFound, serve(t, m, http.MethodGet, "/changes/testrepo/missing", nil).Code)
+	require.Equal(t, http.StatusNotFound, serve(t, m, http.MethodGet, "/changes/nope/master", nil).Code)
+}
+
+func TestCheckoutHandler_sqlite(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "testrepo")
+	_, commit := commitFiles(t, dir, map[string]string{"a.txt": "hello\n", "sub/b.txt": "hello\n"})
+
+	dataDir := t.TempDir()
+	h, err := NewHandler(testhelp.ZapTestingLogger(t), Config{
+		DataDirectory: dataDir,
+		Repos:         []Repository{{LocalPath: dir}},
+	}, tracing.Noop{})
+	require.NoError(t, err)
+	m := mux.NewRouter()
+	h.SetupMux(m)
+
+	rec := serve(t, m, http.MethodGet, "/sqlite/testrepo/master", nil)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Equal(t, "application/vnd.sqlite3", rec.Header().Get("Content-Type"))
+	dbPath := filepath.Join(t.TempDir(), "out.db")
+	require.NoError(t, os.WriteFile(dbPath, rec.Body.Bytes(), 0o600))
+	db, err := sql.Open("sqlite", dbPath)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, db.Close())
+	}()
+	hash := plumbing.ComputeHash(plumbing.BlobObject, []byte("hello\n")).String()
+	var paths []string
+	rows, err := db.Query("SELECT path, size, hash FROM files ORDER BY path")
+	require.NoError(t, err)
+	for rows.Next() {
+		var p, h string
+		var size int64
+		require.NoError(t, rows.Scan(&p, &size, &h))
+		require.Equal(t, int64(6), size, p)
+		require.Equal(t, hash, h, p)
+		paths = append(paths, p)
+	}
+	require.NoError(t, rows.Err())
+	require.NoError(t, rows.Close())
+	require.Equal(t, []string{"a.txt", "sub/b.txt"}, paths)
+	var data []byte
+	require.NoError(t, db.QueryRow("SELECT data FROM blobs WHERE hash = ?", hash).Scan(&data))
+	require.Equal(t, "hello\n", string(data))
+	var exported string
+	require.NoError(t, db.QueryRow("SELECT value FROM meta WHERE key = 'commit'").Scan(&exported))
+	require.Equal(t, commit.String(), exported)
+
+	require.Equal(t, http.StatusNotFound, serve(t, m, http.MethodGet, "/sqlite/testrepo/missing", nil).Code)
+	// The temporary databases are gone once served
+	left, err := filepath.Glob(filepath.Join(dataDir, "gitdb_sqlite_*"))
+	require.NoError(t, err)
+	require.Empty(t, left)
+}
+
+func TestCheckoutHandler_tar(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "testrepo")
+	commitFiles(t, dir, map[string]string{"env/a.yaml": "a: 1\n"})
+
+	h, err := NewHandler(testhelp.ZapTestingLogger(t), Config{
+		Repos: []Repository{{LocalPath: dir}},
+	}, tracing.Noop{})
+	require.NoError(t, err)
+	m := mux.NewRouter()
+	h.SetupMux(m)
+
+	untar := func(r io.Reader) map[string]string {
+		ret := make(map[string]string)
+		tr := tar.NewReader(r)
+		for {
+			hdr, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				return ret
+			}
+			require.NoError(t, err)
+			b, err := io.ReadAll(tr)
+			require.NoError(t, err)
+			ret[hdr.Name] = string(b)
+		}
+	}
+	expected := map[string]string{"a.yaml": "a: 1\n"}
+
+	rec := serve(t, m, http.MethodGet, "/tar/testrepo/master/env", nil)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Equal(t, "application/gzip", rec.Header().Get("Content-Type"))
+	gz, err := gzip.NewReader(rec.Body)
+	require.NoError(t, err)
+	require.Equal(t, expected, untar(gz))
+
+	rec = serve(t, m, http.MethodGet, "/tar/testrepo/master/env", map[string]string{"Accept": "application/zstd"})
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Equal(t, "application/zstd", rec.Header().Get("Content-Type"))
+	zr, err := zstd.NewReader(rec.Body)
+	require.NoError(t, err)
+	defer zr.Close()
+	require.Equal(t, expected, untar(zr))
+}
+
+func TestCheckoutHandler_zipStream(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "testrepo")
+	// Random bytes do not compress, so the zip outgrows zipStartBytes
+	big := make([]byte, zipStartBytes*2)
+	_, err := rand.Read(big)
+	require.NoError(t, err)
+	commitFiles(t, dir, map[string]string{"small/a.txt": "hello\n", "big/random.bin": string(big)})
+
+	h, err := NewHandler(testhelp.ZapTestingLogger(t), Config{
+		Repos:           []Repository{{LocalPath: dir}},
+		MaxArchiveBytes: zipStartBytes + 1024,
+	}, tracing.Noop{})
+	require.NoError(t, err)
+	m := mux.NewRouter()
+	h.SetupMux(m)
+
+	rec := serve(t, m, http.MethodGet, "/zip/testrepo/master/small", nil)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Equal(t, "application/zip", rec.Header().Get("Content-Type"))
+	sum := sha256.Sum256(rec.Body.Bytes())
+	require.Equal(t, "sha-256="+base64.StdEncoding.EncodeToString(sum[:]), rec.Header().Get("Digest"))
+	zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	require.NoError(t, err)
+	require.Len(t, zr.File, 1)
+
+	require.Equal(t, http.StatusNotFound, serve(t, m, http.MethodGet, "/zip/testrepo/master/missing", nil).Code)
+	require.Equal(t, http.StatusNotFound, serve(t, m, http.MethodGet, "/zip/testrepo/nobranch/", nil).Code)
+	// Past the limit once the response started, the connection is dropped
+	require.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		serve(t, m, http.MethodGet, "/zip/testrepo/master/big", nil)
+	})
+
+	require.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		serve(t, m, http.MethodGet, "/tar/testrepo/master/big", nil)
+	})
+
+	h.maxArchiveBytes = 10
+	rec = serve(t, m, http.MethodGet, "/zip/testrepo/master/small", nil)
+	require.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	require.Contains(t, rec.Body.String(), ErrArchiveTooLarge.Error())
+	rec = serve(t, m, http.MethodGet, "/tar/testrepo/master/small", nil)
+	require.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+}
+
+func TestPreflight(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "testrepo")
+	commitFiles(t, dir, map[string]string{"a.txt": "hello\n"})
+
+	results := Preflight(context.Background(), []Repository{
+		{URL: dir, Alias: "remote"},
+		{LocalPath: dir},
+		{URL: filepath.Join(dir, "missing")},
+		{LocalPath: filepath.Join(dir, "missing"), Alias: "missing_local"},
+	}, RepoKeyName, time.Minute)
+	require.Len(t, results, 4)
+	require.NoError(t, results[0].Err)
+	require.Equal(t, "remote", results[0].Repo)
+	require.Positive(t, results[0].Refs)
+	require.NoError(t, results[1].Err)
+	require.Equal(t, "testrepo", results[1].Repo)
+	require.Error(t, results[2].Err)
+	require.Error(t, results[3].Err)
+}
+
+func TestRepository_key(t *testing.T) {
+	for _, tc := range []struct {
+		url    string
+		naming RepoKeyNaming
+		key    string
+	}{
+		{url: "git@github.com:org/repo.git", key: "repo"},
+		{url: "https://github.com/org/repo", naming: RepoKeyName, key: "repo"},
+		{url: "https://github.com/org/repo.git", naming: RepoKeyOrgRepo, key: "org_repo"},
+		{url: "ssh://git@gitlab.com:2222/group/subgroup/repo.git", naming: RepoKeyOrgRepo, key: "group_subgroup_repo"},
+		{url: "https://gitlab.com/group/subgroup/repo/", naming: RepoKeyPath, key: "group/subgroup/repo"},
+		{url: "git@github.com:org/repo.git", naming: RepoKeyPath, key: "org/repo"},
+		{url: "https://github.com/", key: ""},
+	} {
+		require.Equal(t, tc.key, Repository{URL: tc.url}.key(tc.naming), "%s %s", tc.url, tc.naming)
+	}
+	require.Equal(t, "alias", Repository{URL: "https://github.com/org/repo", Alias: "alias"}.key(RepoKeyPath))
+	require.Equal(t, "repo", Repository{LocalPath: "/tmp/org/repo/"}.key(RepoKeyPath))
+}
+
+func TestNewHandler_repoKeyNaming(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b"} {
+		commitFiles(t, filepath.Join(dir, name, "repo"), map[string]string{"a.txt": name})
+	}
+	repos := []Repository{{URL: "file://" + filepath.Join(dir, "a", "repo")}, {URL: "file://" + filepath.Join(dir, "b", "repo")}}
+
+	_, err := NewHandler(testhelp.ZapTestingLogger(t), Config{DataDirectory: t.TempDir(), Repos: repos}, tracing.Noop{})
+	require.ErrorContains(t, err, "both keyed repo")
+	_, err = NewHandler(testhelp.ZapTestingLogger(t), Config{Repos: repos, RepoKeyNaming: "nope"}, tracing.Noop{})
+	require.ErrorContains(t, err, "unknown RepoKeyNaming")
+
+	h, err := NewHandler(testhelp.ZapTestingLogger(t), Config{DataDirectory: t.TempDir(), Repos: repos, RepoKeyNaming: RepoKeyPath}, tracing.Noop{})
+	require.NoError(t, err)
+	m := mux.NewRouter()
+	httpserver.UnescapedVars(m)
+	h.SetupMux(m)
+	key := strings.TrimPrefix(filepath.Join(dir, "b", "repo"), "/")
+	rec := serve(t, m, http.MethodGet, "/file/"+url.PathEscape(key)+"/master/a.txt", nil)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Equal(t, "b", rec.Body.String())
+}
+
+func TestRepository_cloneOptions(t *testing.T) {
+	opts, err := Repository{FetchRefSpecs: []string{"refs/tags/*"}, Branches: []string{"main", "refs/heads/release"}, Depth: 1}.cloneOptions()
+	require.NoError(t, err)
+	require.Equal(t, goget.CloneOptions{
+		RefSpecs: []config.RefSpec{
+			"refs/tags/*:refs/tags/*",
+			"+refs/heads/main:refs/remotes/origin/main",
+			"+refs/heads/release:refs/remotes/origin/release",
+		},
+		Depth: 1,
+	}, opts)
+
+	opts, err = Repository{SingleBranch: true}.cloneOptions()
+	require.NoError(t, err)
+	require.True(t, opts.SingleBranch)
+	require.Empty(t, opts.RefSpecs)
+
+	_, err = Repository{SingleBranch: true, Branches: []string{"main"}}.cloneOptions()
+	require.Error(t, err)
+	_, err = Repository{Depth: -1}.cloneOptions()
+	require.Error(t, err)
+}
+
+func TestCheckoutHandler_Reload(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"one", "two"} {
+		require.NoError(t, os.Mkdir(filepath.Join(root, name), 0o700))
+		require.NoError(t, os.WriteFile(filepath.Join(root, name, "a.txt"), []byte(name), 0o600))
+	}
+	one := Repository{LocalPath: filepath.Join(root, "one")}
+	two := Repository{LocalPath: filepath.Join(root, "two")}
+	h, err := NewHandler(testhelp.ZapTestingLogger(t), Config{Repos: []Repository{one}}, tracing.Noop{})
+	require.NoError(t, err)
+	m := mux.NewRouter()
+	h.SetupMux(m)
+	kept, exists := h.checkout("one")
+	require.True(t, exists)
+
+	require.NoError(t, h.Reload(context.Background(), []Repository{one, two}))
+	rec := serve(t, m, http.MethodGet, "/file/two/main/a.txt", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "two", rec.Body.String())
+	co, exists := h.checkout("one")
+	require.True(t, exists)
+	require.Same(t, kept, co)
+
+	require.NoError(t, h.Reload(context.Background(), []Repository{two}))
+	require.Equal(t, http.StatusNotFound, serve(t, m, http.MethodGet, "/file/one/main/a.txt", nil).Code)
+	require.Equal(t, http.StatusOK, serve(t, m, http.MethodGet, "/file/two/main/a.txt", nil).Code)
+
+	// A config that fails to set up changes nothing
+	require.Error(t, h.Reload(context.Background(), []Repository{one, {LocalPath: filepath.Join(root, "missing")}}))
+	require.Equal(t, http.StatusOK, serve(t, m, http.MethodGet, "/file/two/main/a.txt", nil).Code)
+}
+
+func TestCheckoutHandler_Ready(t *testing.T) {
+	co := &fakecheckout.Checkout{}
+	h := &CheckoutHandler{
+		Checkouts: map[string]Checkout{"repo": co},
+		Log:       testhelp.ZapTestingLogger(t),
+		metrics:   metrics.Noop{},
+	}
+	ctx := context.Background()
+	// Without a window, cloned is enough
+	require.NoError(t, h.Ready(ctx, 0))
+	require.Error(t, h.Ready(ctx, time.Minute))
+	require.NoError(t, co.Refresh(ctx))
+	require.NoError(t, h.Ready(ctx, time.Minute))
+	time.Sleep(time.Millisecond * 2)
+	require.Error(t, h.Ready(ctx, time.Millisecond))
+
+	// A configured repo without a checkout is not cloned yet
+	h.repos = []Repository{{Alias: "repo"}, {Alias: "other"}}
+	require.Error(t, h.Ready(ctx, 0))
+}
+
+// clonedCheckout is a fake checkout cloned into a directory
+type clonedCheckout struct {
+	fakecheckout.Checkout
+	dir string
+}
+
+func (c *clonedCheckout) AbsPath() string {
+	return c.dir
+}
+
+func TestCheckoutHandler_RemoveStaleDirs(t *testing.T) {
+	dataDir := t.TempDir()
+	for _, name := range []string{"gitdb_repo_used", "gitdb_repo_old", "gitdb_repo_used.quarantine", "gitdb_worktree_old", "unrelated"} {
+		require.NoError(t, os.Mkdir(filepath.Join(dataDir, name), 0o700))
+	}
+	h := &CheckoutHandler{
+		Checkouts:     map[string]Checkout{"repo": &clonedCheckout{dir: filepath.Join(dataDir, "gitdb_repo_used")}},
+		Log:           testhelp.ZapTestingLogger(t),
+		metrics:       metrics.Noop{},
+		dataDirectory: dataDir,
+	}
+	stale := []string{
+		filepath.Join(dataDir, "gitdb_repo_old"),
+		filepath.Join(dataDir, "gitdb_repo_used.quarantine"),
+		filepath.Join(dataDir, "gitdb_worktree_old"),
+	}
+	removed, err := h.RemoveStaleDirs(context.Background(), true)
+	require.NoError(t, err)
+	require.Equal(t, stale, removed)
+	require.DirExists(t, stale[0])
+
+	removed, err = h.RemoveStaleDirs(context.Background(), false)
+	require.NoError(t, err)
+	require.Equal(t, stale, removed)
+	for _, p := range stale {
+		require.NoDirExists(t, p)
+	}
+	require.DirExists(t, filepath.Join(dataDir, "gitdb_repo_used"))
+	require.DirExists(t, filepath.Join(dataDir, "unrelated"))
+}
+
+func TestCheckoutHandler_addRemoveRepo(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"one", "two"} {
+		require.NoError(t, os.Mkdir(filepath.Join(root, name), 0o700))
+		require.NoError(t, os.WriteFile(filepath.Join(root, name, "a.txt"), []byte(name), 0o600))
+	}
+	// two is added by URL, since the admin API cannot add a LocalPath
+	commitFiles(t, filepath.Join(root, "two"), nil)
+	var saved []Repository
+	var saveErr error
+	h, err := NewHandler(testhelp.ZapTestingLogger(t), Config{
+		Repos: []Repository{{LocalPath: filepath.Join(root, "one")}},
+		SaveRepos: func(_ context.Context, repos []Repository) error {
+			if saveErr != nil {
+				return saveErr
+			}
+			saved = repos
+			return nil
+		},
+	}, tracing.Noop{})
+	require.NoError(t, err)
+	var reloaded map[string]Checkout
+	h.OnReload(func(checkouts map[string]Checkout) {
+		reloaded = checkouts
+	})
+	m := mux.NewRouter()
+	h.SetupMux(m)
+	h.SetupAdminMux(m)
+	send := func(method string, url string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, req)
+		return rec
+	}
+	twoRepo := Repository{URL: filepath.Join(root, "two"), Storage: StorageMemory}
+	two, err := json.Marshal(twoRepo)
+	require.NoError(t, err)
+
+	rec := send(http.MethodPost, "/admin/repo", string(two))
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	var info RepoInfo
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &info))
+	require.Equal(t, "two", info.Key)
+	require.Len(t, saved, 2)
+	require.Contains(t, reloaded, twoRepo.URL)
+	require.Equal(t, "two", serve(t, m, http.MethodGet, "/file/two/master/a.txt", nil).Body.String())
+	require.Equal(t, http.StatusConflict, send(http.MethodPost, "/admin/repo", string(two)).Code)
+	require.Equal(t, http.StatusBadRequest, send(http.MethodPost, "/admin/repo", `{}`).Code)
+	local, err := json.Marshal(Repository{LocalPath: t.TempDir()})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, send(http.MethodPost, "/admin/repo", string(local)).Code)
+
+	rec = send(http.MethodDelete, "/admin/repo/one", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Equal(t, []Repository{twoRepo}, saved)
+	require.Len(t, reloaded, 1)
+	require.Equal(t, http.StatusNotFound, serve(t, m, http.MethodGet, "/file/one/main/a.txt", nil).Code)
+	require.Equal(t, http.StatusNotFound, send(http.MethodDelete, "/admin/repo/one", "").Code)
+
+	// A change that cannot be saved is undone
+	saveErr = errors.New("read only")
+	require.Equal(t, http.StatusInternalServerError, send(http.MethodDelete, "/admin/repo/two", "").Code)
+	require.Equal(t, http.StatusOK, serve(t, m, http.MethodGet, "/file/two/master/a.txt", nil).Code)
+}
+
+func TestNewHandler_ObjectCacheBytes(t *testing.T) {
+	dir := t.TempDir()
+	for _, repo := range []Repository{
+		{LocalPath: dir, ObjectCacheBytes: 1 << 20},
+		{URL: "git@example.com:org/repo.git", Storage: StorageMemory, ObjectCacheBytes: 1 << 20},
+		{URL: "git@example.com:org/repo.git", ObjectCacheBytes: -1},
+	} {
+		_, err := NewHandler(testhelp.ZapTestingLogger(t), Config{Repos: []Repository{repo}}, tracing.Noop{})
+		require.ErrorContains(t, err, "ObjectCacheBytes")
+	}
+}
+
+func TestCheckoutHandler_SubmoduleMiddleware(t *testing.T) {
+	app := &fakecheckout.Checkout{
+		URL:   "git@github.com:org/app.git",
+		Files: map[string]map[string]string{"main": {}},
+		Submodules: map[string]map[string]goget.Submodule{"main": {
+			"vendor/lib":  {Path: "vendor/lib", URL: "https://github.com/org/lib", Commit: "1111"},
+			"vendor/gone": {Path: "vendor/gone", URL: "https://github.com/org/gone", Commit: "2222"},
+		}},
+	}
+	lib := &fakecheckout.Checkout{
+		URL: "git@github.com:org/lib.git",
+		Files: map[string]map[string]string{
+			"main": {"a.txt": "head"},
+			"1111": {"a.txt": "pinned"},
+		},
+	}
+	h := &CheckoutHandler{
+		Checkouts: map[string]Checkout{"app": app, "lib": lib},
+		Log:       testhelp.ZapTestingLogger(t),
+		metrics:   metrics.Noop{},
+	}
+	m := mux.NewRouter()
+	m.Use(h.SubmoduleMiddleware())
+	h.SetupMux(m)
+
+	rec := serve(t, m, http.MethodGet, "/file/app@vendor:lib/main/a.txt", nil)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Equal(t, "pinned", rec.Body.String())
+	require.Equal(t, "1111", rec.Header().Get("X-Gitdb-Submodule-Commit"))
+	require.Equal(t, "head", serve(t, m, http.MethodGet, "/file/lib/main/a.txt", nil).Body.String())
+
+	require.Equal(t, http.StatusNotFound, serve(t, m, http.MethodGet, "/file/app@vendor:gone/main/a.txt", nil).Code)
+	require.Equal(t, http.StatusNotFound, serve(t, m, http.MethodGet, "/file/app@vendor:none/main/a.txt", nil).Code)
+	require.Equal(t, http.StatusNotFound, serve(t, m, http.MethodGet, "/file/app@vendor:lib/nobranch/a.txt", nil).Code)
+	require.Equal(t, http.StatusNotFound, serve(t, m, http.MethodGet, "/file/none@vendor:lib/main/a.txt", nil).Code)
+}
+
+func TestRemoteIdentity(t *testing.T) {
+	for _, u := range []string{"git@github.com:org/repo.git", "https://github.com/org/repo", "ssh://git@GitHub.com:22/org/repo.git/"} {
+		require.Equal(t, "github.com/org/repo", RemoteIdentity(u), u)
+	}
+	require.Equal(t, "/srv/git/repo", RemoteIdentity("/srv/git/repo.git"))
+}
@@ -0,0 +1,173 @@
+package gitdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cresta/gitdb/internal/gitdb/goget"
+	"github.com/cresta/gitdb/internal/httpserver"
+	"github.com/cresta/gitdb/internal/log"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// Number of NDJSON entries written between flushes of a streaming listing
+const streamFlushEvery = 100
+
+func (h *CheckoutHandler) treeHandler(req *http.Request) httpserver.CanHTTPWrite {
+	vars := mux.Vars(req)
+	repo := vars["repo"]
+	branch := vars["branch"]
+	dir := vars["dir"]
+	logger := h.Log.With(zap.String("repo", repo), zap.String("branch", branch), zap.String("dir", dir))
+	logger.Debug(req.Context(), "tree handler")
+	r, exists := h.checkout(repo)
+	if !exists {
+		buf := strings.NewReader(fmt.Sprintf("unable to find repo %s", repo))
+		logger.Warn(req.Context(), "invalid repo")
+		return &httpserver.BasicResponse{Code: http.StatusNotFound, Msg: buf}
+	}
+	contentType := httpserver.NegotiateContentType(req, "application/json", "text/plain", "application/x-ndjson")
+	if contentType == "application/x-ndjson" {
+		return streamListing(r, dir, branch, true, logger)
+	}
+	stat := make([]goget.FileStat, 0)
+	if err := r.WalkDir(req.Context(), dir, branch, true, func(s goget.FileStat) error {
+		stat = append(stat, s)
+		return nil
+	}); err != nil {
+		return listingError(req.Context(), err, branch, dir, logger)
+	}
+	var body io.WriterTo = FileStatArr(stat)
+	if contentType == "text/plain" {
+		body = FileStatNames(stat)
+	}
+	return &httpserver.BasicResponse{
+		Code: http.StatusOK,
+		Msg:  body,
+		Headers: map[string]string{
+			"Content-Type": contentType,
+			"Vary":         "Accept",
+		},
+	}
+}
+
+func listingError(ctx context.Context, err error, branch string, dir string, logger *log.Logger) httpserver.CanHTTPWrite {
+	if errors.Is(err, goget.ErrUnknownBranch) {
+		return &httpserver.BasicResponse{
+			Code: http.StatusNotFound,
+			Msg:  strings.NewReader(fmt.Sprintf("branch not found %s", branch)),
+		}
+	}
+	if errors.Is(err, object.ErrDirectoryNotFound) {
+		return &httpserver.BasicResponse{
+			Code: http.StatusNotFound,
+			Msg:  strings.NewReader(fmt.Sprintf("directory not found %s", dir)),
+		}
+	}
+	logger.Warn(ctx, "unable to list path", zap.Error(err))
+	return &httpserver.BasicResponse{
+		Code: http.StatusInternalServerError,
+		Msg:  strings.NewReader(fmt.Sprintf("unable to list path %s: %v", dir, err)),
+	}
+}
+
+func streamListing(r Checkout, dir string, branch string, recursive bool, logger *log.Logger) *streamedListing {
+	return &streamedListing{
+		checkout:  r,
+		dir:       dir,
+		branch:    branch,
+		recursive: recursive,
+		log:       logger,
+	}
+}
+
+// streamedListing writes NDJSON entries to the client while the tree is walked.  The status line waits for the first
+// entry, so failing to resolve the branch or directory is still a proper error; errors after it drop the connection,
+// so the client cannot mistake a truncated listing for a whole one.
+type streamedListing struct {
+	checkout  Checkout
+	dir       string
+	branch    string
+	recursive bool
+	// LastModified of the entries by name, if asked for
+	modified map[string]time.Time
+	log      *log.Logger
+}
+
+func (s *streamedListing) HTTPWrite(ctx context.Context, w http.ResponseWriter, l *log.Logger) {
+	flusher, _ := w.(http.Flusher)
+	cw := &countingWriter{w: w}
+	enc := json.NewEncoder(cw)
+	numEntries := 0
+	started := false
+	start := func() {
+		if started {
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Vary", "Accept")
+		w.WriteHeader(http.StatusOK)
+		started = true
+	}
+	err := s.checkout.WalkDir(ctx, s.dir, s.branch, s.recursive, func(stat goget.FileStat) error {
+		start()
+		if t, exists := s.modified[stat.Name]; exists {
+			stat.LastModified = &t
+		}
+		if err := enc.Encode(stat); err != nil {
+			return fmt.Errorf("unable to encode entry %s: %w", stat.Name, err)
+		}
+		numEntries++
+		// Flush the first entry at once, for first-byte latency
+		if flusher != nil && (numEntries == 1 || numEntries%streamFlushEvery == 0) {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if !started && err != nil {
+		listingError(ctx, err, s.branch, s.dir, s.log).HTTPWrite(ctx, w, l)
+		return
+	}
+	if err != nil {
+		s.log.Error(ctx, "unable to stream listing", zap.Error(err), zap.Int("entries", numEntries), zap.Int64("bytes", cw.n))
+		panic(http.ErrAbortHandler)
+	}
+	// An empty directory
+	start()
+	s.log.Debug(ctx, "streamed listing", zap.Int("entries", numEntries))
+}
+
+var _ httpserver.CanHTTPWrite = &streamedListing{}
+
+// listingHash is the hash of the git tree with the entries of stat, in order.  A git checkout lists in tree order, so
+// its listings hash to the tree they were read from.
+func listingHash(stat []goget.FileStat) (string, error) {
+	t := &object.Tree{Entries: make([]object.TreeEntry, 0, len(stat))}
+	for _, s := range stat {
+		t.Entries = append(t.Entries, object.TreeEntry{Name: s.Name, Mode: filemode.FileMode(s.Mode), Hash: plumbing.NewHash(s.Hash)})
+	}
+	obj := &plumbing.MemoryObject{}
+	if err := t.Encode(obj); err != nil {
+		return "", fmt.Errorf("unable to encode tree: %w", err)
+	}
+	return obj.Hash().String(), nil
+}
+
+// setLastModified fills the LastModified of each entry found in modified
+func setLastModified(stat []goget.FileStat, modified map[string]time.Time) {
+	for i := range stat {
+		if t, exists := modified[stat[i].Name]; exists {
+			stat[i].LastModified = &t
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package gitdb
+
+import (
+	"context"
+	"errors"
+
+	"github.com/cresta/gitdb/internal/gitdb/goget"
+	"github.com/cresta/gitdb/internal/gitdb/localdir"
+	"github.com/go-git/go-git/v5"
+)
+
+// openLocal serves the directory at path for Repository.LocalPath: in place when it is a git repository, otherwise as
+// plain files
+func openLocal(ctx context.Context, g *goget.GitOperator, path string) (Checkout, error) {
+	co, err := g.OpenLocal(ctx, path)
+	if err == nil {
+		return co, nil
+	}
+	if !errors.Is(err, git.ErrRepositoryNotExists) {
+		return nil, err
+	}
+	return localdir.New(path)
+}
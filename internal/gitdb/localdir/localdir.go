@@ -0,0 +1,452 @@
+// Package localdir serves a plain directory, without git, like a repository, so developers can point gitdb at a working
+// tree.  Every branch name serves the directory as it is on disk.
+package localdir
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/cresta/gitdb/internal/gitdb/goget"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// ErrNotGit is returned for what needs git history, like commits, diffs and bundles
+var ErrNotGit = errors.New("local directory is not a git repository")
+
+// Checkout serves the files under a directory
+type Checkout struct {
+	dir         string
+	files       fs.FS
+	refreshedAt atomic.Int64
+}
+
+// New serves dir, which must exist
+func New(dir string) (*Checkout, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find absolute path of %s: %w", dir, err)
+	}
+	st, err := os.Stat(abs)
+	if err != nil {
+		return nil, fmt.Errorf("unable to stat %s: %w", abs, err)
+	}
+	if !st.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", abs)
+	}
+	ret := &Checkout{
+		dir:   abs,
+		files: os.DirFS(abs),
+	}
+	ret.refreshedAt.Store(time.Now().UnixNano())
+	return ret, nil
+}
+
+func (c *Checkout) RemoteURL() string {
+	return c.dir
+}
+
+func (c *Checkout) AbsPath() string {
+	return c.dir
+}
+
+// readFile reads p, mapping a missing file to object.ErrFileNotFound like a git tree would
+func (c *Checkout) readFile(p string) ([]byte, goget.FileInfo, error) {
+	p = strings.Trim(p, "/")
+	if !fs.ValidPath(p) {
+		return nil, goget.FileInfo{}, fmt.Errorf("invalid path %s: %w", p, object.ErrFileNotFound)
+	}
+	st, err := fs.Stat(c.files, p)
+	if err != nil || st.IsDir() {
+		return nil, goget.FileInfo{}, fmt.Errorf("unable to find file %s: %w", p, object.ErrFileNotFound)
+	}
+	data, err := fs.ReadFile(c.files, p)
+	if err != nil {
+		return nil, goget.FileInfo{}, fmt.Errorf("unable to read file %s: %w", p, err)
+	}
+	return data, goget.FileInfo{
+		CommitTime: st.ModTime(),
+		BlobHash:   plumbing.ComputeHash(plumbing.BlobObject, data).String(),
+	}, nil
+}
+
+func (c *Checkout) GetFile(ctx context.Context, branch string, path string) (io.WriterTo, error) {
+	f, _, err := c.GetFileWithInfo(ctx, branch, path)
+	return f, err
+}
+
+func (c *Checkout) GetFileWithInfo(_ context.Context, _ string, path string) (io.WriterTo, goget.FileInfo, error) {
+	data, info, err := c.readFile(path)
+	if err != nil {
+		return nil, info, err
+	}
+	return bytes.NewBuffer(data), info, nil
+}
+
+func (c *Checkout) GetFiles(ctx context.Context, branch string, paths []string) (string, []goget.BatchFile, error) {
+	ret := make([]goget.BatchFile, 0, len(paths))
+	err := c.StreamFiles(ctx, branch, paths, func(_ string, f goget.BatchFile) error {
+		ret = append(ret, f)
+		return nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return "", ret, nil
+}
+
+func (c *Checkout) StreamFiles(ctx context.Context, _ string, paths []string, callback func(commit string, f goget.BatchFile) error) error {
+	for _, p := range paths {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		f := goget.BatchFile{Path: p}
+		data, info, err := c.readFile(p)
+		if err != nil {
+			f.Error = err.Error()
+			f.NotFound = errors.Is(err, object.ErrFileNotFound)
+		} else {
+			f.Content = data
+			f.BlobHash = info.BlobHash
+		}
+		if err := callback("", f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Checkout) StatFile(_ context.Context, _ string, path string) (goget.FileInfo, int64, error) {
+	data, info, err := c.readFile(path)
+	return info, int64(len(data)), err
+}
+
+func (c *Checkout) Gzipped(string, string, goget.FileInfo, []byte) ([]byte, bool) {
+	return nil, false
+}
+
+// dirPath checks dir exists and returns it as an fs.FS path
+func (c *Checkout) dirPath(dir string) (string, error) {
+	dir = strings.Trim(dir, "/")
+	if dir == "" {
+		return ".", nil
+	}
+	if fs.ValidPath(dir) {
+		if st, err := fs.Stat(c.files, dir); err == nil && st.IsDir() {
+			return dir, nil
+		}
+	}
+	return "", fmt.Errorf("unable to find entry named %s: %w", dir, object.ErrDirectoryNotFound)
+}
+
+func (c *Checkout) fileStat(p string, name string, d fs.DirEntry) (goget.FileStat, error) {
+	if d.IsDir() {
+		return goget.FileStat{Name: name, Mode: uint32(filemode.Dir)}, nil
+	}
+	info, err := d.Info()
+	if err != nil {
+		return goget.FileStat{}, fmt.Errorf("unable to stat %s: %w", p, err)
+	}
+	mode, err := filemode.NewFromOSFileMode(info.Mode())
+	if err != nil {
+		return goget.FileStat{}, fmt.Errorf("unable to convert mode of %s: %w", p, err)
+	}
+	data, err := fs.ReadFile(c.files, p)
+	if err != nil {
+		return goget.FileStat{}, fmt.Errorf("unable to read file %s: %w", p, err)
+	}
+	return goget.FileStat{Name: name, Mode: uint32(mode), Hash: plumbing.ComputeHash(plumbing.BlobObject, data).String(), Size: int64(len(data))}, nil
+}
+
+func (c *Checkout) WalkDir(ctx context.Context, dir string, _ string, recursive bool, callback func(goget.FileStat) error) error {
+	root, err := c.dirPath(dir)
+	if err != nil {
+		return err
+	}
+	return fs.WalkDir(c.files, root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if p == root {
+			return nil
+		}
+		name := strings.TrimPrefix(p, root+"/")
+		if root == "." {
+			name = p
+		}
+		stat, err := c.fileStat(p, name, d)
+		if err != nil {
+			return err
+		}
+		if err := callback(stat); err != nil {
+			return err
+		}
+		if d.IsDir() && !recursive {
+			return fs.SkipDir
+		}
+		return nil
+	})
+}
+
+func (c *Checkout) LsDir(ctx context.Context, dir string, branch string) ([]goget.FileStat, error) {
+	ret := make([]goget.FileStat, 0)
+	err := c.WalkDir(ctx, dir, branch, false, func(stat goget.FileStat) error {
+		ret = append(ret, stat)
+		return nil
+	})
+	return ret, err
+}
+
+// LastModified is the modification time of each entry of dir, since there are no commits
+func (c *Checkout) LastModified(_ context.Context, dir string, _ string) (map[string]time.Time, error) {
+	root, err := c.dirPath(dir)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := fs.ReadDir(c.files, root)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read dir %s: %w", root, err)
+	}
+	ret := make(map[string]time.Time, len(entries))
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			return nil, fmt.Errorf("unable to stat %s: %w", path.Join(root, e.Name()), err)
+		}
+		ret[e.Name()] = info.ModTime()
+	}
+	return ret, nil
+}
+
+func (c *Checkout) LsFiles(ctx context.Context, branch string) ([]string, error) {
+	ret := make([]string, 0)
+	err := c.WalkDir(ctx, "", branch, true, func(stat goget.FileStat) error {
+		if filemode.FileMode(stat.Mode) != filemode.Dir {
+			ret = append(ret, stat.Name)
+		}
+		return nil
+	})
+	sort.Strings(ret)
+	return ret, err
+}
+
+func (c *Checkout) WalkFiles(ctx context.Context, branch string, callback func(f *object.File) error) (string, error) {
+	files, err := c.LsFiles(ctx, branch)
+	if err != nil {
+		return "", err
+	}
+	for _, p := range files {
+		data, _, err := c.readFile(p)
+		if err != nil {
+			return "", err
+		}
+		st, err := fs.Stat(c.files, p)
+		if err != nil {
+			return "", fmt.Errorf("unable to stat %s: %w", p, err)
+		}
+		mode, err := filemode.NewFromOSFileMode(st.Mode())
+		if err != nil {
+			return "", fmt.Errorf("unable to convert mode of %s: %w", p, err)
+		}
+		obj := &plumbing.MemoryObject{}
+		obj.SetType(plumbing.BlobObject)
+		if _, err := obj.Write(data); err != nil {
+			return "", fmt.Errorf("unable to buffer %s: %w", p, err)
+		}
+		blob, err := object.DecodeBlob(obj)
+		if err != nil {
+			return "", fmt.Errorf("unable to make blob of %s: %w", p, err)
+		}
+		if err := callback(object.NewFile(p, mode, blob)); err != nil {
+			return "", err
+		}
+	}
+	return "", nil
+}
+
+// walkPrefixes calls add with every file under each prefix, and the path it has under the prefix's folder, like
+// GitCheckout.ZipContents
+func (c *Checkout) walkPrefixes(ctx context.Context, prefixes []goget.ZipPrefix, branch string, add func(filePath string, data []byte, st fs.FileInfo) error) (int, error) {
+	files, err := c.LsFiles(ctx, branch)
+	if err != nil {
+		return 0, fmt.Errorf("unable to list files: %w", err)
+	}
+	numFiles := 0
+	for _, p := range prefixes {
+		prefix := strings.Trim(p.Prefix, "/")
+		folder := strings.Trim(p.Folder, "/")
+		for _, file := range files {
+			if !strings.HasPrefix(file, prefix) {
+				continue
+			}
+			if err := ctx.Err(); err != nil {
+				return numFiles, fmt.Errorf("stopped archiving: %w", err)
+			}
+			filePath := strings.TrimPrefix(file[len(prefix):], "/")
+			if folder != "" {
+				filePath = path.Join(folder, filePath)
+			}
+			data, _, err := c.readFile(file)
+			if err != nil {
+				return numFiles, err
+			}
+			st, err := fs.Stat(c.files, file)
+			if err != nil {
+				return numFiles, fmt.Errorf("unable to stat %s: %w", file, err)
+			}
+			if err := add(filePath, data, st); err != nil {
+				return numFiles, err
+			}
+			numFiles++
+		}
+	}
+	return numFiles, nil
+}
+
+func (c *Checkout) ZipContent(ctx context.Context, into io.Writer, prefix string, branch string) (int, error) {
+	return c.ZipContents(ctx, into, []goget.ZipPrefix{{Prefix: prefix}}, branch)
+}
+
+func (c *Checkout) ZipContents(ctx context.Context, into io.Writer, prefixes []goget.ZipPrefix, branch string) (int, error) {
+	w := zip.NewWriter(into)
+	numFiles, err := c.walkPrefixes(ctx, prefixes, branch, func(filePath string, data []byte, _ fs.FileInfo) error {
+		wf, err := w.Create(filePath)
+		if err != nil {
+			return fmt.Errorf("unable to create file at path %s: %w", filePath, err)
+		}
+		if _, err := wf.Write(data); err != nil {
+			return fmt.Errorf("unable to write file named %s: %w", filePath, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return numFiles, err
+	}
+	if err := w.Close(); err != nil {
+		return numFiles, fmt.Errorf("unable to close zip: %w", err)
+	}
+	return numFiles, nil
+}
+
+func (c *Checkout) Tar(ctx context.Context, into io.Writer, prefixes []goget.ZipPrefix, branch string) (int, error) {
+	tw := tar.NewWriter(into)
+	numFiles, err := c.walkPrefixes(ctx, prefixes, branch, func(filePath string, data []byte, st fs.FileInfo) error {
+		err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     filePath,
+			Mode:     int64(st.Mode().Perm()),
+			Size:     int64(len(data)),
+			ModTime:  st.ModTime(),
+		})
+		if err != nil {
+			return fmt.Errorf("unable to write header for %s: %w", filePath, err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			return fmt.Errorf("unable to write file named %s: %w", filePath, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return numFiles, err
+	}
+	if err := tw.Close(); err != nil {
+		return numFiles, fmt.Errorf("unable to close tar: %w", err)
+	}
+	return numFiles, nil
+}
+
+func (c *Checkout) Bundle(context.Context, io.Writer, string) error {
+	return ErrNotGit
+}
+
+func (c *Checkout) BundleAll(context.Context, io.Writer) error {
+	return ErrNotGit
+}
+
+func (c *Checkout) Commit(context.Context, string) (goget.CommitInfo, error) {
+	return goget.CommitInfo{}, ErrNotGit
+}
+
+func (c *Checkout) Commits(context.Context, string, int) ([]goget.CommitInfo, error) {
+	return nil, ErrNotGit
+}
+
+func (c *Checkout) Log(context.Context, string, string, int) ([]goget.CommitInfo, error) {
+	return nil, ErrNotGit
+}
+
+func (c *Checkout) Diff(context.Context, string, string, string, bool) (goget.TreeDiff, error) {
+	return goget.TreeDiff{}, ErrNotGit
+}
+
+func (c *Checkout) Changes(string) (goget.BranchChange, bool) {
+	return goget.BranchChange{}, false
+}
+
+func (c *Checkout) HasHead(string, string) bool {
+	return false
+}
+
+func (c *Checkout) BranchDeleted(string) (time.Time, bool) {
+	return time.Time{}, false
+}
+
+// Refresh does nothing but note the time, since files are always read from disk
+func (c *Checkout) Refresh(context.Context) error {
+	c.refreshedAt.Store(time.Now().UnixNano())
+	return nil
+}
+
+func (c *Checkout) RefreshedAt() time.Time {
+	return time.Unix(0, c.refreshedAt.Load())
+}
+
+func (c *Checkout) Revalidate(time.Duration) bool {
+	return false
+}
+
+func (c *Checkout) InMaintenance(time.Time) bool {
+	return false
+}
+
+func (c *Checkout) Verify(context.Context) (goget.VerifyReport, error) {
+	return goget.VerifyReport{}, ErrNotGit
+}
+
+func (c *Checkout) Heal(context.Context) error {
+	return ErrNotGit
+}
+
+func (c *Checkout) RotateAuth(context.Context, transport.AuthMethod) error {
+	return ErrNotGit
+}
+
+func (c *Checkout) WorkTree() (goget.WorkTreeInfo, bool) {
+	return goget.WorkTreeInfo{}, false
+}
+
+func (c *Checkout) Submodule(context.Context, string, string) (goget.Submodule, error) {
+	return goget.Submodule{}, ErrNotGit
+}
+
+func (c *Checkout) Close() error {
+	return nil
+}
@@ -0,0 +1,59 @@
+package localdir
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cresta/gitdb/internal/gitdb/goget"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckout(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "adir", "subdir"), 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello\n"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "adir", "subdir", "b.txt"), []byte("b"), 0o600))
+	c, err := New(dir)
+	require.NoError(t, err)
+
+	f, info, err := c.GetFileWithInfo(ctx, "any-branch", "a.txt")
+	require.NoError(t, err)
+	var b bytes.Buffer
+	_, err = f.WriteTo(&b)
+	require.NoError(t, err)
+	require.Equal(t, "hello\n", b.String())
+	// Same as git's blob hash, so ETags match a repository with the same content
+	require.Equal(t, "ce013625030ba8dba906f756967f9e9ca394464a", info.BlobHash)
+
+	_, _, err = c.GetFileWithInfo(ctx, "master", "missing.txt")
+	require.ErrorIs(t, err, object.ErrFileNotFound)
+	_, _, err = c.GetFileWithInfo(ctx, "master", "../outside.txt")
+	require.ErrorIs(t, err, object.ErrFileNotFound)
+
+	stat, err := c.LsDir(ctx, "adir", "master")
+	require.NoError(t, err)
+	require.Equal(t, []goget.FileStat{{Name: "subdir", Mode: 0o40000}}, stat)
+	_, err = c.LsDir(ctx, "nodir", "master")
+	require.ErrorIs(t, err, object.ErrDirectoryNotFound)
+
+	files, err := c.LsFiles(ctx, "master")
+	require.NoError(t, err)
+	require.Equal(t, []string{"a.txt", "adir/subdir/b.txt"}, files)
+
+	var zipped bytes.Buffer
+	n, err := c.ZipContents(ctx, &zipped, []goget.ZipPrefix{{Prefix: "adir", Folder: "out"}}, "master")
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	zr, err := zip.NewReader(bytes.NewReader(zipped.Bytes()), int64(zipped.Len()))
+	require.NoError(t, err)
+	require.Equal(t, "out/subdir/b.txt", zr.File[0].Name)
+
+	_, err = c.Commits(ctx, "master", 1)
+	require.ErrorIs(t, err, ErrNotGit)
+}
@@ -0,0 +1,14 @@
+package gitdb
+
+import (
+	"io"
+
+	"github.com/cresta/gitdb/internal/gitdb/metrics"
+)
+
+// observeSize records the size of a buffered response body.  Streamed bodies have no size up front and are skipped.
+func (h *CheckoutHandler) observeSize(name string, tags metrics.Tags, body io.WriterTo) {
+	if b, ok := body.(interface{ Len() int }); ok {
+		h.metrics.Observe(name, float64(b.Len()), tags)
+	}
+}
@@ -0,0 +1,105 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/cresta/gitdb/internal/log"
+)
+
+// Tags break a metric down, like by repo.  Each metric should always be sent with the same tag keys.
+type Tags map[string]string
+
+// Metrics sends measurements to a backend.  Names are snake case with a unit suffix, like
+// gitdb_refresh_duration_seconds, so every backend can use them as they are.
+type Metrics interface {
+	// Count adds delta to a counter
+	Count(name string, delta float64, tags Tags)
+	// Observe adds a value, like a duration in seconds or a size in bytes, to a distribution
+	Observe(name string, value float64, tags Tags)
+	// Gauge sets the current value of a gauge
+	Gauge(name string, value float64, tags Tags)
+	// Handler serves the metrics for scraping, or is nil for backends that push them
+	Handler() http.Handler
+}
+
+type Constructor func(config Config) (Metrics, error)
+
+type Registry struct {
+	Constructors map[string]Constructor
+	mu           sync.RWMutex
+}
+
+// DefaultRegistry holds the backends added with RegisterMetrics.  It is the registry gitdb picks GITDB_METRICS from.
+var DefaultRegistry = &Registry{}
+
+// RegisterMetrics adds a backend to DefaultRegistry.  Call it from an init function, then blank import the package
+// from main, to build gitdb with another backend.
+func RegisterMetrics(name string, ctor Constructor) {
+	DefaultRegistry.Register(name, ctor)
+}
+
+// Register adds a backend named name.  It panics if the name is taken, like http.Handle does, since that is a build
+// mistake.
+func (r *Registry) Register(name string, ctor Constructor) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.Constructors[name]; exists {
+		panic(fmt.Sprintf("metrics %s registered twice", name))
+	}
+	if r.Constructors == nil {
+		r.Constructors = make(map[string]Constructor)
+	}
+	r.Constructors[name] = ctor
+}
+
+// New creates the backend named name.  An empty name or "noop" sends metrics nowhere.
+func (r *Registry) New(name string, config Config) (Metrics, error) {
+	if name == "" || name == "noop" || r == nil {
+		config.Log.Info(context.Background(), "returning no-op metrics")
+		return Noop{}, nil
+	}
+	r.mu.RLock()
+	cons, exists := r.Constructors[name]
+	r.mu.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("unable to find metrics named: %s", name)
+	}
+	ret, err := cons(config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create metrics %s: %w", name, err)
+	}
+	return ret, nil
+}
+
+type Config struct {
+	Log *log.Logger
+	Env []string
+}
+
+var _ Metrics = Noop{}
+
+type Noop struct{}
+
+func (n Noop) Count(_ string, _ float64, _ Tags) {
+}
+
+func (n Noop) Observe(_ string, _ float64, _ Tags) {
+}
+
+func (n Noop) Gauge(_ string, _ float64, _ Tags) {
+}
+
+func (n Noop) Handler() http.Handler {
+	return nil
+}
+
+// OrNoop returns m, or Noop if m is nil, for optional Metrics fields
+func OrNoop(m Metrics) Metrics {
+	if m == nil {
+		return Noop{}
+	}
+	return m
+}
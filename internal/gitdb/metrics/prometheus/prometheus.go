@@ -0,0 +1,151 @@
+package prometheus
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cresta/gitdb/internal/gitdb/metrics"
+	"github.com/cresta/gitdb/internal/log"
+	client "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+)
+
+var _ metrics.Constructor = NewMetrics
+
+func init() {
+	metrics.RegisterMetrics("prometheus", NewMetrics)
+}
+
+var (
+	// 256B to 64MB
+	sizeBuckets = client.ExponentialBuckets(256, 4, 10)
+	// 5ms to about 11 minutes, for both requests and clones
+	durationBuckets = client.ExponentialBuckets(0.005, 2, 18)
+)
+
+// NewMetrics keeps metrics in memory for Prometheus to scrape from Handler, along with Go runtime and process metrics.
+// Each metric is created the first time it is sent, with its first tags' keys as labels.
+func NewMetrics(cfg metrics.Config) (metrics.Metrics, error) {
+	registry := client.NewRegistry()
+	if err := registry.Register(collectors.NewGoCollector()); err != nil {
+		return nil, err
+	}
+	if err := registry.Register(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{})); err != nil {
+		return nil, err
+	}
+	cfg.Log.Info(context.Background(), "prometheus metrics enabled")
+	return &Metrics{
+		log:        cfg.Log,
+		registry:   registry,
+		counters:   make(map[string]*client.CounterVec),
+		histograms: make(map[string]*client.HistogramVec),
+		gauges:     make(map[string]*client.GaugeVec),
+	}, nil
+}
+
+var _ metrics.Metrics = &Metrics{}
+
+type Metrics struct {
+	log      *log.Logger
+	registry *client.Registry
+
+	mu         sync.Mutex
+	counters   map[string]*client.CounterVec
+	histograms map[string]*client.HistogramVec
+	gauges     map[string]*client.GaugeVec
+}
+
+func labelNames(tags metrics.Tags) []string {
+	ret := make([]string, 0, len(tags))
+	for k := range tags {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// buckets picks histogram buckets from the unit at the end of name
+func buckets(name string) []float64 {
+	switch {
+	case strings.HasSuffix(name, "_bytes"):
+		return sizeBuckets
+	case strings.HasSuffix(name, "_seconds"):
+		return durationBuckets
+	default:
+		return client.DefBuckets
+	}
+}
+
+// vec returns the metric named name from vecs, creating and registering it if needed.  Must hold m.mu.
+func vec[V client.Collector](m *Metrics, vecs map[string]V, name string, create func() V) (V, bool) {
+	if v, exists := vecs[name]; exists {
+		return v, true
+	}
+	v := create()
+	if err := m.registry.Register(v); err != nil {
+		m.log.Warn(context.Background(), "unable to register metric", zap.String("name", name), zap.Error(err))
+		return v, false
+	}
+	vecs[name] = v
+	return v, true
+}
+
+func (m *Metrics) Count(name string, delta float64, tags metrics.Tags) {
+	m.mu.Lock()
+	v, ok := vec(m, m.counters, name, func() *client.CounterVec {
+		return client.NewCounterVec(client.CounterOpts{Name: name, Help: name}, labelNames(tags))
+	})
+	m.mu.Unlock()
+	if !ok {
+		return
+	}
+	c, err := v.GetMetricWith(client.Labels(tags))
+	if err != nil {
+		m.log.Warn(context.Background(), "unable to count metric", zap.String("name", name), zap.Error(err))
+		return
+	}
+	c.Add(delta)
+}
+
+func (m *Metrics) Observe(name string, value float64, tags metrics.Tags) {
+	m.mu.Lock()
+	v, ok := vec(m, m.histograms, name, func() *client.HistogramVec {
+		return client.NewHistogramVec(client.HistogramOpts{Name: name, Help: name, Buckets: buckets(name)}, labelNames(tags))
+	})
+	m.mu.Unlock()
+	if !ok {
+		return
+	}
+	o, err := v.GetMetricWith(client.Labels(tags))
+	if err != nil {
+		m.log.Warn(context.Background(), "unable to observe metric", zap.String("name", name), zap.Error(err))
+		return
+	}
+	o.Observe(value)
+}
+
+func (m *Metrics) Gauge(name string, value float64, tags metrics.Tags) {
+	m.mu.Lock()
+	v, ok := vec(m, m.gauges, name, func() *client.GaugeVec {
+		return client.NewGaugeVec(client.GaugeOpts{Name: name, Help: name}, labelNames(tags))
+	})
+	m.mu.Unlock()
+	if !ok {
+		return
+	}
+	g, err := v.GetMetricWith(client.Labels(tags))
+	if err != nil {
+		m.log.Warn(context.Background(), "unable to set metric", zap.String("name", name), zap.Error(err))
+		return
+	}
+	g.Set(value)
+}
+
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
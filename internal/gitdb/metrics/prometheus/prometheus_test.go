@@ -0,0 +1,29 @@
+package prometheus
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cresta/gitdb/internal/gitdb/metrics"
+	"github.com/cresta/gitdb/internal/testhelp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	m, err := NewMetrics(metrics.Config{Log: testhelp.ZapTestingLogger(t)})
+	require.NoError(t, err)
+	m.Count("test_requests_total", 2, metrics.Tags{"repo": "a"})
+	m.Observe("test_size_bytes", 300, metrics.Tags{"repo": "a"})
+	m.Gauge("test_checkouts", 3, nil)
+	// Different tag keys than the metric was created with are dropped instead of panicking
+	m.Count("test_requests_total", 1, metrics.Tags{"other": "b"})
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	require.Contains(t, body, `test_requests_total{repo="a"} 2`)
+	require.Contains(t, body, `test_size_bytes_bucket{repo="a",le="1024"} 1`)
+	require.Contains(t, body, "test_checkouts 3")
+	require.Contains(t, body, "go_goroutines")
+}
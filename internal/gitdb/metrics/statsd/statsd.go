@@ -0,0 +1,89 @@
+package statsd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/DataDog/datadog-go/v5/statsd"
+	"github.com/cresta/gitdb/internal/gitdb/metrics"
+	"github.com/cresta/gitdb/internal/log"
+	"go.uber.org/zap"
+)
+
+// Where the DataDog agent listens for DogStatsD by default
+const ddStatsFile = "/var/run/datadog/dsd.socket"
+
+var _ metrics.Constructor = NewMetrics
+
+func init() {
+	metrics.RegisterMetrics("statsd", NewMetrics)
+}
+
+// NewMetrics sends metrics to a StatsD or DogStatsD agent at GITDB_STATSD_ADDR, like "localhost:8125" or
+// "unix:///var/run/datadog/dsd.socket".  Unset uses DD_DOGSTATSD_URL or DD_AGENT_HOST if set, then the DataDog agent's
+// socket if it exists, then localhost:8125.
+func NewMetrics(cfg metrics.Config) (metrics.Metrics, error) {
+	addr := statsdAddr(cfg.Env)
+	c, err := statsd.New(addr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create statsd client for %s: %w", addr, err)
+	}
+	cfg.Log.Info(context.Background(), "statsd metrics enabled", zap.String("addr", addr))
+	return &Metrics{client: c, log: cfg.Log}, nil
+}
+
+func statsdAddr(env []string) string {
+	vars := make(map[string]string)
+	for _, e := range env {
+		if k, v, found := strings.Cut(e, "="); found {
+			vars[k] = v
+		}
+	}
+	if addr := vars["GITDB_STATSD_ADDR"]; addr != "" {
+		return addr
+	}
+	if vars["DD_DOGSTATSD_URL"] != "" || vars["DD_AGENT_HOST"] != "" {
+		// The client reads these itself
+		return ""
+	}
+	if info, err := os.Stat(ddStatsFile); err == nil && !info.IsDir() {
+		return "unix://" + ddStatsFile
+	}
+	return "localhost:8125"
+}
+
+var _ metrics.Metrics = &Metrics{}
+
+type Metrics struct {
+	client *statsd.Client
+	log    *log.Logger
+}
+
+func statsdTags(tags metrics.Tags) []string {
+	ret := make([]string, 0, len(tags))
+	for k, v := range tags {
+		ret = append(ret, k+":"+v)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func (m *Metrics) Count(name string, delta float64, tags metrics.Tags) {
+	m.log.IfErr(m.client.Count(name, int64(delta), statsdTags(tags), 1)).Debug(context.Background(), "unable to send metric", zap.String("name", name))
+}
+
+func (m *Metrics) Observe(name string, value float64, tags metrics.Tags) {
+	m.log.IfErr(m.client.Distribution(name, value, statsdTags(tags), 1)).Debug(context.Background(), "unable to send metric", zap.String("name", name))
+}
+
+func (m *Metrics) Gauge(name string, value float64, tags metrics.Tags) {
+	m.log.IfErr(m.client.Gauge(name, value, statsdTags(tags), 1)).Debug(context.Background(), "unable to send metric", zap.String("name", name))
+}
+
+func (m *Metrics) Handler() http.Handler {
+	return nil
+}
@@ -0,0 +1,75 @@
+package gitdb
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/cresta/gitdb/internal/httpserver"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// Routes that read at ?at=, by mux route name
+var pinnedRoutes = map[string]struct{}{
+	"get_file_handler":        {},
+	"ls_dir_handler":          {},
+	"tree_handler":            {},
+	"zip_dir_handler":         {},
+	"tar_dir_handler":         {},
+	"batch_handler":           {},
+	"snapshot_handler":        {},
+	"public_get_file_handler": {},
+	"public_ls_dir_handler":   {},
+	"public_zip_dir_handler":  {},
+	"public_tar_dir_handler":  {},
+}
+
+// PinnedReadMiddleware serves requests with ?at=<commit> at that commit instead of the tip of their branch, so a client
+// reading several files can read them all at the Commit of one /snapshot even if refreshes land in between.  Routes
+// that cannot read at a commit reject ?at= rather than ignore it.
+func (h *CheckoutHandler) PinnedReadMiddleware() func(http.Handler) http.Handler {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			at := request.URL.Query().Get("at")
+			if at == "" {
+				handler.ServeHTTP(writer, request)
+				return
+			}
+			vars := mux.Vars(request)
+			errResp := pinnedReadError(request, at)
+			if errResp != nil {
+				h.Log.Debug(request.Context(), "invalid pinned read", zap.String("at", at), zap.String("repo", vars["repo"]))
+				errResp.HTTPWrite(request.Context(), writer, h.Log)
+				return
+			}
+			resolved := make(map[string]string, len(vars))
+			for k, v := range vars {
+				resolved[k] = v
+			}
+			resolved["branch"] = at
+			handler.ServeHTTP(writer, mux.SetURLVars(request, resolved))
+		})
+	}
+}
+
+func pinnedReadError(request *http.Request, at string) *httpserver.BasicResponse {
+	route := mux.CurrentRoute(request)
+	if route == nil {
+		return nil
+	}
+	if _, exists := pinnedRoutes[route.GetName()]; !exists {
+		return &httpserver.BasicResponse{
+			Code: http.StatusBadRequest,
+			Msg:  strings.NewReader(fmt.Sprintf("route %s cannot read at a commit", route.GetName())),
+		}
+	}
+	if !plumbing.IsHash(at) {
+		return &httpserver.BasicResponse{
+			Code: http.StatusBadRequest,
+			Msg:  strings.NewReader(fmt.Sprintf("at must be a full commit hash, like the Commit of a snapshot, not %s", at)),
+		}
+	}
+	return nil
+}
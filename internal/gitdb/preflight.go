@@ -0,0 +1,65 @@
+package gitdb
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cresta/gitdb/internal/gitdb/goget"
+)
+
+// PreflightResult is whether one configured repository is reachable
+type PreflightResult struct {
+	Repo string
+	// Refs the remote listed.  Zero for LocalPath repositories.
+	Refs     int
+	Duration time.Duration
+	Err      error
+}
+
+// Preflight checks every repository in repos can be cloned, without cloning it: credentials are loaded and the remote
+// is listed with them, which fails on DNS, SSH and token problems alike.  LocalPath repositories only need to exist.
+// Results are in the order of repos, keyed by naming, each checked within timeout.
+func Preflight(ctx context.Context, repos []Repository, naming RepoKeyNaming, timeout time.Duration) []PreflightResult {
+	ret := make([]PreflightResult, len(repos))
+	var wg sync.WaitGroup
+	for idx, repo := range repos {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			start := time.Now()
+			refs, err := preflightRepo(ctx, repo)
+			ret[idx] = PreflightResult{
+				Repo:     repo.key(naming),
+				Refs:     refs,
+				Duration: time.Since(start),
+				Err:      err,
+			}
+		}()
+	}
+	wg.Wait()
+	return ret
+}
+
+func preflightRepo(ctx context.Context, repo Repository) (int, error) {
+	if localPath := strings.TrimSpace(repo.LocalPath); localPath != "" {
+		if _, err := os.Stat(localPath); err != nil {
+			return 0, fmt.Errorf("unable to find local path: %w", err)
+		}
+		return 0, nil
+	}
+	authMethod, err := getAuthMethod(repo)
+	if err != nil {
+		return 0, fmt.Errorf("unable to load credentials: %w", err)
+	}
+	refs, err := goget.LsRemote(ctx, strings.TrimSpace(repo.URL), authMethod)
+	if err != nil {
+		return 0, err
+	}
+	return len(refs), nil
+}
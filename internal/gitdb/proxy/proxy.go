@@ -0,0 +1,180 @@
+// Package proxy serves reads from another gitdb instead of from clones, caching content by the blob or tree hash the
+// upstream tags it with, for a regional read tier that clones nothing
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/cresta/gitdb/client"
+	"github.com/cresta/gitdb/internal/gitdb/goget"
+	"github.com/cresta/gitdb/internal/gitdb/metrics"
+	"github.com/cresta/gitdb/internal/httpserver"
+	"github.com/cresta/gitdb/internal/log"
+	"github.com/gorilla/mux"
+	lru "github.com/hashicorp/golang-lru"
+	"go.uber.org/zap"
+)
+
+// How many request paths the proxy remembers the hash of the content last served for
+const maxKnownPaths = 100000
+
+type Config struct {
+	// The gitdb reads are proxied to
+	Upstream *client.Client
+	// Bytes of content to keep in memory, keyed by blob or tree hash.  Zero caches nothing.
+	CacheBytes int64
+	// Optional: where hits and misses are counted
+	Metrics metrics.Metrics
+}
+
+// Handler serves /file and /ls from the upstream.  Every request still asks the upstream, with If-None-Match for the
+// hash last served for the same path, so content that did not change is only a 304 away.  Listings are always JSON.
+type Handler struct {
+	upstream *client.Client
+	cache    *goget.HashCache
+	// Keyed by upstream path, the ETag of the content last served for it
+	known   *lru.Cache
+	metrics metrics.Metrics
+	log     *log.Logger
+}
+
+func New(logger *log.Logger, cfg Config) (*Handler, error) {
+	if cfg.Upstream == nil {
+		return nil, errors.New("proxy needs an Upstream")
+	}
+	known, err := lru.New(maxKnownPaths)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create path cache: %w", err)
+	}
+	return &Handler{
+		upstream: cfg.Upstream,
+		cache:    goget.NewHashCache(cfg.CacheBytes),
+		known:    known,
+		metrics:  metrics.OrNoop(cfg.Metrics),
+		log:      logger.With(zap.String("class", "proxy.Handler")),
+	}, nil
+}
+
+// SetupMux serves the proxied routes under the names the upstream gives them, so per route settings carry over
+func (h *Handler) SetupMux(m *mux.Router) {
+	m.Methods(http.MethodGet).Path("/file/{repo}/{branch}/{path:.*}").Handler(httpserver.BasicHandler(h.fileHandler, h.log)).Name("get_file_handler")
+	m.Methods(http.MethodGet).Path("/ls/{repo}/{branch}/{dir:.*}").Handler(httpserver.BasicHandler(h.lsDirHandler, h.log)).Name("ls_dir_handler")
+}
+
+// CacheBytes is how much content the proxy holds
+func (h *Handler) CacheBytes() int64 {
+	return h.cache.Bytes()
+}
+
+func (h *Handler) fileHandler(req *http.Request) httpserver.CanHTTPWrite {
+	vars := mux.Vars(req)
+	return h.proxy(req, "file", client.FilePath(vars["repo"], vars["branch"], vars["path"]))
+}
+
+func (h *Handler) lsDirHandler(req *http.Request) httpserver.CanHTTPWrite {
+	vars := mux.Vars(req)
+	upstreamPath := client.LsPath(vars["repo"], vars["branch"], vars["dir"])
+	if req.URL.RawQuery != "" {
+		upstreamPath += "?" + req.URL.RawQuery
+	}
+	return h.proxy(req, "ls", upstreamPath)
+}
+
+// proxy serves upstreamPath from the cache if the upstream says it did not change since it was last served, and from
+// the upstream's response otherwise.  Responses without an ETag are passed through uncached.
+func (h *Handler) proxy(req *http.Request, route string, upstreamPath string) httpserver.CanHTTPWrite {
+	ctx := req.Context()
+	logger := h.log.With(zap.String("upstream_path", upstreamPath))
+	etag, cached := h.lookup(upstreamPath)
+	resp, err := h.upstream.GetIfNoneMatch(ctx, upstreamPath, etag)
+	if err != nil {
+		return h.upstreamError(ctx, err, logger)
+	}
+	result := "hit"
+	if !resp.NotModified {
+		result = "miss"
+		cached = goget.HashCacheEntry{Data: resp.Body, ContentType: resp.Header.Get("Content-Type")}
+		etag = ""
+		if hash := etagHash(resp.Header.Get("ETag")); hash != "" {
+			etag = `"` + hash + `"`
+			h.cache.Add(hash, cached)
+			h.known.Add(upstreamPath, etag)
+		}
+	}
+	h.metrics.Count("gitdb_proxy_requests_total", 1, metrics.Tags{"route": route, "result": result})
+	logger.Debug(ctx, "proxied", zap.String("result", result))
+	headers := forwardedHeaders(resp.Header)
+	headers["X-Gitdb-Proxy-Cache"] = result
+	if etag != "" {
+		// Served whole, so the ETag is strong even if the upstream compressed it
+		headers["ETag"] = etag
+		if httpserver.ETagMatches(req, etag) {
+			return &httpserver.BasicResponse{
+				Code:    http.StatusNotModified,
+				Msg:     strings.NewReader(""),
+				Headers: headers,
+			}
+		}
+	}
+	if cached.ContentType != "" {
+		headers["Content-Type"] = cached.ContentType
+	}
+	return &httpserver.BasicResponse{
+		Code:    http.StatusOK,
+		Msg:     bytes.NewReader(cached.Data),
+		Headers: headers,
+	}
+}
+
+// lookup is the ETag last served for upstreamPath and its content, or an empty ETag if either was forgotten
+func (h *Handler) lookup(upstreamPath string) (string, goget.HashCacheEntry) {
+	v, exists := h.known.Get(upstreamPath)
+	if !exists {
+		return "", goget.HashCacheEntry{}
+	}
+	etag := v.(string)
+	cached, exists := h.cache.Get(etagHash(etag))
+	if !exists {
+		return "", goget.HashCacheEntry{}
+	}
+	return etag, cached
+}
+
+// upstreamError passes the upstream's own errors, like a 404, through.  Failing to reach it at all is a 502.
+func (h *Handler) upstreamError(ctx context.Context, err error, logger *log.Logger) httpserver.CanHTTPWrite {
+	var statusErr *client.StatusError
+	if errors.As(err, &statusErr) {
+		return &httpserver.BasicResponse{
+			Code: statusErr.Code,
+			Msg:  strings.NewReader(statusErr.Body),
+		}
+	}
+	logger.Warn(ctx, "unable to reach upstream", zap.Error(err))
+	return &httpserver.BasicResponse{
+		Code: http.StatusBadGateway,
+		Msg:  strings.NewReader(fmt.Sprintf("unable to reach upstream: %v", err)),
+	}
+}
+
+// etagHash is the hash an upstream ETag, weak or strong, names
+func etagHash(etag string) string {
+	return strings.Trim(strings.TrimPrefix(etag, "W/"), `"`)
+}
+
+// forwardedHeaders are the headers of an upstream response describing the content, like X-Gitdb-Commit, rather than
+// the transfer
+func forwardedHeaders(header http.Header) map[string]string {
+	ret := make(map[string]string)
+	for k := range header {
+		switch {
+		case strings.HasPrefix(k, "X-Gitdb-"), k == "Cache-Control", k == "Deprecation", k == "Sunset":
+			ret[k] = header.Get(k)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cresta/gitdb/client"
+	"github.com/cresta/gitdb/internal/gitdb"
+	"github.com/cresta/gitdb/internal/gitdb/tracing"
+	"github.com/cresta/gitdb/internal/testhelp"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+)
+
+// newUpstream serves a repository named testrepo, remembering the status of every response
+func newUpstream(t *testing.T) (*httptest.Server, func() []int) {
+	dir := filepath.Join(t.TempDir(), "testrepo")
+	repo, err := git.PlainInit(dir, false)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello\n"), 0o600))
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+	require.NoError(t, wt.AddGlob("."))
+	_, err = wt.Commit("first", &git.CommitOptions{Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()}})
+	require.NoError(t, err)
+	h, err := gitdb.NewHandler(testhelp.ZapTestingLogger(t), gitdb.Config{
+		Repos: []gitdb.Repository{{LocalPath: dir}},
+	}, tracing.Noop{})
+	require.NoError(t, err)
+	m := mux.NewRouter()
+	h.SetupMux(m)
+	var mu sync.Mutex
+	var codes []int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, r)
+		mu.Lock()
+		codes = append(codes, rec.Code)
+		mu.Unlock()
+		for k, v := range rec.Header() {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(rec.Code)
+		_, _ = w.Write(rec.Body.Bytes())
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []int {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]int(nil), codes...)
+	}
+}
+
+func serve(t *testing.T, m *mux.Router, url string, headers map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHandler(t *testing.T) {
+	upstream, upstreamCodes := newUpstream(t)
+	h, err := New(testhelp.ZapTestingLogger(t), Config{
+		Upstream:   &client.Client{BaseURL: upstream.URL, MaxRetries: -1},
+		CacheBytes: 1 << 20,
+	})
+	require.NoError(t, err)
+	m := mux.NewRouter()
+	h.SetupMux(m)
+
+	rec := serve(t, m, "/file/testrepo/master/a.txt", nil)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Equal(t, "hello\n", rec.Body.String())
+	require.Equal(t, "miss", rec.Header().Get("X-Gitdb-Proxy-Cache"))
+	require.NotEmpty(t, rec.Header().Get("X-Gitdb-Commit"))
+	etag := rec.Header().Get("ETag")
+	require.Equal(t, `"`+rec.Header().Get("X-Gitdb-Blob-Hash")+`"`, etag)
+
+	rec = serve(t, m, "/file/testrepo/master/a.txt", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "hello\n", rec.Body.String())
+	require.Equal(t, "hit", rec.Header().Get("X-Gitdb-Proxy-Cache"))
+	require.Equal(t, []int{http.StatusOK, http.StatusNotModified}, upstreamCodes())
+
+	rec = serve(t, m, "/file/testrepo/master/a.txt", map[string]string{"If-None-Match": etag})
+	require.Equal(t, http.StatusNotModified, rec.Code)
+	require.Empty(t, rec.Body.String())
+
+	rec = serve(t, m, "/ls/testrepo/master/", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "miss", rec.Header().Get("X-Gitdb-Proxy-Cache"))
+	rec = serve(t, m, "/ls/testrepo/master/", nil)
+	require.Equal(t, "hit", rec.Header().Get("X-Gitdb-Proxy-Cache"))
+	require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	var stat []client.FileStat
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &stat))
+	require.Len(t, stat, 1)
+	require.Equal(t, "a.txt", stat[0].Name)
+	require.Positive(t, h.CacheBytes())
+
+	rec = serve(t, m, "/file/testrepo/master/missing.txt", nil)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	upstream.Close()
+	rec = serve(t, m, "/file/testrepo/master/a.txt", nil)
+	require.Equal(t, http.StatusBadGateway, rec.Code)
+}
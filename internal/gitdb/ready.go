@@ -0,0 +1,37 @@
+package gitdb
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Ready returns an error until every configured repository is cloned and, if maxStaleness is positive, fetched within
+// maxStaleness, so load balancers only route to a server that can serve every repository
+func (h *CheckoutHandler) Ready(_ context.Context, maxStaleness time.Duration) error {
+	state := h.state()
+	for _, repo := range state.repos {
+		if _, exists := state.checkouts[repo.key(h.repoKeyNaming)]; !exists {
+			return fmt.Errorf("repo %s is not cloned", repo.key(h.repoKeyNaming))
+		}
+	}
+	if maxStaleness <= 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(state.checkouts))
+	for key := range state.checkouts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		refreshedAt := state.checkouts[key].RefreshedAt()
+		if refreshedAt.IsZero() {
+			return fmt.Errorf("repo %s has never fetched", key)
+		}
+		if age := time.Since(refreshedAt); age > maxStaleness {
+			return fmt.Errorf("repo %s last fetched %s ago", key, age.Round(time.Second))
+		}
+	}
+	return nil
+}
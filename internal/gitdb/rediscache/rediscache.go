@@ -0,0 +1,97 @@
+package rediscache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cresta/gitdb/internal/gitdb/goget"
+	"github.com/redis/go-redis/v9"
+)
+
+type Config struct {
+	// URL in the form redis://[:password@]host:port/db
+	URL string
+	// How long values live in redis.  Defaults to 24 hours.
+	TTL time.Duration
+	// Values larger than this are not stored.  Defaults to 1MB.
+	MaxObjectBytes int
+	// Prepended to every key.  Defaults to "gitdb:".
+	KeyPrefix string
+	// How long a Get or Set may take before it gives up.  Checkouts read and fill the cache holding their lock, so a slow
+	// redis must not hold up every read of the repository.  Defaults to 200ms.
+	Timeout time.Duration
+}
+
+type Cache struct {
+	client         *redis.Client
+	ttl            time.Duration
+	maxObjectBytes int
+	keyPrefix      string
+	timeout        time.Duration
+}
+
+var _ goget.SharedCache = &Cache{}
+
+func New(cfg Config) (*Cache, error) {
+	opts, err := redis.ParseURL(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse redis url: %w", err)
+	}
+	// Dials, waits for a connection and reads stop at the deadline of the context, which Get and Set bound by Timeout
+	opts.ContextTimeoutEnabled = true
+	ret := &Cache{
+		client:         redis.NewClient(opts),
+		ttl:            cfg.TTL,
+		maxObjectBytes: cfg.MaxObjectBytes,
+		keyPrefix:      cfg.KeyPrefix,
+		timeout:        cfg.Timeout,
+	}
+	if ret.ttl == 0 {
+		ret.ttl = time.Hour * 24
+	}
+	if ret.maxObjectBytes == 0 {
+		ret.maxObjectBytes = 1 << 20
+	}
+	if ret.keyPrefix == "" {
+		ret.keyPrefix = "gitdb:"
+	}
+	if ret.timeout == 0 {
+		ret.timeout = time.Millisecond * 200
+	}
+	return ret, nil
+}
+
+func (c *Cache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	ret, err := c.client.Get(ctx, c.keyPrefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("unable to get key %s: %w", key, err)
+	}
+	return ret, true, nil
+}
+
+func (c *Cache) Set(ctx context.Context, key string, value []byte) error {
+	if len(value) > c.maxObjectBytes {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	if err := c.client.Set(ctx, c.keyPrefix+key, value, c.ttl).Err(); err != nil {
+		return fmt.Errorf("unable to set key %s: %w", key, err)
+	}
+	return nil
+}
+
+func (c *Cache) Ping(ctx context.Context) error {
+	return c.client.Ping(ctx).Err()
+}
+
+func (c *Cache) Close() error {
+	return c.client.Close()
+}
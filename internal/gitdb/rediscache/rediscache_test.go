@@ -0,0 +1,158 @@
+package rediscache
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRedis answers the GET, SET and PING commands of the redis protocol from memory
+type fakeRedis struct {
+	mu     sync.Mutex
+	values map[string]string
+	// The arguments of every SET after the key and value
+	setArgs [][]string
+}
+
+// serveFakeRedis listens for clients of f, or of a server that never answers if f is nil, and returns its url
+func serveFakeRedis(t *testing.T, f *fakeRedis) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, l.Close())
+	})
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if f == nil {
+					_, _ = io.Copy(io.Discard, conn)
+					return
+				}
+				f.serve(conn)
+			}()
+		}
+	}()
+	return "redis://" + l.Addr().String()
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	for {
+		cmd, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		if _, err := io.WriteString(conn, f.reply(cmd)); err != nil {
+			return
+		}
+	}
+}
+
+func (f *fakeRedis) reply(cmd []string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch strings.ToUpper(cmd[0]) {
+	case "PING":
+		return "+PONG\r\n"
+	case "GET":
+		v, exists := f.values[cmd[1]]
+		if !exists {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+	case "SET":
+		f.values[cmd[1]] = cmd[2]
+		f.setArgs = append(f.setArgs, cmd[3:])
+		return "+OK\r\n"
+	}
+	return "-ERR unknown command\r\n"
+}
+
+// readCommand reads an array of bulk strings, which is how clients send commands
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		ret = append(ret, string(buf[:size]))
+	}
+	return ret, nil
+}
+
+func TestCache(t *testing.T) {
+	ctx := context.Background()
+	server := &fakeRedis{values: map[string]string{}}
+	c, err := New(Config{URL: serveFakeRedis(t, server), TTL: time.Minute, MaxObjectBytes: 5})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, c.Close())
+	}()
+	require.NoError(t, c.Ping(ctx))
+
+	_, exists, err := c.Get(ctx, "blob:a")
+	require.NoError(t, err)
+	require.False(t, exists)
+	require.NoError(t, c.Set(ctx, "blob:a", []byte("hello")))
+	data, exists, err := c.Get(ctx, "blob:a")
+	require.NoError(t, err)
+	require.True(t, exists)
+	require.Equal(t, "hello", string(data))
+
+	// Too large to store
+	require.NoError(t, c.Set(ctx, "blob:b", []byte("goodbye")))
+	_, exists, err = c.Get(ctx, "blob:b")
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	require.Equal(t, map[string]string{"gitdb:blob:a": "hello"}, server.values)
+	require.Equal(t, [][]string{{"ex", "60"}}, server.setArgs)
+}
+
+func TestCache_timeout(t *testing.T) {
+	c, err := New(Config{URL: serveFakeRedis(t, nil), Timeout: time.Millisecond * 50})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, c.Close())
+	}()
+	start := time.Now()
+	_, _, err = c.Get(context.Background(), "blob:a")
+	require.Error(t, err)
+	require.Less(t, time.Since(start), time.Second)
+	start = time.Now()
+	require.Error(t, c.Set(context.Background(), "blob:a", []byte("hello")))
+	require.Less(t, time.Since(start), time.Second)
+}
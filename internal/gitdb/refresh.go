@@ -0,0 +1,76 @@
+package gitdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/cresta/gitdb/internal/gitdb/goget"
+	"github.com/cresta/gitdb/internal/httpserver"
+	"go.uber.org/zap"
+)
+
+// RefreshRequest is the body of POST /refresh
+type RefreshRequest struct {
+	Repos []string
+}
+
+// RefreshResult is the outcome of refreshing one repository
+type RefreshResult struct {
+	Repo  string
+	Error string `json:",omitempty"`
+}
+
+// refreshReposHandler refreshes only the listed repos.  Every repo is attempted even if an earlier one fails; the
+// response is 500 if any failed, with the result of each in the body.
+func (h *CheckoutHandler) refreshReposHandler(req *http.Request) httpserver.CanHTTPWrite {
+	var body RefreshRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		return &httpserver.BasicResponse{
+			Code: http.StatusBadRequest,
+			Msg:  strings.NewReader(fmt.Sprintf("unable to decode body: %v", err)),
+		}
+	}
+	if len(body.Repos) == 0 {
+		return &httpserver.BasicResponse{
+			Code: http.StatusBadRequest,
+			Msg:  strings.NewReader("no repos to refresh"),
+		}
+	}
+	checkouts := h.checkouts()
+	for _, repo := range body.Repos {
+		if _, exists := checkouts[repo]; !exists {
+			return &httpserver.BasicResponse{
+				Code: http.StatusNotFound,
+				Msg:  strings.NewReader(fmt.Sprintf("unknown repo %s", repo)),
+			}
+		}
+	}
+	code := http.StatusOK
+	results := make([]RefreshResult, 0, len(body.Repos))
+	for _, repo := range body.Repos {
+		result := RefreshResult{Repo: repo}
+		if err := checkouts[repo].Refresh(goget.WithTrigger(req.Context(), goget.TriggerManual)); err != nil {
+			h.Log.Warn(req.Context(), "unable to refresh repo", zap.String("repo", repo), zap.Error(err))
+			result.Error = err.Error()
+			code = http.StatusInternalServerError
+		}
+		results = append(results, result)
+	}
+	b, err := json.Marshal(results)
+	if err != nil {
+		return &httpserver.BasicResponse{
+			Code: http.StatusInternalServerError,
+			Msg:  strings.NewReader(fmt.Sprintf("unable to encode results: %v", err)),
+		}
+	}
+	return &httpserver.BasicResponse{
+		Code: code,
+		Msg:  bytes.NewReader(b),
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+	}
+}
@@ -0,0 +1,227 @@
+package gitdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Reload serves repos in place of the repositories currently configured, without a restart.  New repositories are
+// cloned, removed ones are no longer served, and changed credentials are rotated.  A repository whose clone settings
+// changed, like its URL, Storage or Depth, is cloned again.  Requests are served from the current repositories until
+// every new one is ready, and nothing changes if any fails to set up.
+func (h *CheckoutHandler) Reload(ctx context.Context, repos []Repository) error {
+	h.reloadMu.Lock()
+	defer h.reloadMu.Unlock()
+	return h.reloadNoLock(ctx, repos)
+}
+
+// reloadNoLock is Reload.  Must hold h.reloadMu.
+func (h *CheckoutHandler) reloadNoLock(ctx context.Context, repos []Repository) error {
+	if h.setup == nil {
+		return fmt.Errorf("unable to reload a handler not made by NewHandler")
+	}
+	current := h.state()
+	next, err := h.setup.build(ctx, repos, current)
+	if err != nil {
+		return err
+	}
+	var added, removed, recloned []string
+	for key, co := range next.checkouts {
+		if old, exists := current.checkouts[key]; !exists {
+			added = append(added, key)
+		} else if old != co {
+			recloned = append(recloned, key)
+		}
+	}
+	for key := range current.checkouts {
+		if _, exists := next.checkouts[key]; !exists {
+			removed = append(removed, key)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(recloned)
+	h.install(next)
+	h.Log.Info(ctx, "reloaded repos", zap.Strings("added", added), zap.Strings("removed", removed), zap.Strings("recloned", recloned))
+	byRepo := h.CheckoutsByRepo()
+	for _, f := range h.onReload {
+		f(byRepo)
+	}
+	dropped := make(map[string]Checkout, len(removed)+len(recloned))
+	for _, key := range append(removed, recloned...) {
+		dropped[key] = current.checkouts[key]
+	}
+	// Close waits for reads still running on the checkouts, which must not hold up the reload
+	go h.closeCheckouts(dropped)
+	return nil
+}
+
+// OnReload calls f with the checkouts by remote URL, like CheckoutsByRepo, whenever Reload or the admin API changes
+// the repositories served
+func (h *CheckoutHandler) OnReload(f func(checkoutsByRepo map[string]Checkout)) {
+	h.reloadMu.Lock()
+	defer h.reloadMu.Unlock()
+	h.onReload = append(h.onReload, f)
+}
+
+// closeCheckouts closes checkouts that are no longer served
+func (h *CheckoutHandler) closeCheckouts(checkouts map[string]Checkout) {
+	for key, co := range checkouts {
+		h.Log.IfErr(co.Close()).Warn(context.Background(), "unable to close dropped repo", zap.String("repo", key))
+	}
+}
+
+// Close closes every checkout, once the server stops serving them
+func (h *CheckoutHandler) Close() error {
+	var errs []error
+	for key, co := range h.checkouts() {
+		if err := co.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("unable to close repo %s: %w", key, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// install starts serving state
+func (h *CheckoutHandler) install(state *repoState) {
+	h.mu.Lock()
+	h.repos = state.repos
+	h.Checkouts = state.checkouts
+	h.checkoutConfigs = state.configs
+	h.readSemaphores = state.readSemaphores
+	h.s3Redirectors = state.s3Redirectors
+	h.revalidateAfter = state.revalidateAfter
+	h.deprecatedAliases = state.deprecatedAliases
+	h.mu.Unlock()
+	h.metrics.Gauge("gitdb_checkouts", float64(len(state.checkouts)), nil)
+}
+
+// state is what is served now
+func (h *CheckoutHandler) state() *repoState {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return &repoState{
+		repos:             h.repos,
+		checkouts:         h.Checkouts,
+		configs:           h.checkoutConfigs,
+		readSemaphores:    h.readSemaphores,
+		s3Redirectors:     h.s3Redirectors,
+		revalidateAfter:   h.revalidateAfter,
+		deprecatedAliases: h.deprecatedAliases,
+	}
+}
+
+// reusable returns the checkout serving key if repo can keep using it, because none of its clone settings changed
+func (r *repoState) reusable(key string, repo Repository) (Checkout, bool) {
+	if r == nil {
+		return nil, false
+	}
+	old, exists := r.configs[key]
+	if !exists || !reflect.DeepEqual(old.cloneSettings(), repo.cloneSettings()) {
+		return nil, false
+	}
+	co, exists := r.checkouts[key]
+	return co, exists
+}
+
+// authChanged is true if repo picks different credentials than the repository it replaces
+func (r *repoState) authChanged(key string, repo Repository) bool {
+	return !reflect.DeepEqual(r.configs[key].authSettings(), repo.authSettings())
+}
+
+func (r *repoState) readSemaphore(key string) (chan struct{}, bool) {
+	if r == nil {
+		return nil, false
+	}
+	sem, exists := r.readSemaphores[key]
+	return sem, exists
+}
+
+// authSettings is only the fields of r that pick credentials
+func (r Repository) authSettings() Repository {
+	return Repository{
+		PrivateKey:              r.PrivateKey,
+		PrivateKeyPassword:      r.PrivateKeyPassword,
+		PrivateKeyPasswordFile:  r.PrivateKeyPasswordFile,
+		Username:                r.Username,
+		Password:                r.Password,
+		PasswordFile:            r.PasswordFile,
+		TokenEnvVar:             r.TokenEnvVar,
+		GitHubAppID:             r.GitHubAppID,
+		GitHubAppInstallationID: r.GitHubAppInstallationID,
+		GitHubAppPrivateKey:     r.GitHubAppPrivateKey,
+		GitHubAPIURL:            r.GitHubAPIURL,
+	}
+}
+
+// cloneSettings is r without credentials and the settings applied while serving, which change without a new clone
+func (r Repository) cloneSettings() Repository {
+	r.PrivateKey, r.PrivateKeyPassword, r.PrivateKeyPasswordFile = "", "", ""
+	r.Username, r.Password, r.PasswordFile, r.TokenEnvVar = "", "", "", ""
+	r.GitHubAppID, r.GitHubAppInstallationID, r.GitHubAppPrivateKey, r.GitHubAPIURL = 0, 0, "", ""
+	r.Public = false
+	r.Anonymous = false
+	r.ResponseHeaders = nil
+	r.S3Redirect = nil
+	r.MaxConcurrentReads = 0
+	r.DisabledEndpoints = nil
+	r.RevalidateAfter = ""
+	r.DeprecatedAliases = nil
+	return r
+}
+
+// checkout returns the checkout serving repo
+func (h *CheckoutHandler) checkout(repo string) (Checkout, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	co, exists := h.Checkouts[repo]
+	return co, exists
+}
+
+// checkouts returns every checkout, keyed by repository key.  The map must not be modified.
+func (h *CheckoutHandler) checkouts() map[string]Checkout {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.Checkouts
+}
+
+func (h *CheckoutHandler) repoConfig(repo string) (Repository, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	r, exists := h.checkoutConfigs[repo]
+	return r, exists
+}
+
+func (h *CheckoutHandler) readSemaphore(repo string) (chan struct{}, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	sem, exists := h.readSemaphores[repo]
+	return sem, exists
+}
+
+func (h *CheckoutHandler) s3Redirector(repo string) (*s3Redirector, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	s, exists := h.s3Redirectors[repo]
+	return s, exists
+}
+
+func (h *CheckoutHandler) revalidateAge(repo string) (time.Duration, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	age, exists := h.revalidateAfter[repo]
+	return age, exists
+}
+
+func (h *CheckoutHandler) deprecatedAlias(name string) (deprecatedAlias, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	alias, exists := h.deprecatedAliases[name]
+	return alias, exists
+}
@@ -0,0 +1,86 @@
+package gitdb
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// RepoKeyNaming is how a repository without an Alias is keyed from its URL
+type RepoKeyNaming string
+
+const (
+	// The last segment of the path, like "repo" for https://github.com/org/repo.git.  The default.
+	RepoKeyName RepoKeyNaming = "name"
+	// Every segment of the path joined by underscores, like "org_repo", or "group_subgroup_repo" for nested GitLab groups
+	RepoKeyOrgRepo RepoKeyNaming = "org_repo"
+	// The whole path, like "org/repo".  Clients escape its slashes in routes, like /file/org%2Frepo/main/README.md.
+	RepoKeyPath RepoKeyNaming = "path"
+)
+
+func (n RepoKeyNaming) validate() error {
+	switch n {
+	case "", RepoKeyName, RepoKeyOrgRepo, RepoKeyPath:
+		return nil
+	}
+	return fmt.Errorf("unknown RepoKeyNaming %s, expected %s, %s or %s", n, RepoKeyName, RepoKeyOrgRepo, RepoKeyPath)
+}
+
+// key is what the repository is served as: its Alias, the name of its LocalPath, or a name taken from its URL by naming
+func (r Repository) key(naming RepoKeyNaming) string {
+	if r.Alias != "" {
+		return r.Alias
+	}
+	if localPath := strings.TrimSpace(r.LocalPath); localPath != "" {
+		return filepath.Base(filepath.Clean(localPath))
+	}
+	p := repoURLPath(strings.TrimSpace(r.URL))
+	switch naming {
+	case RepoKeyOrgRepo:
+		return strings.ReplaceAll(p, "/", "_")
+	case RepoKeyPath:
+		return p
+	default:
+		return p[strings.LastIndex(p, "/")+1:]
+	}
+}
+
+// repoURLPath is the path of a remote URL, without slashes around it or a .git suffix, like "org/repo" for both
+// git@github.com:org/repo.git and https://github.com/org/repo
+func repoURLPath(remoteURL string) string {
+	p := remoteURL
+	if strings.Contains(remoteURL, "://") {
+		if u, err := url.Parse(remoteURL); err == nil {
+			p = u.Path
+		}
+	} else if idx := strings.Index(remoteURL, ":"); idx >= 0 && !strings.Contains(remoteURL[:idx], "/") {
+		// scp-like, as in user@host:path
+		p = remoteURL[idx+1:]
+	}
+	return strings.Trim(strings.TrimSuffix(strings.TrimRight(p, "/"), ".git"), "/")
+}
+
+// repoKeys keys each of repos, in order.  Two repositories with one key fail before either is cloned, since the second
+// would silently replace the first.
+func repoKeys(repos []Repository, naming RepoKeyNaming) ([]string, error) {
+	ret := make([]string, len(repos))
+	seen := make(map[string]int, len(repos))
+	for idx, repo := range repos {
+		key := repo.key(naming)
+		if other, exists := seen[key]; exists && key != "" {
+			return nil, fmt.Errorf("repos %s and %s are both keyed %s: set an Alias on one, or another RepoKeyNaming", repos[other].source(), repo.source(), key)
+		}
+		seen[key] = idx
+		ret[idx] = key
+	}
+	return ret, nil
+}
+
+// source is where the repository comes from, for errors
+func (r Repository) source() string {
+	if u := strings.TrimSpace(r.URL); u != "" {
+		return u
+	}
+	return strings.TrimSpace(r.LocalPath)
+}
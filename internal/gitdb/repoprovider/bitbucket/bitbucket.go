@@ -0,0 +1,306 @@
+package bitbucket
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/cresta/gitdb/internal/gitdb"
+	"github.com/cresta/gitdb/internal/gitdb/goget"
+	"github.com/cresta/gitdb/internal/gitdb/tracing"
+	"github.com/cresta/gitdb/internal/httpserver"
+	"github.com/cresta/gitdb/internal/log"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+type GitCheckout interface {
+	Refresh(ctx context.Context) error
+	HasHead(branch string, hash string) bool
+}
+
+type Provider struct {
+	Secret    []byte
+	Logger    *log.Logger
+	Checkouts map[string]GitCheckout
+	Tracing   tracing.Tracing
+
+	mu sync.RWMutex
+}
+
+func Setup(secret string, logger *log.Logger, handler *gitdb.CheckoutHandler, tracer tracing.Tracing) *Provider {
+	if secret == "" {
+		logger.Info(context.Background(), "no bitbucket webhook secret.  Not setting up bitbucket push notifier")
+		return nil
+	}
+	return &Provider{
+		Tracing:   tracer,
+		Secret:    []byte(secret),
+		Logger:    logger.With(zap.String("class", "bitbucket.Provider")),
+		Checkouts: asGitCheckouts(handler.CheckoutsByRepo()),
+	}
+}
+
+func asGitCheckouts(in map[string]gitdb.Checkout) map[string]GitCheckout {
+	ret := make(map[string]GitCheckout, len(in))
+	for k, v := range in {
+		ret[k] = v
+	}
+	return ret
+}
+
+// SetCheckouts replaces the checkouts pushes refresh, keyed by remote URL, like after the config is reloaded
+func (p *Provider) SetCheckouts(checkouts map[string]gitdb.Checkout) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Checkouts = asGitCheckouts(checkouts)
+}
+
+func (p *Provider) checkout(remoteURL string) (GitCheckout, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	c, exists := p.Checkouts[remoteURL]
+	return c, exists
+}
+
+func (p *Provider) SetupMux(mux *mux.Router) {
+	mux.Methods(http.MethodPost).Path("/public/bitbucket/webhook").Handler(httpserver.BasicHandler(p.bitbucketWebhook, p.Logger)).Name("bitbucket_webhook")
+}
+
+// Event keys sent in X-Event-Key
+const (
+	// Bitbucket Cloud
+	cloudPush = "repo:push"
+	// Bitbucket Server and Data Center
+	serverPush = "repo:refs_changed"
+	serverPing = "diagnostics:ping"
+)
+
+type link struct {
+	Href string `json:"href"`
+	Name string `json:"name"`
+}
+
+type repository struct {
+	// Cloud only, like "team/repo"
+	FullName string `json:"full_name"`
+	Links    struct {
+		// Server only
+		Clone []link `json:"clone"`
+	} `json:"links"`
+}
+
+// pushEvent is the part of Cloud repo:push and Server repo:refs_changed payloads gitdb uses
+type pushEvent struct {
+	Repository repository `json:"repository"`
+	// Cloud
+	Push struct {
+		Changes []struct {
+			New *struct {
+				Type   string `json:"type"`
+				Name   string `json:"name"`
+				Target struct {
+					Hash string `json:"hash"`
+				} `json:"target"`
+			} `json:"new"`
+		} `json:"changes"`
+	} `json:"push"`
+	// Server
+	Changes []struct {
+		Ref struct {
+			DisplayID string `json:"displayId"`
+			Type      string `json:"type"`
+		} `json:"ref"`
+		ToHash string `json:"toHash"`
+		Type   string `json:"type"`
+	} `json:"changes"`
+}
+
+// cloneURLs are the urls a checkout of the repository may have been cloned from.  Cloud payloads have no clone links,
+// so they are built from the full name.
+func (e *pushEvent) cloneURLs() []string {
+	var ret []string
+	for _, l := range e.Repository.Links.Clone {
+		ret = append(ret, l.Href)
+	}
+	if e.Repository.FullName != "" {
+		ret = append(ret, "git@bitbucket.org:"+e.Repository.FullName+".git", "https://bitbucket.org/"+e.Repository.FullName+".git")
+	}
+	return ret
+}
+
+type head struct {
+	branch string
+	hash   string
+}
+
+// pushedHeads returns the branches and new heads of a push.  ok is false if anything other than a branch was updated,
+// like a tag or a deleted branch, since those cannot be checked with HasHead.
+func (e *pushEvent) pushedHeads() (heads []head, ok bool) {
+	for _, c := range e.Push.Changes {
+		if c.New == nil || c.New.Type != "branch" || c.New.Target.Hash == "" {
+			return nil, false
+		}
+		heads = append(heads, head{branch: c.New.Name, hash: c.New.Target.Hash})
+	}
+	for _, c := range e.Changes {
+		if c.Ref.Type != "BRANCH" || c.Type == "DELETE" || c.ToHash == "" {
+			return nil, false
+		}
+		heads = append(heads, head{branch: c.Ref.DisplayID, hash: c.ToHash})
+	}
+	return heads, len(heads) > 0
+}
+
+// validSignature checks X-Hub-Signature, an HMAC of the body like "sha256=<hex>".  Cloud and newer Server versions send
+// sha256, older Server versions sha1.
+func validSignature(signature string, body []byte, secret []byte) bool {
+	algo, sum, ok := strings.Cut(signature, "=")
+	if !ok {
+		return false
+	}
+	var newHash func() hash.Hash
+	switch algo {
+	case "sha256":
+		newHash = sha256.New
+	case "sha1":
+		newHash = sha1.New
+	default:
+		return false
+	}
+	expected, err := hex.DecodeString(sum)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(newHash, secret)
+	_, _ = mac.Write(body)
+	return hmac.Equal(expected, mac.Sum(nil))
+}
+
+func (p *Provider) bitbucketWebhook(req *http.Request) httpserver.CanHTTPWrite {
+	eventKey := req.Header.Get("X-Event-Key")
+	if eventKey == "" {
+		p.Logger.Warn(req.Context(), "invalid webhook type")
+		return &httpserver.BasicResponse{
+			Code: http.StatusBadRequest,
+			Msg:  strings.NewReader("could not find webhook type"),
+		}
+	}
+	p.Tracing.AttachTag(req.Context(), "bitbucket.event_key", eventKey)
+	body, err := io.ReadAll(req.Body)
+	if httpserver.IsBodyTooLarge(err) {
+		p.Logger.Warn(req.Context(), "webhook payload too large", zap.Error(err))
+		return &httpserver.BasicResponse{
+			Code: http.StatusRequestEntityTooLarge,
+			Msg:  strings.NewReader(fmt.Sprintf("webhook payload too large: %v", err)),
+		}
+	}
+	if err != nil {
+		p.Logger.Warn(req.Context(), "unable to read payload", zap.Error(err))
+		return &httpserver.BasicResponse{
+			Code: http.StatusBadRequest,
+			Msg:  strings.NewReader(fmt.Sprintf("unable to read payload: %v", err)),
+		}
+	}
+	if !validSignature(req.Header.Get("X-Hub-Signature"), body, p.Secret) {
+		p.Logger.Warn(req.Context(), "invalid webhook signature")
+		return &httpserver.BasicResponse{
+			Code: http.StatusForbidden,
+			Msg:  strings.NewReader("invalid X-Hub-Signature"),
+		}
+	}
+	switch eventKey {
+	case serverPing:
+		p.Logger.Info(req.Context(), "ping event")
+		return &httpserver.BasicResponse{
+			Code: http.StatusOK,
+			Msg:  strings.NewReader("PONG"),
+		}
+	case cloudPush, serverPush:
+	default:
+		return &httpserver.BasicResponse{
+			Code: http.StatusNotAcceptable,
+			Msg:  strings.NewReader(fmt.Sprintf("cannot process event: %s", eventKey)),
+		}
+	}
+	var event pushEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		p.Logger.Warn(req.Context(), "unable to parse webhook", zap.Error(err))
+		return &httpserver.BasicResponse{
+			Code: http.StatusBadRequest,
+			Msg:  strings.NewReader(fmt.Sprintf("cannot parse webhook: %v", err)),
+		}
+	}
+	return p.pushEvent(req, &event)
+}
+
+func (p *Provider) pushEvent(req *http.Request, event *pushEvent) httpserver.CanHTTPWrite {
+	p.Logger.Info(req.Context(), "push event")
+	urls := event.cloneURLs()
+	if len(urls) == 0 {
+		p.Logger.Warn(req.Context(), "No repository url set")
+		return &httpserver.BasicResponse{
+			Code: http.StatusBadRequest,
+			Msg:  strings.NewReader("no repository url set"),
+		}
+	}
+	var repoURL string
+	var checkout GitCheckout
+	for _, u := range urls {
+		if c, exists := p.checkout(u); exists {
+			repoURL, checkout = u, c
+			break
+		}
+	}
+	if checkout == nil {
+		p.Logger.Warn(req.Context(), "cannot find checkout", zap.Strings("urls", urls))
+		return &httpserver.BasicResponse{
+			Code: http.StatusBadRequest,
+			Msg:  strings.NewReader("cannot find checkout"),
+		}
+	}
+	logger := p.Logger.With(zap.String("repo", repoURL))
+	if heads, ok := event.pushedHeads(); ok && hasHeads(checkout, heads) {
+		logger.Info(req.Context(), "branches already at pushed heads, skipping refresh")
+		return &httpserver.BasicResponse{
+			Code: http.StatusOK,
+			Msg:  strings.NewReader(fmt.Sprintf("repository %s already up to date", repoURL)),
+		}
+	}
+	if httpserver.DryRun(req) {
+		logger.Info(req.Context(), "dry run, not refreshing repository")
+		return &httpserver.BasicResponse{
+			Code: http.StatusOK,
+			Msg:  strings.NewReader(fmt.Sprintf("dry run: would refresh repository %s", repoURL)),
+		}
+	}
+	if err := checkout.Refresh(goget.WithTrigger(req.Context(), goget.TriggerWebhook)); err != nil {
+		logger.Warn(req.Context(), "cannot refresh repository", zap.Error(err))
+		return &httpserver.BasicResponse{
+			Code: http.StatusInternalServerError,
+			Msg:  strings.NewReader(fmt.Sprintf("cannot refresh repository: %v", err)),
+		}
+	}
+	return &httpserver.BasicResponse{
+		Code: http.StatusOK,
+		Msg:  strings.NewReader(fmt.Sprintf("refreshed repository %s", repoURL)),
+	}
+}
+
+func hasHeads(checkout GitCheckout, heads []head) bool {
+	for _, h := range heads {
+		if !checkout.HasHead(h.branch, h.hash) {
+			return false
+		}
+	}
+	return true
+}
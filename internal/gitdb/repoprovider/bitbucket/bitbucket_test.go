@@ -0,0 +1,74 @@
+package bitbucket
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cresta/gitdb/internal/gitdb/tracing"
+	"github.com/cresta/gitdb/internal/testhelp"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeCheckout struct {
+	heads     map[string]string
+	refreshes int
+}
+
+func (f *fakeCheckout) Refresh(_ context.Context) error {
+	f.refreshes++
+	return nil
+}
+
+func (f *fakeCheckout) HasHead(branch string, hash string) bool {
+	return f.heads[branch] == hash
+}
+
+const cloudPayload = `{"repository":{"full_name":"team/repo"},"push":{"changes":[{"new":{"type":"branch","name":"master","target":{"hash":"abc"}}}]}}`
+
+const serverPayload = `{"repository":{"links":{"clone":[{"href":"ssh://git@bitbucket.example.com:7999/prj/repo.git","name":"ssh"}]}},"changes":[{"ref":{"displayId":"master","type":"BRANCH"},"toHash":"def","type":"UPDATE"}]}`
+
+func sign(body string, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestProvider_Webhook(t *testing.T) {
+	cloud := &fakeCheckout{heads: map[string]string{"master": "abc"}}
+	server := &fakeCheckout{}
+	p := &Provider{
+		Secret: []byte("secret"),
+		Logger: testhelp.ZapTestingLogger(t),
+		Checkouts: map[string]GitCheckout{
+			"git@bitbucket.org:team/repo.git":                   cloud,
+			"ssh://git@bitbucket.example.com:7999/prj/repo.git": server,
+		},
+		Tracing: tracing.Noop{},
+	}
+	send := func(eventKey string, body string, signature string) int {
+		return sendTo(p, "/public/bitbucket/webhook", eventKey, body, signature)
+	}
+	require.Equal(t, http.StatusForbidden, send(cloudPush, cloudPayload, sign(cloudPayload, "wrong")))
+	require.Equal(t, http.StatusOK, send(cloudPush, cloudPayload, sign(cloudPayload, "secret")))
+	require.Equal(t, 0, cloud.refreshes)
+	require.Equal(t, http.StatusOK, sendTo(p, "/public/bitbucket/webhook?dry_run=true", serverPush, serverPayload, sign(serverPayload, "secret")))
+	require.Equal(t, 0, server.refreshes)
+	require.Equal(t, http.StatusOK, send(serverPush, serverPayload, sign(serverPayload, "secret")))
+	require.Equal(t, 1, server.refreshes)
+	require.Equal(t, http.StatusOK, send(serverPing, "{}", sign("{}", "secret")))
+}
+
+func sendTo(p *Provider, target string, eventKey string, body string, signature string) int {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	req.Header.Set("X-Event-Key", eventKey)
+	req.Header.Set("X-Hub-Signature", signature)
+	rec := httptest.NewRecorder()
+	p.bitbucketWebhook(req).HTTPWrite(req.Context(), rec, p.Logger)
+	return rec.Code
+}
@@ -5,12 +5,12 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
-
-	"github.com/cresta/gitdb/internal/gitdb/goget"
+	"sync"
 
 	"github.com/cresta/gitdb/internal/gitdb/tracing"
 
 	"github.com/cresta/gitdb/internal/gitdb"
+	"github.com/cresta/gitdb/internal/gitdb/goget"
 	"github.com/cresta/gitdb/internal/httpserver"
 
 	"github.com/cresta/gitdb/internal/log"
@@ -22,6 +22,7 @@ import (
 
 type GitCheckout interface {
 	Refresh(ctx context.Context) error
+	HasHead(branch string, hash string) bool
 }
 
 type Provider struct {
@@ -29,6 +30,8 @@ type Provider struct {
 	Logger    *log.Logger
 	Checkouts map[string]GitCheckout
 	Tracing   tracing.Tracing
+
+	mu sync.RWMutex
 }
 
 func Setup(pushToken string, logger *log.Logger, handler *gitdb.CheckoutHandler, tracer tracing.Tracing) *Provider {
@@ -45,7 +48,7 @@ func Setup(pushToken string, logger *log.Logger, handler *gitdb.CheckoutHandler,
 	return ret
 }
 
-func uselessCasting(in map[string]*goget.GitCheckout) map[string]GitCheckout {
+func uselessCasting(in map[string]gitdb.Checkout) map[string]GitCheckout {
 	ret := make(map[string]GitCheckout)
 	for k, v := range in {
 		ret[k] = v
@@ -53,8 +56,22 @@ func uselessCasting(in map[string]*goget.GitCheckout) map[string]GitCheckout {
 	return ret
 }
 
+// SetCheckouts replaces the checkouts pushes refresh, keyed by remote URL, like after the config is reloaded
+func (p *Provider) SetCheckouts(checkouts map[string]gitdb.Checkout) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Checkouts = uselessCasting(checkouts)
+}
+
+func (p *Provider) checkout(remoteURL string) (GitCheckout, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	c, exists := p.Checkouts[remoteURL]
+	return c, exists
+}
+
 func (p *Provider) SetupMux(mux *mux.Router) {
-	mux.Methods(http.MethodPost).Path("/public/github/webhook").Handler(httpserver.BasicHandler(p.githubWebhook, p.Logger)).Name("webhook")
+	mux.Methods(http.MethodPost).Path(WebhookPath).Handler(httpserver.BasicHandler(p.githubWebhook, p.Logger)).Name("webhook")
 }
 
 func (p *Provider) pingEvent(req *http.Request, _ interface{}) httpserver.CanHTTPWrite {
@@ -90,7 +107,7 @@ func (p *Provider) pushEvent(req *http.Request, evt interface{}) httpserver.CanH
 		}
 	}
 	logger := p.Logger.With(zap.String("repo", *event.Repo.SSHURL))
-	checkout, exists := p.Checkouts[*event.Repo.SSHURL]
+	checkout, exists := p.checkout(*event.Repo.SSHURL)
 	if !exists {
 		logger.Warn(req.Context(), "cannot find checkout")
 		return &httpserver.BasicResponse{
@@ -98,7 +115,21 @@ func (p *Provider) pushEvent(req *http.Request, evt interface{}) httpserver.CanH
 			Msg:  strings.NewReader("cannot find checkout"),
 		}
 	}
-	if err := checkout.Refresh(req.Context()); err != nil {
+	if branch, after := pushedHead(event); branch != "" && checkout.HasHead(branch, after) {
+		logger.Info(req.Context(), "branch already at pushed head, skipping refresh", zap.String("branch", branch), zap.String("head", after))
+		return &httpserver.BasicResponse{
+			Code: http.StatusOK,
+			Msg:  strings.NewReader(fmt.Sprintf("repository %s already up to date", *event.Repo.SSHURL)),
+		}
+	}
+	if httpserver.DryRun(req) {
+		logger.Info(req.Context(), "dry run, not refreshing repository")
+		return &httpserver.BasicResponse{
+			Code: http.StatusOK,
+			Msg:  strings.NewReader(fmt.Sprintf("dry run: would refresh repository %s", *event.Repo.SSHURL)),
+		}
+	}
+	if err := checkout.Refresh(goget.WithTrigger(req.Context(), goget.TriggerWebhook)); err != nil {
 		logger.Warn(req.Context(), "cannot refresh repository", zap.Error(err))
 		return &httpserver.BasicResponse{
 			Code: http.StatusInternalServerError,
@@ -111,6 +142,17 @@ func (p *Provider) pushEvent(req *http.Request, evt interface{}) httpserver.CanH
 	}
 }
 
+// pushedHead returns the branch and new head of a push, or an empty branch if the push is not to a branch.  Deletes
+// have an all zero head that never matches a local ref, so they still refresh.
+func pushedHead(event *github.PushEvent) (string, string) {
+	ref := event.GetRef()
+	after := event.GetAfter()
+	if !strings.HasPrefix(ref, "refs/heads/") || after == "" {
+		return "", ""
+	}
+	return strings.TrimPrefix(ref, "refs/heads/"), after
+}
+
 // TODO: Also log out the event type (should be in headers)
 func (p *Provider) githubWebhook(req *http.Request) httpserver.CanHTTPWrite {
 	hookType := github.WebHookType(req)
@@ -123,6 +165,13 @@ func (p *Provider) githubWebhook(req *http.Request) httpserver.CanHTTPWrite {
 	}
 	p.Tracing.AttachTag(req.Context(), "github.hook_type", hookType)
 	body, err := github.ValidatePayload(req, p.Token)
+	if httpserver.IsBodyTooLarge(err) {
+		p.Logger.Warn(req.Context(), "webhook payload too large", zap.Error(err))
+		return &httpserver.BasicResponse{
+			Code: http.StatusRequestEntityTooLarge,
+			Msg:  strings.NewReader(fmt.Sprintf("webhook payload too large: %v", err)),
+		}
+	}
 	if err != nil {
 		p.Logger.Warn(req.Context(), "unable to validate payload", zap.Error(err))
 		return &httpserver.BasicResponse{
@@ -0,0 +1,132 @@
+package github
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/cresta/gitdb/internal/gitdb"
+	"github.com/cresta/gitdb/internal/log"
+	"github.com/google/go-github/v54/github"
+	"go.uber.org/zap"
+)
+
+// WebhookPath is where this provider receives pushes
+const WebhookPath = "/public/github/webhook"
+
+// HookRegistrar creates or updates the push webhook of GitHub repositories, so each new repository needs no manual
+// hook setup
+type HookRegistrar struct {
+	Client *github.Client
+	// Repositories on other hosts are skipped, like github.com
+	Host string
+	// Where GitHub sends pushes: WebhookPath of this server
+	HookURL string
+	// The push token, which signs payloads
+	Secret string
+	Logger *log.Logger
+}
+
+// NewHookRegistrar calls the API at apiURL, or api.github.com when empty, with token.  baseURL is where GitHub reaches
+// this server.
+func NewHookRegistrar(apiURL string, token string, baseURL string, secret string, client *http.Client, logger *log.Logger) (*HookRegistrar, error) {
+	client = withToken(client, "token "+token)
+	ret := &HookRegistrar{
+		Client:  github.NewClient(client),
+		Host:    "github.com",
+		HookURL: strings.TrimSuffix(baseURL, "/") + WebhookPath,
+		Secret:  secret,
+		Logger:  logger.With(zap.String("class", "github.HookRegistrar")),
+	}
+	if apiURL != "" {
+		var err error
+		if ret.Client, err = github.NewEnterpriseClient(apiURL, apiURL, client); err != nil {
+			return nil, fmt.Errorf("invalid github api url %s: %w", apiURL, err)
+		}
+		ret.Host = ret.Client.BaseURL.Hostname()
+	}
+	return ret, nil
+}
+
+// Register makes sure each repository of remoteURLs on r.Host has an active push webhook to r.HookURL.  A failure for
+// one repository does not stop the others.
+func (r *HookRegistrar) Register(ctx context.Context, remoteURLs []string) error {
+	var errs []error
+	for _, remoteURL := range remoteURLs {
+		host, fullName, _ := strings.Cut(gitdb.RemoteIdentity(remoteURL), "/")
+		owner, repo, found := strings.Cut(fullName, "/")
+		if host != r.Host || !found || strings.Contains(repo, "/") {
+			continue
+		}
+		if err := r.register(ctx, owner, repo); err != nil {
+			errs = append(errs, fmt.Errorf("unable to register webhook of %s: %w", remoteURL, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (r *HookRegistrar) register(ctx context.Context, owner string, repo string) error {
+	hook := &github.Hook{
+		Config: map[string]interface{}{
+			"url":          r.HookURL,
+			"content_type": "json",
+			"secret":       r.Secret,
+		},
+		Events: []string{"push"},
+		Active: github.Bool(true),
+	}
+	logger := r.Logger.With(zap.String("repo", owner+"/"+repo))
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		hooks, resp, err := r.Client.Repositories.ListHooks(ctx, owner, repo, opts)
+		if err != nil {
+			return fmt.Errorf("unable to list hooks: %w", err)
+		}
+		for _, h := range hooks {
+			if u, _ := h.Config["url"].(string); u == r.HookURL {
+				// The secret cannot be read back, so is always set again
+				if _, _, err := r.Client.Repositories.EditHook(ctx, owner, repo, h.GetID(), hook); err != nil {
+					return fmt.Errorf("unable to update hook %d: %w", h.GetID(), err)
+				}
+				logger.Info(ctx, "updated webhook", zap.Int64("hook_id", h.GetID()))
+				return nil
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	created, _, err := r.Client.Repositories.CreateHook(ctx, owner, repo, hook)
+	if err != nil {
+		return fmt.Errorf("unable to create hook: %w", err)
+	}
+	logger.Info(ctx, "created webhook", zap.Int64("hook_id", created.GetID()))
+	return nil
+}
+
+// withToken returns a copy of client that sends authorization on every request
+func withToken(client *http.Client, authorization string) *http.Client {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	ret := *client
+	next := ret.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	ret.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		req = req.Clone(req.Context())
+		req.Header.Set("Authorization", authorization)
+		return next.RoundTrip(req)
+	})
+	return &ret
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
@@ -0,0 +1,55 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/cresta/gitdb/internal/testhelp"
+	"github.com/google/go-github/v54/github"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHookRegistrar_Register(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		require.Equal(t, "token api-token", req.Header.Get("Authorization"))
+		calls = append(calls, req.Method+" "+req.URL.Path)
+		switch req.Method {
+		case http.MethodGet:
+			hooks := []*github.Hook{{ID: github.Int64(1), Config: map[string]interface{}{"url": "https://elsewhere.example.com/hook"}}}
+			if req.URL.Path == "/api/v3/repos/org/existing/hooks" {
+				hooks = append(hooks, &github.Hook{ID: github.Int64(2), Config: map[string]interface{}{"url": "https://gitdb.example.com/public/github/webhook"}})
+			}
+			_ = json.NewEncoder(rw).Encode(hooks)
+		default:
+			var h github.Hook
+			require.NoError(t, json.NewDecoder(req.Body).Decode(&h))
+			require.Equal(t, "https://gitdb.example.com/public/github/webhook", h.Config["url"])
+			require.Equal(t, "secret", h.Config["secret"])
+			require.Equal(t, []string{"push"}, h.Events)
+			_ = json.NewEncoder(rw).Encode(&github.Hook{ID: github.Int64(3)})
+		}
+	}))
+	defer srv.Close()
+	r, err := NewHookRegistrar(srv.URL+"/api/v3/", "api-token", "https://gitdb.example.com", "secret", srv.Client(), testhelp.ZapTestingLogger(t))
+	require.NoError(t, err)
+	err = r.Register(context.Background(), []string{
+		"git@127.0.0.1:org/new.git",
+		"https://127.0.0.1/org/existing",
+		"git@gitlab.com:org/other.git",
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"GET /api/v3/repos/org/new/hooks",
+		"POST /api/v3/repos/org/new/hooks",
+		"GET /api/v3/repos/org/existing/hooks",
+		"PATCH /api/v3/repos/org/existing/hooks/2",
+	}, calls)
+}
@@ -0,0 +1,209 @@
+package gitlab
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/cresta/gitdb/internal/gitdb"
+	"github.com/cresta/gitdb/internal/gitdb/goget"
+	"github.com/cresta/gitdb/internal/gitdb/tracing"
+	"github.com/cresta/gitdb/internal/httpserver"
+	"github.com/cresta/gitdb/internal/log"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+type GitCheckout interface {
+	Refresh(ctx context.Context) error
+	HasHead(branch string, hash string) bool
+}
+
+type Provider struct {
+	Token     []byte
+	Logger    *log.Logger
+	Checkouts map[string]GitCheckout
+	Tracing   tracing.Tracing
+
+	mu sync.RWMutex
+}
+
+func Setup(pushToken string, logger *log.Logger, handler *gitdb.CheckoutHandler, tracer tracing.Tracing) *Provider {
+	if pushToken == "" {
+		logger.Info(context.Background(), "no gitlab push token.  Not setting up gitlab push notifier")
+		return nil
+	}
+	return &Provider{
+		Tracing:   tracer,
+		Token:     []byte(pushToken),
+		Logger:    logger.With(zap.String("class", "gitlab.Provider")),
+		Checkouts: asGitCheckouts(handler.CheckoutsByRepo()),
+	}
+}
+
+func asGitCheckouts(in map[string]gitdb.Checkout) map[string]GitCheckout {
+	ret := make(map[string]GitCheckout, len(in))
+	for k, v := range in {
+		ret[k] = v
+	}
+	return ret
+}
+
+// SetCheckouts replaces the checkouts pushes refresh, keyed by remote URL, like after the config is reloaded
+func (p *Provider) SetCheckouts(checkouts map[string]gitdb.Checkout) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Checkouts = asGitCheckouts(checkouts)
+}
+
+func (p *Provider) checkout(remoteURL string) (GitCheckout, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	c, exists := p.Checkouts[remoteURL]
+	return c, exists
+}
+
+func (p *Provider) SetupMux(mux *mux.Router) {
+	mux.Methods(http.MethodPost).Path(WebhookPath).Handler(httpserver.BasicHandler(p.gitlabWebhook, p.Logger)).Name("gitlab_webhook")
+}
+
+// Event types sent in X-Gitlab-Event
+const (
+	pushHook    = "Push Hook"
+	tagPushHook = "Tag Push Hook"
+)
+
+type project struct {
+	GitSSHURL  string `json:"git_ssh_url"`
+	GitHTTPURL string `json:"git_http_url"`
+}
+
+// pushEvent is the part of GitLab's push and tag push payloads gitdb uses
+type pushEvent struct {
+	ObjectKind string  `json:"object_kind"`
+	Ref        string  `json:"ref"`
+	After      string  `json:"after"`
+	Project    project `json:"project"`
+}
+
+func (p *Provider) gitlabWebhook(req *http.Request) httpserver.CanHTTPWrite {
+	hookType := req.Header.Get("X-Gitlab-Event")
+	if hookType == "" {
+		p.Logger.Warn(req.Context(), "invalid webhook type")
+		return &httpserver.BasicResponse{
+			Code: http.StatusBadRequest,
+			Msg:  strings.NewReader("could not find webhook type"),
+		}
+	}
+	p.Tracing.AttachTag(req.Context(), "gitlab.hook_type", hookType)
+	if subtle.ConstantTimeCompare([]byte(req.Header.Get("X-Gitlab-Token")), p.Token) != 1 {
+		p.Logger.Warn(req.Context(), "invalid gitlab token")
+		return &httpserver.BasicResponse{
+			Code: http.StatusForbidden,
+			Msg:  strings.NewReader("invalid X-Gitlab-Token"),
+		}
+	}
+	body, err := io.ReadAll(req.Body)
+	if httpserver.IsBodyTooLarge(err) {
+		p.Logger.Warn(req.Context(), "webhook payload too large", zap.Error(err))
+		return &httpserver.BasicResponse{
+			Code: http.StatusRequestEntityTooLarge,
+			Msg:  strings.NewReader(fmt.Sprintf("webhook payload too large: %v", err)),
+		}
+	}
+	if err != nil {
+		p.Logger.Warn(req.Context(), "unable to read payload", zap.Error(err))
+		return &httpserver.BasicResponse{
+			Code: http.StatusBadRequest,
+			Msg:  strings.NewReader(fmt.Sprintf("unable to read payload: %v", err)),
+		}
+	}
+	if hookType != pushHook && hookType != tagPushHook {
+		return &httpserver.BasicResponse{
+			Code: http.StatusNotAcceptable,
+			Msg:  strings.NewReader(fmt.Sprintf("cannot process event: %s", hookType)),
+		}
+	}
+	var event pushEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		p.Logger.Warn(req.Context(), "unable to parse webhook", zap.Error(err))
+		return &httpserver.BasicResponse{
+			Code: http.StatusBadRequest,
+			Msg:  strings.NewReader(fmt.Sprintf("cannot parse webhook: %v", err)),
+		}
+	}
+	return p.pushEvent(req, &event)
+}
+
+func (p *Provider) pushEvent(req *http.Request, event *pushEvent) httpserver.CanHTTPWrite {
+	p.Logger.Info(req.Context(), "push event", zap.String("object_kind", event.ObjectKind))
+	repoURL, checkout := p.findCheckout(event.Project)
+	if repoURL == "" {
+		p.Logger.Warn(req.Context(), "No project url set")
+		return &httpserver.BasicResponse{
+			Code: http.StatusBadRequest,
+			Msg:  strings.NewReader("no project url set"),
+		}
+	}
+	logger := p.Logger.With(zap.String("repo", repoURL))
+	if checkout == nil {
+		logger.Warn(req.Context(), "cannot find checkout")
+		return &httpserver.BasicResponse{
+			Code: http.StatusBadRequest,
+			Msg:  strings.NewReader("cannot find checkout"),
+		}
+	}
+	if branch, after := pushedHead(event); branch != "" && checkout.HasHead(branch, after) {
+		logger.Info(req.Context(), "branch already at pushed head, skipping refresh", zap.String("branch", branch), zap.String("head", after))
+		return &httpserver.BasicResponse{
+			Code: http.StatusOK,
+			Msg:  strings.NewReader(fmt.Sprintf("repository %s already up to date", repoURL)),
+		}
+	}
+	if httpserver.DryRun(req) {
+		logger.Info(req.Context(), "dry run, not refreshing repository")
+		return &httpserver.BasicResponse{
+			Code: http.StatusOK,
+			Msg:  strings.NewReader(fmt.Sprintf("dry run: would refresh repository %s", repoURL)),
+		}
+	}
+	if err := checkout.Refresh(goget.WithTrigger(req.Context(), goget.TriggerWebhook)); err != nil {
+		logger.Warn(req.Context(), "cannot refresh repository", zap.Error(err))
+		return &httpserver.BasicResponse{
+			Code: http.StatusInternalServerError,
+			Msg:  strings.NewReader(fmt.Sprintf("cannot refresh repository: %v", err)),
+		}
+	}
+	return &httpserver.BasicResponse{
+		Code: http.StatusOK,
+		Msg:  strings.NewReader(fmt.Sprintf("refreshed repository %s", repoURL)),
+	}
+}
+
+// findCheckout matches the project's SSH url first, then its HTTP url, since repositories may be cloned with either.
+// The returned url is empty if the project has neither.
+func (p *Provider) findCheckout(proj project) (string, GitCheckout) {
+	for _, u := range []string{proj.GitSSHURL, proj.GitHTTPURL} {
+		if c, exists := p.checkout(u); u != "" && exists {
+			return u, c
+		}
+	}
+	if proj.GitSSHURL != "" {
+		return proj.GitSSHURL, nil
+	}
+	return proj.GitHTTPURL, nil
+}
+
+// pushedHead returns the branch and new head of a push, or an empty branch for tag pushes.  Deletes have an all zero
+// head that never matches a local ref, so they still refresh.
+func pushedHead(event *pushEvent) (string, string) {
+	if !strings.HasPrefix(event.Ref, "refs/heads/") || event.After == "" {
+		return "", ""
+	}
+	return strings.TrimPrefix(event.Ref, "refs/heads/"), event.After
+}
@@ -0,0 +1,160 @@
+package gitlab
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/cresta/gitdb/internal/gitdb"
+	"github.com/cresta/gitdb/internal/log"
+	"go.uber.org/zap"
+)
+
+// WebhookPath is where this provider receives pushes
+const WebhookPath = "/public/gitlab/webhook"
+
+// DefaultAPIURL is the GitLab HookRegistrar calls unless told otherwise
+const DefaultAPIURL = "https://gitlab.com"
+
+// HookRegistrar creates or updates the push webhook of GitLab projects, so each new repository needs no manual hook
+// setup
+type HookRegistrar struct {
+	// Like https://gitlab.com.  Projects on other hosts are skipped.
+	APIURL string
+	// Sent as PRIVATE-TOKEN, needs the api scope and at least the maintainer role
+	Token string
+	// Where GitLab sends pushes: WebhookPath of this server
+	HookURL string
+	// The push token, sent back in X-Gitlab-Token
+	Secret string
+	Client *http.Client
+	Logger *log.Logger
+}
+
+// NewHookRegistrar calls the API at apiURL, or DefaultAPIURL when empty, with token.  baseURL is where GitLab reaches
+// this server.
+func NewHookRegistrar(apiURL string, token string, baseURL string, secret string, client *http.Client, logger *log.Logger) *HookRegistrar {
+	if apiURL == "" {
+		apiURL = DefaultAPIURL
+	}
+	return &HookRegistrar{
+		APIURL:  apiURL,
+		Token:   token,
+		HookURL: strings.TrimSuffix(baseURL, "/") + WebhookPath,
+		Secret:  secret,
+		Client:  client,
+		Logger:  logger.With(zap.String("class", "gitlab.HookRegistrar")),
+	}
+}
+
+type hook struct {
+	ID                    int64  `json:"id,omitempty"`
+	URL                   string `json:"url"`
+	PushEvents            bool   `json:"push_events"`
+	TagPushEvents         bool   `json:"tag_push_events"`
+	Token                 string `json:"token,omitempty"`
+	EnableSSLVerification bool   `json:"enable_ssl_verification"`
+}
+
+// Register makes sure each project of remoteURLs on the host of r.APIURL has a push webhook to r.HookURL.  A failure
+// for one project does not stop the others.
+func (r *HookRegistrar) Register(ctx context.Context, remoteURLs []string) error {
+	api, err := url.Parse(r.APIURL)
+	if err != nil {
+		return fmt.Errorf("invalid gitlab api url %s: %w", r.APIURL, err)
+	}
+	var errs []error
+	for _, remoteURL := range remoteURLs {
+		host, project, found := strings.Cut(gitdb.RemoteIdentity(remoteURL), "/")
+		if host != strings.ToLower(api.Hostname()) || !found {
+			continue
+		}
+		if err := r.register(ctx, project); err != nil {
+			errs = append(errs, fmt.Errorf("unable to register webhook of %s: %w", remoteURL, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (r *HookRegistrar) register(ctx context.Context, project string) error {
+	hooksURL := strings.TrimSuffix(r.APIURL, "/") + "/api/v4/projects/" + url.PathEscape(project) + "/hooks"
+	want := hook{
+		URL:                   r.HookURL,
+		PushEvents:            true,
+		TagPushEvents:         true,
+		Token:                 r.Secret,
+		EnableSSLVerification: true,
+	}
+	logger := r.Logger.With(zap.String("project", project))
+	for page := "1"; page != ""; {
+		var hooks []hook
+		resp, err := r.do(ctx, http.MethodGet, hooksURL+"?per_page=100&page="+page, nil, &hooks)
+		if err != nil {
+			return fmt.Errorf("unable to list hooks: %w", err)
+		}
+		for _, h := range hooks {
+			if h.URL == r.HookURL {
+				// The token cannot be read back, so is always set again
+				if _, err := r.do(ctx, http.MethodPut, fmt.Sprintf("%s/%d", hooksURL, h.ID), want, nil); err != nil {
+					return fmt.Errorf("unable to update hook %d: %w", h.ID, err)
+				}
+				logger.Info(ctx, "updated webhook", zap.Int64("hook_id", h.ID))
+				return nil
+			}
+		}
+		page = resp.Header.Get("X-Next-Page")
+	}
+	var created hook
+	if _, err := r.do(ctx, http.MethodPost, hooksURL, want, &created); err != nil {
+		return fmt.Errorf("unable to create hook: %w", err)
+	}
+	logger.Info(ctx, "created webhook", zap.Int64("hook_id", created.ID))
+	return nil
+}
+
+// do sends body as JSON and decodes the response into into, if not nil
+func (r *HookRegistrar) do(ctx context.Context, method string, u string, body interface{}, into interface{}) (*http.Response, error) {
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("PRIVATE-TOKEN", r.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("%s %s: %s: %s", method, req.URL.Path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if into != nil {
+		if err := json.NewDecoder(resp.Body).Decode(into); err != nil {
+			return nil, fmt.Errorf("unable to decode response: %w", err)
+		}
+	}
+	return resp, nil
+}
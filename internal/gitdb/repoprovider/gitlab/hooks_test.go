@@ -0,0 +1,55 @@
+package gitlab
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/cresta/gitdb/internal/testhelp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHookRegistrar_Register(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	var sent []hook
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		require.Equal(t, "api-token", req.Header.Get("PRIVATE-TOKEN"))
+		calls = append(calls, req.Method+" "+req.URL.EscapedPath())
+		switch req.Method {
+		case http.MethodGet:
+			hooks := []hook{{ID: 1, URL: "https://elsewhere.example.com/hook"}}
+			if req.URL.EscapedPath() == "/api/v4/projects/org%2Fexisting/hooks" {
+				hooks = append(hooks, hook{ID: 2, URL: "https://gitdb.example.com/public/gitlab/webhook"})
+			}
+			_ = json.NewEncoder(rw).Encode(hooks)
+		default:
+			var h hook
+			require.NoError(t, json.NewDecoder(req.Body).Decode(&h))
+			sent = append(sent, h)
+			_ = json.NewEncoder(rw).Encode(hook{ID: 3})
+		}
+	}))
+	defer srv.Close()
+	r := NewHookRegistrar(srv.URL, "api-token", "https://gitdb.example.com/", "secret", srv.Client(), testhelp.ZapTestingLogger(t))
+	err := r.Register(context.Background(), []string{
+		"git@127.0.0.1:org/new.git",
+		"https://127.0.0.1/org/existing",
+		"git@github.com:org/other.git",
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"GET /api/v4/projects/org%2Fnew/hooks",
+		"POST /api/v4/projects/org%2Fnew/hooks",
+		"GET /api/v4/projects/org%2Fexisting/hooks",
+		"PUT /api/v4/projects/org%2Fexisting/hooks/2",
+	}, calls)
+	for _, h := range sent {
+		require.Equal(t, hook{URL: "https://gitdb.example.com/public/gitlab/webhook", PushEvents: true, TagPushEvents: true, Token: "secret", EnableSSLVerification: true}, h)
+	}
+}
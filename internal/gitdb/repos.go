@@ -0,0 +1,79 @@
+package gitdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/cresta/gitdb/internal/httpserver"
+)
+
+// Ways a client can be allowed to read a repository
+const (
+	// The unauthenticated routes, like /file, meant to be reachable only from inside the network
+	AuthInternal = "internal"
+	// The /public routes, with a JWT from /public/signin or /public/token
+	AuthJWT = "jwt"
+	// The /public routes, without any token
+	AuthAnonymous = "anonymous"
+)
+
+// RepoInfo describes how clients can use a repository
+type RepoInfo struct {
+	Key    string
+	Public bool
+	// How access can be granted, like "internal", "jwt" or "anonymous"
+	Auth []string
+	// Endpoints not turned off by DisabledEndpoints
+	Endpoints []string
+}
+
+func (h *CheckoutHandler) repoInfo(key string) RepoInfo {
+	repoCfg, _ := h.repoConfig(key)
+	ret := RepoInfo{
+		Key:    key,
+		Public: repoCfg.Public,
+		Auth:   []string{AuthInternal},
+	}
+	if repoCfg.Public && h.publicJWT {
+		ret.Auth = append(ret.Auth, AuthJWT)
+	}
+	if repoCfg.Public && repoCfg.Anonymous && h.publicRoutes {
+		ret.Auth = append(ret.Auth, AuthAnonymous)
+	}
+	for e := range knownEndpoints {
+		if !repoCfg.endpointDisabled(e) {
+			ret.Endpoints = append(ret.Endpoints, e)
+		}
+	}
+	sort.Strings(ret.Endpoints)
+	return ret
+}
+
+func (h *CheckoutHandler) reposHandler(_ *http.Request) httpserver.CanHTTPWrite {
+	checkouts := h.checkouts()
+	ret := make([]RepoInfo, 0, len(checkouts))
+	for key := range checkouts {
+		ret = append(ret, h.repoInfo(key))
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Key < ret[j].Key
+	})
+	b, err := json.Marshal(ret)
+	if err != nil {
+		return &httpserver.BasicResponse{
+			Code: http.StatusInternalServerError,
+			Msg:  strings.NewReader(fmt.Sprintf("unable to encode repos: %v", err)),
+		}
+	}
+	return &httpserver.BasicResponse{
+		Code: http.StatusOK,
+		Msg:  bytes.NewReader(b),
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+	}
+}
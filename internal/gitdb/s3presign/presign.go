@@ -0,0 +1,141 @@
+// Package s3presign creates pre-signed S3 URLs (AWS signature version 4, query string auth) without the AWS SDK
+package s3presign
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Presigner signs URLs for objects in one bucket
+type Presigner struct {
+	Bucket string
+	Region string
+	// Optional: like "http://minio:9000" for S3 compatible stores.  Uses path style URLs.  Defaults to AWS with
+	// virtual host style URLs.
+	Endpoint        string
+	AccessKeyID     string
+	SecretAccessKey string
+	// Optional: for temporary credentials
+	SessionToken string
+	// Defaults to time.Now
+	Now func() time.Time
+}
+
+// FromEnv fills the credentials from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN
+func (p *Presigner) FromEnv() error {
+	p.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+	p.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	p.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	if p.AccessKeyID == "" || p.SecretAccessKey == "" {
+		return fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+	return nil
+}
+
+func (p *Presigner) now() time.Time {
+	if p.Now == nil {
+		return time.Now()
+	}
+	return p.Now()
+}
+
+// Presign returns a URL that allows method (like GET or HEAD) on key until expires passes
+func (p *Presigner) Presign(method string, key string, expires time.Duration) (string, error) {
+	scheme, host, path, err := p.location(key)
+	if err != nil {
+		return "", err
+	}
+	now := p.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	scope := now.Format("20060102") + "/" + p.Region + "/s3/aws4_request"
+	query := map[string]string{
+		"X-Amz-Algorithm":     "AWS4-HMAC-SHA256",
+		"X-Amz-Credential":    p.AccessKeyID + "/" + scope,
+		"X-Amz-Date":          amzDate,
+		"X-Amz-Expires":       fmt.Sprintf("%d", int64(expires/time.Second)),
+		"X-Amz-SignedHeaders": "host",
+	}
+	if p.SessionToken != "" {
+		query["X-Amz-Security-Token"] = p.SessionToken
+	}
+	canonicalQuery := canonicalQueryString(query)
+	canonicalRequest := strings.Join([]string{
+		method,
+		path,
+		canonicalQuery,
+		"host:" + host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+	signingKey := hmacSHA256([]byte("AWS4"+p.SecretAccessKey), now.Format("20060102"))
+	signingKey = hmacSHA256(signingKey, p.Region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+	return scheme + "://" + host + path + "?" + canonicalQuery + "&X-Amz-Signature=" + signature, nil
+}
+
+func (p *Presigner) location(key string) (scheme string, host string, path string, err error) {
+	encodedKey := uriEncode(strings.TrimPrefix(key, "/"), false)
+	if p.Endpoint == "" {
+		host = p.Bucket + ".s3." + p.Region + ".amazonaws.com"
+		if p.Region == "us-east-1" {
+			host = p.Bucket + ".s3.amazonaws.com"
+		}
+		return "https", host, "/" + encodedKey, nil
+	}
+	u, err := url.Parse(p.Endpoint)
+	if err != nil {
+		return "", "", "", fmt.Errorf("unable to parse endpoint %s: %w", p.Endpoint, err)
+	}
+	return u.Scheme, u.Host, "/" + uriEncode(p.Bucket, true) + "/" + encodedKey, nil
+}
+
+func canonicalQueryString(query map[string]string) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, uriEncode(k, true)+"="+uriEncode(query[k], true))
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode percent encodes everything except unreserved characters, and '/' unless encodeSlash is set
+func uriEncode(s string, encodeSlash bool) string {
+	var sb strings.Builder
+	for _, b := range []byte(s) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9', b == '-', b == '_', b == '.', b == '~':
+			sb.WriteByte(b)
+		case b == '/' && !encodeSlash:
+			sb.WriteByte(b)
+		default:
+			fmt.Fprintf(&sb, "%%%02X", b)
+		}
+	}
+	return sb.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
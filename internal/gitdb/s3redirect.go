@@ -0,0 +1,174 @@
+package gitdb
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cresta/gitdb/internal/gitdb/s3presign"
+	"github.com/cresta/gitdb/internal/gitdb/tracing"
+	"github.com/cresta/gitdb/internal/httpserver"
+	"github.com/cresta/gitdb/internal/log"
+	"go.uber.org/zap"
+)
+
+// S3RedirectConfig redirects /file and /zip to pre-signed S3 URLs for content a mirror has copied to the bucket.  The
+// mirror writes blobs to <Prefix>/blobs/<blob hash> and zips to <Prefix>/zip/<commit>/<dir>.zip
+// (<Prefix>/zip/<commit>.zip for the root).  Content missing from the bucket is streamed as usual.  Credentials come
+// from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN.
+type S3RedirectConfig struct {
+	Bucket string
+	Region string
+	// Optional: like "http://minio:9000" for S3 compatible stores
+	Endpoint string
+	Prefix   string
+	// Only redirect files at least this large
+	MinBytes int64
+	// How long pre-signed URLs work, like "5m".  Defaults to 15m
+	URLExpiry string
+}
+
+type s3Redirector struct {
+	presigner *s3presign.Presigner
+	prefix    string
+	minBytes  int64
+	expiry    time.Duration
+	client    *http.Client
+	log       *log.Logger
+
+	mu sync.Mutex
+	// Keys known to be in the bucket.  Keys are content addressed, so they never go stale.
+	mirrored map[string]struct{}
+}
+
+func newS3Redirector(cfg S3RedirectConfig, tracer tracing.Tracing, logger *log.Logger) (*s3Redirector, error) {
+	expiry := time.Minute * 15
+	if cfg.URLExpiry != "" {
+		var err error
+		if expiry, err = time.ParseDuration(cfg.URLExpiry); err != nil {
+			return nil, fmt.Errorf("invalid URLExpiry %s: %w", cfg.URLExpiry, err)
+		}
+	}
+	p := &s3presign.Presigner{
+		Bucket:   cfg.Bucket,
+		Region:   cfg.Region,
+		Endpoint: cfg.Endpoint,
+	}
+	if err := p.FromEnv(); err != nil {
+		return nil, fmt.Errorf("unable to load S3 credentials: %w", err)
+	}
+	return &s3Redirector{
+		presigner: p,
+		prefix:    strings.Trim(cfg.Prefix, "/"),
+		minBytes:  cfg.MinBytes,
+		expiry:    expiry,
+		client:    tracing.NewHTTPClient(tracer, time.Second*5),
+		log:       logger.With(zap.String("bucket", cfg.Bucket)),
+		mirrored:  make(map[string]struct{}),
+	}, nil
+}
+
+func (s *s3Redirector) blobKey(blobHash string) string {
+	return path.Join(s.prefix, "blobs", blobHash)
+}
+
+func (s *s3Redirector) zipKey(commit string, dir string) string {
+	if dir = strings.Trim(dir, "/"); dir == "" {
+		return path.Join(s.prefix, "zip", commit+".zip")
+	}
+	return path.Join(s.prefix, "zip", commit, dir+".zip")
+}
+
+// redirect returns a 302 to key, or nil if key is not in the bucket
+func (s *s3Redirector) redirect(ctx context.Context, key string) httpserver.CanHTTPWrite {
+	if !s.isMirrored(ctx, key) {
+		return nil
+	}
+	u, err := s.presigner.Presign(http.MethodGet, key, s.expiry)
+	if err != nil {
+		s.log.Warn(ctx, "unable to presign URL", zap.String("key", key), zap.Error(err))
+		return nil
+	}
+	return &httpserver.BasicResponse{
+		Code: http.StatusFound,
+		Msg:  strings.NewReader(""),
+		Headers: map[string]string{
+			"Location": u,
+		},
+	}
+}
+
+func (s *s3Redirector) isMirrored(ctx context.Context, key string) bool {
+	s.mu.Lock()
+	_, exists := s.mirrored[key]
+	s.mu.Unlock()
+	if exists {
+		return true
+	}
+	u, err := s.presigner.Presign(http.MethodHead, key, time.Minute)
+	if err != nil {
+		s.log.Warn(ctx, "unable to presign URL", zap.String("key", key), zap.Error(err))
+		return false
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, u, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		s.log.Warn(ctx, "unable to check S3 for key", zap.String("key", key), zap.Error(err))
+		return false
+	}
+	s.log.IfErr(resp.Body.Close()).Warn(ctx, "unable to close response body")
+	if resp.StatusCode != http.StatusOK {
+		return false
+	}
+	s.mu.Lock()
+	s.mirrored[key] = struct{}{}
+	s.mu.Unlock()
+	return true
+}
+
+// fileRedirect returns a redirect to S3 for a file, or nil to serve it directly
+func (h *CheckoutHandler) fileRedirect(ctx context.Context, repo string, branch string, filePath string) httpserver.CanHTTPWrite {
+	s, exists := h.s3Redirector(repo)
+	if !exists {
+		return nil
+	}
+	co, exists := h.checkout(repo)
+	if !exists {
+		return nil
+	}
+	info, size, err := co.StatFile(ctx, branch, filePath)
+	if err != nil || size < s.minBytes {
+		return nil
+	}
+	resp := s.redirect(ctx, s.blobKey(info.BlobHash))
+	if r, ok := resp.(*httpserver.BasicResponse); ok {
+		for k, v := range fileInfoHeaders(info) {
+			r.Headers[k] = v
+		}
+	}
+	return resp
+}
+
+// zipRedirect returns a redirect to S3 for a zip of dir, or nil to build it here
+func (h *CheckoutHandler) zipRedirect(req *http.Request, repo string, branch string, dir string) httpserver.CanHTTPWrite {
+	s, exists := h.s3Redirector(repo)
+	if !exists || req.URL.Query().Get("dirs") != "" {
+		return nil
+	}
+	co, exists := h.checkout(repo)
+	if !exists {
+		return nil
+	}
+	commits, err := co.Commits(req.Context(), branch, 1)
+	if err != nil || len(commits) == 0 {
+		return nil
+	}
+	return s.redirect(req.Context(), s.zipKey(commits[0].Hash, dir))
+}
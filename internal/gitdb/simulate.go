@@ -0,0 +1,107 @@
+package gitdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/cresta/gitdb/internal/gitdb/goget"
+	"github.com/cresta/gitdb/internal/httpserver"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// SimulateRequest is the body of POST /simulate/{repo}/{branch}
+type SimulateRequest struct {
+	// Defaults to "simulated change"
+	Message string
+	// Content keyed by path.  Null deletes the path.
+	Files map[string]*string
+}
+
+// simulator is a Checkout that can fabricate commits.  Plain local directories have no history to add to.
+type simulator interface {
+	Simulate(ctx context.Context, branch string, message string, files map[string]*string) (goget.BranchChange, error)
+}
+
+// SetupSimulationMux serves POST /simulate/{repo}/{branch}, which commits files to a branch as if they had been pushed.
+// It is for integration tests of consumers, and must never be served in production: it changes what every client
+// reads.
+func (h *CheckoutHandler) SetupSimulationMux(muxRouter *mux.Router) {
+	muxRouter.Methods(http.MethodPost).Path("/simulate/{repo}/{branch}").Handler(httpserver.BasicHandler(h.simulateHandler, h.Log)).Name("simulate")
+}
+
+// simulateHandler commits the files of a SimulateRequest and responds with the change recorded for the branch, which
+// /changes then also serves
+func (h *CheckoutHandler) simulateHandler(req *http.Request) httpserver.CanHTTPWrite {
+	vars := mux.Vars(req)
+	repo := vars["repo"]
+	branch := vars["branch"]
+	logger := h.Log.With(zap.String("repo", repo), zap.String("branch", branch))
+	r, exists := h.checkout(repo)
+	if !exists {
+		logger.Warn(req.Context(), "invalid repo")
+		return &httpserver.BasicResponse{Code: http.StatusNotFound, Msg: strings.NewReader(fmt.Sprintf("unable to find repo %s", repo))}
+	}
+	sim, ok := r.(simulator)
+	if !ok {
+		return &httpserver.BasicResponse{
+			Code: http.StatusBadRequest,
+			Msg:  strings.NewReader(fmt.Sprintf("repo %s is not a git repository", repo)),
+		}
+	}
+	var body SimulateRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		return &httpserver.BasicResponse{
+			Code: http.StatusBadRequest,
+			Msg:  strings.NewReader(fmt.Sprintf("unable to decode body: %v", err)),
+		}
+	}
+	if len(body.Files) == 0 {
+		return &httpserver.BasicResponse{
+			Code: http.StatusBadRequest,
+			Msg:  strings.NewReader("expected at least one file"),
+		}
+	}
+	if body.Message == "" {
+		body.Message = "simulated change"
+	}
+	change, err := sim.Simulate(req.Context(), branch, body.Message, body.Files)
+	if err != nil {
+		if errors.Is(err, goget.ErrUnknownBranch) {
+			return &httpserver.BasicResponse{
+				Code: http.StatusNotFound,
+				Msg:  strings.NewReader(fmt.Sprintf("branch not found %s", branch)),
+			}
+		}
+		if errors.Is(err, goget.ErrInvalidChange) {
+			return &httpserver.BasicResponse{
+				Code: http.StatusBadRequest,
+				Msg:  strings.NewReader(err.Error()),
+			}
+		}
+		logger.Warn(req.Context(), "unable to simulate change", zap.Error(err))
+		return &httpserver.BasicResponse{
+			Code: http.StatusInternalServerError,
+			Msg:  strings.NewReader(fmt.Sprintf("unable to simulate change: %v", err)),
+		}
+	}
+	b, err := json.Marshal(change)
+	if err != nil {
+		return &httpserver.BasicResponse{
+			Code: http.StatusInternalServerError,
+			Msg:  strings.NewReader(fmt.Sprintf("unable to encode change: %v", err)),
+		}
+	}
+	return &httpserver.BasicResponse{
+		Code: http.StatusOK,
+		Msg:  bytes.NewReader(b),
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+	}
+}
@@ -0,0 +1,111 @@
+package gitdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/cresta/gitdb/internal/httpserver"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// Snapshot lists every file of a branch with where to download it, so a client can keep a full local mirror and serve
+// reads offline until its next sync
+type Snapshot struct {
+	Repo   string
+	Branch string
+	// The commit listed.  Pass it as ?at= to read other routes at this same commit.  Empty for repositories that are
+	// not git, whose files are downloaded by branch.
+	Commit string
+	Files  []SnapshotFile
+}
+
+type SnapshotFile struct {
+	Path string
+	Mode uint32
+	// Blob hash, which is also the ETag of the download
+	Hash string
+	Size int64
+	// Relative to this server.  Pinned to Commit, so every file comes from the same snapshot even if the branch moves.
+	URL string
+}
+
+// snapshotHandler lists every file of the branch.  Its ETag is the commit, so a client syncing a mirror that is up to
+// date gets 304 Not Modified without the tree being walked.
+func (h *CheckoutHandler) snapshotHandler(req *http.Request) httpserver.CanHTTPWrite {
+	vars := mux.Vars(req)
+	repo := vars["repo"]
+	branch := vars["branch"]
+	logger := h.Log.With(zap.String("repo", repo), zap.String("branch", branch))
+	logger.Debug(req.Context(), "snapshot handler")
+	r, exists := h.checkout(repo)
+	if !exists {
+		buf := strings.NewReader(fmt.Sprintf("unable to find repo %s", repo))
+		logger.Warn(req.Context(), "invalid repo")
+		return &httpserver.BasicResponse{Code: http.StatusNotFound, Msg: buf}
+	}
+	if info, err := r.Commit(req.Context(), branch); err == nil && httpserver.ETagMatches(req, `"`+info.Hash+`"`) {
+		return &httpserver.BasicResponse{
+			Code:    http.StatusNotModified,
+			Msg:     strings.NewReader(""),
+			Headers: map[string]string{"ETag": `"` + info.Hash + `"`},
+		}
+	}
+	ret := Snapshot{
+		Repo:   repo,
+		Branch: branch,
+		Files:  make([]SnapshotFile, 0),
+	}
+	commit, err := r.WalkFiles(req.Context(), branch, func(f *object.File) error {
+		ret.Files = append(ret.Files, SnapshotFile{
+			Path: f.Name,
+			Mode: uint32(f.Mode),
+			Hash: f.Hash.String(),
+			Size: f.Size,
+		})
+		return nil
+	})
+	if err != nil {
+		return listingError(req.Context(), err, branch, "", logger)
+	}
+	ret.Commit = commit
+	ref := branch
+	if commit != "" {
+		ref = commit
+	}
+	for i := range ret.Files {
+		ret.Files[i].URL = snapshotFileURL(repo, ref, ret.Files[i].Path)
+	}
+	b, err := json.Marshal(ret)
+	if err != nil {
+		return &httpserver.BasicResponse{
+			Code: http.StatusInternalServerError,
+			Msg:  strings.NewReader(fmt.Sprintf("unable to encode snapshot: %v", err)),
+		}
+	}
+	headers := map[string]string{
+		"Content-Type": "application/json",
+	}
+	if commit != "" {
+		headers["ETag"] = `"` + commit + `"`
+	}
+	return &httpserver.BasicResponse{
+		Code:    http.StatusOK,
+		Msg:     bytes.NewReader(b),
+		Headers: headers,
+	}
+}
+
+// snapshotFileURL is the /file route serving p at ref
+func snapshotFileURL(repo string, ref string, p string) string {
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return "/file/" + url.PathEscape(repo) + "/" + url.PathEscape(ref) + "/" + strings.Join(parts, "/")
+}
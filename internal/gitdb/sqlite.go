@@ -0,0 +1,110 @@
+package gitdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/cresta/gitdb/internal/gitdb/goget"
+	"github.com/cresta/gitdb/internal/gitdb/sqliteexport"
+	"github.com/cresta/gitdb/internal/httpserver"
+	"github.com/cresta/gitdb/internal/log"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+func (h *CheckoutHandler) sqliteHandler(req *http.Request) httpserver.CanHTTPWrite {
+	vars := mux.Vars(req)
+	repo := vars["repo"]
+	branch := vars["branch"]
+	logger := h.Log.With(zap.String("repo", repo), zap.String("branch", branch))
+	logger.Debug(req.Context(), "sqlite handler")
+	r, exists := h.checkout(repo)
+	if !exists {
+		buf := strings.NewReader(fmt.Sprintf("unable to find repo %s", repo))
+		logger.Warn(req.Context(), "invalid repo")
+		return &httpserver.BasicResponse{Code: http.StatusNotFound, Msg: buf}
+	}
+	f, err := os.CreateTemp(h.dataDirectory, "gitdb_sqlite_*.db")
+	if err != nil {
+		logger.Warn(req.Context(), "unable to create temp file", zap.Error(err))
+		return &httpserver.BasicResponse{
+			Code: http.StatusInternalServerError,
+			Msg:  strings.NewReader(fmt.Sprintf("unable to create temp file: %v", err)),
+		}
+	}
+	dbFile := &tempFile{path: f.Name(), log: logger}
+	if err := f.Close(); err != nil {
+		dbFile.remove(req.Context())
+		logger.Warn(req.Context(), "unable to close temp file", zap.Error(err))
+		return &httpserver.BasicResponse{
+			Code: http.StatusInternalServerError,
+			Msg:  strings.NewReader(fmt.Sprintf("unable to close temp file: %v", err)),
+		}
+	}
+	if err := sqliteexport.Export(req.Context(), dbFile.path, r, repo, branch); err != nil {
+		dbFile.remove(req.Context())
+		if errors.Is(err, goget.ErrUnknownBranch) {
+			return &httpserver.BasicResponse{
+				Code: http.StatusNotFound,
+				Msg:  strings.NewReader(fmt.Sprintf("branch not found %s", branch)),
+			}
+		}
+		logger.Warn(req.Context(), "unable to export sqlite", zap.Error(err))
+		return &httpserver.BasicResponse{
+			Code: http.StatusInternalServerError,
+			Msg:  strings.NewReader(fmt.Sprintf("unable to export branch %s: %v", branch, err)),
+		}
+	}
+	return &sqliteResponse{
+		DigestResponse: httpserver.DigestResponse{BasicResponse: httpserver.BasicResponse{
+			Code: http.StatusOK,
+			Msg:  dbFile,
+			Headers: map[string]string{
+				"Content-Type":        "application/vnd.sqlite3",
+				"Content-Disposition": fmt.Sprintf("attachment; filename=%q", sanitizeDir(repo+"-"+branch)+".db"),
+			},
+		}},
+		dbFile: dbFile,
+	}
+}
+
+// sqliteResponse serves an exported database and deletes it once served, even when the body was never written
+type sqliteResponse struct {
+	httpserver.DigestResponse
+	dbFile *tempFile
+}
+
+func (s *sqliteResponse) HTTPWrite(ctx context.Context, w http.ResponseWriter, l *log.Logger) {
+	defer s.dbFile.remove(ctx)
+	s.DigestResponse.HTTPWrite(ctx, w, l)
+}
+
+var _ httpserver.CanHTTPWrite = &sqliteResponse{}
+
+// tempFile streams a temporary file to the client
+type tempFile struct {
+	path string
+	log  *log.Logger
+}
+
+func (r *tempFile) WriteTo(w io.Writer) (int64, error) {
+	f, err := os.Open(r.path)
+	if err != nil {
+		return 0, fmt.Errorf("unable to open %s: %w", r.path, err)
+	}
+	defer func() {
+		r.log.IfErr(f.Close()).Warn(context.Background(), "unable to close temp file")
+	}()
+	return io.Copy(w, f)
+}
+
+func (r *tempFile) remove(ctx context.Context) {
+	r.log.IfErr(os.Remove(r.path)).Warn(ctx, "unable to remove temp file", zap.String("path", r.path))
+}
+
+var _ io.WriterTo = &tempFile{}
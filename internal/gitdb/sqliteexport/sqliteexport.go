@@ -0,0 +1,102 @@
+package sqliteexport
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	// Registers the pure go "sqlite" driver so exports work in a scratch image
+	_ "modernc.org/sqlite"
+)
+
+const schema = `
+CREATE TABLE meta (key TEXT PRIMARY KEY, value TEXT NOT NULL);
+CREATE TABLE blobs (hash TEXT PRIMARY KEY, size INTEGER NOT NULL, data BLOB NOT NULL);
+CREATE TABLE files (path TEXT PRIMARY KEY, mode INTEGER NOT NULL, size INTEGER NOT NULL, hash TEXT NOT NULL REFERENCES blobs(hash));
+`
+
+// FileWalker is the part of a checkout Export reads.  It returns the hash of the commit walked.
+type FileWalker interface {
+	WalkFiles(ctx context.Context, branch string, callback func(f *object.File) error) (string, error)
+}
+
+// Export writes every file of branch into a new SQLite database at dbPath.  Identical blobs are stored once in the
+// blobs table and referenced by hash from the files table.
+func Export(ctx context.Context, dbPath string, co FileWalker, repo string, branch string) (retErr error) {
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		return fmt.Errorf("unable to open sqlite file %s: %w", dbPath, err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil && retErr == nil {
+			retErr = fmt.Errorf("unable to close sqlite file %s: %w", dbPath, err)
+		}
+	}()
+	if _, err := db.ExecContext(ctx, schema); err != nil {
+		return fmt.Errorf("unable to create schema: %w", err)
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("unable to start transaction: %w", err)
+	}
+	defer func() {
+		if retErr != nil {
+			_ = tx.Rollback()
+		}
+	}()
+	commit, err := co.WalkFiles(ctx, branch, func(f *object.File) error {
+		return insertFile(ctx, tx, f)
+	})
+	if err != nil {
+		return err
+	}
+	meta := map[string]string{
+		"repo":        repo,
+		"branch":      branch,
+		"commit":      commit,
+		"exported_at": time.Now().UTC().Format(time.RFC3339),
+	}
+	for k, v := range meta {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO meta (key, value) VALUES (?, ?)", k, v); err != nil {
+			return fmt.Errorf("unable to write meta %s: %w", k, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("unable to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func insertFile(ctx context.Context, tx *sql.Tx, f *object.File) error {
+	hash := f.Hash.String()
+	var exists int
+	err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM blobs WHERE hash = ?", hash).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("unable to check blob %s: %w", hash, err)
+	}
+	if exists == 0 {
+		rd, err := f.Reader()
+		if err != nil {
+			return fmt.Errorf("unable to make reader for %s: %w", f.Name, err)
+		}
+		data, err := io.ReadAll(rd)
+		closeErr := rd.Close()
+		if err != nil {
+			return fmt.Errorf("unable to read %s: %w", f.Name, err)
+		}
+		if closeErr != nil {
+			return fmt.Errorf("unable to close reader for %s: %w", f.Name, closeErr)
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO blobs (hash, size, data) VALUES (?, ?, ?)", hash, f.Size, data); err != nil {
+			return fmt.Errorf("unable to insert blob %s: %w", hash, err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO files (path, mode, size, hash) VALUES (?, ?, ?, ?)", f.Name, uint32(f.Mode), f.Size, hash); err != nil {
+		return fmt.Errorf("unable to insert file %s: %w", f.Name, err)
+	}
+	return nil
+}
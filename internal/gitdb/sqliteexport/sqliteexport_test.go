@@ -0,0 +1,65 @@
+package sqliteexport
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cresta/gitdb/internal/gitdb/localdir"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExport(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("same"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "b.txt"), []byte("same"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "c.txt"), []byte("other"), 0o600))
+	co, err := localdir.New(dir)
+	require.NoError(t, err)
+
+	dbPath := filepath.Join(t.TempDir(), "out.db")
+	require.NoError(t, Export(context.Background(), dbPath, co, "testrepo", "master"))
+
+	db, err := sql.Open("sqlite", dbPath)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, db.Close())
+	}()
+	hash := func(s string) string {
+		return plumbing.ComputeHash(plumbing.BlobObject, []byte(s)).String()
+	}
+	type file struct {
+		path string
+		size int64
+		hash string
+	}
+	var files []file
+	rows, err := db.Query("SELECT path, size, hash FROM files ORDER BY path")
+	require.NoError(t, err)
+	for rows.Next() {
+		var f file
+		require.NoError(t, rows.Scan(&f.path, &f.size, &f.hash))
+		files = append(files, f)
+	}
+	require.NoError(t, rows.Err())
+	require.NoError(t, rows.Close())
+	require.Equal(t, []file{
+		{path: "a.txt", size: 4, hash: hash("same")},
+		{path: "sub/b.txt", size: 4, hash: hash("same")},
+		{path: "sub/c.txt", size: 5, hash: hash("other")},
+	}, files)
+
+	var blobs int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM blobs").Scan(&blobs))
+	require.Equal(t, 2, blobs)
+	var data []byte
+	require.NoError(t, db.QueryRow("SELECT data FROM blobs WHERE hash = ?", hash("other")).Scan(&data))
+	require.Equal(t, "other", string(data))
+	var repo string
+	require.NoError(t, db.QueryRow("SELECT value FROM meta WHERE key = 'repo'").Scan(&repo))
+	require.Equal(t, "testrepo", repo)
+}
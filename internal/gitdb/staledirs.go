@@ -0,0 +1,79 @@
+package gitdb
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// Prefixes of the directories checkouts create under the data directory
+var checkoutDirPrefixes = []string{"gitdb_repo_", "gitdb_worktree_"}
+
+// RemoveStaleDirs removes the checkout directories under the data directory that no checkout uses: clones and work
+// trees left by earlier runs, of repositories no longer configured, or quarantined by Heal.  It returns their paths.
+// With dryRun they are only logged.  Nothing is removed while a clone runs, since its directory is not served yet.
+// Other processes must not share the data directory.
+func (h *CheckoutHandler) RemoveStaleDirs(ctx context.Context, dryRun bool) ([]string, error) {
+	// Reloads create directories before serving them
+	h.reloadMu.Lock()
+	defer h.reloadMu.Unlock()
+	if h.cloneTracker != nil {
+		for _, c := range h.cloneTracker.Snapshot() {
+			if !c.Done {
+				h.Log.Info(ctx, "not removing stale directories while a clone runs", zap.String("repo", c.Repo))
+				return nil, nil
+			}
+		}
+	}
+	inUse := make(map[string]struct{})
+	for _, co := range h.checkouts() {
+		if p := co.AbsPath(); p != "" {
+			inUse[filepath.Clean(p)] = struct{}{}
+		}
+		if wt, ok := co.WorkTree(); ok {
+			inUse[filepath.Clean(wt.Path)] = struct{}{}
+		}
+	}
+	entries, err := os.ReadDir(h.dataDirectory)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list data directory %s: %w", h.dataDirectory, err)
+	}
+	var ret []string
+	for _, e := range entries {
+		if !e.IsDir() || !isCheckoutDir(e.Name()) {
+			continue
+		}
+		p := filepath.Join(h.dataDirectory, e.Name())
+		if _, exists := inUse[filepath.Clean(p)]; exists {
+			continue
+		}
+		if dryRun {
+			h.Log.Info(ctx, "would remove stale checkout directory", zap.String("path", p))
+			ret = append(ret, p)
+			continue
+		}
+		if err := os.RemoveAll(p); err != nil {
+			h.Log.Warn(ctx, "unable to remove stale checkout directory", zap.String("path", p), zap.Error(err))
+			continue
+		}
+		h.Log.Info(ctx, "removed stale checkout directory", zap.String("path", p))
+		ret = append(ret, p)
+	}
+	if !dryRun {
+		h.metrics.Count("gitdb_stale_dirs_removed_total", float64(len(ret)), nil)
+	}
+	return ret, nil
+}
+
+func isCheckoutDir(name string) bool {
+	for _, prefix := range checkoutDirPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+package gitdb
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/cresta/gitdb/internal/gitdb/goget"
+	"github.com/cresta/gitdb/internal/httpserver"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// SubmoduleMiddleware serves requests for "{repo}@{submodule}", like /file/app@vendor:lib/main/README.md, from the
+// submodule at the exact commit branch of repo pins it at, rather than the submodule's own branch head.  Slashes in the
+// submodule's path are written as colons.  The submodule must be served as a repository itself.  Responses carry the
+// pinned commit in X-Gitdb-Submodule-Commit.
+func (h *CheckoutHandler) SubmoduleMiddleware() func(http.Handler) http.Handler {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			vars := mux.Vars(request)
+			superKey, subPath, found := strings.Cut(vars["repo"], "@")
+			if !found || vars["branch"] == "" {
+				handler.ServeHTTP(writer, request)
+				return
+			}
+			subPath = strings.ReplaceAll(subPath, ":", "/")
+			logger := h.Log.With(zap.String("repo", superKey), zap.String("submodule", subPath), zap.String("branch", vars["branch"]))
+			subKey, sub, errResp := h.resolveSubmodule(request, superKey, subPath, vars["branch"])
+			if errResp != nil {
+				errResp.HTTPWrite(request.Context(), writer, logger)
+				return
+			}
+			logger.Debug(request.Context(), "serving pinned submodule", zap.String("submodule_repo", subKey), zap.String("commit", sub.Commit))
+			writer.Header().Set("X-Gitdb-Submodule-Commit", sub.Commit)
+			resolved := make(map[string]string, len(vars))
+			for k, v := range vars {
+				resolved[k] = v
+			}
+			resolved["repo"] = subKey
+			resolved["branch"] = sub.Commit
+			handler.ServeHTTP(writer, mux.SetURLVars(request, resolved))
+		})
+	}
+}
+
+// resolveSubmodule finds the key of the repository serving the submodule at subPath of superKey's branch
+func (h *CheckoutHandler) resolveSubmodule(req *http.Request, superKey string, subPath string, branch string) (string, goget.Submodule, *httpserver.BasicResponse) {
+	superCo, exists := h.checkout(superKey)
+	if !exists {
+		return "", goget.Submodule{}, &httpserver.BasicResponse{
+			Code: http.StatusNotFound,
+			Msg:  strings.NewReader(fmt.Sprintf("unable to find repo %s", superKey)),
+		}
+	}
+	sub, err := superCo.Submodule(req.Context(), branch, subPath)
+	if err != nil {
+		switch {
+		case errors.Is(err, goget.ErrUnknownBranch):
+			return "", sub, &httpserver.BasicResponse{
+				Code: http.StatusNotFound,
+				Msg:  strings.NewReader(fmt.Sprintf("branch not found %s", branch)),
+			}
+		case errors.Is(err, goget.ErrNotSubmodule):
+			return "", sub, &httpserver.BasicResponse{
+				Code: http.StatusNotFound,
+				Msg:  strings.NewReader(err.Error()),
+			}
+		}
+		h.Log.Warn(req.Context(), "unable to find submodule", zap.String("repo", superKey), zap.String("submodule", subPath), zap.Error(err))
+		return "", sub, &httpserver.BasicResponse{
+			Code: http.StatusInternalServerError,
+			Msg:  strings.NewReader(fmt.Sprintf("unable to find submodule %s: %v", subPath, err)),
+		}
+	}
+	subKey, found := h.keyForRemote(sub.URL)
+	if !found {
+		return "", sub, &httpserver.BasicResponse{
+			Code: http.StatusNotFound,
+			Msg:  strings.NewReader(fmt.Sprintf("submodule %s is cloned from %s, which is not served", subPath, sub.URL)),
+		}
+	}
+	return subKey, sub, nil
+}
+
+// keyForRemote finds the repository cloned from remoteURL, however its URL is written.  If several are, the first key
+// in order wins.
+func (h *CheckoutHandler) keyForRemote(remoteURL string) (string, bool) {
+	want := RemoteIdentity(remoteURL)
+	var keys []string
+	for key, co := range h.checkouts() {
+		if RemoteIdentity(co.RemoteURL()) == want {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return "", false
+	}
+	sort.Strings(keys)
+	return keys[0], true
+}
+
+// RemoteIdentity is u without what differs between ways of cloning the same repository: the scheme, user, port and a
+// .git suffix.  "git@github.com:org/repo.git" and "https://github.com/org/repo" are both "github.com/org/repo".
+func RemoteIdentity(u string) string {
+	u = strings.TrimSuffix(strings.TrimSuffix(strings.TrimSpace(u), "/"), ".git")
+	if parsed, err := url.Parse(u); err == nil && parsed.Scheme != "" && parsed.Host != "" {
+		return strings.ToLower(parsed.Hostname()) + "/" + strings.Trim(parsed.Path, "/")
+	}
+	if host, p, found := strings.Cut(u, ":"); found && !strings.Contains(host, "/") {
+		if _, h, hasUser := strings.Cut(host, "@"); hasUser {
+			host = h
+		}
+		return strings.ToLower(host) + "/" + strings.Trim(p, "/")
+	}
+	return u
+}
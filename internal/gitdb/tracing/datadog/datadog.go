@@ -20,6 +20,7 @@ import (
 	ddtrace2 "gopkg.in/DataDog/dd-trace-go.v1/contrib/gorilla/mux"
 	ddhttp "gopkg.in/DataDog/dd-trace-go.v1/contrib/net/http"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+	"gopkg.in/DataDog/dd-trace-go.v1/profiler"
 )
 
 // Abstract these constants out
@@ -32,6 +33,8 @@ type config struct {
 	ApmAddress string `json:"DD_APM_RECEIVER_ADDR"`
 	ApmFile    string `json:"DD_APM_RECEIVER_SOCKET"`
 	StatsFile  string `json:"DD_DOGSTATSD_SOCKET"`
+	// Set to "true" to also run the continuous profiler
+	ProfilingEnabled string `json:"DD_PROFILING_ENABLED"`
 }
 
 func (c *config) apmFile() string {
@@ -74,6 +77,31 @@ func verifyFileExistsWithRetry(file string, retries int, delay time.Duration) bo
 	return false
 }
 
+func init() {
+	tracing.RegisterTracer("datadog", NewTracer)
+	tracing.RegisterPreflight("datadog", Preflight)
+}
+
+// How long Preflight waits to connect to the agent
+const preflightDialTimeout = 5 * time.Second
+
+// Preflight connects to the agent's APM address or socket, the way NewTracer would send traces
+func Preflight(originalConfig tracing.Config) error {
+	var cfg config
+	if err := envToStruct(originalConfig.Env, &cfg); err != nil {
+		return fmt.Errorf("unable to convert env to config: %w", err)
+	}
+	network, address := "unix", cfg.apmFile()
+	if cfg.ApmAddress != "" {
+		network, address = "tcp", cfg.ApmAddress
+	}
+	conn, err := net.DialTimeout(network, address, preflightDialTimeout)
+	if err != nil {
+		return fmt.Errorf("unable to reach datadog agent at %s: %w", address, err)
+	}
+	return conn.Close()
+}
+
 func NewTracer(originalConfig tracing.Config) (tracing.Tracing, error) {
 	var cfg config
 	if err := envToStruct(originalConfig.Env, &cfg); err != nil {
@@ -103,9 +131,29 @@ func NewTracer(originalConfig tracing.Config) (tracing.Tracing, error) {
 	}
 	tracer.Start(startOptions...)
 	originalConfig.Log.Info(context.Background(), "DataDog tracing enabled")
+	if cfg.ProfilingEnabled == "true" {
+		if err := startProfiler(cfg); err != nil {
+			return nil, fmt.Errorf("unable to start profiler: %w", err)
+		}
+		originalConfig.Log.Info(context.Background(), "DataDog profiling enabled")
+	}
 	return &Tracing{}, nil
 }
 
+// startProfiler sends CPU and heap profiles to the same agent as traces
+func startProfiler(cfg config) error {
+	opts := []profiler.Option{
+		profiler.WithService("gitdb"),
+		profiler.WithProfileTypes(profiler.CPUProfile, profiler.HeapProfile),
+	}
+	if cfg.ApmAddress == "" {
+		opts = append(opts, profiler.WithUDS(cfg.apmFile()))
+	} else {
+		opts = append(opts, profiler.WithAgentAddr(cfg.ApmAddress))
+	}
+	return profiler.Start(opts...)
+}
+
 var _ tracing.Tracing = &Tracing{}
 
 type Tracing struct {
@@ -1,7 +1,11 @@
 package datadog
 
 import (
+	"net"
+	"path/filepath"
 	"testing"
+
+	"github.com/cresta/gitdb/internal/gitdb/tracing"
 )
 
 func TestEnvToStruct_ValidEnv(t *testing.T) {
@@ -48,3 +52,33 @@ func TestEnvToStruct_InvalidEnvFormat(t *testing.T) {
 		t.Errorf("expected ApmFile to be empty, got %s", cfg.ApmFile)
 	}
 }
+
+func TestEnvToStruct_ProfilingEnabled(t *testing.T) {
+	env := []string{"DD_PROFILING_ENABLED=true"}
+	var cfg config
+	err := envToStruct(env, &cfg)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if cfg.ProfilingEnabled != "true" {
+		t.Errorf("expected ProfilingEnabled to be 'true', got %s", cfg.ProfilingEnabled)
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "apm.socket")
+	env := []string{"DD_APM_RECEIVER_SOCKET=" + socket}
+	if err := Preflight(tracing.Config{Env: env}); err == nil {
+		t.Fatalf("expected an error without an agent listening")
+	}
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer func() {
+		_ = l.Close()
+	}()
+	if err := Preflight(tracing.Config{Env: env}); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/cresta/gitdb/internal/log"
 	"github.com/gorilla/mux"
@@ -23,8 +25,72 @@ type Tracing interface {
 
 type Constructor func(config Config) (Tracing, error)
 
+// Preflight checks a tracer's agent is reachable without starting the tracer
+type Preflight func(config Config) error
+
 type Registry struct {
 	Constructors map[string]Constructor
+	Preflights   map[string]Preflight
+	mu           sync.RWMutex
+}
+
+// DefaultRegistry holds the tracers added with RegisterTracer.  It is the registry gitdb picks GITDB_TRACER from.
+var DefaultRegistry = &Registry{}
+
+// RegisterTracer adds a tracer to DefaultRegistry.  Call it from an init function, then blank import the package from
+// main, to build gitdb with another tracer.
+func RegisterTracer(name string, ctor Constructor) {
+	DefaultRegistry.Register(name, ctor)
+}
+
+// Register adds a tracer named name.  It panics if the name is taken, like http.Handle does, since that is a build
+// mistake.
+func (r *Registry) Register(name string, ctor Constructor) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.Constructors[name]; exists {
+		panic(fmt.Sprintf("tracer %s registered twice", name))
+	}
+	if r.Constructors == nil {
+		r.Constructors = make(map[string]Constructor)
+	}
+	r.Constructors[name] = ctor
+}
+
+// RegisterPreflight adds a check of the tracer named name to DefaultRegistry, for "gitdb --preflight"
+func RegisterPreflight(name string, check Preflight) {
+	DefaultRegistry.RegisterPreflight(name, check)
+}
+
+// RegisterPreflight adds a check of the tracer named name.  Tracers without one always pass preflight.
+func (r *Registry) RegisterPreflight(name string, check Preflight) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.Preflights[name]; exists {
+		panic(fmt.Sprintf("tracer preflight %s registered twice", name))
+	}
+	if r.Preflights == nil {
+		r.Preflights = make(map[string]Preflight)
+	}
+	r.Preflights[name] = check
+}
+
+// Preflight runs the check of the tracer named name, like New would create it
+func (r *Registry) Preflight(name string, config Config) error {
+	if name == "" || r == nil {
+		return nil
+	}
+	r.mu.RLock()
+	_, exists := r.Constructors[name]
+	check := r.Preflights[name]
+	r.mu.RUnlock()
+	if !exists {
+		return fmt.Errorf("unable to find tracer named: %s", name)
+	}
+	if check == nil {
+		return nil
+	}
+	return check(config)
 }
 
 func (r *Registry) New(name string, config Config) (Tracing, error) {
@@ -32,7 +98,9 @@ func (r *Registry) New(name string, config Config) (Tracing, error) {
 		config.Log.Info(context.Background(), "returning no-op tracer")
 		return Noop{}, nil
 	}
+	r.mu.RLock()
 	cons, exists := r.Constructors[name]
+	r.mu.RUnlock()
 	if !exists {
 		return nil, fmt.Errorf("unable to find tracer named: %s", name)
 	}
@@ -72,6 +140,32 @@ func (n Noop) CreateRootMux() (*mux.Router, http.Handler) {
 	return ret, ret
 }
 
+// NewHTTPClient returns a client whose requests are traced by t and carry the trace context to the server.  Every
+// outbound HTTP call should use a client from here.
+func NewHTTPClient(t Tracing, timeout time.Duration) *http.Client {
+	return &http.Client{
+		Transport: t.WrapRoundTrip(http.DefaultTransport.(*http.Transport).Clone()),
+		Timeout:   timeout,
+	}
+}
+
+type routeKeyType struct{}
+
+var routeKey = routeKeyType{}
+
+// WithRoute remembers the name of the mux route serving a request, so spans started deeper down, like git's, can be
+// tagged with it
+func WithRoute(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, routeKey, name)
+}
+
+// Route is the route name set by WithRoute, or empty outside a request
+func Route(ctx context.Context) string {
+	ret, _ := ctx.Value(routeKey).(string)
+	return ret
+}
+
+// MuxTagging tags the request's span with its mux vars and route name, and remembers the route name with WithRoute
 func MuxTagging(t Tracing) func(handler http.Handler) http.Handler {
 	return func(handler http.Handler) http.Handler {
 		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
@@ -81,6 +175,7 @@ func MuxTagging(t Tracing) func(handler http.Handler) http.Handler {
 			if r := mux.CurrentRoute(request); r != nil {
 				if r.GetName() != "" {
 					t.AttachTag(request.Context(), "mux.name", r.GetName())
+					request = request.WithContext(WithRoute(request.Context(), r.GetName()))
 				}
 			}
 			handler.ServeHTTP(writer, request)
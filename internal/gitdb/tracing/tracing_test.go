@@ -0,0 +1,86 @@
+package tracing
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cresta/gitdb/internal/testhelp"
+	"github.com/stretchr/testify/require"
+)
+
+// headerTracing is a tracer whose round trips carry a trace header, like a real one propagating its context
+type headerTracing struct {
+	Noop
+}
+
+func (headerTracing) WrapRoundTrip(rt http.RoundTripper) http.RoundTripper {
+	return roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		req = req.Clone(req.Context())
+		req.Header.Set("X-Trace-Id", "123")
+		return rt.RoundTrip(req)
+	})
+}
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestNewHTTPClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("X-Seen-Trace-Id", request.Header.Get("X-Trace-Id"))
+	}))
+	defer srv.Close()
+
+	client := NewHTTPClient(headerTracing{}, time.Second)
+	require.Equal(t, time.Second, client.Timeout)
+	resp, err := client.Get(srv.URL)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, "123", resp.Header.Get("X-Seen-Trace-Id"))
+	// Clients do not share a transport with each other or http.DefaultClient
+	require.NotSame(t, http.DefaultTransport, NewHTTPClient(Noop{}, 0).Transport)
+}
+
+func TestRegistry(t *testing.T) {
+	cfg := Config{Log: testhelp.ZapTestingLogger(t)}
+	r := &Registry{}
+	ret, err := r.New("", cfg)
+	require.NoError(t, err)
+	require.Equal(t, Noop{}, ret)
+	_, err = r.New("custom", cfg)
+	require.ErrorContains(t, err, "unable to find tracer named: custom")
+
+	r.Register("custom", func(Config) (Tracing, error) { return headerTracing{}, nil })
+	ret, err = r.New("custom", cfg)
+	require.NoError(t, err)
+	require.Equal(t, headerTracing{}, ret)
+	require.Panics(t, func() {
+		r.Register("custom", func(Config) (Tracing, error) { return Noop{}, nil })
+	})
+	r.Register("broken", func(Config) (Tracing, error) { return nil, errors.New("no agent") })
+	_, err = r.New("broken", cfg)
+	require.ErrorContains(t, err, "no agent")
+
+	// Tracers without a preflight always pass it
+	require.NoError(t, r.Preflight("custom", cfg))
+	r.RegisterPreflight("custom", func(Config) error { return errors.New("unreachable") })
+	require.ErrorContains(t, r.Preflight("custom", cfg), "unreachable")
+	require.ErrorContains(t, r.Preflight("missing", cfg), "unable to find tracer named: missing")
+}
+
+func TestRegisterTracer(t *testing.T) {
+	old := DefaultRegistry
+	DefaultRegistry = &Registry{}
+	defer func() {
+		DefaultRegistry = old
+	}()
+	RegisterTracer("tracing_test", func(Config) (Tracing, error) { return headerTracing{}, nil })
+	ret, err := DefaultRegistry.New("tracing_test", Config{Log: testhelp.ZapTestingLogger(t)})
+	require.NoError(t, err)
+	require.Equal(t, headerTracing{}, ret)
+}
@@ -0,0 +1,221 @@
+package gitdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cresta/gitdb/internal/gitdb/goget"
+	"github.com/cresta/gitdb/internal/httpserver"
+	"github.com/cresta/gitdb/internal/log"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// writer is a Checkout that can commit and push.  Plain local directories have no history to add to.
+type writer interface {
+	Write(ctx context.Context, branch string, r goget.CommitRequest) (goget.BranchChange, error)
+}
+
+// SetupWriteMux serves PUT and DELETE /file/{repo}/{branch}/{path}, which commit the file to the branch and push it
+// upstream.  auth is applied to every route.  A JWT it validated must carry the write claim, and can only write inside
+// its repo and path prefix.
+func (h *CheckoutHandler) SetupWriteMux(muxRouter *mux.Router, auth func(http.Handler) http.Handler) {
+	muxRouter.Methods(http.MethodPut).Path("/file/{repo}/{branch}/{path:.*}").Handler(auth(h.jwtWriteScope(httpserver.BasicHandler(h.endpointGate(EndpointWrite, h.putFileHandler), h.Log)))).Name("put_file_handler")
+	muxRouter.Methods(http.MethodDelete).Path("/file/{repo}/{branch}/{path:.*}").Handler(auth(h.jwtWriteScope(httpserver.BasicHandler(h.endpointGate(EndpointWrite, h.deleteFileHandler), h.Log)))).Name("delete_file_handler")
+}
+
+// jwtWriteScope rejects JWTs without the write claim, then applies their repo and path scope like reads
+func (h *CheckoutHandler) jwtWriteScope(root http.Handler) http.Handler {
+	scoped := h.jwtScope("path", root)
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		token, ok := request.Context().Value("user").(*jwt.Token)
+		if !ok {
+			scoped.ServeHTTP(writer, request)
+			return
+		}
+		claims, err := httpserver.ScopedClaimsFromToken(token)
+		if err != nil || !claims.Write {
+			h.Log.Warn(request.Context(), "token does not allow writes", zap.Error(err))
+			resp := httpserver.BasicResponse{
+				Code: http.StatusForbidden,
+				Msg:  strings.NewReader("token does not allow writes"),
+			}
+			resp.HTTPWrite(request.Context(), writer, h.Log)
+			return
+		}
+		scoped.ServeHTTP(writer, request)
+	})
+}
+
+// putFileHandler commits the request body as the content of the file
+func (h *CheckoutHandler) putFileHandler(req *http.Request) httpserver.CanHTTPWrite {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return &httpserver.BasicResponse{
+			Code: http.StatusBadRequest,
+			Msg:  strings.NewReader(fmt.Sprintf("unable to read body: %v", err)),
+		}
+	}
+	content := string(body)
+	return h.writeFile(req, &content, "Update")
+}
+
+func (h *CheckoutHandler) deleteFileHandler(req *http.Request) httpserver.CanHTTPWrite {
+	return h.writeFile(req, nil, "Delete")
+}
+
+// writeFile commits content, or deletes the file if content is nil, and responds with the change recorded for the
+// branch.  The commit message defaults to verb and the path.
+func (h *CheckoutHandler) writeFile(req *http.Request, content *string, verb string) httpserver.CanHTTPWrite {
+	vars := mux.Vars(req)
+	repo := vars["repo"]
+	branch := vars["branch"]
+	path := vars["path"]
+	logger := h.Log.With(zap.String("repo", repo), zap.String("branch", branch), zap.String("path", path))
+	r, exists := h.checkout(repo)
+	if !exists {
+		logger.Warn(req.Context(), "invalid repo")
+		return &httpserver.BasicResponse{Code: http.StatusNotFound, Msg: strings.NewReader(fmt.Sprintf("unable to find repo %s", repo))}
+	}
+	w, ok := r.(writer)
+	if !ok {
+		return &httpserver.BasicResponse{
+			Code: http.StatusBadRequest,
+			Msg:  strings.NewReader(fmt.Sprintf("repo %s is not a git repository", repo)),
+		}
+	}
+	author, err := commitAuthor(req)
+	if err != nil {
+		return &httpserver.BasicResponse{
+			Code: http.StatusBadRequest,
+			Msg:  strings.NewReader(err.Error()),
+		}
+	}
+	expect, err := writePrecondition(req, path)
+	if err != nil {
+		return &httpserver.BasicResponse{
+			Code: http.StatusBadRequest,
+			Msg:  strings.NewReader(err.Error()),
+		}
+	}
+	message := req.Header.Get("X-Gitdb-Commit-Message")
+	if message == "" {
+		message = fmt.Sprintf("%s %s", verb, path)
+	}
+	change, err := w.Write(req.Context(), branch, goget.CommitRequest{
+		Message: message,
+		Author:  author,
+		Files:   map[string]*string{path: content},
+		Expect:  expect,
+	})
+	if err != nil {
+		return h.writeError(req.Context(), err, branch, logger)
+	}
+	b, err := json.Marshal(change)
+	if err != nil {
+		return &httpserver.BasicResponse{
+			Code: http.StatusInternalServerError,
+			Msg:  strings.NewReader(fmt.Sprintf("unable to encode change: %v", err)),
+		}
+	}
+	headers := map[string]string{
+		"Content-Type":   "application/json",
+		"X-Gitdb-Commit": change.To,
+	}
+	if content != nil {
+		// The ETag the file is now served with, for the If-Match of the next write
+		headers["ETag"] = `"` + plumbing.ComputeHash(plumbing.BlobObject, []byte(*content)).String() + `"`
+	}
+	return &httpserver.BasicResponse{
+		Code:    http.StatusOK,
+		Msg:     bytes.NewReader(b),
+		Headers: headers,
+	}
+}
+
+// writeError maps the errors of writer.Write to a status: a push upstream refused is a conflict the client can retry
+func (h *CheckoutHandler) writeError(ctx context.Context, err error, branch string, logger *log.Logger) httpserver.CanHTTPWrite {
+	switch {
+	case errors.Is(err, goget.ErrUnknownBranch):
+		return &httpserver.BasicResponse{
+			Code: http.StatusNotFound,
+			Msg:  strings.NewReader(fmt.Sprintf("branch not found %s", branch)),
+		}
+	case errors.Is(err, goget.ErrInvalidChange):
+		return &httpserver.BasicResponse{
+			Code: http.StatusBadRequest,
+			Msg:  strings.NewReader(err.Error()),
+		}
+	case errors.Is(err, goget.ErrPreconditionFailed):
+		return &httpserver.BasicResponse{
+			Code: http.StatusPreconditionFailed,
+			Msg:  strings.NewReader(err.Error()),
+		}
+	case errors.Is(err, goget.ErrPushRejected):
+		return &httpserver.BasicResponse{
+			Code: http.StatusConflict,
+			Msg:  strings.NewReader(err.Error()),
+		}
+	}
+	logger.Warn(ctx, "unable to write change", zap.Error(err))
+	return &httpserver.BasicResponse{
+		Code: http.StatusInternalServerError,
+		Msg:  strings.NewReader(fmt.Sprintf("unable to write change: %v", err)),
+	}
+}
+
+// commitAuthor is who the request writes as: the name and email claims of its JWT, falling back to the subject for the
+// name, and otherwise the X-Gitdb-Author-Name and X-Gitdb-Author-Email headers.  Claims win so a token cannot write
+// as someone else.
+func commitAuthor(req *http.Request) (object.Signature, error) {
+	ret := object.Signature{When: time.Now()}
+	claims, err := httpserver.RequestClaims(req)
+	if err != nil {
+		return ret, err
+	}
+	claim := func(name string) string {
+		s, _ := claims[name].(string)
+		return s
+	}
+	ret.Name, ret.Email = claim("name"), claim("email")
+	if ret.Name == "" {
+		ret.Name = claim("sub")
+	}
+	if ret.Name == "" {
+		ret.Name = req.Header.Get("X-Gitdb-Author-Name")
+	}
+	if ret.Email == "" {
+		ret.Email = req.Header.Get("X-Gitdb-Author-Email")
+	}
+	if ret.Name == "" {
+		return ret, errors.New("unable to find an author: send X-Gitdb-Author-Name or a JWT with a name or sub claim")
+	}
+	return ret, nil
+}
+
+// writePrecondition is what path must be for the write to happen: the blob hash of an If-Match ETag, like the ETag
+// GET /file served, or not existing for If-None-Match: *
+func writePrecondition(req *http.Request, path string) (map[string]string, error) {
+	if req.Header.Get("If-None-Match") == "*" {
+		return map[string]string{path: ""}, nil
+	}
+	ifMatch := req.Header.Get("If-Match")
+	if ifMatch == "" {
+		return nil, nil
+	}
+	hash := strings.Trim(strings.TrimPrefix(ifMatch, "W/"), `"`)
+	if !plumbing.IsHash(hash) {
+		return nil, fmt.Errorf("expected If-Match to be the ETag of the file, got %s", ifMatch)
+	}
+	return map[string]string{path: hash}, nil
+}
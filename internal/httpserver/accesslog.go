@@ -0,0 +1,63 @@
+package httpserver
+
+import (
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cresta/gitdb/internal/log"
+	"go.uber.org/zap"
+)
+
+// ProbePaths are the health and metrics paths polled by infrastructure, which are never worth an access log
+var ProbePaths = []string{"/health", "/live", "/ready", "/metrics"}
+
+// AccessLog decides which requests LogMiddleware logs
+type AccessLog struct {
+	// Paths never logged.  A path ending in "*" excludes every path it prefixes, like "/public/*".
+	Exclude []string
+	// Fraction, from 0 to 1, of requests answered below 400 that are logged.  Requests answered with an error are always
+	// logged.
+	SuccessSampleRate float64
+}
+
+func (a AccessLog) excluded(path string) bool {
+	for _, e := range a.Exclude {
+		if prefix, isPrefix := strings.CutSuffix(e, "*"); isPrefix {
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+		} else if e == path {
+			return true
+		}
+	}
+	return false
+}
+
+func (a AccessLog) sampled(code int) bool {
+	if code >= http.StatusBadRequest || a.SuccessSampleRate >= 1 {
+		return true
+	}
+	return a.SuccessSampleRate > 0 && rand.Float64() < a.SuccessSampleRate
+}
+
+// LogMiddleware logs the end of requests chosen by cfg, with their status code, time and response size
+func LogMiddleware(logger *log.Logger, cfg AccessLog) func(handler http.Handler) http.Handler {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			if cfg.excluded(request.URL.Path) {
+				handler.ServeHTTP(writer, request)
+				return
+			}
+			start := time.Now()
+			sw := &statusWriter{ResponseWriter: writer, code: http.StatusOK}
+			defer func() {
+				if cfg.sampled(sw.code) {
+					logger.Info(request.Context(), "end request", zap.Int("code", sw.code), zap.Duration("total_time", time.Since(start)), zap.Int64("response_bytes", ResponseBytes(request)))
+				}
+			}()
+			handler.ServeHTTP(sw, request)
+		})
+	}
+}
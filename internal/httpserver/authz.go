@@ -0,0 +1,181 @@
+package httpserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+)
+
+// AuthzInput describes a request to an Authorizer
+type AuthzInput struct {
+	Method   string `json:"method"`
+	Path     string `json:"path"`
+	Route    string `json:"route,omitempty"`
+	Endpoint string `json:"endpoint,omitempty"`
+	// Mux variables of the route, like repo, branch and path
+	Vars     map[string]string `json:"vars,omitempty"`
+	Query    url.Values        `json:"query,omitempty"`
+	ClientIP string            `json:"client_ip"`
+	// Claims of the request's JWT, if it has one
+	Claims map[string]interface{} `json:"claims,omitempty"`
+}
+
+// Authorizer decides whether a request may be served
+type Authorizer interface {
+	Authorize(ctx context.Context, input AuthzInput) (bool, error)
+}
+
+// NewAuthzInput describes req.  endpoint is the name of the endpoint being served, like "file".
+func NewAuthzInput(req *http.Request, endpoint string) (AuthzInput, error) {
+	ret := AuthzInput{
+		Method:   req.Method,
+		Path:     req.URL.Path,
+		Endpoint: endpoint,
+		Vars:     mux.Vars(req),
+		Query:    req.URL.Query(),
+		ClientIP: ClientIP(req),
+	}
+	if r := mux.CurrentRoute(req); r != nil {
+		ret.Route = r.GetName()
+	}
+	claims, err := RequestClaims(req)
+	ret.Claims = claims
+	return ret, err
+}
+
+// RequestClaims are the claims of the JWT a jwtmiddleware validated for req, or nil if it has none
+func RequestClaims(req *http.Request) (map[string]interface{}, error) {
+	token, ok := req.Context().Value("user").(*jwt.Token)
+	if !ok {
+		return nil, nil
+	}
+	if claims, ok := token.Claims.(jwt.MapClaims); ok {
+		return claims, nil
+	}
+	b, err := json.Marshal(token.Claims)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode claims: %w", err)
+	}
+	var ret map[string]interface{}
+	if err := json.Unmarshal(b, &ret); err != nil {
+		return nil, fmt.Errorf("unable to decode claims: %w", err)
+	}
+	return ret, nil
+}
+
+// OPAAuthorizer asks an OPA server, usually a sidecar, for a decision with its data API.  The policy, from bundles or
+// policy files loaded into OPA, gets an AuthzInput as input and must evaluate to a boolean, or to an object with a
+// boolean "allow".  Undefined decisions deny the request.
+type OPAAuthorizer struct {
+	// Decision document, like "http://localhost:8181/v1/data/gitdb/allow"
+	URL string
+	// Defaults to http.DefaultClient
+	Client *http.Client
+}
+
+type opaRequest struct {
+	Input AuthzInput `json:"input"`
+}
+
+type opaResponse struct {
+	Result json.RawMessage `json:"result"`
+}
+
+func (o *OPAAuthorizer) Authorize(ctx context.Context, input AuthzInput) (bool, error) {
+	b, err := json.Marshal(opaRequest{Input: input})
+	if err != nil {
+		return false, fmt.Errorf("unable to encode opa input: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.URL, bytes.NewReader(b))
+	if err != nil {
+		return false, fmt.Errorf("unable to create opa request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := o.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("unable to query opa: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, fmt.Errorf("unable to read opa response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("unexpected opa status code %d: %s", resp.StatusCode, body)
+	}
+	var decision opaResponse
+	if err := json.Unmarshal(body, &decision); err != nil {
+		return false, fmt.Errorf("unable to decode opa response: %w", err)
+	}
+	if len(decision.Result) == 0 {
+		return false, nil
+	}
+	var allow bool
+	if err := json.Unmarshal(decision.Result, &allow); err == nil {
+		return allow, nil
+	}
+	var obj struct {
+		Allow bool `json:"allow"`
+	}
+	if err := json.Unmarshal(decision.Result, &obj); err != nil {
+		return false, fmt.Errorf("unexpected opa result %s", decision.Result)
+	}
+	return obj.Allow, nil
+}
+
+// LoadPolicies puts Rego policy files into the OPA server with its policy API, so policies can ship with gitdb rather
+// than with the OPA deployment.  Each file is stored as gitdb/<base name>, replacing the last version of it.
+func (o *OPAAuthorizer) LoadPolicies(ctx context.Context, files []string) error {
+	base, err := url.Parse(o.URL)
+	if err != nil {
+		return fmt.Errorf("invalid opa url %s: %w", o.URL, err)
+	}
+	client := o.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	for _, f := range files {
+		policy, err := os.ReadFile(f)
+		if err != nil {
+			return fmt.Errorf("unable to read policy %s: %w", f, err)
+		}
+		u := *base
+		u.Path = "/v1/policies/gitdb/" + filepath.Base(f)
+		u.RawQuery = ""
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), bytes.NewReader(policy))
+		if err != nil {
+			return fmt.Errorf("unable to create opa request: %w", err)
+		}
+		req.Header.Set("Content-Type", "text/plain")
+		resp, err := client.Do(req)
+		if err != nil {
+			return fmt.Errorf("unable to load policy %s: %w", f, err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("unable to read opa response: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("unable to load policy %s, status code %d: %s", f, resp.StatusCode, body)
+		}
+	}
+	return nil
+}
+
+var _ Authorizer = &OPAAuthorizer{}
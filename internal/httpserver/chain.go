@@ -0,0 +1,57 @@
+package httpserver
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// Chain is an ordered list of middlewares: the first sees a request first.  Append never changes the chain it is
+// called on, so groups of routes can extend a shared chain.
+type Chain []mux.MiddlewareFunc
+
+func NewChain(middlewares ...mux.MiddlewareFunc) Chain {
+	return append(Chain(nil), middlewares...)
+}
+
+// Append returns a chain running middlewares after those of c
+func (c Chain) Append(middlewares ...mux.MiddlewareFunc) Chain {
+	ret := make(Chain, 0, len(c)+len(middlewares))
+	return append(append(ret, c...), middlewares...)
+}
+
+// Then wraps handler in every middleware of c
+func (c Chain) Then(handler http.Handler) http.Handler {
+	for i := len(c) - 1; i >= 0; i-- {
+		handler = c[i](handler)
+	}
+	return handler
+}
+
+// Use runs c on every route r matches, in order, after any middlewares r already uses
+func (c Chain) Use(r *mux.Router) {
+	r.Use(c...)
+}
+
+// Group calls setup to add routes to r, then wraps the handler of each route it added in c.  A group runs after the
+// middlewares r uses, which wrap whichever route matched.
+func (c Chain) Group(r *mux.Router, setup func(r *mux.Router)) {
+	existing := make(map[*mux.Route]struct{})
+	_ = r.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		existing[route] = struct{}{}
+		return nil
+	})
+	setup(r)
+	if len(c) == 0 {
+		return
+	}
+	_ = r.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		if _, exists := existing[route]; exists {
+			return nil
+		}
+		if handler := route.GetHandler(); handler != nil {
+			route.Handler(c.Then(handler))
+		}
+		return nil
+	})
+}
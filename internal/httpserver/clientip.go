@@ -0,0 +1,114 @@
+package httpserver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/cresta/gitdb/internal/log"
+	"go.uber.org/zap"
+)
+
+// TrustedProxies are the networks allowed to set X-Forwarded-For and X-Real-IP.  Headers from any other peer are
+// ignored, since a client could otherwise claim any address it likes.
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies parses a comma separated list of CIDRs, like "10.0.0.0/8,192.168.1.1".  Bare IPs are treated as a
+// single address network.
+func ParseTrustedProxies(cidrs string) (TrustedProxies, error) {
+	var ret TrustedProxies
+	for _, part := range strings.Split(cidrs, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if !strings.Contains(part, "/") {
+			ip := net.ParseIP(part)
+			if ip == nil {
+				return nil, fmt.Errorf("unable to parse trusted proxy %s", part)
+			}
+			bits := 8 * len(ip.To4())
+			if bits == 0 {
+				bits = 8 * net.IPv6len
+			}
+			ret = append(ret, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(part)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse trusted proxy %s: %w", part, err)
+		}
+		ret = append(ret, n)
+	}
+	return ret, nil
+}
+
+func (t TrustedProxies) trusted(ip net.IP) bool {
+	for _, n := range t {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP resolves the address of the client that made the request.  X-Forwarded-For is walked from the right,
+// skipping trusted proxies, so the first untrusted hop is the client.  X-Real-IP is used when X-Forwarded-For is
+// missing.  Forwarding headers are only honored when the direct peer is a trusted proxy.
+func (t TrustedProxies) ClientIP(req *http.Request) string {
+	peer := req.RemoteAddr
+	if host, _, err := net.SplitHostPort(peer); err == nil {
+		peer = host
+	}
+	peerIP := net.ParseIP(peer)
+	if peerIP == nil || !t.trusted(peerIP) {
+		return peer
+	}
+	if xff := req.Header.Values("X-Forwarded-For"); len(xff) != 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			ip := net.ParseIP(hop)
+			if ip == nil {
+				// Anything to the left of a malformed entry cannot be trusted
+				return peer
+			}
+			if !t.trusted(ip) {
+				return hop
+			}
+			peer = hop
+		}
+		return peer
+	}
+	if realIP := strings.TrimSpace(req.Header.Get("X-Real-IP")); net.ParseIP(realIP) != nil {
+		return realIP
+	}
+	return peer
+}
+
+type clientIPVal string
+
+var clientIPKey = clientIPVal("client_ip")
+
+// ClientIPMiddleware resolves the client IP once per request, adds it to request logs, and makes it available with
+// ClientIP.
+func ClientIPMiddleware(trusted TrustedProxies) func(handler http.Handler) http.Handler {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			ip := trusted.ClientIP(request)
+			ctx := context.WithValue(request.Context(), clientIPKey, ip)
+			ctx = log.With(ctx, zap.String("client_ip", ip))
+			handler.ServeHTTP(writer, request.WithContext(ctx))
+		})
+	}
+}
+
+// ClientIP returns the client IP resolved by ClientIPMiddleware, or the direct peer if the middleware was not used.
+func ClientIP(req *http.Request) string {
+	if ip, ok := req.Context().Value(clientIPKey).(string); ok {
+		return ip
+	}
+	return TrustedProxies(nil).ClientIP(req)
+}
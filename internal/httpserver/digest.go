@@ -0,0 +1,44 @@
+package httpserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"net/http"
+
+	"github.com/cresta/gitdb/internal/log"
+)
+
+// DigestResponse is a BasicResponse with a "Digest: sha-256=..." header, so clients can verify what they downloaded.
+// Buffered bodies get a header; streamed bodies are hashed as they are written and get a trailer.
+type DigestResponse struct {
+	BasicResponse
+}
+
+// DigestValue formats a sha-256 sum for the Digest header
+func DigestValue(sum []byte) string {
+	return "sha-256=" + base64.StdEncoding.EncodeToString(sum)
+}
+
+func (d *DigestResponse) HTTPWrite(ctx context.Context, w http.ResponseWriter, l *log.Logger) {
+	if b, ok := d.Msg.(interface{ Bytes() []byte }); ok {
+		sum := sha256.Sum256(b.Bytes())
+		w.Header().Set("Digest", DigestValue(sum[:]))
+		d.BasicResponse.HTTPWrite(ctx, w, l)
+		return
+	}
+	for k, v := range d.Headers {
+		w.Header().Set(k, v)
+	}
+	w.Header().Set("Trailer", "Digest")
+	w.WriteHeader(d.Code)
+	h := sha256.New()
+	if _, err := d.Msg.WriteTo(io.MultiWriter(w, h)); err != nil {
+		l.IfErr(err).Error(ctx, "unable to write final object")
+		return
+	}
+	w.Header().Set("Digest", DigestValue(h.Sum(nil)))
+}
+
+var _ CanHTTPWrite = &DigestResponse{}
@@ -0,0 +1,74 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/cresta/gitdb/internal/log"
+	"go.uber.org/zap"
+)
+
+// Drainer tracks readiness and in flight requests so a server can stop taking traffic before it shuts down
+type Drainer struct {
+	// How long to keep serving after readiness fails, so load balancers notice and stop sending traffic
+	Delay time.Duration
+	// How long to wait for in flight requests before force closing connections
+	Timeout time.Duration
+	Log     *log.Logger
+
+	draining atomic.Bool
+	inFlight atomic.Int64
+}
+
+// Draining is true once Drain is called.  Health checks should fail while draining.
+func (d *Drainer) Draining() bool {
+	if d == nil {
+		return false
+	}
+	return d.draining.Load()
+}
+
+// InFlight is the number of requests currently being served
+func (d *Drainer) InFlight() int64 {
+	return d.inFlight.Load()
+}
+
+// Middleware counts in flight requests
+func (d *Drainer) Middleware() func(handler http.Handler) http.Handler {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			d.inFlight.Add(1)
+			defer d.inFlight.Add(-1)
+			handler.ServeHTTP(writer, request)
+		})
+	}
+}
+
+// Drain fails readiness, waits Delay for load balancers to deregister the server, then gracefully shuts down server.
+// Connections still open after Timeout are force closed.
+func (d *Drainer) Drain(ctx context.Context, server *http.Server) error {
+	start := time.Now()
+	d.draining.Store(true)
+	d.Log.Info(ctx, "draining: readiness failing", zap.Duration("delay", d.Delay), zap.Int64("in_flight", d.InFlight()))
+	select {
+	case <-time.After(d.Delay):
+	case <-ctx.Done():
+	}
+	d.Log.Info(ctx, "draining: shutting down", zap.Int64("in_flight", d.InFlight()))
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), d.Timeout)
+	defer cancel()
+	err := server.Shutdown(shutdownCtx)
+	if err == nil {
+		d.Log.Info(ctx, "draining: finished", zap.Duration("total_time", time.Since(start)))
+		return nil
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	interrupted := d.InFlight()
+	d.Log.Warn(ctx, "draining: timeout exceeded, force closing", zap.Int64("interrupted", interrupted), zap.Duration("timeout", d.Timeout))
+	return server.Close()
+}
@@ -0,0 +1,13 @@
+package httpserver
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// DryRun is true if the request asks, with ?dry_run=true, to be checked and reported on without changing anything.
+// Webhooks use it to test hook configuration, like from GitHub's redelivery UI, without fetching.
+func DryRun(req *http.Request) bool {
+	dryRun, err := strconv.ParseBool(req.URL.Query().Get("dry_run"))
+	return err == nil && dryRun
+}
@@ -0,0 +1,26 @@
+package httpserver
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// AcceptsEncoding is true if the request's Accept-Encoding lists coding, or "*", without q=0
+func AcceptsEncoding(req *http.Request, coding string) bool {
+	for _, candidate := range strings.Split(req.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(candidate, ";")
+		name = strings.TrimSpace(name)
+		if !strings.EqualFold(name, coding) && name != "*" {
+			continue
+		}
+		q, found := strings.CutPrefix(strings.TrimSpace(params), "q=")
+		if !found {
+			return true
+		}
+		if weight, err := strconv.ParseFloat(q, 64); err == nil && weight > 0 {
+			return true
+		}
+	}
+	return false
+}
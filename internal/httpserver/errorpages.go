@@ -0,0 +1,193 @@
+package httpserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"net/http"
+	"strings"
+	"text/template"
+
+	"github.com/cresta/gitdb/internal/log"
+	"go.uber.org/zap"
+)
+
+// Formats of ErrorPages
+const (
+	ErrorFormatPlain = "plain"
+	ErrorFormatJSON  = "json"
+	ErrorFormatHTML  = "html"
+)
+
+// Messages longer than this are truncated in error pages
+const maxErrorMessageBytes = 1024
+
+var defaultErrorTemplates = map[string]string{
+	ErrorFormatPlain: "{{.Code}} {{.Status}}: {{.Message}}\n{{if .Contact}}Contact {{.Contact}} for help\n{{end}}",
+	ErrorFormatJSON:  `{"code":{{.Code}},"status":{{json .Status}},"message":{{json .Message}},"path":{{json .Path}}{{if .Contact}},"contact":{{json .Contact}}{{end}}}` + "\n",
+	ErrorFormatHTML: `<!DOCTYPE html>
+<html><head><title>{{.Code}} {{.Status}}</title></head>
+<body><h1>{{.Status}}</h1><p>{{.Message}}</p><p><code>{{.Path}}</code></p>{{if .Contact}}<p>Contact {{.Contact}} for help</p>{{end}}</body></html>
+`,
+}
+
+var errorContentTypes = map[string]string{
+	ErrorFormatPlain: "text/plain; charset=utf-8",
+	ErrorFormatJSON:  "application/json",
+	ErrorFormatHTML:  "text/html; charset=utf-8",
+}
+
+// ErrorPageData is what error page templates are executed with
+type ErrorPageData struct {
+	Code int
+	// Like "Not Found"
+	Status string
+	// What the handler wrote, or Status if it wrote nothing
+	Message string
+	Path    string
+	Contact string
+}
+
+// ErrorPages replaces the bodies of 403 and 404 responses, which are otherwise whatever each handler writes, like the
+// bare "404 page not found" of an unknown path.  A nil ErrorPages leaves responses as they are.
+type ErrorPages struct {
+	contentType string
+	execute     func(w io.Writer, data ErrorPageData) error
+	contact     string
+	logger      *log.Logger
+}
+
+// NewErrorPages renders errors in format, which defaults to plain, with tmpl or the format's default template when
+// tmpl is empty.  Plain and JSON templates are text/template with a json function that quotes a value; HTML templates
+// are html/template.  contact, like an email or a chat channel, is shown by the default templates.
+func NewErrorPages(format string, tmpl string, contact string, logger *log.Logger) (*ErrorPages, error) {
+	if format == "" {
+		format = ErrorFormatPlain
+	}
+	contentType, exists := errorContentTypes[format]
+	if !exists {
+		return nil, fmt.Errorf("unknown error page format %s", format)
+	}
+	if tmpl == "" {
+		tmpl = defaultErrorTemplates[format]
+	}
+	ret := &ErrorPages{contentType: contentType, contact: contact, logger: logger}
+	if format == ErrorFormatHTML {
+		t, err := htmltemplate.New("error").Parse(tmpl)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse error page template: %w", err)
+		}
+		ret.execute = func(w io.Writer, data ErrorPageData) error {
+			return t.Execute(w, data)
+		}
+	} else {
+		t, err := template.New("error").Funcs(template.FuncMap{"json": quoteJSON}).Parse(tmpl)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse error page template: %w", err)
+		}
+		ret.execute = func(w io.Writer, data ErrorPageData) error {
+			return t.Execute(w, data)
+		}
+	}
+	// Fail at startup, not on the first 404, for templates using fields that do not exist
+	if err := ret.execute(io.Discard, ErrorPageData{Code: http.StatusNotFound, Status: http.StatusText(http.StatusNotFound), Contact: contact}); err != nil {
+		return nil, fmt.Errorf("unable to execute error page template: %w", err)
+	}
+	return ret, nil
+}
+
+func quoteJSON(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+// Middleware renders the 403 and 404 responses of handler as error pages
+func (e *ErrorPages) Middleware() func(handler http.Handler) http.Handler {
+	return func(handler http.Handler) http.Handler {
+		if e == nil {
+			return handler
+		}
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			ew := &errorPageWriter{ResponseWriter: writer}
+			handler.ServeHTTP(ew, request)
+			if ew.code != 0 {
+				e.write(writer, request, ew.code, ew.body.String())
+			}
+		})
+	}
+}
+
+func (e *ErrorPages) write(writer http.ResponseWriter, request *http.Request, code int, body string) {
+	data := ErrorPageData{
+		Code:    code,
+		Status:  http.StatusText(code),
+		Message: strings.TrimSpace(body),
+		Path:    request.URL.Path,
+		Contact: e.contact,
+	}
+	if data.Message == "" {
+		data.Message = data.Status
+	}
+	var buf bytes.Buffer
+	contentType := e.contentType
+	if err := e.execute(&buf, data); err != nil {
+		e.logger.Warn(request.Context(), "unable to execute error page template", zap.Error(err))
+		buf.Reset()
+		buf.WriteString(data.Message)
+		contentType = errorContentTypes[ErrorFormatPlain]
+	}
+	// Headers the handler set for its own body
+	writer.Header().Del("Content-Length")
+	writer.Header().Del("Content-Encoding")
+	writer.Header().Del("ETag")
+	writer.Header().Set("Content-Type", contentType)
+	writer.WriteHeader(code)
+	_, err := buf.WriteTo(writer)
+	e.logger.IfErr(err).Warn(request.Context(), "unable to write error page")
+}
+
+// errorPageWriter holds back 403 and 404 responses, keeping the start of their body as the message.  Like
+// statusWriter, it forwards Flush and unwraps.
+type errorPageWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+	code        int
+	body        bytes.Buffer
+}
+
+func (e *errorPageWriter) WriteHeader(code int) {
+	if e.wroteHeader {
+		return
+	}
+	e.wroteHeader = true
+	if code == http.StatusForbidden || code == http.StatusNotFound {
+		e.code = code
+		return
+	}
+	e.ResponseWriter.WriteHeader(code)
+}
+
+func (e *errorPageWriter) Write(b []byte) (int, error) {
+	if !e.wroteHeader {
+		e.WriteHeader(http.StatusOK)
+	}
+	if e.code == 0 {
+		return e.ResponseWriter.Write(b)
+	}
+	if room := maxErrorMessageBytes - e.body.Len(); room > 0 {
+		e.body.Write(b[:min(room, len(b))])
+	}
+	return len(b), nil
+}
+
+func (e *errorPageWriter) Flush() {
+	if e.code == 0 {
+		_ = http.NewResponseController(e.ResponseWriter).Flush()
+	}
+}
+
+func (e *errorPageWriter) Unwrap() http.ResponseWriter {
+	return e.ResponseWriter
+}
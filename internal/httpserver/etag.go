@@ -0,0 +1,22 @@
+package httpserver
+
+import (
+	"net/http"
+	"strings"
+)
+
+// ETagMatches is true if the request's If-None-Match lists etag, or is "*".  Like RFC 7232 asks for If-None-Match,
+// weak and strong tags compare equal.
+func ETagMatches(req *http.Request, etag string) bool {
+	ifNoneMatch := req.Header.Get("If-None-Match")
+	if ifNoneMatch == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
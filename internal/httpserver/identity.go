@@ -0,0 +1,60 @@
+package httpserver
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/cresta/gitdb/internal/gitdb/tracing"
+	"github.com/cresta/gitdb/internal/log"
+	"github.com/dgrijalva/jwt-go"
+	"go.uber.org/zap"
+)
+
+type principalVal string
+
+var principalKey = principalVal("principal")
+
+// IdentityMiddleware tags traces and request logs with who made the request: the subject of a valid bearer JWT, the
+// client IP from ClientIPMiddleware, and the user agent.  Tokens are checked with keyFunc, and ignored when keyFunc is
+// nil, so an unverified subject is never recorded.
+func IdentityMiddleware(t tracing.Tracing, keyFunc jwt.Keyfunc) func(handler http.Handler) http.Handler {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			ctx := request.Context()
+			ip := ClientIP(request)
+			ua := request.UserAgent()
+			t.AttachTag(ctx, "http.client_ip", ip)
+			t.AttachTag(ctx, "http.useragent", ua)
+			fields := []zap.Field{zap.String("user_agent", ua)}
+			if sub := bearerSubject(request, keyFunc); sub != "" {
+				t.AttachTag(ctx, "usr.id", sub)
+				fields = append(fields, zap.String("principal", sub))
+				ctx = context.WithValue(ctx, principalKey, sub)
+			}
+			handler.ServeHTTP(writer, request.WithContext(log.With(ctx, fields...)))
+		})
+	}
+}
+
+// Principal returns the JWT subject found by IdentityMiddleware, or "" for anonymous requests
+func Principal(req *http.Request) string {
+	ret, _ := req.Context().Value(principalKey).(string)
+	return ret
+}
+
+func bearerSubject(req *http.Request, keyFunc jwt.Keyfunc) string {
+	if keyFunc == nil {
+		return ""
+	}
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return ""
+	}
+	var claims jwt.StandardClaims
+	token, err := jwt.ParseWithClaims(strings.TrimPrefix(auth, "Bearer "), &claims, keyFunc)
+	if err != nil || !token.Valid || token.Method != jwt.SigningMethodRS256 {
+		return ""
+	}
+	return claims.Subject
+}
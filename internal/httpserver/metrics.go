@@ -0,0 +1,71 @@
+package httpserver
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cresta/gitdb/internal/gitdb/metrics"
+	"github.com/gorilla/mux"
+)
+
+// MetricsMiddleware counts and times requests by the name of the mux route they matched.  Routes without a name use
+// their path template, so tags stay bounded.
+func MetricsMiddleware(m metrics.Metrics) func(handler http.Handler) http.Handler {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			route := routeName(request)
+			start := time.Now()
+			sw := &statusWriter{ResponseWriter: writer, code: http.StatusOK}
+			defer func() {
+				m.Observe("gitdb_http_request_duration_seconds", time.Since(start).Seconds(), metrics.Tags{"route": route, "method": request.Method})
+				m.Count("gitdb_http_requests_total", 1, metrics.Tags{"route": route, "method": request.Method, "code": strconv.Itoa(sw.code)})
+			}()
+			handler.ServeHTTP(sw, request)
+		})
+	}
+}
+
+// routeName is the name of the mux route request matched, or its path template for routes without a name
+func routeName(request *http.Request) string {
+	r := mux.CurrentRoute(request)
+	if r == nil {
+		return "unknown"
+	}
+	if r.GetName() != "" {
+		return r.GetName()
+	}
+	if tpl, err := r.GetPathTemplate(); err == nil {
+		return tpl
+	}
+	return "unknown"
+}
+
+// statusWriter remembers the status code sent.  Like headerTrackingWriter, it forwards Flush and unwraps.
+type statusWriter struct {
+	http.ResponseWriter
+	code        int
+	wroteHeader bool
+}
+
+func (s *statusWriter) WriteHeader(statusCode int) {
+	if !s.wroteHeader {
+		s.code = statusCode
+		s.wroteHeader = true
+	}
+	s.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (s *statusWriter) Write(b []byte) (int, error) {
+	s.wroteHeader = true
+	return s.ResponseWriter.Write(b)
+}
+
+func (s *statusWriter) Flush() {
+	s.wroteHeader = true
+	_ = http.NewResponseController(s.ResponseWriter).Flush()
+}
+
+func (s *statusWriter) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
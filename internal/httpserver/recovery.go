@@ -0,0 +1,110 @@
+package httpserver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/cresta/gitdb/internal/log"
+	"go.uber.org/zap"
+)
+
+// PanicCount is how many handler panics RecoveryMiddleware has recovered
+var PanicCount = expvar.NewInt("gitdb_handler_panics")
+
+type requestIDVal string
+
+var requestIDKey = requestIDVal("request_id")
+
+// RequestID returns the ID RecoveryMiddleware gave the request, or empty if the middleware was not used
+func RequestID(req *http.Request) string {
+	id, _ := req.Context().Value(requestIDKey).(string)
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// PanicResponse is the body sent when a handler panics
+type PanicResponse struct {
+	Error     string
+	RequestID string
+}
+
+// RecoveryMiddleware turns handler panics into a 500 JSON response and a logged stack, instead of a dropped
+// connection.  Every request gets an ID, taken from X-Request-Id if the caller sent one, that is logged and echoed
+// back so a failure can be matched to its logs.
+func RecoveryMiddleware(logger *log.Logger) func(handler http.Handler) http.Handler {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			id := request.Header.Get("X-Request-Id")
+			if id == "" {
+				id = newRequestID()
+			}
+			ctx := context.WithValue(request.Context(), requestIDKey, id)
+			ctx = log.With(ctx, zap.String("request_id", id))
+			request = request.WithContext(ctx)
+			writer.Header().Set("X-Request-Id", id)
+			rw := &headerTrackingWriter{ResponseWriter: writer}
+			defer func() {
+				r := recover()
+				if r == nil {
+					return
+				}
+				if r == http.ErrAbortHandler {
+					// The handler asked for the connection to be dropped
+					panic(r)
+				}
+				PanicCount.Add(1)
+				logger.Error(ctx, "handler panic", zap.String("panic", fmt.Sprint(r)), zap.ByteString("stack", debug.Stack()))
+				if rw.wroteHeader {
+					// Too late for a 500: the client sees a truncated response
+					return
+				}
+				b, err := json.Marshal(PanicResponse{Error: "internal server error", RequestID: id})
+				logger.IfErr(err).Warn(ctx, "unable to encode panic response")
+				writer.Header().Set("Content-Type", "application/json")
+				writer.WriteHeader(http.StatusInternalServerError)
+				_, err = writer.Write(b)
+				logger.IfErr(err).Warn(ctx, "unable to write panic response")
+			}()
+			handler.ServeHTTP(rw, request)
+		})
+	}
+}
+
+// headerTrackingWriter remembers if the status line was sent.  It forwards Flush, and Unwrap lets
+// http.ResponseController reach the underlying writer.
+type headerTrackingWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (h *headerTrackingWriter) WriteHeader(statusCode int) {
+	h.wroteHeader = true
+	h.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (h *headerTrackingWriter) Write(b []byte) (int, error) {
+	h.wroteHeader = true
+	return h.ResponseWriter.Write(b)
+}
+
+func (h *headerTrackingWriter) Flush() {
+	h.wroteHeader = true
+	_ = http.NewResponseController(h.ResponseWriter).Flush()
+}
+
+func (h *headerTrackingWriter) Unwrap() http.ResponseWriter {
+	return h.ResponseWriter
+}
@@ -0,0 +1,88 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/cresta/gitdb/internal/gitdb/metrics"
+	"github.com/cresta/gitdb/internal/log"
+	"go.uber.org/zap"
+)
+
+// ErrResponseTooLarge is returned by writes past the limit of ResponseSizeMiddleware
+var ErrResponseTooLarge = errors.New("response too large")
+
+type responseBytesVal string
+
+var responseBytesKey = responseBytesVal("response_bytes")
+
+// ResponseSizeMiddleware counts the bytes of every response body, observed as gitdb_http_response_bytes by route and
+// logged by LogMiddleware.  A response growing past the limit configured for its mux route name, or defaultLimit when
+// the route is not listed, is cut off: writes fail with ErrResponseTooLarge, the request context is cancelled so the
+// handler stops, and the connection is dropped so the client cannot mistake the truncated body for a whole one.  A
+// limit of zero leaves the route unbounded.
+func ResponseSizeMiddleware(m metrics.Metrics, limits map[string]int64, defaultLimit int64, logger *log.Logger) func(handler http.Handler) http.Handler {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			route := routeName(request)
+			limit := defaultLimit
+			if l, exists := limits[route]; exists {
+				limit = l
+			}
+			ctx, cancel := context.WithCancel(request.Context())
+			defer cancel()
+			cw := &countingWriter{ResponseWriter: writer, limit: limit, cancel: cancel}
+			ctx = context.WithValue(ctx, responseBytesKey, &cw.written)
+			handler.ServeHTTP(cw, request.WithContext(ctx))
+			m.Observe("gitdb_http_response_bytes", float64(cw.written.Load()), metrics.Tags{"route": route, "method": request.Method})
+			if cw.cut {
+				logger.Warn(ctx, "response cut off at size limit", zap.Int64("limit", limit))
+				m.Count("gitdb_http_responses_cut_total", 1, metrics.Tags{"route": route})
+				panic(http.ErrAbortHandler)
+			}
+		})
+	}
+}
+
+// ResponseBytes is how much of the response body was written so far, counted by ResponseSizeMiddleware
+func ResponseBytes(req *http.Request) int64 {
+	if written, ok := req.Context().Value(responseBytesKey).(*atomic.Int64); ok {
+		return written.Load()
+	}
+	return 0
+}
+
+// countingWriter counts the bytes written, refusing to write past a positive limit.  Like statusWriter, it forwards
+// Flush and unwraps.
+type countingWriter struct {
+	http.ResponseWriter
+	written atomic.Int64
+	limit   int64
+	cancel  context.CancelFunc
+	cut     bool
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	if c.cut {
+		return 0, ErrResponseTooLarge
+	}
+	if c.limit > 0 && c.written.Load()+int64(len(b)) > c.limit {
+		c.cut = true
+		c.cancel()
+		return 0, fmt.Errorf("%w: limit is %d bytes", ErrResponseTooLarge, c.limit)
+	}
+	n, err := c.ResponseWriter.Write(b)
+	c.written.Add(int64(n))
+	return n, err
+}
+
+func (c *countingWriter) Flush() {
+	_ = http.NewResponseController(c.ResponseWriter).Flush()
+}
+
+func (c *countingWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
@@ -1,26 +1,65 @@
 package httpserver
 
 import (
+	"bytes"
 	"context"
 	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
 
+	jwtmiddleware "github.com/auth0/go-jwt-middleware"
 	"github.com/cresta/gitdb/internal/gitdb/tracing"
 	"github.com/cresta/gitdb/internal/log"
 	"github.com/gorilla/mux"
 	"go.uber.org/zap"
 )
 
-func HealthHandler(z *log.Logger, tracer tracing.Tracing) http.Handler {
+// HealthHandler is ReadyHandler without any readiness check: it fails only while draining
+func HealthHandler(z *log.Logger, tracer tracing.Tracing, drainer *Drainer) http.Handler {
+	return ReadyHandler(z, tracer, drainer, nil)
+}
+
+// LiveHandler reports the process is up.  Unlike ReadyHandler it passes while draining and before ready, so a
+// liveness probe does not restart a server that is still cloning or shutting down.
+func LiveHandler(z *log.Logger, tracer tracing.Tracing) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		tracer.AttachTag(req.Context(), "sampling.priority", 0)
+		_, err := io.WriteString(rw, "OK")
+		z.IfErr(err).Warn(req.Context(), "unable to write back live response")
+	})
+}
+
+// ReadyHandler reports whether the server should get traffic: it fails while draining or while ready, if not nil,
+// returns an error
+func ReadyHandler(z *log.Logger, tracer tracing.Tracing, drainer *Drainer, ready func(ctx context.Context) error) http.Handler {
 	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
 		// Note: I may need to eventually abstarct this per tracing handler
 		tracer.AttachTag(req.Context(), "sampling.priority", 0)
+		if drainer.Draining() {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			_, err := io.WriteString(rw, "draining")
+			z.IfErr(err).Warn(req.Context(), "unable to write back health response")
+			return
+		}
+		if ready != nil {
+			if err := ready(req.Context()); err != nil {
+				rw.WriteHeader(http.StatusServiceUnavailable)
+				_, err := io.WriteString(rw, "not ready: "+err.Error())
+				z.IfErr(err).Warn(req.Context(), "unable to write back health response")
+				return
+			}
+		}
 		_, err := io.WriteString(rw, "OK")
 		z.IfErr(err).Warn(req.Context(), "unable to write back health response")
 	})
@@ -53,20 +92,180 @@ func BasicHandler(handler func(request *http.Request) CanHTTPWrite, l *log.Logge
 	})
 }
 
-func LogMiddleware(logger *log.Logger, filterFunc func(req *http.Request) bool) func(handler http.Handler) http.Handler {
+// NegotiateContentType returns the entry of offers that best matches the request's Accept header.  The first offer is
+// the default when the header is missing or nothing matches.
+func NegotiateContentType(req *http.Request, offers ...string) string {
+	if len(offers) == 0 {
+		return ""
+	}
+	accept := req.Header.Get("Accept")
+	if accept == "" {
+		return offers[0]
+	}
+	type mediaRange struct {
+		mediaType string
+		q         float64
+	}
+	ranges := make([]mediaRange, 0, strings.Count(accept, ",")+1)
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		mr := mediaRange{mediaType: strings.ToLower(strings.TrimSpace(params[0])), q: 1}
+		for _, p := range params[1:] {
+			k, v, ok := strings.Cut(strings.TrimSpace(p), "=")
+			if !ok || strings.TrimSpace(k) != "q" {
+				continue
+			}
+			if q, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+				mr.q = q
+			}
+		}
+		if mr.mediaType != "" && mr.q > 0 {
+			ranges = append(ranges, mr)
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].q > ranges[j].q
+	})
+	for _, r := range ranges {
+		for _, offer := range offers {
+			if mediaTypeMatches(r.mediaType, offer) {
+				return offer
+			}
+		}
+	}
+	return offers[0]
+}
+
+func mediaTypeMatches(pattern string, offer string) bool {
+	if pattern == "*/*" || pattern == offer {
+		return true
+	}
+	if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+		return strings.HasPrefix(offer, prefix+"/")
+	}
+	return false
+}
+
+// MaxBodyMiddleware limits request bodies to maxBytes.  Requests that declare a larger Content-Length are rejected up
+// front; bodies that grow past the limit while being read fail with an error detectable by IsBodyTooLarge.
+func MaxBodyMiddleware(maxBytes int64, logger *log.Logger) func(handler http.Handler) http.Handler {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			if maxBytes <= 0 || request.Body == nil || request.Body == http.NoBody {
+				handler.ServeHTTP(writer, request)
+				return
+			}
+			if request.ContentLength > maxBytes {
+				logger.Warn(request.Context(), "request body too large", zap.Int64("content_length", request.ContentLength))
+				BodyTooLargeResponse(maxBytes).HTTPWrite(request.Context(), writer, logger)
+				return
+			}
+			request.Body = http.MaxBytesReader(writer, request.Body, maxBytes)
+			handler.ServeHTTP(writer, request)
+		})
+	}
+}
+
+// IsBodyTooLarge returns true if err was caused by reading past the limit of MaxBodyMiddleware
+func IsBodyTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
+
+func BodyTooLargeResponse(maxBytes int64) *BasicResponse {
+	return &BasicResponse{
+		Code: http.StatusRequestEntityTooLarge,
+		Msg:  strings.NewReader(fmt.Sprintf("request body too large: limit is %d bytes", maxBytes)),
+	}
+}
+
+// TimeoutMiddleware bounds each route by the timeout configured for its mux name, or defaultTimeout when the route is
+// not listed.  The request context is cancelled at the deadline and the connection's write deadline is set, so a slow
+// handler or a client that stops reading cannot hold the connection forever.  Unlike http.TimeoutHandler the response
+// is not buffered, so streaming routes keep streaming.  A timeout of zero leaves the route unbounded.
+func TimeoutMiddleware(timeouts map[string]time.Duration, defaultTimeout time.Duration) func(handler http.Handler) http.Handler {
 	return func(handler http.Handler) http.Handler {
 		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
-			start := time.Now()
-			defer func() {
-				if !filterFunc(request) {
-					logger.Info(request.Context(), "end request", zap.Duration("total_time", time.Since(start)))
+			timeout := defaultTimeout
+			if r := mux.CurrentRoute(request); r != nil {
+				if d, exists := timeouts[r.GetName()]; exists {
+					timeout = d
 				}
-			}()
+			}
+			if timeout <= 0 {
+				handler.ServeHTTP(writer, request)
+				return
+			}
+			ctx, cancel := context.WithTimeout(request.Context(), timeout)
+			defer cancel()
+			// Not every ResponseWriter supports deadlines (for example httptest.ResponseRecorder).  The context
+			// deadline still applies.
+			_ = http.NewResponseController(writer).SetWriteDeadline(time.Now().Add(timeout))
+			handler.ServeHTTP(writer, request.WithContext(ctx))
+		})
+	}
+}
+
+// BearerTokenMiddleware rejects requests that do not send "Authorization: Bearer <token>"
+func BearerTokenMiddleware(token string, logger *log.Logger) func(handler http.Handler) http.Handler {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			sent := strings.TrimPrefix(request.Header.Get("Authorization"), "Bearer ")
+			if token == "" || subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+				logger.Warn(request.Context(), "invalid bearer token")
+				resp := BasicResponse{
+					Code:    http.StatusUnauthorized,
+					Msg:     strings.NewReader("invalid bearer token"),
+					Headers: map[string]string{"WWW-Authenticate": "Bearer"},
+				}
+				resp.HTTPWrite(request.Context(), writer, logger)
+				return
+			}
 			handler.ServeHTTP(writer, request)
 		})
 	}
 }
 
+// BasicAuthMiddleware rejects requests that do not send the given basic auth credentials
+func BasicAuthMiddleware(username string, password string, logger *log.Logger) func(handler http.Handler) http.Handler {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			u, p, ok := request.BasicAuth()
+			userMatch := subtle.ConstantTimeCompare([]byte(u), []byte(username)) == 1
+			passMatch := subtle.ConstantTimeCompare([]byte(p), []byte(password)) == 1
+			if !ok || password == "" || !userMatch || !passMatch {
+				logger.Warn(request.Context(), "invalid basic auth")
+				resp := BasicResponse{
+					Code:    http.StatusUnauthorized,
+					Msg:     strings.NewReader("invalid credentials"),
+					Headers: map[string]string{"WWW-Authenticate": `Basic realm="gitdb"`},
+				}
+				resp.HTTPWrite(request.Context(), writer, logger)
+				return
+			}
+			handler.ServeHTTP(writer, request)
+		})
+	}
+}
+
+// JWTMiddleware rejects requests that do not send a bearer JWT signed with RS256 by the key of keyFunc.  RequestClaims
+// then reads its claims.
+func JWTMiddleware(keyFunc jwt.Keyfunc, logger *log.Logger) func(handler http.Handler) http.Handler {
+	middleware := jwtmiddleware.New(jwtmiddleware.Options{
+		ValidationKeyGetter: keyFunc,
+		SigningMethod:       jwt.SigningMethodRS256,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err string) {
+			resp := BasicResponse{
+				Code: http.StatusUnauthorized,
+				Msg:  strings.NewReader(err),
+			}
+			logger.Warn(r.Context(), "error during JWT", zap.String("err_string", err))
+			resp.HTTPWrite(r.Context(), w, logger)
+		},
+	})
+	return middleware.Handler
+}
+
 func MuxMiddleware() func(handler http.Handler) http.Handler {
 	return func(handler http.Handler) http.Handler {
 		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
@@ -84,6 +283,26 @@ func MuxMiddleware() func(handler http.Handler) http.Handler {
 	}
 }
 
+// UnescapedVars matches the routes of r against the escaped path, so a variable can hold an escaped slash, like
+// org%2Frepo, and unescapes the variables before any other middleware of r sees them
+func UnescapedVars(r *mux.Router) {
+	r.UseEncodedPath()
+	r.Use(func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			vars := mux.Vars(request)
+			unescaped := make(map[string]string, len(vars))
+			for k, v := range vars {
+				// The server already rejected requests with invalid escapes
+				if u, err := url.PathUnescape(v); err == nil {
+					v = u
+				}
+				unescaped[k] = v
+			}
+			handler.ServeHTTP(writer, mux.SetURLVars(request, unescaped))
+		})
+	})
+}
+
 func NotFoundHandler(logger *log.Logger) http.Handler {
 	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
 		logger.With(zap.String("handler", "not_found"), zap.String("url", req.URL.String())).Warn(req.Context(), "unknown request")
@@ -152,3 +371,49 @@ func (j *JWTSignIn) ServeHTTP(writer http.ResponseWriter, request *http.Request)
 }
 
 var _ http.Handler = &JWTSignIn{}
+
+// RouteInfo describes a registered mux route
+type RouteInfo struct {
+	Name    string   `json:"name"`
+	Methods []string `json:"methods,omitempty"`
+	Path    string   `json:"path"`
+}
+
+// RoutesHandler lists every route registered on router, so operators can see which optional features are mounted
+func RoutesHandler(router *mux.Router, logger *log.Logger) http.Handler {
+	return BasicHandler(func(req *http.Request) CanHTTPWrite {
+		routes := make([]RouteInfo, 0)
+		err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+			path, err := route.GetPathTemplate()
+			if err != nil {
+				// Routes without a path, like subrouter matchers, are not interesting
+				return nil
+			}
+			methods, _ := route.GetMethods()
+			routes = append(routes, RouteInfo{
+				Name:    route.GetName(),
+				Methods: methods,
+				Path:    path,
+			})
+			return nil
+		})
+		if err != nil {
+			return &BasicResponse{
+				Code: http.StatusInternalServerError,
+				Msg:  strings.NewReader(fmt.Sprintf("unable to walk routes: %v", err)),
+			}
+		}
+		b, err := json.Marshal(routes)
+		if err != nil {
+			return &BasicResponse{
+				Code: http.StatusInternalServerError,
+				Msg:  strings.NewReader(fmt.Sprintf("unable to encode routes: %v", err)),
+			}
+		}
+		return &BasicResponse{
+			Code:    http.StatusOK,
+			Msg:     bytes.NewReader(b),
+			Headers: map[string]string{"Content-Type": "application/json"},
+		}
+	}, logger)
+}
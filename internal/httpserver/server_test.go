@@ -1,15 +1,30 @@
 package httpserver
 
 import (
+	"bytes"
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/cresta/gitdb/internal/gitdb/metrics"
+	"github.com/cresta/gitdb/internal/gitdb/tracing"
+	"github.com/cresta/gitdb/internal/log"
 	"github.com/cresta/gitdb/internal/testhelp"
 	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 func TestJWTSignIn(t *testing.T) {
@@ -37,3 +52,566 @@ func TestJWTSignIn(t *testing.T) {
 	require.NoError(t, err)
 	require.True(t, tok.Valid)
 }
+
+func TestNegotiateContentType(t *testing.T) {
+	offers := []string{"application/json", "text/plain", "application/x-ndjson"}
+	run := func(accept string, expected string) func(t *testing.T) {
+		return func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "http://localhost/url", nil)
+			require.NoError(t, err)
+			if accept != "" {
+				req.Header.Set("Accept", accept)
+			}
+			require.Equal(t, expected, NegotiateContentType(req, offers...))
+		}
+	}
+	t.Run("missing", run("", "application/json"))
+	t.Run("exact", run("text/plain", "text/plain"))
+	t.Run("ndjson", run("application/x-ndjson", "application/x-ndjson"))
+	t.Run("wildcard", run("*/*", "application/json"))
+	t.Run("type_wildcard", run("text/*", "text/plain"))
+	t.Run("quality", run("text/plain;q=0.5, application/x-ndjson", "application/x-ndjson"))
+	t.Run("unknown", run("image/png", "application/json"))
+}
+
+func TestMaxBodyMiddleware(t *testing.T) {
+	handler := MaxBodyMiddleware(4, testhelp.ZapTestingLogger(t))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := io.ReadAll(r.Body)
+		if IsBodyTooLarge(err) {
+			BodyTooLargeResponse(4).HTTPWrite(r.Context(), w, nil)
+			return
+		}
+		require.NoError(t, err)
+		w.WriteHeader(http.StatusOK)
+	}))
+	run := func(body io.Reader, contentLength int64, expected int) func(t *testing.T) {
+		return func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "http://localhost/url", body)
+			req.ContentLength = contentLength
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			require.Equal(t, expected, rec.Code)
+		}
+	}
+	t.Run("small", run(strings.NewReader("abc"), 3, http.StatusOK))
+	t.Run("declared_large", run(strings.NewReader("abcdef"), 6, http.StatusRequestEntityTooLarge))
+	t.Run("undeclared_large", run(io.MultiReader(strings.NewReader("abc"), strings.NewReader("def")), -1, http.StatusRequestEntityTooLarge))
+}
+
+func TestTimeoutMiddleware(t *testing.T) {
+	router := mux.NewRouter()
+	router.Use(TimeoutMiddleware(map[string]time.Duration{"slow": time.Millisecond * 10}, 0))
+	var slowErr, fastErr error
+	router.Path("/slow").Name("slow").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second * 5):
+		case <-r.Context().Done():
+		}
+		slowErr = r.Context().Err()
+	})
+	router.Path("/fast").Name("fast").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, hasDeadline := r.Context().Deadline()
+		require.False(t, hasDeadline)
+		fastErr = r.Context().Err()
+	})
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/slow", nil))
+	require.ErrorIs(t, slowErr, context.DeadlineExceeded)
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://localhost/fast", nil))
+	require.NoError(t, fastErr)
+}
+
+func TestTrustedProxiesClientIP(t *testing.T) {
+	trusted, err := ParseTrustedProxies("10.0.0.0/8, 192.168.1.1")
+	require.NoError(t, err)
+	run := func(remoteAddr string, headers map[string]string) string {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/", nil)
+		req.RemoteAddr = remoteAddr
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		return trusted.ClientIP(req)
+	}
+	require.Equal(t, "1.2.3.4", run("1.2.3.4:1234", nil))
+	require.Equal(t, "1.2.3.4", run("1.2.3.4:1234", map[string]string{"X-Forwarded-For": "5.6.7.8"}))
+	require.Equal(t, "5.6.7.8", run("10.1.1.1:1234", map[string]string{"X-Forwarded-For": "5.6.7.8"}))
+	require.Equal(t, "5.6.7.8", run("10.1.1.1:1234", map[string]string{"X-Forwarded-For": "9.9.9.9, 5.6.7.8, 192.168.1.1"}))
+	require.Equal(t, "10.1.1.1", run("10.1.1.1:1234", map[string]string{"X-Forwarded-For": "garbage"}))
+	require.Equal(t, "5.6.7.8", run("192.168.1.1:1234", map[string]string{"X-Real-IP": "5.6.7.8"}))
+	_, err = ParseTrustedProxies("not-an-ip")
+	require.Error(t, err)
+}
+
+func TestBearerTokenMiddleware(t *testing.T) {
+	h := BearerTokenMiddleware("secret", testhelp.ZapTestingLogger(t))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	run := func(auth string) int {
+		req := httptest.NewRequest(http.MethodPost, "http://localhost/admin", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	require.Equal(t, http.StatusOK, run("Bearer secret"))
+	require.Equal(t, http.StatusUnauthorized, run("Bearer wrong"))
+	require.Equal(t, http.StatusUnauthorized, run(""))
+}
+
+func TestBasicAuthMiddleware(t *testing.T) {
+	h := BasicAuthMiddleware("user", "pass", testhelp.ZapTestingLogger(t))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	run := func(username string, password string) int {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost/debug/vars", nil)
+		if username != "" {
+			req.SetBasicAuth(username, password)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	require.Equal(t, http.StatusOK, run("user", "pass"))
+	require.Equal(t, http.StatusUnauthorized, run("user", "wrong"))
+	require.Equal(t, http.StatusUnauthorized, run("", ""))
+}
+
+func TestDrainer(t *testing.T) {
+	d := &Drainer{
+		Timeout: time.Second,
+		Log:     testhelp.ZapTestingLogger(t),
+	}
+	health := HealthHandler(testhelp.ZapTestingLogger(t), tracing.Noop{}, d)
+	rec := httptest.NewRecorder()
+	health.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://localhost/health", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	srv := httptest.NewServer(d.Middleware()(health))
+	require.NoError(t, d.Drain(context.Background(), srv.Config))
+	require.True(t, d.Draining())
+	require.Equal(t, int64(0), d.InFlight())
+
+	rec = httptest.NewRecorder()
+	health.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://localhost/health", nil))
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
+
+func TestReadyHandler(t *testing.T) {
+	d := &Drainer{
+		Timeout: time.Second,
+		Log:     testhelp.ZapTestingLogger(t),
+	}
+	notReady := errors.New("cloning")
+	ready := ReadyHandler(testhelp.ZapTestingLogger(t), tracing.Noop{}, d, func(context.Context) error {
+		return notReady
+	})
+	live := LiveHandler(testhelp.ZapTestingLogger(t), tracing.Noop{})
+	run := func(h http.Handler) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://localhost/", nil))
+		return rec.Code
+	}
+	require.Equal(t, http.StatusServiceUnavailable, run(ready))
+	require.Equal(t, http.StatusOK, run(live))
+	notReady = nil
+	require.Equal(t, http.StatusOK, run(ready))
+
+	srv := httptest.NewServer(d.Middleware()(ready))
+	require.NoError(t, d.Drain(context.Background(), srv.Config))
+	require.Equal(t, http.StatusServiceUnavailable, run(ready))
+	require.Equal(t, http.StatusOK, run(live))
+}
+
+func TestChain(t *testing.T) {
+	tag := func(name string) mux.MiddlewareFunc {
+		return func(handler http.Handler) http.Handler {
+			return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Add("X-Order", name)
+				handler.ServeHTTP(rw, req)
+			})
+		}
+	}
+	ok := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = io.WriteString(rw, "OK")
+	})
+	router := mux.NewRouter()
+	root := NewChain(tag("root"))
+	root.Use(router)
+	router.Handle("/before", ok)
+	base := NewChain(tag("group"))
+	group := base.Append(tag("inner"))
+	require.Len(t, base, 1)
+	group.Group(router, func(r *mux.Router) {
+		r.Handle("/grouped", ok)
+	})
+	router.Handle("/after", ok)
+	run := func(path string) []string {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://localhost"+path, nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+		return rec.Header().Values("X-Order")
+	}
+	require.Equal(t, []string{"root"}, run("/before"))
+	require.Equal(t, []string{"root", "group", "inner"}, run("/grouped"))
+	require.Equal(t, []string{"root"}, run("/after"))
+}
+
+func TestErrorPages(t *testing.T) {
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/missing":
+			rw.Header().Set("Content-Length", "9")
+			rw.WriteHeader(http.StatusNotFound)
+			_, _ = io.WriteString(rw, "no <file>")
+		case "/empty":
+			rw.WriteHeader(http.StatusForbidden)
+		case "/broken":
+			rw.WriteHeader(http.StatusInternalServerError)
+			_, _ = io.WriteString(rw, "broken")
+		default:
+			_, _ = io.WriteString(rw, "OK")
+		}
+	})
+	run := func(pages *ErrorPages, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		pages.Middleware()(handler).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://localhost"+path, nil))
+		return rec
+	}
+
+	pages, err := NewErrorPages(ErrorFormatJSON, "", "#gitdb", testhelp.ZapTestingLogger(t))
+	require.NoError(t, err)
+	rec := run(pages, "/missing")
+	require.Equal(t, http.StatusNotFound, rec.Code)
+	require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	require.Empty(t, rec.Header().Get("Content-Length"))
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	require.Equal(t, map[string]interface{}{"code": 404.0, "status": "Not Found", "message": "no <file>", "path": "/missing", "contact": "#gitdb"}, body)
+	require.NoError(t, json.Unmarshal(run(pages, "/empty").Body.Bytes(), &body))
+	require.Equal(t, "Forbidden", body["message"])
+	require.Equal(t, "broken", run(pages, "/broken").Body.String())
+	require.Equal(t, "OK", run(pages, "/").Body.String())
+
+	pages, err = NewErrorPages(ErrorFormatHTML, "<p>{{.Message}}</p>", "", testhelp.ZapTestingLogger(t))
+	require.NoError(t, err)
+	require.Equal(t, "<p>no &lt;file&gt;</p>", run(pages, "/missing").Body.String())
+
+	// Without error pages, handlers write their own
+	require.Equal(t, "no <file>", run(nil, "/missing").Body.String())
+
+	_, err = NewErrorPages("xml", "", "", testhelp.ZapTestingLogger(t))
+	require.Error(t, err)
+	_, err = NewErrorPages(ErrorFormatPlain, "{{.Missing}}", "", testhelp.ZapTestingLogger(t))
+	require.Error(t, err)
+}
+
+func TestRoutesHandler(t *testing.T) {
+	router := mux.NewRouter()
+	router.Methods(http.MethodGet).Path("/file/{repo}").Name("get_file").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	router.Path("/health").Name("health").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	rec := httptest.NewRecorder()
+	RoutesHandler(router, testhelp.ZapTestingLogger(t)).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://localhost/admin/routes", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.JSONEq(t, `[{"name":"get_file","methods":["GET"],"path":"/file/{repo}"},{"name":"health","path":"/health"}]`, rec.Body.String())
+}
+
+func TestJWTTokenExchange(t *testing.T) {
+	pk, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	j := &JWTTokenExchange{
+		Logger:     testhelp.ZapTestingLogger(t),
+		PrivateKey: pk,
+		APIKeys:    []string{"key"},
+		DefaultTTL: time.Minute,
+		MaxTTL:     time.Hour,
+	}
+	exchange := func(auth func(req *http.Request), body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "http://localhost/public/token", strings.NewReader(body))
+		auth(req)
+		j.ServeHTTP(rec, req)
+		return rec
+	}
+	rec := exchange(func(req *http.Request) { req.Header.Set("X-Api-Key", "wrong") }, `{}`)
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = exchange(func(req *http.Request) { req.Header.Set("X-Api-Key", "key") }, `{"Repo":"repo","PathPrefix":"adir/","TTL":"2h"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	scoped := rec.Body.String()
+	var claims ScopedClaims
+	_, err = jwt.ParseWithClaims(scoped, &claims, func(_ *jwt.Token) (interface{}, error) {
+		return &pk.PublicKey, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, "repo", claims.Repo)
+	require.Equal(t, "adir", claims.PathPrefix)
+	require.LessOrEqual(t, claims.ExpiresAt, time.Now().Add(time.Hour).Unix())
+	require.True(t, claims.Allows("repo", "adir/a.txt"))
+	require.False(t, claims.Allows("repo", "adirb/a.txt"))
+	require.False(t, claims.Allows("other", "adir/a.txt"))
+
+	bearer := func(req *http.Request) { req.Header.Set("Authorization", "Bearer "+scoped) }
+	rec = exchange(bearer, `{"Repo":"repo","PathPrefix":"adir/sub"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = exchange(bearer, `{"Repo":"repo"}`)
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	rec = exchange(bearer, `{"Repo":"other","PathPrefix":"adir"}`)
+	require.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+func TestRecoveryMiddleware(t *testing.T) {
+	h := RecoveryMiddleware(testhelp.ZapTestingLogger(t))(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+		panic("oops")
+	}))
+	before := PanicCount.Value()
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/", nil)
+	req.Header.Set("X-Request-Id", "abc")
+	h.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusInternalServerError, rec.Code)
+	require.Equal(t, "abc", rec.Header().Get("X-Request-Id"))
+	require.JSONEq(t, `{"Error":"internal server error","RequestID":"abc"}`, rec.Body.String())
+	require.Equal(t, before+1, PanicCount.Value())
+}
+
+func TestDigestResponse(t *testing.T) {
+	want := "sha-256=LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ="
+	rec := httptest.NewRecorder()
+	resp := &DigestResponse{BasicResponse{Code: http.StatusOK, Msg: bytes.NewBufferString("hello")}}
+	resp.HTTPWrite(context.Background(), rec, testhelp.ZapTestingLogger(t))
+	require.Equal(t, want, rec.Header().Get("Digest"))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp := &DigestResponse{BasicResponse{Code: http.StatusOK, Msg: &onlyWriterTo{s: "hello"}}}
+		resp.HTTPWrite(r.Context(), w, testhelp.ZapTestingLogger(t))
+	}))
+	defer srv.Close()
+	httpResp, err := http.Get(srv.URL)
+	require.NoError(t, err)
+	body, err := io.ReadAll(httpResp.Body)
+	require.NoError(t, err)
+	require.NoError(t, httpResp.Body.Close())
+	require.Equal(t, "hello", string(body))
+	require.Equal(t, want, httpResp.Trailer.Get("Digest"))
+}
+
+type onlyWriterTo struct {
+	s string
+}
+
+func (o *onlyWriterTo) WriteTo(w io.Writer) (int64, error) {
+	n, err := io.WriteString(w, o.s)
+	return int64(n), err
+}
+
+func TestOPAAuthorizer(t *testing.T) {
+	opa := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Input AuthzInput `json:"input"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		switch body.Input.Vars["repo"] {
+		case "allowed":
+			_, _ = io.WriteString(w, `{"result": true}`)
+		case "object":
+			_, _ = io.WriteString(w, `{"result": {"allow": true, "reason": "ok"}}`)
+		case "undefined":
+			_, _ = io.WriteString(w, `{}`)
+		default:
+			_, _ = io.WriteString(w, `{"result": false}`)
+		}
+	}))
+	defer opa.Close()
+	a := &OPAAuthorizer{URL: opa.URL + "/v1/data/gitdb/allow"}
+	for repo, want := range map[string]bool{"allowed": true, "object": true, "undefined": false, "denied": false} {
+		allowed, err := a.Authorize(context.Background(), AuthzInput{Vars: map[string]string{"repo": repo}})
+		require.NoError(t, err)
+		require.Equal(t, want, allowed, repo)
+	}
+}
+
+func TestOPAAuthorizer_LoadPolicies(t *testing.T) {
+	loaded := make(map[string]string)
+	opa := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPut, r.Method)
+		b, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		if strings.Contains(string(b), "invalid") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		loaded[r.URL.Path] = string(b)
+	}))
+	defer opa.Close()
+	dir := t.TempDir()
+	policy := filepath.Join(dir, "gitdb.rego")
+	require.NoError(t, os.WriteFile(policy, []byte("package gitdb\ndefault allow = false\n"), 0o600))
+	a := &OPAAuthorizer{URL: opa.URL + "/v1/data/gitdb/allow"}
+	require.NoError(t, a.LoadPolicies(context.Background(), []string{policy}))
+	require.Equal(t, map[string]string{"/v1/policies/gitdb/gitdb.rego": "package gitdb\ndefault allow = false\n"}, loaded)
+
+	invalid := filepath.Join(dir, "invalid.rego")
+	require.NoError(t, os.WriteFile(invalid, []byte("invalid"), 0o600))
+	require.Error(t, a.LoadPolicies(context.Background(), []string{invalid}))
+	require.Error(t, a.LoadPolicies(context.Background(), []string{filepath.Join(dir, "missing.rego")}))
+}
+
+func TestNewAuthzInput(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/file/repo/master/a.txt?x=1", nil)
+	req = mux.SetURLVars(req, map[string]string{"repo": "repo", "path": "a.txt"})
+	req = req.WithContext(context.WithValue(req.Context(), "user", &jwt.Token{Claims: &ScopedClaims{Repo: "repo"}}))
+	input, err := NewAuthzInput(req, "file")
+	require.NoError(t, err)
+	require.Equal(t, "file", input.Endpoint)
+	require.Equal(t, "a.txt", input.Vars["path"])
+	require.Equal(t, "1", input.Query.Get("x"))
+	require.Equal(t, "repo", input.Claims["repo"])
+}
+
+type tagRecorder struct {
+	tracing.Noop
+	tags map[string]interface{}
+}
+
+func (r *tagRecorder) AttachTag(_ context.Context, key string, value interface{}) {
+	r.tags[key] = value
+}
+
+func TestIdentityMiddleware(t *testing.T) {
+	pk, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.StandardClaims{Subject: "svc-a"}).SignedString(pk)
+	require.NoError(t, err)
+	keyFunc := func(_ *jwt.Token) (interface{}, error) {
+		return pk.Public(), nil
+	}
+	for _, tc := range []struct {
+		auth      string
+		principal string
+	}{
+		{auth: "Bearer " + signed, principal: "svc-a"},
+		{auth: "Bearer not-a-jwt"},
+		{},
+	} {
+		rec := &tagRecorder{tags: make(map[string]interface{})}
+		var principal string
+		h := IdentityMiddleware(rec, keyFunc)(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			principal = Principal(r)
+		}))
+		req := httptest.NewRequest(http.MethodGet, "/file/repo/master/a.txt", nil)
+		req.Header.Set("User-Agent", "test-agent")
+		if tc.auth != "" {
+			req.Header.Set("Authorization", tc.auth)
+		}
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		require.Equal(t, tc.principal, principal)
+		require.Equal(t, "test-agent", rec.tags["http.useragent"])
+		require.Equal(t, "192.0.2.1", rec.tags["http.client_ip"])
+		if tc.principal != "" {
+			require.Equal(t, tc.principal, rec.tags["usr.id"])
+		} else {
+			require.NotContains(t, rec.tags, "usr.id")
+		}
+	}
+}
+
+func TestETagMatches(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	require.False(t, ETagMatches(req, `"abc"`))
+	req.Header.Set("If-None-Match", `"xyz", W/"abc"`)
+	require.True(t, ETagMatches(req, `"abc"`))
+	require.False(t, ETagMatches(req, `"ab"`))
+	req.Header.Set("If-None-Match", "*")
+	require.True(t, ETagMatches(req, `"abc"`))
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	require.False(t, AcceptsEncoding(req, "gzip"))
+	req.Header.Set("Accept-Encoding", "br, GZIP;q=0.5")
+	require.True(t, AcceptsEncoding(req, "gzip"))
+	req.Header.Set("Accept-Encoding", "gzip;q=0, deflate")
+	require.False(t, AcceptsEncoding(req, "gzip"))
+	req.Header.Set("Accept-Encoding", "*")
+	require.True(t, AcceptsEncoding(req, "gzip"))
+}
+
+type countingMetrics struct {
+	metrics.Noop
+	counts map[string]float64
+}
+
+func (c *countingMetrics) Count(name string, delta float64, tags metrics.Tags) {
+	c.counts[name+" "+tags["route"]+" "+tags["code"]] += delta
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	c := &countingMetrics{counts: make(map[string]float64)}
+	m := mux.NewRouter()
+	m.Use(MetricsMiddleware(c))
+	m.Handle("/teapot", http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})).Name("teapot")
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/teapot", nil))
+	require.Equal(t, map[string]float64{"gitdb_http_requests_total teapot 418": 1}, c.counts)
+}
+
+func TestResponseSizeMiddleware(t *testing.T) {
+	c := &countingMetrics{counts: make(map[string]float64)}
+	m := mux.NewRouter()
+	m.Use(ResponseSizeMiddleware(c, map[string]int64{"small": 10}, 0, testhelp.ZapTestingLogger(t)))
+	var written int64
+	var writeErr error
+	var ctxErr error
+	body := func(w http.ResponseWriter, r *http.Request) {
+		_, writeErr = w.Write([]byte("12345678"))
+		if writeErr == nil {
+			_, writeErr = w.Write([]byte("12345678"))
+		}
+		written = ResponseBytes(r)
+		ctxErr = r.Context().Err()
+	}
+	m.HandleFunc("/small", body).Name("small")
+	m.HandleFunc("/big", body).Name("big")
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/big", nil))
+	require.NoError(t, writeErr)
+	require.Equal(t, int64(16), written)
+	require.Equal(t, "1234567812345678", rec.Body.String())
+
+	require.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/small", nil))
+	})
+	require.ErrorIs(t, writeErr, ErrResponseTooLarge)
+	require.Equal(t, int64(8), written)
+	require.ErrorIs(t, ctxErr, context.Canceled)
+	require.Equal(t, map[string]float64{"gitdb_http_responses_cut_total small ": 1}, c.counts)
+}
+
+func TestLogMiddleware(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	m := mux.NewRouter()
+	m.Use(LogMiddleware(log.New(zap.New(core)), AccessLog{Exclude: []string{"/health", "/quiet/*"}, SuccessSampleRate: -1}))
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("fail") != "" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}
+	m.PathPrefix("/").HandlerFunc(handler)
+	for _, u := range []string{"/health", "/quiet/a", "/quiet/b?fail=1", "/ok"} {
+		m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, u, nil))
+	}
+	require.Zero(t, logs.Len())
+
+	m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ok?fail=1", nil))
+	require.Equal(t, 1, logs.Len())
+	require.Equal(t, int64(http.StatusNotFound), logs.TakeAll()[0].ContextMap()["code"])
+
+	all := mux.NewRouter()
+	all.Use(LogMiddleware(log.New(zap.New(core)), AccessLog{SuccessSampleRate: 1}))
+	all.PathPrefix("/").HandlerFunc(handler)
+	all.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ok", nil))
+	require.Equal(t, 1, logs.Len())
+}
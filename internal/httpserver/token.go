@@ -0,0 +1,179 @@
+package httpserver
+
+import (
+	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cresta/gitdb/internal/log"
+	"github.com/dgrijalva/jwt-go"
+	"go.uber.org/zap"
+)
+
+// ScopedClaims are JWT claims that can narrow a token to one repository and path prefix.  Empty fields are
+// unrestricted.
+type ScopedClaims struct {
+	jwt.StandardClaims
+	Repo       string `json:"repo,omitempty"`
+	PathPrefix string `json:"path_prefix,omitempty"`
+	// Allows PUT and DELETE /file within the scope.  Only minted outside gitdb: signed in and exchanged tokens never
+	// carry it.
+	Write bool `json:"write,omitempty"`
+}
+
+// Allows is true if the claims permit reading p in repo
+func (s *ScopedClaims) Allows(repo string, p string) bool {
+	if s.Repo != "" && s.Repo != repo {
+		return false
+	}
+	prefix := strings.Trim(s.PathPrefix, "/")
+	p = strings.Trim(p, "/")
+	return prefix == "" || p == prefix || strings.HasPrefix(p, prefix+"/")
+}
+
+// narrows is true if the claims are at least as restrictive as parent
+func (s *ScopedClaims) narrows(parent *ScopedClaims) bool {
+	return parent.Allows(s.Repo, s.PathPrefix)
+}
+
+// ScopedClaimsFromToken reads the scope of a token parsed with map claims, like tokens from jwtmiddleware
+func ScopedClaimsFromToken(token *jwt.Token) (*ScopedClaims, error) {
+	if c, ok := token.Claims.(*ScopedClaims); ok {
+		return c, nil
+	}
+	b, err := json.Marshal(token.Claims)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode claims: %w", err)
+	}
+	var ret ScopedClaims
+	if err := json.Unmarshal(b, &ret); err != nil {
+		return nil, fmt.Errorf("unable to decode claims: %w", err)
+	}
+	return &ret, nil
+}
+
+// TokenExchangeRequest asks for a token limited to Repo and PathPrefix that expires after TTL
+type TokenExchangeRequest struct {
+	Repo       string
+	PathPrefix string
+	// Like "10m".  Defaults to JWTTokenExchange.DefaultTTL
+	TTL string
+}
+
+// JWTTokenExchange trades a broad token, or an API key, for a narrower and shorter lived one.  The new token can never
+// be broader, or outlive, the one presented.
+type JWTTokenExchange struct {
+	Logger     *log.Logger
+	PrivateKey *rsa.PrivateKey
+	// Keys that may request a token for any repository and path
+	APIKeys    []string
+	DefaultTTL time.Duration
+	MaxTTL     time.Duration
+	Now        func() time.Time
+}
+
+func (j *JWTTokenExchange) now() time.Time {
+	if j.Now == nil {
+		return time.Now()
+	}
+	return j.Now()
+}
+
+func (j *JWTTokenExchange) fail(request *http.Request, writer http.ResponseWriter, code int, msg string) {
+	resp := BasicResponse{
+		Code: code,
+		Msg:  strings.NewReader(msg),
+	}
+	j.Logger.Info(request.Context(), "token exchange refused", zap.Int("code", code), zap.String("reason", msg))
+	resp.HTTPWrite(request.Context(), writer, j.Logger)
+}
+
+// parent returns the scope of the credential on the request, or an error if there is no valid one
+func (j *JWTTokenExchange) parent(request *http.Request) (*ScopedClaims, error) {
+	if apiKey := request.Header.Get("X-Api-Key"); apiKey != "" {
+		for _, k := range j.APIKeys {
+			if subtle.ConstantTimeCompare([]byte(apiKey), []byte(k)) == 1 {
+				return &ScopedClaims{}, nil
+			}
+		}
+		return nil, errors.New("unknown api key")
+	}
+	auth := request.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return nil, errors.New("no bearer token or api key")
+	}
+	var claims ScopedClaims
+	_, err := jwt.ParseWithClaims(strings.TrimPrefix(auth, "Bearer "), &claims, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodRS256 {
+			return nil, fmt.Errorf("unexpected signing method %s", token.Header["alg"])
+		}
+		return &j.PrivateKey.PublicKey, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+	return &claims, nil
+}
+
+func (j *JWTTokenExchange) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	parent, err := j.parent(request)
+	if err != nil {
+		j.fail(request, writer, http.StatusUnauthorized, err.Error())
+		return
+	}
+	var body TokenExchangeRequest
+	if err := json.NewDecoder(request.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		j.fail(request, writer, http.StatusBadRequest, fmt.Sprintf("unable to decode body: %v", err))
+		return
+	}
+	ttl := j.DefaultTTL
+	if body.TTL != "" {
+		ttl, err = time.ParseDuration(body.TTL)
+		if err != nil || ttl <= 0 {
+			j.fail(request, writer, http.StatusBadRequest, fmt.Sprintf("invalid ttl %s", body.TTL))
+			return
+		}
+	}
+	if ttl > j.MaxTTL {
+		ttl = j.MaxTTL
+	}
+	now := j.now()
+	expires := now.Add(ttl).Unix()
+	if parent.ExpiresAt != 0 && parent.ExpiresAt < expires {
+		expires = parent.ExpiresAt
+	}
+	claims := &ScopedClaims{
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: expires,
+			IssuedAt:  now.Unix(),
+			Issuer:    "gitdb",
+			NotBefore: now.Add(-time.Minute).Unix(),
+		},
+		Repo:       body.Repo,
+		PathPrefix: strings.Trim(body.PathPrefix, "/"),
+	}
+	if !claims.narrows(parent) {
+		j.fail(request, writer, http.StatusForbidden, "requested scope is broader than the presented token")
+		return
+	}
+	s, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(j.PrivateKey)
+	if err != nil {
+		j.Logger.IfErr(err).Warn(request.Context(), "unable to sign token")
+		j.fail(request, writer, http.StatusInternalServerError, "unable to sign token")
+		return
+	}
+	resp := BasicResponse{
+		Code: http.StatusOK,
+		Msg:  strings.NewReader(s),
+	}
+	j.Logger.Info(request.Context(), "exchanged token", zap.String("repo", claims.Repo), zap.String("path_prefix", claims.PathPrefix), zap.Duration("ttl", ttl))
+	resp.HTTPWrite(request.Context(), writer, j.Logger)
+}
+
+var _ http.Handler = &JWTTokenExchange{}
@@ -0,0 +1,321 @@
+// Package fakecheckout is an in memory gitdb.Checkout, so handler tests need no clone.  It is separate from testhelp
+// because goget's tests import testhelp.
+package fakecheckout
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cresta/gitdb/internal/gitdb/goget"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// ErrNotFaked is returned by methods the fake does not implement
+var ErrNotFaked = errors.New("not supported by fake checkout")
+
+// Checkout serves Files without any git storage.  The zero value has no branches.
+type Checkout struct {
+	URL string
+	// Keyed by branch, then by path
+	Files map[string]map[string]string
+	// Commit SHA of each branch, for HasHead and FileInfo.Commit
+	Heads map[string]string
+	// Returned by Refresh
+	RefreshErr error
+	// Keyed by branch, then by path
+	Submodules map[string]map[string]goget.Submodule
+
+	mu          sync.Mutex
+	refreshes   int
+	refreshedAt time.Time
+}
+
+// Refreshes is how many times Refresh was called
+func (c *Checkout) Refreshes() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.refreshes
+}
+
+func (c *Checkout) RemoteURL() string {
+	return c.URL
+}
+
+func (c *Checkout) AbsPath() string {
+	return ""
+}
+
+func (c *Checkout) branch(branch string) (map[string]string, error) {
+	files, exists := c.Files[branch]
+	if !exists {
+		return nil, fmt.Errorf("%w %s", goget.ErrUnknownBranch, branch)
+	}
+	return files, nil
+}
+
+func (c *Checkout) file(branch string, p string) (string, goget.FileInfo, error) {
+	files, err := c.branch(branch)
+	if err != nil {
+		return "", goget.FileInfo{}, err
+	}
+	content, exists := files[p]
+	if !exists {
+		return "", goget.FileInfo{}, fmt.Errorf("unable to find file %s: %w", p, object.ErrFileNotFound)
+	}
+	return content, goget.FileInfo{
+		Commit:   c.Heads[branch],
+		BlobHash: plumbing.ComputeHash(plumbing.BlobObject, []byte(content)).String(),
+	}, nil
+}
+
+func (c *Checkout) GetFile(ctx context.Context, branch string, path string) (io.WriterTo, error) {
+	f, _, err := c.GetFileWithInfo(ctx, branch, path)
+	return f, err
+}
+
+func (c *Checkout) GetFileWithInfo(_ context.Context, branch string, path string) (io.WriterTo, goget.FileInfo, error) {
+	content, info, err := c.file(branch, path)
+	if err != nil {
+		return nil, info, err
+	}
+	return bytes.NewBufferString(content), info, nil
+}
+
+func (c *Checkout) GetFiles(_ context.Context, branch string, paths []string) (string, []goget.BatchFile, error) {
+	if _, err := c.branch(branch); err != nil {
+		return "", nil, err
+	}
+	ret := make([]goget.BatchFile, 0, len(paths))
+	for _, p := range paths {
+		f := goget.BatchFile{Path: p}
+		content, info, err := c.file(branch, p)
+		if err != nil {
+			f.Error = err.Error()
+			f.NotFound = errors.Is(err, object.ErrFileNotFound)
+		} else {
+			f.Content = []byte(content)
+			f.BlobHash = info.BlobHash
+		}
+		ret = append(ret, f)
+	}
+	return c.Heads[branch], ret, nil
+}
+
+func (c *Checkout) StreamFiles(ctx context.Context, branch string, paths []string, callback func(commit string, f goget.BatchFile) error) error {
+	commit, files, err := c.GetFiles(ctx, branch, paths)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := callback(commit, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Checkout) StatFile(_ context.Context, branch string, path string) (goget.FileInfo, int64, error) {
+	content, info, err := c.file(branch, path)
+	return info, int64(len(content)), err
+}
+
+func (c *Checkout) Gzipped(string, string, goget.FileInfo, []byte) ([]byte, bool) {
+	return nil, false
+}
+
+// entries lists dir like a git tree would: directories are implied by the paths of files under them
+func (c *Checkout) entries(branch string, dir string, recursive bool) ([]goget.FileStat, error) {
+	files, err := c.branch(branch)
+	if err != nil {
+		return nil, err
+	}
+	prefix := ""
+	if dir != "" {
+		prefix = strings.TrimSuffix(dir, "/") + "/"
+	}
+	seen := make(map[string]struct{})
+	ret := make([]goget.FileStat, 0)
+	add := func(name string, mode filemode.FileMode, hash string, size int64) {
+		if _, exists := seen[name]; !exists {
+			seen[name] = struct{}{}
+			ret = append(ret, goget.FileStat{Name: name, Mode: uint32(mode), Hash: hash, Size: size})
+		}
+	}
+	for p, content := range files {
+		rel, found := strings.CutPrefix(p, prefix)
+		if !found {
+			continue
+		}
+		parts := strings.Split(rel, "/")
+		for i := 1; i < len(parts); i++ {
+			if i > 1 && !recursive {
+				break
+			}
+			add(path.Join(parts[:i]...), filemode.Dir, "", 0)
+		}
+		if len(parts) == 1 || recursive {
+			add(rel, filemode.Regular, plumbing.ComputeHash(plumbing.BlobObject, []byte(content)).String(), int64(len(content)))
+		}
+	}
+	if len(ret) == 0 && dir != "" {
+		return nil, fmt.Errorf("unable to find entry named %s: %w", dir, object.ErrDirectoryNotFound)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+	return ret, nil
+}
+
+func (c *Checkout) LsDir(_ context.Context, dir string, branch string) ([]goget.FileStat, error) {
+	return c.entries(branch, dir, false)
+}
+
+func (c *Checkout) LastModified(context.Context, string, string) (map[string]time.Time, error) {
+	return nil, ErrNotFaked
+}
+
+func (c *Checkout) LsFiles(_ context.Context, branch string) ([]string, error) {
+	files, err := c.branch(branch)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]string, 0, len(files))
+	for p := range files {
+		ret = append(ret, p)
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+func (c *Checkout) WalkDir(_ context.Context, dir string, branch string, recursive bool, callback func(goget.FileStat) error) error {
+	entries, err := c.entries(branch, dir, recursive)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := callback(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Checkout) WalkFiles(context.Context, string, func(f *object.File) error) (string, error) {
+	return "", ErrNotFaked
+}
+
+func (c *Checkout) ZipContent(context.Context, io.Writer, string, string) (int, error) {
+	return 0, ErrNotFaked
+}
+
+func (c *Checkout) ZipContents(context.Context, io.Writer, []goget.ZipPrefix, string) (int, error) {
+	return 0, ErrNotFaked
+}
+
+func (c *Checkout) Tar(context.Context, io.Writer, []goget.ZipPrefix, string) (int, error) {
+	return 0, ErrNotFaked
+}
+
+func (c *Checkout) Bundle(context.Context, io.Writer, string) error {
+	return ErrNotFaked
+}
+
+func (c *Checkout) BundleAll(context.Context, io.Writer) error {
+	return ErrNotFaked
+}
+
+func (c *Checkout) Commit(context.Context, string) (goget.CommitInfo, error) {
+	return goget.CommitInfo{}, ErrNotFaked
+}
+
+func (c *Checkout) Commits(context.Context, string, int) ([]goget.CommitInfo, error) {
+	return nil, ErrNotFaked
+}
+
+func (c *Checkout) Log(context.Context, string, string, int) ([]goget.CommitInfo, error) {
+	return nil, ErrNotFaked
+}
+
+func (c *Checkout) Diff(context.Context, string, string, string, bool) (goget.TreeDiff, error) {
+	return goget.TreeDiff{}, ErrNotFaked
+}
+
+func (c *Checkout) Changes(string) (goget.BranchChange, bool) {
+	return goget.BranchChange{}, false
+}
+
+func (c *Checkout) HasHead(branch string, hash string) bool {
+	head, exists := c.Heads[branch]
+	return exists && head == hash
+}
+
+func (c *Checkout) BranchDeleted(string) (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (c *Checkout) Refresh(context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.refreshes++
+	if c.RefreshErr != nil {
+		return c.RefreshErr
+	}
+	c.refreshedAt = time.Now()
+	return nil
+}
+
+func (c *Checkout) RefreshedAt() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.refreshedAt
+}
+
+func (c *Checkout) Revalidate(time.Duration) bool {
+	return false
+}
+
+func (c *Checkout) InMaintenance(time.Time) bool {
+	return false
+}
+
+func (c *Checkout) Verify(context.Context) (goget.VerifyReport, error) {
+	return goget.VerifyReport{}, ErrNotFaked
+}
+
+func (c *Checkout) Heal(context.Context) error {
+	return ErrNotFaked
+}
+
+func (c *Checkout) RotateAuth(context.Context, transport.AuthMethod) error {
+	return ErrNotFaked
+}
+
+func (c *Checkout) WorkTree() (goget.WorkTreeInfo, bool) {
+	return goget.WorkTreeInfo{}, false
+}
+
+func (c *Checkout) Submodule(_ context.Context, branch string, p string) (goget.Submodule, error) {
+	if _, err := c.branch(branch); err != nil {
+		return goget.Submodule{}, err
+	}
+	sub, exists := c.Submodules[branch][p]
+	if !exists {
+		return goget.Submodule{}, fmt.Errorf("path %s: %w", p, goget.ErrNotSubmodule)
+	}
+	return sub, nil
+}
+
+func (c *Checkout) Close() error {
+	return nil
+}